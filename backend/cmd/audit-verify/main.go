@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	passvaultService "github.com/Novando/pintartek/internal/passvault-service"
+	"github.com/Novando/pintartek/pkg/crypto"
+	"github.com/Novando/pintartek/pkg/env"
+	"github.com/Novando/pintartek/pkg/logger"
+	"github.com/Novando/pintartek/pkg/postgresql/pgx/v5"
+	"github.com/spf13/viper"
+	"os"
+)
+
+// audit-verify walk the audit chain of the configured database and print the report,
+// exiting with 1 when a link is broken. Using -generate-key it print a new key pair for the audit config instead
+func main() {
+	generateKey := flag.Bool("generate-key", false, "print a new audit.signingKey and its audit.publicKey, then exit")
+	flag.Parse()
+
+	log := logger.InitZerolog(logger.Config{
+		ConsoleLoggingEnabled: true,
+		CallerSkip:            3,
+	})
+
+	if *generateKey {
+		pub, pvt, err := crypto.GenerateKeyPairEd25519()
+		if err != nil {
+			log.Fatalf("%s: %s", "Error generating the audit key", err)
+		}
+		out, _ := json.MarshalIndent(map[string]string{
+			"signingKey": fmt.Sprintf("%x", pvt),
+			"publicKey":  fmt.Sprintf("%x", pub),
+		}, "", "  ")
+		fmt.Println(string(out))
+		return
+	}
+
+	// Environment configuration, the same as the service
+	if err := env.InitViper("./config/config.local.json", log); err != nil || os.Getenv("CONSUL_PATH") != "" {
+		consul := env.InitConsul(
+			"52.230.98.3",
+			8800,
+			"http",
+			log,
+		)
+		consul.RetrieveConfiguration(
+			os.Getenv("CONSUL_PATH"),
+			"json",
+		)
+	}
+
+	pgxpool, query, err := pgx.InitPGXv5(
+		viper.GetString("postgres.username"),
+		viper.GetString("postgres.password"),
+		viper.GetString("postgres.host"),
+		viper.GetInt("postgres.port"),
+		viper.GetString("postgres.database"),
+		viper.GetString("postgres.schema"),
+		1,
+	)
+	if err != nil {
+		log.Panic(err.Error())
+	}
+	defer pgxpool.Close()
+
+	report, err := passvaultService.VerifyAudit(context.Background(), query, pgxpool, log)
+	if err != nil {
+		log.Fatalf("%s: %s", "Error verifying the audit chain", err)
+	}
+	out, _ := json.MarshalIndent(report, "", "  ")
+	fmt.Println(string(out))
+	if !report.Verified {
+		pgxpool.Close()
+		os.Exit(1)
+	}
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	passvaultService "github.com/Novando/pintartek/internal/passvault-service"
+	"github.com/Novando/pintartek/pkg/auth"
 	"github.com/Novando/pintartek/pkg/env"
 	"github.com/Novando/pintartek/pkg/logger"
 	"github.com/Novando/pintartek/pkg/postgresql/pgx/v5"
@@ -71,18 +73,33 @@ func main() {
 	}
 	defer pgxpool.Close()
 
-	// Redis configuration
-	rds := redis.Init(
-		viper.GetString("redis.host"),
-		viper.GetInt("redis.port"),
-		viper.GetString("redis.password"),
-		log,
-	)
-	defer rds.Close()
+	// Redis configuration, without redis the sessions are kept in Postgres
+	// and the rate limit is counted by each replica
+	var rds *redis.Redis
+	if viper.GetString("redis.host") != "" {
+		rds = redis.Init(
+			viper.GetString("redis.host"),
+			viper.GetInt("redis.port"),
+			viper.GetString("redis.password"),
+			log,
+		)
+		defer rds.Close()
+	} else {
+		log.Info("Redis is not configured")
+	}
 
-	// Fiber configuration
-	app := fiber.New()
-	app.Use(cors.New())
+	// Fiber configuration, the client IP is read from the proxy header only when the request
+	// come from a trusted proxy, otherwise any client could pick the IP it is rate limited by
+	app := fiber.New(fiber.Config{
+		ProxyHeader:             viper.GetString("application.proxyHeader"),
+		EnableTrustedProxyCheck: true,
+		TrustedProxies:          viper.GetStringSlice("application.trustedProxies"),
+		EnableIPValidation:      true,
+	})
+	app.Use(cors.New(cors.Config{
+		// let the browser read the rotated session token and how long a rate limited client should wait
+		ExposeHeaders: auth.SessionTokenHeader + ", " + fiber.HeaderRetryAfter,
+	}))
 
 	// Define a health check endpoint
 	app.Get("/health", func(c *fiber.Ctx) error {
@@ -91,7 +108,17 @@ func main() {
 
 	// Module initialization
 	v1 := app.Group("/v1")
-	passvaultService.InitPassvaultService(v1, query, pgxpool, rds, log)
+	if err = passvaultService.InitPassvaultService(v1, query, pgxpool, rds, log); err != nil {
+		log.Fatalf("%s: %s", "Error initializing the service", err)
+	}
+
+	// Background maintenance, stopped and waited for before the database pool is closed
+	maintenanceCtx, stopMaintenance := context.WithCancel(context.Background())
+	maintenanceDone := passvaultService.StartMaintenance(maintenanceCtx, query, pgxpool, log)
+	defer func() {
+		stopMaintenance()
+		<-maintenanceDone
+	}()
 
 	// Start Fiber
 	go func() {
@@ -0,0 +1,19 @@
+-- +migrate Up
+ALTER TABLE user_vault_pivots
+    ADD COLUMN IF NOT EXISTS vault_key VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS invite_key VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS accepted_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP;
+UPDATE user_vault_pivots SET accepted_at = CURRENT_TIMESTAMP WHERE accepted_at IS NULL;
+ALTER TABLE user_vault_pivots
+    ADD CONSTRAINT uq_user_vault_pivots_user_id_vault_id UNIQUE (user_id, vault_id);
+ALTER TABLE vaults ADD COLUMN IF NOT EXISTS seal_version INTEGER NOT NULL DEFAULT 0;
+
+-- +migrate Down
+ALTER TABLE vaults DROP COLUMN IF EXISTS seal_version;
+ALTER TABLE user_vault_pivots DROP CONSTRAINT IF EXISTS uq_user_vault_pivots_user_id_vault_id;
+ALTER TABLE user_vault_pivots
+    DROP COLUMN IF EXISTS created_at,
+    DROP COLUMN IF EXISTS accepted_at,
+    DROP COLUMN IF EXISTS invite_key,
+    DROP COLUMN IF EXISTS vault_key;
@@ -0,0 +1,10 @@
+-- +migrate Up
+ALTER TABLE user_vault_pivots ADD COLUMN IF NOT EXISTS role VARCHAR(15) NOT NULL DEFAULT 'viewer';
+-- The earliest member of a vault is the one who created it
+UPDATE user_vault_pivots uvp SET role = CASE
+    WHEN uvp.id = (SELECT MIN(p.id) FROM user_vault_pivots p WHERE p.vault_id = uvp.vault_id) THEN 'owner'
+    ELSE 'editor'
+END;
+
+-- +migrate Down
+ALTER TABLE user_vault_pivots DROP COLUMN IF EXISTS role;
@@ -0,0 +1,6 @@
+-- +migrate Up
+-- Empty parameters mark an access token encrypted by the padded password
+ALTER TABLE users ADD COLUMN IF NOT EXISTS kdf_params VARCHAR(255) NOT NULL DEFAULT '';
+
+-- +migrate Down
+ALTER TABLE users DROP COLUMN IF EXISTS kdf_params;
@@ -0,0 +1,14 @@
+-- +migrate Up
+ALTER TABLE vaults RENAME COLUMN pivot_id TO owner_id;
+ALTER TABLE vaults RENAME CONSTRAINT fk_vaults_pivot_id TO fk_vaults_owner_id;
+-- The earliest owner of a vault is the one who created it
+UPDATE vaults v SET owner_id = (
+    SELECT p.user_id FROM user_vault_pivots p
+    WHERE p.vault_id = v.id AND p.role = 'owner'
+    ORDER BY p.id
+    LIMIT 1
+) WHERE v.owner_id IS NULL;
+
+-- +migrate Down
+ALTER TABLE vaults RENAME CONSTRAINT fk_vaults_owner_id TO fk_vaults_pivot_id;
+ALTER TABLE vaults RENAME COLUMN owner_id TO pivot_id;
@@ -0,0 +1,19 @@
+-- +migrate Up
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_counter BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled_at TIMESTAMPTZ;
+CREATE TABLE IF NOT EXISTS user_recovery_codes(
+    id BIGSERIAL NOT NULL PRIMARY KEY,
+    user_id UUID NOT NULL CONSTRAINT fk_user_recovery_codes_user_id REFERENCES users(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    code_hash VARCHAR(255) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    used_at TIMESTAMPTZ
+);
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS scope VARCHAR(15) NOT NULL DEFAULT '';
+
+-- +migrate Down
+ALTER TABLE sessions DROP COLUMN IF EXISTS scope;
+DROP TABLE IF EXISTS user_recovery_codes;
+ALTER TABLE users DROP COLUMN IF EXISTS totp_enabled_at;
+ALTER TABLE users DROP COLUMN IF EXISTS totp_counter;
+ALTER TABLE users DROP COLUMN IF EXISTS totp_secret;
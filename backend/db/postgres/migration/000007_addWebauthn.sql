@@ -0,0 +1,22 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS webauthn_credentials(
+    id BIGSERIAL NOT NULL PRIMARY KEY,
+    user_id UUID NOT NULL CONSTRAINT fk_webauthn_credentials_user_id REFERENCES users(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    credential_id VARCHAR(1023) NOT NULL CONSTRAINT uq_webauthn_credentials_credential_id UNIQUE,
+    public_key TEXT NOT NULL,
+    sign_count BIGINT NOT NULL DEFAULT 0,
+    name VARCHAR(255) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_used_at TIMESTAMPTZ
+);
+CREATE TABLE IF NOT EXISTS webauthn_challenges(
+    user_id UUID NOT NULL CONSTRAINT fk_webauthn_challenges_user_id REFERENCES users(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    ceremony VARCHAR(15) NOT NULL,
+    challenge VARCHAR(255) NOT NULL,
+    expired_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (user_id, ceremony)
+);
+
+-- +migrate Down
+DROP TABLE IF EXISTS webauthn_challenges;
+DROP TABLE IF EXISTS webauthn_credentials;
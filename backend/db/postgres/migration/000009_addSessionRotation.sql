@@ -0,0 +1,15 @@
+-- +migrate Up
+ALTER TABLE sessions
+    ADD COLUMN IF NOT EXISTS family_id UUID,
+    ADD COLUMN IF NOT EXISTS replaced_by UUID,
+    ADD COLUMN IF NOT EXISTS rotated_at TIMESTAMPTZ;
+UPDATE sessions SET family_id = id WHERE family_id IS NULL;
+ALTER TABLE sessions ALTER COLUMN family_id SET NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_sessions_family_id ON sessions(family_id);
+
+-- +migrate Down
+DROP INDEX IF EXISTS idx_sessions_family_id;
+ALTER TABLE sessions
+    DROP COLUMN IF EXISTS rotated_at,
+    DROP COLUMN IF EXISTS replaced_by,
+    DROP COLUMN IF EXISTS family_id;
@@ -0,0 +1,12 @@
+-- +migrate Up
+-- idle_timeout is in seconds, every use of a session push expired_at up to max_expired_at
+ALTER TABLE sessions
+    ADD COLUMN IF NOT EXISTS idle_timeout INTEGER NOT NULL DEFAULT 1800,
+    ADD COLUMN IF NOT EXISTS max_expired_at TIMESTAMPTZ;
+UPDATE sessions SET max_expired_at = expired_at WHERE max_expired_at IS NULL;
+ALTER TABLE sessions ALTER COLUMN max_expired_at SET NOT NULL;
+
+-- +migrate Down
+ALTER TABLE sessions
+    DROP COLUMN IF EXISTS max_expired_at,
+    DROP COLUMN IF EXISTS idle_timeout;
@@ -0,0 +1,14 @@
+-- +migrate Up
+-- the credential of a vault stays bound to the owner it was sealed by,
+-- as the ownership is moved to another member when the owner is deleted
+ALTER TABLE vaults ADD COLUMN IF NOT EXISTS sealed_owner_id UUID;
+UPDATE vaults SET sealed_owner_id = owner_id;
+ALTER TABLE vaults DROP CONSTRAINT fk_vaults_owner_id;
+ALTER TABLE vaults ADD CONSTRAINT fk_vaults_owner_id FOREIGN KEY (owner_id)
+    REFERENCES users(id) ON UPDATE CASCADE ON DELETE SET NULL;
+
+-- +migrate Down
+ALTER TABLE vaults DROP CONSTRAINT fk_vaults_owner_id;
+ALTER TABLE vaults ADD CONSTRAINT fk_vaults_owner_id FOREIGN KEY (owner_id)
+    REFERENCES users(id) ON UPDATE CASCADE ON DELETE CASCADE;
+ALTER TABLE vaults DROP COLUMN IF EXISTS sealed_owner_id;
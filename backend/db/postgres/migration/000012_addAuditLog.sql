@@ -0,0 +1,15 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS audit_events(
+    id BIGSERIAL PRIMARY KEY,
+    user_id UUID CONSTRAINT fk_audit_events_user_id REFERENCES users(id) ON UPDATE CASCADE ON DELETE SET NULL,
+    action VARCHAR(64) NOT NULL,
+    vault_id UUID,
+    target_id VARCHAR(255) NOT NULL DEFAULT '',
+    ip VARCHAR(45) NOT NULL DEFAULT '',
+    user_agent VARCHAR(512) NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_audit_events_user_id ON audit_events(user_id, id DESC);
+
+-- +migrate Down
+DROP TABLE IF EXISTS audit_events;
@@ -0,0 +1,36 @@
+-- +migrate Up
+-- the events outlive their user, deleting a user must not edit the history
+ALTER TABLE audit_events DROP CONSTRAINT IF EXISTS fk_audit_events_user_id;
+ALTER TABLE audit_events
+    ADD COLUMN IF NOT EXISTS prev_hash BYTEA,
+    ADD COLUMN IF NOT EXISTS hash BYTEA,
+    ADD COLUMN IF NOT EXISTS signature BYTEA,
+    ADD COLUMN IF NOT EXISTS key_id VARCHAR(16);
+
+-- +migrate StatementBegin
+CREATE OR REPLACE FUNCTION reject_audit_events_change() RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'audit_events is append-only';
+END;
+$$ LANGUAGE plpgsql;
+-- +migrate StatementEnd
+
+CREATE TRIGGER trg_audit_events_append_only
+    BEFORE UPDATE OR DELETE ON audit_events
+    FOR EACH ROW EXECUTE FUNCTION reject_audit_events_change();
+CREATE TRIGGER trg_audit_events_no_truncate
+    BEFORE TRUNCATE ON audit_events
+    FOR EACH STATEMENT EXECUTE FUNCTION reject_audit_events_change();
+
+-- +migrate Down
+DROP TRIGGER IF EXISTS trg_audit_events_no_truncate ON audit_events;
+DROP TRIGGER IF EXISTS trg_audit_events_append_only ON audit_events;
+DROP FUNCTION IF EXISTS reject_audit_events_change();
+ALTER TABLE audit_events
+    DROP COLUMN IF EXISTS key_id,
+    DROP COLUMN IF EXISTS signature,
+    DROP COLUMN IF EXISTS hash,
+    DROP COLUMN IF EXISTS prev_hash;
+ALTER TABLE audit_events
+    ADD CONSTRAINT fk_audit_events_user_id FOREIGN KEY (user_id)
+    REFERENCES users(id) ON UPDATE CASCADE ON DELETE SET NULL NOT VALID;
@@ -0,0 +1,12 @@
+-- +migrate Up
+CREATE TABLE IF NOT EXISTS credential_histories(
+    id BIGSERIAL NOT NULL PRIMARY KEY,
+    vault_id UUID NOT NULL CONSTRAINT fk_credential_histories_vault_id REFERENCES vaults(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    credential_id VARCHAR(64) NOT NULL,
+    password TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_credential_histories_credential ON credential_histories(vault_id, credential_id, id DESC);
+
+-- +migrate Down
+DROP TABLE IF EXISTS credential_histories;
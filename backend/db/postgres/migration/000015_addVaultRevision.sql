@@ -0,0 +1,13 @@
+-- +migrate Up
+ALTER TABLE vaults ADD COLUMN IF NOT EXISTS revision INTEGER NOT NULL DEFAULT 1;
+CREATE TABLE IF NOT EXISTS vault_revisions(
+    vault_id UUID NOT NULL CONSTRAINT fk_vault_revisions_vault_id REFERENCES vaults(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    revision INTEGER NOT NULL,
+    credential TEXT NOT NULL,
+    replaced_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (vault_id, revision)
+);
+
+-- +migrate Down
+DROP TABLE IF EXISTS vault_revisions;
+ALTER TABLE vaults DROP COLUMN IF EXISTS revision;
@@ -0,0 +1,7 @@
+-- +migrate Up
+ALTER TABLE vaults ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS idx_vaults_deleted_at ON vaults(deleted_at) WHERE deleted_at IS NOT NULL;
+
+-- +migrate Down
+DROP INDEX IF EXISTS idx_vaults_deleted_at;
+ALTER TABLE vaults DROP COLUMN IF EXISTS deleted_at;
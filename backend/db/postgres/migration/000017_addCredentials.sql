@@ -0,0 +1,24 @@
+-- +migrate Up
+-- the credentials of a vault used to be a single encrypted JSON map in vaults.credential,
+-- a vault blob is moved into these rows the first time the vault is unlocked
+CREATE TABLE IF NOT EXISTS credentials(
+    vault_id UUID NOT NULL CONSTRAINT fk_credentials_vault_id REFERENCES vaults(id) ON UPDATE CASCADE ON DELETE CASCADE,
+    id UUID NOT NULL,
+    data TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (vault_id, id)
+);
+-- a legacy blob sealed again as an envelope grows past the former 1023 characters
+ALTER TABLE vaults ALTER COLUMN credential TYPE TEXT;
+ALTER TABLE vaults ALTER COLUMN credential SET DEFAULT '';
+-- a revision holding a credential ID is the previous version of that credential alone,
+-- the others hold every credential the vault had
+ALTER TABLE vault_revisions ADD COLUMN IF NOT EXISTS credential_id UUID;
+
+-- +migrate Down
+-- the rows are not moved back into the vault blob, the revisions still hold the migrated blobs,
+-- the column stays TEXT as a blob sealed again may not fit the former length
+ALTER TABLE vault_revisions DROP COLUMN IF EXISTS credential_id;
+ALTER TABLE vaults ALTER COLUMN credential DROP DEFAULT;
+DROP TABLE IF EXISTS credentials;
@@ -0,0 +1,11 @@
+-- +migrate Up
+-- the history keeps the hidden custom fields of a credential as well, an empty field is the password
+ALTER TABLE credential_histories ADD COLUMN IF NOT EXISTS field VARCHAR(100) NOT NULL DEFAULT '';
+DROP INDEX IF EXISTS idx_credential_histories_credential;
+CREATE INDEX IF NOT EXISTS idx_credential_histories_credential ON credential_histories(vault_id, credential_id, field, id DESC);
+
+-- +migrate Down
+DELETE FROM credential_histories WHERE field <> '';
+DROP INDEX IF EXISTS idx_credential_histories_credential;
+ALTER TABLE credential_histories DROP COLUMN IF EXISTS field;
+CREATE INDEX IF NOT EXISTS idx_credential_histories_credential ON credential_histories(vault_id, credential_id, id DESC);
@@ -0,0 +1,8 @@
+-- +migrate Up
+-- the values of a vault sealed before version 1 are bound to the owner they were sealed by,
+-- they are sealed again bound to their purpose the next time the vault is unlocked
+UPDATE vaults SET seal_version = 0;
+ALTER TABLE vaults ALTER COLUMN seal_version SET DEFAULT 1;
+
+-- +migrate Down
+ALTER TABLE vaults ALTER COLUMN seal_version SET DEFAULT 0;
@@ -0,0 +1,9 @@
+-- +migrate Up
+-- the field of a history is the key of a secret field of the item type, or the label of a custom field
+-- when custom is set. The password keeps an empty field
+ALTER TABLE credential_histories ADD COLUMN IF NOT EXISTS custom BOOLEAN NOT NULL DEFAULT FALSE;
+UPDATE credential_histories SET custom = TRUE WHERE field <> '';
+
+-- +migrate Down
+DELETE FROM credential_histories WHERE custom = FALSE AND field <> '';
+ALTER TABLE credential_histories DROP COLUMN IF EXISTS custom;
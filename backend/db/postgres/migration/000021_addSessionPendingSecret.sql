@@ -0,0 +1,7 @@
+-- +migrate Up
+-- a session waiting for the second factor keep the replacement of a legacy session secret,
+-- which is only applied once the second factor is verified
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS pending_secret TEXT NOT NULL DEFAULT '';
+
+-- +migrate Down
+ALTER TABLE sessions DROP COLUMN IF EXISTS pending_secret;
@@ -0,0 +1,48 @@
+package rest
+
+import (
+	"github.com/Novando/pintartek/internal/passvault-service/app/service"
+	"github.com/Novando/pintartek/pkg/auth"
+	"github.com/Novando/pintartek/pkg/common/structs"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 100
+)
+
+type AuditRestController struct {
+	auditServ *service.AuditService
+}
+
+// NewAuditRestController Initialize Audit controller using REST API
+func NewAuditRestController(sa *service.AuditService) *AuditRestController {
+	return &AuditRestController{auditServ: sa}
+}
+
+// GetAll audit events of current user, paginated by the `page` (starting from 0) and `size` query
+func (c *AuditRestController) GetAll(ctx *fiber.Ctx) error {
+	tokenStr := auth.GetTokenFromBearer(ctx.Get("Authorization"))
+	if tokenStr == "" {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(structs.StdResponse{
+			Message: "ACCESS_DENIED",
+			Data:    "token not provided",
+		})
+	}
+	page := ctx.QueryInt("page", 0)
+	size := ctx.QueryInt("size", defaultAuditPageSize)
+	if page < 0 || size < 1 || size > maxAuditPageSize {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "PARAM_ERROR",
+			Data:    "page must not be negative and size must be between 1 and 100",
+		})
+	}
+	res, code := c.auditServ.GetAll(tokenStr, structs.StdPagination{Page: int32(page), Size: int32(size)})
+	return ctx.Status(code).JSON(res)
+}
+
+// clientOf describe the client sending the request for the audit log
+func clientOf(ctx *fiber.Ctx) structs.StdClient {
+	return structs.StdClient{IP: ctx.IP(), UserAgent: ctx.Get("User-Agent")}
+}
@@ -0,0 +1,56 @@
+package rest
+
+import (
+	"encoding/json"
+	"github.com/Novando/pintartek/internal/passvault-service/app/service"
+	"github.com/Novando/pintartek/pkg/auth"
+	"github.com/gofiber/fiber/v2"
+)
+
+type RateLimitRestController struct {
+	rateLimitServ *service.RateLimitService
+	userServ      *service.UserService
+}
+
+// NewRateLimitRestController Initialize the middleware limiting the attempts using REST API
+func NewRateLimitRestController(sr *service.RateLimitService, su *service.UserService) *RateLimitRestController {
+	return &RateLimitRestController{rateLimitServ: sr, userServ: su}
+}
+
+// limitedAccount is the part of the payload identifying whom an attempt is made for
+type limitedAccount struct {
+	Email    string `json:"email"`
+	MfaToken string `json:"mfaToken"`
+}
+
+// Protect limit the attempts of an IP and of an account, whether they succeed or not, and delay the account
+// whose credentials keep failing from the IP. The account is the email of the payload, the email of the user
+// its MFA token was given to when completing a login, or the owner of the session of an authenticated request.
+// The failed attempts are forgotten by the user service once a login completes
+func (c *RateLimitRestController) Protect(ctx *fiber.Ctx) error {
+	var payload limitedAccount
+	// an invalid payload is left for the handler to reject
+	_ = json.Unmarshal(ctx.Body(), &payload)
+	account := payload.Email
+	if account == "" && payload.MfaToken != "" {
+		account = c.userServ.MfaAccount(payload.MfaToken)
+	}
+	if token := auth.GetTokenFromBearer(ctx.Get("Authorization")); account == "" && token != "" {
+		account = c.userServ.SessionAccount(token)
+	}
+	retryAfter, res, code := c.rateLimitServ.Attempt(ctx.IP(), account)
+	if code != fiber.StatusOK {
+		if code == fiber.StatusTooManyRequests {
+			ctx.Set(fiber.HeaderRetryAfter, service.RetryAfterSeconds(retryAfter))
+		}
+		return ctx.Status(code).JSON(res)
+	}
+	if err := ctx.Next(); err != nil {
+		return err
+	}
+	// the attempt is already counted, a wrong credential also delay the account for the IP
+	if ctx.Response().StatusCode() == fiber.StatusUnauthorized {
+		c.rateLimitServ.Fail(ctx.IP(), account)
+	}
+	return nil
+}
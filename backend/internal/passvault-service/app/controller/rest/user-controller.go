@@ -33,6 +33,8 @@ func (c *UserRestController) Register(ctx *fiber.Ctx) error {
 			Data:    err.Error(),
 		})
 	}
+	params.IP = ctx.IP()
+	params.UserAgent = ctx.Get("User-Agent")
 	res, code := c.userServ.Register(params)
 	return ctx.Status(code).JSON(res)
 }
@@ -52,13 +54,312 @@ func (c *UserRestController) Login(ctx *fiber.Ctx) error {
 			Data:    err.Error(),
 		})
 	}
+	params.IP = ctx.IP()
+	params.UserAgent = ctx.Get("User-Agent")
 	res, code := c.userServ.Login(params)
 	return ctx.Status(code).JSON(res)
 }
 
+// Recover the entry point for resetting password using the private key
+func (c *UserRestController) Recover(ctx *fiber.Ctx) error {
+	var params user.RecoverRequest
+	if err := ctx.BodyParser(&params); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "PAYLOAD_ERROR",
+			Data:    err.Error(),
+		})
+	}
+	if err := validator.Validate(params); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "VALIDATION_ERROR",
+			Data:    err.Error(),
+		})
+	}
+	params.IP = ctx.IP()
+	params.UserAgent = ctx.Get("User-Agent")
+	res, code := c.userServ.Recover(params)
+	return ctx.Status(code).JSON(res)
+}
+
 // Logout delete the session for current user
 func (c *UserRestController) Logout(ctx *fiber.Ctx) error {
 	tokenStr := auth.GetTokenFromBearer(ctx.Get("Authorization"))
-	res, code := c.userServ.Logout(tokenStr)
+	res, code := c.userServ.Logout(tokenStr, clientOf(ctx))
+	return ctx.Status(code).JSON(res)
+}
+
+// ChangePassword the entry point for replacing the master password of current user
+func (c *UserRestController) ChangePassword(ctx *fiber.Ctx) error {
+	var params user.ChangePasswordRequest
+	tokenStr := auth.GetTokenFromBearer(ctx.Get("Authorization"))
+	if tokenStr == "" {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(structs.StdResponse{
+			Message: "ACCESS_DENIED",
+			Data:    "token not provided",
+		})
+	}
+	if err := ctx.BodyParser(&params); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "PAYLOAD_ERROR",
+			Data:    err.Error(),
+		})
+	}
+	if err := validator.Validate(params); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "VALIDATION_ERROR",
+			Data:    err.Error(),
+		})
+	}
+	res, code := c.userServ.ChangePassword(tokenStr, params, clientOf(ctx))
+	return ctx.Status(code).JSON(res)
+}
+
+// LoginMfa the entry point for completing the login using a second factor
+func (c *UserRestController) LoginMfa(ctx *fiber.Ctx) error {
+	var params user.LoginMfaRequest
+	if err := ctx.BodyParser(&params); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "PAYLOAD_ERROR",
+			Data:    err.Error(),
+		})
+	}
+	if err := validator.Validate(params); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "VALIDATION_ERROR",
+			Data:    err.Error(),
+		})
+	}
+	params.IP = ctx.IP()
+	params.UserAgent = ctx.Get("User-Agent")
+	res, code := c.userServ.LoginMfa(params)
+	return ctx.Status(code).JSON(res)
+}
+
+// SetupTotp the entry point for generating a TOTP secret for current user
+func (c *UserRestController) SetupTotp(ctx *fiber.Ctx) error {
+	tokenStr := auth.GetTokenFromBearer(ctx.Get("Authorization"))
+	if tokenStr == "" {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(structs.StdResponse{
+			Message: "ACCESS_DENIED",
+			Data:    "token not provided",
+		})
+	}
+	res, code := c.userServ.SetupTotp(tokenStr)
+	return ctx.Status(code).JSON(res)
+}
+
+// ConfirmTotp the entry point for enabling the TOTP of current user
+func (c *UserRestController) ConfirmTotp(ctx *fiber.Ctx) error {
+	var params user.TotpConfirmRequest
+	tokenStr := auth.GetTokenFromBearer(ctx.Get("Authorization"))
+	if tokenStr == "" {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(structs.StdResponse{
+			Message: "ACCESS_DENIED",
+			Data:    "token not provided",
+		})
+	}
+	if err := ctx.BodyParser(&params); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "PAYLOAD_ERROR",
+			Data:    err.Error(),
+		})
+	}
+	if err := validator.Validate(params); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "VALIDATION_ERROR",
+			Data:    err.Error(),
+		})
+	}
+	res, code := c.userServ.ConfirmTotp(tokenStr, params, clientOf(ctx))
+	return ctx.Status(code).JSON(res)
+}
+
+// DisableTotp the entry point for disabling the TOTP of current user
+func (c *UserRestController) DisableTotp(ctx *fiber.Ctx) error {
+	var params user.TotpDisableRequest
+	tokenStr := auth.GetTokenFromBearer(ctx.Get("Authorization"))
+	if tokenStr == "" {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(structs.StdResponse{
+			Message: "ACCESS_DENIED",
+			Data:    "token not provided",
+		})
+	}
+	if err := ctx.BodyParser(&params); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "PAYLOAD_ERROR",
+			Data:    err.Error(),
+		})
+	}
+	if err := validator.Validate(params); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "VALIDATION_ERROR",
+			Data:    err.Error(),
+		})
+	}
+	res, code := c.userServ.DisableTotp(tokenStr, params, clientOf(ctx))
+	return ctx.Status(code).JSON(res)
+}
+
+// BeginWebauthnRegistration the entry point for registering an authenticator to current user
+func (c *UserRestController) BeginWebauthnRegistration(ctx *fiber.Ctx) error {
+	tokenStr := auth.GetTokenFromBearer(ctx.Get("Authorization"))
+	if tokenStr == "" {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(structs.StdResponse{
+			Message: "ACCESS_DENIED",
+			Data:    "token not provided",
+		})
+	}
+	res, code := c.userServ.BeginWebauthnRegistration(tokenStr)
+	return ctx.Status(code).JSON(res)
+}
+
+// FinishWebauthnRegistration the entry point for storing the authenticator of current user
+func (c *UserRestController) FinishWebauthnRegistration(ctx *fiber.Ctx) error {
+	var params user.WebauthnRegisterRequest
+	tokenStr := auth.GetTokenFromBearer(ctx.Get("Authorization"))
+	if tokenStr == "" {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(structs.StdResponse{
+			Message: "ACCESS_DENIED",
+			Data:    "token not provided",
+		})
+	}
+	if err := ctx.BodyParser(&params); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "PAYLOAD_ERROR",
+			Data:    err.Error(),
+		})
+	}
+	if err := validator.Validate(params); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "VALIDATION_ERROR",
+			Data:    err.Error(),
+		})
+	}
+	res, code := c.userServ.FinishWebauthnRegistration(tokenStr, params, clientOf(ctx))
+	return ctx.Status(code).JSON(res)
+}
+
+// GetAllWebauthn the entry point for listing the authenticators of current user
+func (c *UserRestController) GetAllWebauthn(ctx *fiber.Ctx) error {
+	tokenStr := auth.GetTokenFromBearer(ctx.Get("Authorization"))
+	if tokenStr == "" {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(structs.StdResponse{
+			Message: "ACCESS_DENIED",
+			Data:    "token not provided",
+		})
+	}
+	res, code := c.userServ.GetAllWebauthn(tokenStr)
+	return ctx.Status(code).JSON(res)
+}
+
+// DeleteWebauthn the entry point for removing an authenticator of current user
+func (c *UserRestController) DeleteWebauthn(ctx *fiber.Ctx) error {
+	tokenStr := auth.GetTokenFromBearer(ctx.Get("Authorization"))
+	if tokenStr == "" {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(structs.StdResponse{
+			Message: "ACCESS_DENIED",
+			Data:    "token not provided",
+		})
+	}
+	credentialId := ctx.Params("credentialId")
+	if credentialId == "" {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "PARAM_ERROR",
+			Data:    "credential ID not provided",
+		})
+	}
+	var params user.WebauthnDeleteRequest
+	if err := ctx.BodyParser(&params); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "PAYLOAD_ERROR",
+			Data:    err.Error(),
+		})
+	}
+	if err := validator.Validate(params); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "VALIDATION_ERROR",
+			Data:    err.Error(),
+		})
+	}
+	res, code := c.userServ.DeleteWebauthn(tokenStr, credentialId, params, clientOf(ctx))
+	return ctx.Status(code).JSON(res)
+}
+
+// BeginWebauthnAssertion the entry point for asserting an authenticator of current user,
+// confirming a change of the authenticators
+func (c *UserRestController) BeginWebauthnAssertion(ctx *fiber.Ctx) error {
+	tokenStr := auth.GetTokenFromBearer(ctx.Get("Authorization"))
+	if tokenStr == "" {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(structs.StdResponse{
+			Message: "ACCESS_DENIED",
+			Data:    "token not provided",
+		})
+	}
+	res, code := c.userServ.BeginWebauthnAssertion(tokenStr)
+	return ctx.Status(code).JSON(res)
+}
+
+// BeginWebauthnLogin the entry point for asserting an authenticator as the second factor
+func (c *UserRestController) BeginWebauthnLogin(ctx *fiber.Ctx) error {
+	var params user.WebauthnLoginRequest
+	if err := ctx.BodyParser(&params); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "PAYLOAD_ERROR",
+			Data:    err.Error(),
+		})
+	}
+	if err := validator.Validate(params); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "VALIDATION_ERROR",
+			Data:    err.Error(),
+		})
+	}
+	res, code := c.userServ.BeginWebauthnLogin(params)
+	return ctx.Status(code).JSON(res)
+}
+
+// GetAllSession the entry point for listing the active sessions of current user
+func (c *UserRestController) GetAllSession(ctx *fiber.Ctx) error {
+	tokenStr := auth.GetTokenFromBearer(ctx.Get("Authorization"))
+	if tokenStr == "" {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(structs.StdResponse{
+			Message: "ACCESS_DENIED",
+			Data:    "token not provided",
+		})
+	}
+	res, code := c.userServ.GetAllSession(tokenStr)
+	return ctx.Status(code).JSON(res)
+}
+
+// DeleteSession the entry point for revoking a session of current user
+func (c *UserRestController) DeleteSession(ctx *fiber.Ctx) error {
+	tokenStr := auth.GetTokenFromBearer(ctx.Get("Authorization"))
+	if tokenStr == "" {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(structs.StdResponse{
+			Message: "ACCESS_DENIED",
+			Data:    "token not provided",
+		})
+	}
+	sessionId := ctx.Params("sessionId")
+	if sessionId == "" {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "PARAM_ERROR",
+			Data:    "session ID not provided",
+		})
+	}
+	res, code := c.userServ.DeleteSession(tokenStr, sessionId, clientOf(ctx))
+	return ctx.Status(code).JSON(res)
+}
+
+// DeleteAllSession the entry point for logging current user out everywhere
+func (c *UserRestController) DeleteAllSession(ctx *fiber.Ctx) error {
+	tokenStr := auth.GetTokenFromBearer(ctx.Get("Authorization"))
+	if tokenStr == "" {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(structs.StdResponse{
+			Message: "ACCESS_DENIED",
+			Data:    "token not provided",
+		})
+	}
+	res, code := c.userServ.DeleteAllSession(tokenStr, clientOf(ctx))
 	return ctx.Status(code).JSON(res)
 }
@@ -18,6 +18,23 @@ func NewVaultRestController(sv *service.VaultService) *VaultRestController {
 	return &VaultRestController{vaultServ: sv}
 }
 
+// RotateToken replace the session token after a vault request succeed,
+// the new token is sent back in the auth.SessionTokenHeader header
+func (c *VaultRestController) RotateToken(ctx *fiber.Ctx) error {
+	if err := ctx.Next(); err != nil {
+		return err
+	}
+	tokenStr := auth.GetTokenFromBearer(ctx.Get("Authorization"))
+	if tokenStr == "" || ctx.Response().StatusCode() >= fiber.StatusBadRequest {
+		return nil
+	}
+	res, code := c.vaultServ.RotateSession(tokenStr)
+	if newToken, ok := res.Data.(string); ok && code == fiber.StatusOK {
+		ctx.Set(auth.SessionTokenHeader, newToken)
+	}
+	return nil
+}
+
 // Create vault for storing credential
 func (c *VaultRestController) Create(ctx *fiber.Ctx) error {
 	var params vault.VaultRequest
@@ -40,7 +57,13 @@ func (c *VaultRestController) Create(ctx *fiber.Ctx) error {
 			Data:    err.Error(),
 		})
 	}
-	res, code := c.vaultServ.Create(tokenStr, params)
+	res, code := c.vaultServ.Create(tokenStr, params, clientOf(ctx))
+	return ctx.Status(code).JSON(res)
+}
+
+// GetAllItemType list the item types a vault can hold
+func (c *VaultRestController) GetAllItemType(ctx *fiber.Ctx) error {
+	res, code := c.vaultServ.GetAllItemType()
 	return ctx.Status(code).JSON(res)
 }
 
@@ -66,7 +89,54 @@ func (c *VaultRestController) GetOne(ctx *fiber.Ctx) error {
 			Data:    "token not provided",
 		})
 	}
-	res, code := c.vaultServ.GetOne(tokenStr, ctx.Params("vaultId"))
+	res, code := c.vaultServ.GetOne(tokenStr, ctx.Params("vaultId"), clientOf(ctx))
+	return ctx.Status(code).JSON(res)
+}
+
+// GetAllCredential list the credentials of a vault without their secrets
+func (c *VaultRestController) GetAllCredential(ctx *fiber.Ctx) error {
+	tokenStr := auth.GetTokenFromBearer(ctx.Get("Authorization"))
+	if tokenStr == "" {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(structs.StdResponse{
+			Message: "ACCESS_DENIED",
+			Data:    "token not provided",
+		})
+	}
+	vaultId := ctx.Params("vaultId")
+	if vaultId == "" {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "PARAM_ERROR",
+			Data:    "Path Param for vaultID is required",
+		})
+	}
+	res, code := c.vaultServ.GetAllCredential(tokenStr, vaultId)
+	return ctx.Status(code).JSON(res)
+}
+
+// GetCredential decrypt a single credential of a vault
+func (c *VaultRestController) GetCredential(ctx *fiber.Ctx) error {
+	tokenStr := auth.GetTokenFromBearer(ctx.Get("Authorization"))
+	if tokenStr == "" {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(structs.StdResponse{
+			Message: "ACCESS_DENIED",
+			Data:    "token not provided",
+		})
+	}
+	vaultId := ctx.Params("vaultId")
+	if vaultId == "" {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "PARAM_ERROR",
+			Data:    "Path Param for vaultID is required",
+		})
+	}
+	credentialId := ctx.Params("credentialId")
+	if credentialId == "" {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "PARAM_ERROR",
+			Data:    "Path Param for credentialId is required",
+		})
+	}
+	res, code := c.vaultServ.GetCredential(tokenStr, vaultId, credentialId, clientOf(ctx))
 	return ctx.Status(code).JSON(res)
 }
 
@@ -99,7 +169,7 @@ func (c *VaultRestController) UpdateVaultName(ctx *fiber.Ctx) error {
 			Data:    "Path Param for vaultID is required",
 		})
 	}
-	res, code := c.vaultServ.UpdateVaultName(tokenStr, vaultId, params)
+	res, code := c.vaultServ.UpdateVaultName(tokenStr, vaultId, params, clientOf(ctx))
 	return ctx.Status(code).JSON(res)
 }
 
@@ -139,7 +209,7 @@ func (c *VaultRestController) UpdateCredential(ctx *fiber.Ctx) error {
 			Data:    "Path Param for credentialId is required",
 		})
 	}
-	res, code := c.vaultServ.UpdateCredential(tokenStr, vaultId, credentialId, params)
+	res, code := c.vaultServ.UpdateCredential(tokenStr, vaultId, credentialId, params, clientOf(ctx))
 	return ctx.Status(code).JSON(res)
 }
 
@@ -172,7 +242,7 @@ func (c *VaultRestController) CreateCredential(ctx *fiber.Ctx) error {
 			Data:    "Path Param for vaultID is required",
 		})
 	}
-	res, code := c.vaultServ.CreateCredential(tokenStr, vaultId, params)
+	res, code := c.vaultServ.CreateCredential(tokenStr, vaultId, params, clientOf(ctx))
 	return ctx.Status(code).JSON(res)
 }
 
@@ -192,7 +262,7 @@ func (c *VaultRestController) Delete(ctx *fiber.Ctx) error {
 			Data:    "Path Param for vaultID is required",
 		})
 	}
-	res, code := c.vaultServ.Delete(tokenStr, vaultId)
+	res, code := c.vaultServ.Delete(tokenStr, vaultId, clientOf(ctx))
 	return ctx.Status(code).JSON(res)
 }
 
@@ -219,6 +289,313 @@ func (c *VaultRestController) DeleteCredential(ctx *fiber.Ctx) error {
 			Data:    "Path Param for credentialId is required",
 		})
 	}
-	res, code := c.vaultServ.DeleteCredential(tokenStr, vaultId, credentialId)
+	res, code := c.vaultServ.DeleteCredential(tokenStr, vaultId, credentialId, clientOf(ctx))
+	return ctx.Status(code).JSON(res)
+}
+
+// GetAllCredentialHistory list the passwords a credential had before
+func (c *VaultRestController) GetAllCredentialHistory(ctx *fiber.Ctx) error {
+	tokenStr := auth.GetTokenFromBearer(ctx.Get("Authorization"))
+	if tokenStr == "" {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(structs.StdResponse{
+			Message: "ACCESS_DENIED",
+			Data:    "token not provided",
+		})
+	}
+	vaultId := ctx.Params("vaultId")
+	if vaultId == "" {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "PARAM_ERROR",
+			Data:    "Path Param for vaultID is required",
+		})
+	}
+	credentialId := ctx.Params("credentialId")
+	if credentialId == "" {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "PARAM_ERROR",
+			Data:    "Path Param for credentialId is required",
+		})
+	}
+	res, code := c.vaultServ.GetAllCredentialHistory(tokenStr, vaultId, credentialId, clientOf(ctx))
+	return ctx.Status(code).JSON(res)
+}
+
+// RestoreCredentialHistory put back a password a credential had before
+func (c *VaultRestController) RestoreCredentialHistory(ctx *fiber.Ctx) error {
+	tokenStr := auth.GetTokenFromBearer(ctx.Get("Authorization"))
+	if tokenStr == "" {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(structs.StdResponse{
+			Message: "ACCESS_DENIED",
+			Data:    "token not provided",
+		})
+	}
+	vaultId := ctx.Params("vaultId")
+	if vaultId == "" {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "PARAM_ERROR",
+			Data:    "Path Param for vaultID is required",
+		})
+	}
+	credentialId := ctx.Params("credentialId")
+	if credentialId == "" {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "PARAM_ERROR",
+			Data:    "Path Param for credentialId is required",
+		})
+	}
+	historyId := ctx.Params("historyId")
+	if historyId == "" {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "PARAM_ERROR",
+			Data:    "Path Param for historyId is required",
+		})
+	}
+	res, code := c.vaultServ.RestoreCredentialHistory(tokenStr, vaultId, credentialId, historyId, clientOf(ctx))
+	return ctx.Status(code).JSON(res)
+}
+
+// InviteMember share a vault to another user by email
+func (c *VaultRestController) InviteMember(ctx *fiber.Ctx) error {
+	var params vault.MemberInviteRequest
+	tokenStr := auth.GetTokenFromBearer(ctx.Get("Authorization"))
+	if tokenStr == "" {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(structs.StdResponse{
+			Message: "ACCESS_DENIED",
+			Data:    "token not provided",
+		})
+	}
+	if err := ctx.BodyParser(&params); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "PAYLOAD_ERROR",
+			Data:    err.Error(),
+		})
+	}
+	if err := validator.Validate(params); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "VALIDATION_ERROR",
+			Data:    err.Error(),
+		})
+	}
+	vaultId := ctx.Params("vaultId")
+	if vaultId == "" {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "PARAM_ERROR",
+			Data:    "Path Param for vaultID is required",
+		})
+	}
+	res, code := c.vaultServ.InviteMember(tokenStr, vaultId, params, clientOf(ctx))
+	return ctx.Status(code).JSON(res)
+}
+
+// GetAllMember list the members of a vault
+func (c *VaultRestController) GetAllMember(ctx *fiber.Ctx) error {
+	tokenStr := auth.GetTokenFromBearer(ctx.Get("Authorization"))
+	if tokenStr == "" {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(structs.StdResponse{
+			Message: "ACCESS_DENIED",
+			Data:    "token not provided",
+		})
+	}
+	vaultId := ctx.Params("vaultId")
+	if vaultId == "" {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "PARAM_ERROR",
+			Data:    "Path Param for vaultID is required",
+		})
+	}
+	res, code := c.vaultServ.GetAllMember(tokenStr, vaultId)
+	return ctx.Status(code).JSON(res)
+}
+
+// RemoveMember revoke the access of a member to a vault
+func (c *VaultRestController) RemoveMember(ctx *fiber.Ctx) error {
+	tokenStr := auth.GetTokenFromBearer(ctx.Get("Authorization"))
+	if tokenStr == "" {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(structs.StdResponse{
+			Message: "ACCESS_DENIED",
+			Data:    "token not provided",
+		})
+	}
+	vaultId := ctx.Params("vaultId")
+	if vaultId == "" {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "PARAM_ERROR",
+			Data:    "Path Param for vaultID is required",
+		})
+	}
+	userId := ctx.Params("userId")
+	if userId == "" {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "PARAM_ERROR",
+			Data:    "Path Param for userId is required",
+		})
+	}
+	res, code := c.vaultServ.RemoveMember(tokenStr, vaultId, userId, clientOf(ctx))
+	return ctx.Status(code).JSON(res)
+}
+
+// UpdateMemberRole change the role of a member in a vault
+func (c *VaultRestController) UpdateMemberRole(ctx *fiber.Ctx) error {
+	var params vault.MemberRoleRequest
+	tokenStr := auth.GetTokenFromBearer(ctx.Get("Authorization"))
+	if tokenStr == "" {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(structs.StdResponse{
+			Message: "ACCESS_DENIED",
+			Data:    "token not provided",
+		})
+	}
+	if err := ctx.BodyParser(&params); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "PAYLOAD_ERROR",
+			Data:    err.Error(),
+		})
+	}
+	if err := validator.Validate(params); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "VALIDATION_ERROR",
+			Data:    err.Error(),
+		})
+	}
+	vaultId := ctx.Params("vaultId")
+	if vaultId == "" {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "PARAM_ERROR",
+			Data:    "Path Param for vaultID is required",
+		})
+	}
+	userId := ctx.Params("userId")
+	if userId == "" {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "PARAM_ERROR",
+			Data:    "Path Param for userId is required",
+		})
+	}
+	res, code := c.vaultServ.UpdateMemberRole(tokenStr, vaultId, userId, params, clientOf(ctx))
+	return ctx.Status(code).JSON(res)
+}
+
+// AcceptInvitation accept a shared vault using the private key of current user
+func (c *VaultRestController) AcceptInvitation(ctx *fiber.Ctx) error {
+	var params vault.MemberAcceptRequest
+	tokenStr := auth.GetTokenFromBearer(ctx.Get("Authorization"))
+	if tokenStr == "" {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(structs.StdResponse{
+			Message: "ACCESS_DENIED",
+			Data:    "token not provided",
+		})
+	}
+	if err := ctx.BodyParser(&params); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "PAYLOAD_ERROR",
+			Data:    err.Error(),
+		})
+	}
+	if err := validator.Validate(params); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "VALIDATION_ERROR",
+			Data:    err.Error(),
+		})
+	}
+	vaultId := ctx.Params("vaultId")
+	if vaultId == "" {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "PARAM_ERROR",
+			Data:    "Path Param for vaultID is required",
+		})
+	}
+	res, code := c.vaultServ.AcceptInvitation(tokenStr, vaultId, params, clientOf(ctx))
+	return ctx.Status(code).JSON(res)
+}
+
+// GetAllRevision list the credentials a vault had before
+func (c *VaultRestController) GetAllRevision(ctx *fiber.Ctx) error {
+	tokenStr := auth.GetTokenFromBearer(ctx.Get("Authorization"))
+	if tokenStr == "" {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(structs.StdResponse{
+			Message: "ACCESS_DENIED",
+			Data:    "token not provided",
+		})
+	}
+	vaultId := ctx.Params("vaultId")
+	if vaultId == "" {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "PARAM_ERROR",
+			Data:    "Path Param for vaultID is required",
+		})
+	}
+	res, code := c.vaultServ.GetAllRevision(tokenStr, vaultId)
+	return ctx.Status(code).JSON(res)
+}
+
+// RestoreRevision put back the credential a vault had at a revision
+func (c *VaultRestController) RestoreRevision(ctx *fiber.Ctx) error {
+	tokenStr := auth.GetTokenFromBearer(ctx.Get("Authorization"))
+	if tokenStr == "" {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(structs.StdResponse{
+			Message: "ACCESS_DENIED",
+			Data:    "token not provided",
+		})
+	}
+	vaultId := ctx.Params("vaultId")
+	if vaultId == "" {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "PARAM_ERROR",
+			Data:    "Path Param for vaultID is required",
+		})
+	}
+	revision := ctx.Params("revision")
+	if revision == "" {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "PARAM_ERROR",
+			Data:    "Path Param for revision is required",
+		})
+	}
+	res, code := c.vaultServ.RestoreRevision(tokenStr, vaultId, revision, clientOf(ctx))
+	return ctx.Status(code).JSON(res)
+}
+
+// GetAllTrash list the vaults in the trash of current user
+func (c *VaultRestController) GetAllTrash(ctx *fiber.Ctx) error {
+	tokenStr := auth.GetTokenFromBearer(ctx.Get("Authorization"))
+	if tokenStr == "" {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(structs.StdResponse{
+			Message: "ACCESS_DENIED",
+			Data:    "token not provided",
+		})
+	}
+	res, code := c.vaultServ.GetAllTrash(tokenStr)
+	return ctx.Status(code).JSON(res)
+}
+
+// RestoreTrash take a vault out of the trash
+func (c *VaultRestController) RestoreTrash(ctx *fiber.Ctx) error {
+	tokenStr := auth.GetTokenFromBearer(ctx.Get("Authorization"))
+	if tokenStr == "" {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(structs.StdResponse{
+			Message: "ACCESS_DENIED",
+			Data:    "token not provided",
+		})
+	}
+	vaultId := ctx.Params("vaultId")
+	if vaultId == "" {
+		return ctx.Status(fiber.StatusBadRequest).JSON(structs.StdResponse{
+			Message: "PARAM_ERROR",
+			Data:    "Path Param for vaultID is required",
+		})
+	}
+	res, code := c.vaultServ.RestoreTrash(tokenStr, vaultId, clientOf(ctx))
+	return ctx.Status(code).JSON(res)
+}
+
+// EmptyTrash permanently delete the vaults in the trash of current user
+func (c *VaultRestController) EmptyTrash(ctx *fiber.Ctx) error {
+	tokenStr := auth.GetTokenFromBearer(ctx.Get("Authorization"))
+	if tokenStr == "" {
+		return ctx.Status(fiber.StatusUnauthorized).JSON(structs.StdResponse{
+			Message: "ACCESS_DENIED",
+			Data:    "token not provided",
+		})
+	}
+	res, code := c.vaultServ.EmptyTrash(tokenStr, clientOf(ctx))
 	return ctx.Status(code).JSON(res)
 }
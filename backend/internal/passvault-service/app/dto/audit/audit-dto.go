@@ -0,0 +1,26 @@
+package audit
+
+import "time"
+
+type AuditResponse struct {
+	ID        int64     `json:"id"`
+	Action    string    `json:"action"`
+	VaultID   string    `json:"vaultId,omitempty"`
+	TargetID  string    `json:"targetId,omitempty"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"userAgent"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// VerifyResponse is the result of walking the audit chain. The head hash should be kept outside
+// of the database, the chain alone can not tell that its latest events were removed
+type VerifyResponse struct {
+	Verified bool `json:"verified"`
+	// Checked is the number of sealed events, Unsealed the events recorded before the chain started
+	Checked  int64  `json:"checked"`
+	Unsealed int64  `json:"unsealed"`
+	HeadID   int64  `json:"headId,omitempty"`
+	HeadHash string `json:"headHash,omitempty"`
+	BrokenID int64  `json:"brokenId,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
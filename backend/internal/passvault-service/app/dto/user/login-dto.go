@@ -3,6 +3,11 @@ package user
 type LoginRequest struct {
 	Email    string `json:"email" validate:"required,email"`
 	Password string `json:"password" validate:"required,min=8"`
+	// RememberDevice request a long-lived session
+	RememberDevice bool `json:"rememberDevice"`
+	// IP and UserAgent describe the client, they are filled by the controller
+	IP        string `json:"-"`
+	UserAgent string `json:"-"`
 }
 
 type LoginResponse struct {
@@ -0,0 +1,7 @@
+package user
+
+type ChangePasswordRequest struct {
+	OldPassword     string `json:"oldPassword" validate:"required"`
+	NewPassword     string `json:"newPassword" validate:"required,min=8,nefield=OldPassword"`
+	ConfirmPassword string `json:"confirmPassword" validate:"required,eqfield=NewPassword"`
+}
@@ -0,0 +1,18 @@
+package user
+
+import "github.com/Novando/pintartek/pkg/webauthn"
+
+type RecoverRequest struct {
+	Email           string `json:"email" validate:"required,email"`
+	PrivateKey      string `json:"privateKey" validate:"required,hexadecimal"`
+	NewPassword     string `json:"newPassword" validate:"required,min=8"`
+	ConfirmPassword string `json:"confirmPassword" validate:"required,eqfield=NewPassword"`
+	// MfaToken is given by the previous attempt answered by MFA_REQUIRED, together with one of the second factors
+	MfaToken     string                      `json:"mfaToken"`
+	Code         string                      `json:"code" validate:"omitempty,numeric,len=6"`
+	RecoveryCode string                      `json:"recoveryCode"`
+	Assertion    *webauthn.AssertionResponse `json:"assertion"`
+	// IP and UserAgent describe the client, they are filled by the controller
+	IP        string `json:"-"`
+	UserAgent string `json:"-"`
+}
@@ -5,6 +5,9 @@ type RegisterRequest struct {
 	Email           string `json:"email" validate:"required,email"`
 	Password        string `json:"password" validate:"required,min=8"`
 	ConfirmPassword string `json:"confirmPassword" validate:"required,eqfield=Password"`
+	// IP and UserAgent describe the client, they are filled by the controller
+	IP        string `json:"-"`
+	UserAgent string `json:"-"`
 }
 
 type RegisterResponse struct {
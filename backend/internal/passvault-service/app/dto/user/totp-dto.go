@@ -0,0 +1,40 @@
+package user
+
+import "github.com/Novando/pintartek/pkg/webauthn"
+
+type LoginMfaRequest struct {
+	MfaToken     string                      `json:"mfaToken" validate:"required"`
+	Code         string                      `json:"code" validate:"required_without_all=RecoveryCode Assertion,omitempty,numeric,len=6"`
+	RecoveryCode string                      `json:"recoveryCode" validate:"required_without_all=Code Assertion"`
+	Assertion    *webauthn.AssertionResponse `json:"assertion" validate:"required_without_all=Code RecoveryCode"`
+	// RememberDevice request a long-lived session
+	RememberDevice bool `json:"rememberDevice"`
+	// IP and UserAgent describe the client, they are filled by the controller
+	IP        string `json:"-"`
+	UserAgent string `json:"-"`
+}
+
+type LoginMfaResponse struct {
+	MfaToken string `json:"mfaToken"`
+	// Methods list the second factors enabled by the user, `totp`, `recovery` and `webauthn`
+	Methods []string `json:"methods"`
+}
+
+type TotpSetupResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioningUri"`
+}
+
+type TotpConfirmRequest struct {
+	Code string `json:"code" validate:"required,numeric,len=6"`
+}
+
+type TotpConfirmResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+type TotpDisableRequest struct {
+	Password     string `json:"password" validate:"required"`
+	Code         string `json:"code" validate:"required_without=RecoveryCode,omitempty,numeric,len=6"`
+	RecoveryCode string `json:"recoveryCode" validate:"required_without=Code"`
+}
@@ -0,0 +1,36 @@
+package user
+
+import (
+	"github.com/Novando/pintartek/pkg/webauthn"
+	"time"
+)
+
+// WebauthnReauthRequest confirm a change of the authenticators like disabling TOTP, using the password
+// and a second factor. The second factor is only required once the user has one enabled
+type WebauthnReauthRequest struct {
+	Password     string                      `json:"password" validate:"required"`
+	Code         string                      `json:"code" validate:"omitempty,numeric,len=6"`
+	RecoveryCode string                      `json:"recoveryCode"`
+	Assertion    *webauthn.AssertionResponse `json:"assertion"`
+}
+
+type WebauthnRegisterRequest struct {
+	Name       string                        `json:"name" validate:"required,max=255"`
+	Credential webauthn.RegistrationResponse `json:"credential"`
+	WebauthnReauthRequest
+}
+
+type WebauthnDeleteRequest struct {
+	WebauthnReauthRequest
+}
+
+type WebauthnLoginRequest struct {
+	MfaToken string `json:"mfaToken" validate:"required"`
+}
+
+type WebauthnCredentialResponse struct {
+	ID         uint64     `json:"id"`
+	Name       string     `json:"name"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+}
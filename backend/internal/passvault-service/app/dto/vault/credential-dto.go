@@ -1,9 +1,72 @@
 package vault
 
+import "time"
+
+// Credential is an item of a vault, its fields depend on its Type. An item without a Type is a login
 type Credential struct {
-	Name       string `json:"name" validate:"required"`
-	Password   string `json:"password" validate:"required"`
-	Credential string `json:"credential"`
-	Url        string `json:"url"`
-	Note       string `json:"note"`
+	Type string `json:"type,omitempty" validate:"omitempty,oneof=login note card identity ssh_key api_token"`
+	Name string `json:"name" validate:"required"`
+	Note string `json:"note,omitempty" validate:"required_if=Type note"`
+	// login, the URL is shared with the API token
+	Credential string `json:"credential,omitempty"`
+	Password   string `json:"password,omitempty" validate:"required_if=Type login,required_without=Type"`
+	Url        string `json:"url,omitempty"`
+	// payment card, the expiry is formatted as MM/YY
+	CardholderName string `json:"cardholderName,omitempty"`
+	CardNumber     string `json:"cardNumber,omitempty" validate:"required_if=Type card"`
+	CardExpiry     string `json:"cardExpiry,omitempty" validate:"omitempty,datetime=01/06"`
+	CardCvv        string `json:"cardCvv,omitempty"`
+	// identity
+	FullName string `json:"fullName,omitempty" validate:"required_if=Type identity"`
+	Email    string `json:"email,omitempty" validate:"omitempty,email"`
+	Phone    string `json:"phone,omitempty"`
+	Address  string `json:"address,omitempty"`
+	// SSH key
+	PrivateKey string `json:"privateKey,omitempty" validate:"required_if=Type ssh_key"`
+	PublicKey  string `json:"publicKey,omitempty"`
+	Passphrase string `json:"passphrase,omitempty"`
+	// API token
+	Token string `json:"token,omitempty" validate:"required_if=Type api_token"`
+	// Fields is shown after the fields of the type, in the order it is sent
+	Fields []CustomField `json:"fields,omitempty" validate:"omitempty,max=50,unique=Label,dive"`
+}
+
+// CustomField is a field defined by the user on a credential, identified by its label
+type CustomField struct {
+	Label string `json:"label" validate:"required,max=100"`
+	Type  string `json:"type" validate:"required,oneof=text hidden boolean url totp"`
+	Value string `json:"value"`
+}
+
+type CredentialResponse struct {
+	ID string `json:"id"`
+	Credential
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// CredentialWriteResponse is a credential that was just written, the other credentials of its vault are left out
+type CredentialWriteResponse struct {
+	ID string `json:"id"`
+	Credential
+}
+
+// CredentialListResponse is a credential without its secrets
+type CredentialListResponse struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Name      string    `json:"name"`
+	Url       string    `json:"url"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// CredentialHistoryResponse is a replaced password, or the replaced value of the secret field of the item type
+// with the key Field, or of the custom field labelled Field when Custom is set
+type CredentialHistoryResponse struct {
+	ID        int64     `json:"id"`
+	Field     string    `json:"field,omitempty"`
+	Custom    bool      `json:"custom,omitempty"`
+	Password  string    `json:"password"`
+	CreatedAt time.Time `json:"createdAt"`
 }
@@ -0,0 +1,35 @@
+package vault
+
+const (
+	ItemTypeLogin    = "login"
+	ItemTypeNote     = "note"
+	ItemTypeCard     = "card"
+	ItemTypeIdentity = "identity"
+	ItemTypeSSHKey   = "ssh_key"
+	ItemTypeAPIToken = "api_token"
+)
+
+const (
+	CustomFieldText    = "text"
+	CustomFieldHidden  = "hidden"
+	CustomFieldBoolean = "boolean"
+	CustomFieldUrl     = "url"
+	CustomFieldTotp    = "totp"
+)
+
+// ItemTypeResponse describe how a client display an item type, the fields are listed in display order
+type ItemTypeResponse struct {
+	Type   string              `json:"type"`
+	Label  string              `json:"label"`
+	Icon   string              `json:"icon"`
+	Fields []ItemFieldResponse `json:"fields"`
+}
+
+// ItemFieldResponse describe a field of an item type. A secret field is masked until it is revealed
+type ItemFieldResponse struct {
+	Key       string `json:"key"`
+	Label     string `json:"label"`
+	Required  bool   `json:"required"`
+	Secret    bool   `json:"secret"`
+	Multiline bool   `json:"multiline"`
+}
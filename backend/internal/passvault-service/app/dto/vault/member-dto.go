@@ -0,0 +1,25 @@
+package vault
+
+import "time"
+
+type MemberInviteRequest struct {
+	Email string `json:"email" validate:"required,email"`
+	Role  string `json:"role" validate:"required,oneof=owner editor viewer"`
+}
+
+type MemberRoleRequest struct {
+	Role string `json:"role" validate:"required,oneof=owner editor viewer"`
+}
+
+type MemberAcceptRequest struct {
+	PrivateKey string `json:"privateKey" validate:"required,hexadecimal"`
+}
+
+type MemberResponse struct {
+	UserID     string     `json:"userId"`
+	Email      string     `json:"email"`
+	Role       string     `json:"role"`
+	Pending    bool       `json:"pending"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	AcceptedAt *time.Time `json:"acceptedAt"`
+}
@@ -8,12 +8,31 @@ type VaultRequest struct {
 }
 
 type VaultResponse struct {
-	ID        string    `json:"id"`
-	Name      string    `json:"name"`
-	CreatedAt time.Time `json:"createdAt"`
-	UpdatedAt time.Time `json:"updatedAt"`
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Role    string `json:"role"`
+	Pending bool   `json:"pending"`
+	// KeyReplaced tell the vault must be accepted again using the private key, as a member was removed
+	KeyReplaced bool      `json:"keyReplaced"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
 }
 
 type VaultEditRequest struct {
 	Name string `json:"name" validate:"required"`
 }
+
+type VaultRevisionResponse struct {
+	Revision int32 `json:"revision"`
+	// CredentialID is the credential replaced at the revision, empty when every credential is replaced
+	CredentialID string    `json:"credentialId,omitempty"`
+	ReplacedAt   time.Time `json:"replacedAt"`
+}
+
+type TrashResponse struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Role      string    `json:"role"`
+	DeletedAt time.Time `json:"deletedAt"`
+	PurgeAt   time.Time `json:"purgeAt"`
+}
@@ -0,0 +1,174 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	dtoAudit "github.com/Novando/pintartek/internal/passvault-service/app/dto/audit"
+	auditEntity "github.com/Novando/pintartek/internal/passvault-service/domain/audit/entity"
+	auditRepo "github.com/Novando/pintartek/internal/passvault-service/domain/audit/repository"
+	sessionRepo "github.com/Novando/pintartek/internal/passvault-service/domain/session/repository"
+	"github.com/Novando/pintartek/pkg/common/structs"
+	"github.com/Novando/pintartek/pkg/crypto"
+	"github.com/Novando/pintartek/pkg/logger"
+	"github.com/Novando/pintartek/pkg/postgresql/pgx"
+	"github.com/Novando/pintartek/pkg/redis"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type AuditConfig func(sa *AuditService)
+
+// auditChainBatch is the number of events read at once when verifying the chain
+const auditChainBatch = 1000
+
+type AuditService struct {
+	log         *logger.Logger
+	auditRepo   auditRepo.Audit
+	sessionRepo sessionRepo.Session
+	// verifyKeys are the public keys trusted to sign the events, by their fingerprint
+	verifyKeys map[string]ed25519.PublicKey
+}
+
+// NewAuditService Initialize the service reading the audit events
+func NewAuditService(config AuditConfig, cfgs ...AuditConfig) *AuditService {
+	serv := &AuditService{}
+	cfgs = append([]AuditConfig{config}, cfgs...)
+	for _, cfg := range cfgs {
+		cfg(serv)
+	}
+	return serv
+}
+
+// WithAuditPostgres Using Postgres to store data
+func WithAuditPostgres(c context.Context, q *pgx.Queries, db *pgxpool.Pool, l *logger.Logger) AuditConfig {
+	return func(sa *AuditService) {
+		sa.log = l
+		sa.auditRepo = auditRepo.NewPostgresAuditRepository(c, q, db)
+		sa.sessionRepo = sessionRepo.NewPostgresSessionRepository(c, q, db)
+	}
+}
+
+// WithAuditRedis Using redis to store session data
+func WithAuditRedis(r *redis.Redis) AuditConfig {
+	return func(sa *AuditService) {
+		sa.sessionRepo = sessionRepo.NewRedisSessionRepository(r)
+	}
+}
+
+// WithAuditKeys Trust the public keys of the current signing key and of the keys which signed the older events
+func WithAuditKeys(verifyKeys ...ed25519.PublicKey) AuditConfig {
+	return func(sa *AuditService) {
+		sa.verifyKeys = map[string]ed25519.PublicKey{}
+		for _, key := range verifyKeys {
+			sa.verifyKeys[crypto.FingerprintEd25519(key)] = key
+		}
+	}
+}
+
+// GetAll list the events of current user, the latest first
+func (s *AuditService) GetAll(token string, pagination structs.StdPagination) (res structs.StdResponse, code int) {
+	_, sessionData, res, code := authenticateSession(s.log, s.sessionRepo, token)
+	if code != fiber.StatusOK {
+		return
+	}
+	events, err := s.auditRepo.GetAllByUserID(sessionData.UserID, pagination)
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	dto := []dtoAudit.AuditResponse{}
+	for _, item := range events {
+		resItem := dtoAudit.AuditResponse{
+			ID:        item.ID,
+			Action:    item.Action,
+			TargetID:  item.TargetID,
+			IP:        item.IP,
+			UserAgent: item.UserAgent,
+			CreatedAt: item.CreatedAt.Time,
+		}
+		if item.VaultID.Valid {
+			resItem.VaultID = fmt.Sprintf("%x", item.VaultID.Bytes)
+		}
+		dto = append(dto, resItem)
+	}
+	res = structs.StdResponse{Message: "FETCHED", Data: dto, Count: int64(len(dto))}
+	code = fiber.StatusOK
+	return
+}
+
+// VerifyChain walk every event in the chain order. Each sealed event must hash to its stored hash,
+// link to the hash of the event before it, and be signed by a trusted key.
+// The events recorded before the chain started are counted but can not be verified
+func (s *AuditService) VerifyChain() (report dtoAudit.VerifyResponse, err error) {
+	var prevHash []byte
+	var afterID int64
+	for {
+		events, err := s.auditRepo.GetChain(afterID, auditChainBatch)
+		if err != nil {
+			return report, err
+		}
+		for _, item := range events {
+			if reason := s.verifyLink(item, prevHash, report.Checked > 0); reason != "" {
+				report.BrokenID = item.ID
+				report.Reason = reason
+				return report, nil
+			}
+			if item.Sealed() {
+				report.Checked++
+				report.HeadID = item.ID
+				report.HeadHash = hex.EncodeToString(item.Hash)
+			} else {
+				report.Unsealed++
+			}
+			prevHash = item.Hash
+			afterID = item.ID
+		}
+		if len(events) < auditChainBatch {
+			break
+		}
+	}
+	report.Verified = true
+	return report, nil
+}
+
+// verifyLink tell why an event does not belong after the previous hash, empty when it does
+func (s *AuditService) verifyLink(event auditEntity.Event, prevHash []byte, chainStarted bool) string {
+	if !event.Sealed() {
+		if chainStarted {
+			return "event is not sealed"
+		}
+		return ""
+	}
+	if !bytes.Equal(event.PrevHash, prevHash) {
+		return "previous hash does not match"
+	}
+	if !bytes.Equal(event.Digest(), event.Hash) {
+		return "event does not match its hash"
+	}
+	key, ok := s.verifyKeys[event.KeyID]
+	if !ok {
+		return fmt.Sprintf("signing key %s is not trusted", event.KeyID)
+	}
+	if !ed25519.Verify(key, event.Hash, event.Signature) {
+		return "signature is invalid"
+	}
+	return ""
+}
+
+// recordAudit store an event of a client. A failure is only logged,
+// the action being audited is already done and can not be undone
+func recordAudit(log *logger.Logger, repo auditRepo.Audit, client structs.StdClient, arg auditRepo.CreateParam) {
+	if len(client.UserAgent) > maxUserAgentLen {
+		client.UserAgent = client.UserAgent[:maxUserAgentLen]
+	}
+	arg.IP = client.IP
+	arg.UserAgent = client.UserAgent
+	if err := repo.Create(arg); err != nil {
+		log.Error(fmt.Sprintf("audit %s: %v", arg.Action, err))
+	}
+}
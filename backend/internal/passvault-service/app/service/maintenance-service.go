@@ -0,0 +1,175 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	sessionRepo "github.com/Novando/pintartek/internal/passvault-service/domain/session/repository"
+	userRepo "github.com/Novando/pintartek/internal/passvault-service/domain/user/repository"
+	vaultRepo "github.com/Novando/pintartek/internal/passvault-service/domain/vault/repository"
+	webauthnRepo "github.com/Novando/pintartek/internal/passvault-service/domain/webauthn/repository"
+	"github.com/Novando/pintartek/pkg/logger"
+	"github.com/Novando/pintartek/pkg/postgresql/pgx"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+)
+
+const (
+	defaultSessionPurgeInterval = time.Minute * 15
+	defaultUserPurgeInterval    = time.Hour * 24
+	defaultUserRetention        = time.Hour * 24 * 30
+	defaultVaultPurgeInterval   = time.Hour
+	defaultVaultRetention       = time.Hour * 24 * 30
+)
+
+type MaintenanceConfig func(sm *MaintenanceService)
+
+type MaintenanceService struct {
+	log                  *logger.Logger
+	sessionRepo          sessionRepo.Session
+	userRepo             userRepo.User
+	vaultRepo            vaultRepo.Vault
+	webauthnRepo         webauthnRepo.Webauthn
+	sessionPurgeInterval time.Duration
+	userPurgeInterval    time.Duration
+	userRetention        time.Duration
+	vaultPurgeInterval   time.Duration
+	vaultRetention       time.Duration
+}
+
+// maintenanceTask is a purge run periodically, the repositories hold an advisory lock within the transaction
+// of the purge, so a single replica run it at a time
+type maintenanceTask struct {
+	name     string
+	interval time.Duration
+	run      func() error
+}
+
+// NewMaintenanceService Initialize the service purging stale data
+func NewMaintenanceService(config MaintenanceConfig, cfgs ...MaintenanceConfig) *MaintenanceService {
+	serv := &MaintenanceService{}
+	cfgs = append([]MaintenanceConfig{config}, cfgs...)
+	for _, cfg := range cfgs {
+		cfg(serv)
+	}
+	return serv
+}
+
+// WithMaintenancePostgres Purge the data stored in Postgres
+func WithMaintenancePostgres(c context.Context, q *pgx.Queries, db *pgxpool.Pool, l *logger.Logger) MaintenanceConfig {
+	return func(sm *MaintenanceService) {
+		sm.log = l
+		sm.sessionRepo = sessionRepo.NewPostgresSessionRepository(c, q, db)
+		sm.userRepo = userRepo.NewPostgresUserRepository(c, q, db)
+		sm.vaultRepo = vaultRepo.NewPostgresVaultRepository(c, q, db)
+		sm.webauthnRepo = webauthnRepo.NewPostgresWebauthnRepository(c, q, db)
+	}
+}
+
+// WithMaintenanceSchedule Set how often the purges run and how long a soft deleted user is kept,
+// zero values fall back to the defaults
+func WithMaintenanceSchedule(sessionInterval, userInterval, userRetention time.Duration) MaintenanceConfig {
+	return func(sm *MaintenanceService) {
+		sm.sessionPurgeInterval = sessionInterval
+		sm.userPurgeInterval = userInterval
+		sm.userRetention = userRetention
+	}
+}
+
+// WithMaintenanceVaultTrash Set how often the trash is purged and how long a vault stay in the trash,
+// zero values fall back to the defaults
+func WithMaintenanceVaultTrash(interval, retention time.Duration) MaintenanceConfig {
+	return func(sm *MaintenanceService) {
+		sm.vaultPurgeInterval = interval
+		sm.vaultRetention = retention
+	}
+}
+
+// Run start every purge on its own schedule, it block until the context is done
+func (s *MaintenanceService) Run(ctx context.Context) {
+	if s.sessionPurgeInterval == 0 {
+		s.sessionPurgeInterval = defaultSessionPurgeInterval
+	}
+	if s.userPurgeInterval == 0 {
+		s.userPurgeInterval = defaultUserPurgeInterval
+	}
+	if s.userRetention == 0 {
+		s.userRetention = defaultUserRetention
+	}
+	if s.vaultPurgeInterval == 0 {
+		s.vaultPurgeInterval = defaultVaultPurgeInterval
+	}
+	if s.vaultRetention == 0 {
+		s.vaultRetention = defaultVaultRetention
+	}
+	tasks := []maintenanceTask{
+		{name: "sessions", interval: s.sessionPurgeInterval, run: s.PurgeSessions},
+		{name: "users", interval: s.userPurgeInterval, run: s.PurgeUsers},
+		{name: "vaults", interval: s.vaultPurgeInterval, run: s.PurgeVaults},
+	}
+	done := make(chan struct{})
+	for _, task := range tasks {
+		go func(task maintenanceTask) {
+			s.schedule(ctx, task)
+			done <- struct{}{}
+		}(task)
+	}
+	for range tasks {
+		<-done
+	}
+}
+
+// schedule run a task on every tick
+func (s *MaintenanceService) schedule(ctx context.Context, task maintenanceTask) {
+	ticker := time.NewTicker(task.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := task.run(); err != nil {
+			s.log.Error(fmt.Sprintf("maintenance %s: %v", task.name, err))
+		}
+	}
+}
+
+// PurgeSessions delete the expired sessions and the WebAuthn challenges that were never answered
+func (s *MaintenanceService) PurgeSessions() error {
+	sessions, err := s.sessionRepo.PurgeExpired()
+	if err != nil {
+		return err
+	}
+	challenges, err := s.webauthnRepo.PurgeExpiredChallenges()
+	if err != nil {
+		return err
+	}
+	if sessions > 0 || challenges > 0 {
+		s.log.Infof("purged %d expired sessions and %d expired challenges", sessions, challenges)
+	}
+	return nil
+}
+
+// PurgeUsers permanently delete the users soft deleted for longer than the retention period
+func (s *MaintenanceService) PurgeUsers() error {
+	users, err := s.userRepo.PurgeDeleted(time.Now().Add(-s.userRetention))
+	if err != nil {
+		return err
+	}
+	if users > 0 {
+		s.log.Infof("purged %d deleted users", users)
+	}
+	return nil
+}
+
+// PurgeVaults permanently delete the vaults in the trash for longer than the retention period
+func (s *MaintenanceService) PurgeVaults() error {
+	vaults, err := s.vaultRepo.PurgeDeleted(time.Now().Add(-s.vaultRetention))
+	if err != nil {
+		return err
+	}
+	if vaults > 0 {
+		s.log.Infof("purged %d vaults from the trash", vaults)
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package service
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"github.com/Novando/pintartek/pkg/common/structs"
+	"github.com/Novando/pintartek/pkg/logger"
+	"github.com/Novando/pintartek/pkg/redis"
+	"github.com/gofiber/fiber/v2"
+	"math"
+	"strings"
+	"time"
+)
+
+// RateLimitPolicy decide how many attempts are allowed, zero values fall back to the defaults
+type RateLimitPolicy struct {
+	// IPLimit is the number of attempts an IP can make within IPWindow, whether they succeed or not
+	IPLimit  int64
+	IPWindow time.Duration
+	// AccountLimit is the number of attempts made on behalf of an account within AccountWindow from every IP
+	AccountLimit  int64
+	AccountWindow time.Duration
+	// FreeFailures is the number of failures of an account from an IP within FailureWindow before they are delayed,
+	// each failure after that double the delay up to MaxDelay
+	FreeFailures  int64
+	FailureWindow time.Duration
+	MaxDelay      time.Duration
+	// MaxFailures lock the account out of the IP for LockoutDuration
+	MaxFailures     int64
+	LockoutDuration time.Duration
+}
+
+var defaultRateLimitPolicy = RateLimitPolicy{
+	IPLimit:         30,
+	IPWindow:        time.Minute * 15,
+	AccountLimit:    30,
+	AccountWindow:   time.Minute * 15,
+	FreeFailures:    3,
+	FailureWindow:   time.Minute * 15,
+	MaxDelay:        time.Minute,
+	MaxFailures:     10,
+	LockoutDuration: time.Minute * 15,
+}
+
+type RateLimitConfig func(sr *RateLimitService)
+
+type RateLimitService struct {
+	log     *logger.Logger
+	limiter redis.Limiter
+	policy  RateLimitPolicy
+}
+
+// NewRateLimitService Initialize the service limiting the attempts on the unauthenticated endpoints
+func NewRateLimitService(config RateLimitConfig, cfgs ...RateLimitConfig) *RateLimitService {
+	serv := &RateLimitService{policy: defaultRateLimitPolicy}
+	cfgs = append([]RateLimitConfig{config}, cfgs...)
+	for _, cfg := range cfgs {
+		cfg(serv)
+	}
+	return serv
+}
+
+// WithRateLimitRedis Count the attempts in redis, or in memory when `r` is nil
+func WithRateLimitRedis(r *redis.Redis, l *logger.Logger) RateLimitConfig {
+	return func(sr *RateLimitService) {
+		sr.log = l
+		sr.limiter = redis.NewLimiter(r)
+	}
+}
+
+// WithRateLimitPolicy Override the default limits, zero values keep the defaults
+func WithRateLimitPolicy(policy RateLimitPolicy) RateLimitConfig {
+	return func(sr *RateLimitService) {
+		if policy.IPLimit > 0 {
+			sr.policy.IPLimit = policy.IPLimit
+		}
+		if policy.IPWindow > 0 {
+			sr.policy.IPWindow = policy.IPWindow
+		}
+		if policy.AccountLimit > 0 {
+			sr.policy.AccountLimit = policy.AccountLimit
+		}
+		if policy.AccountWindow > 0 {
+			sr.policy.AccountWindow = policy.AccountWindow
+		}
+		if policy.FreeFailures > 0 {
+			sr.policy.FreeFailures = policy.FreeFailures
+		}
+		if policy.FailureWindow > 0 {
+			sr.policy.FailureWindow = policy.FailureWindow
+		}
+		if policy.MaxDelay > 0 {
+			sr.policy.MaxDelay = policy.MaxDelay
+		}
+		if policy.MaxFailures > 0 {
+			sr.policy.MaxFailures = policy.MaxFailures
+		}
+		if policy.LockoutDuration > 0 {
+			sr.policy.LockoutDuration = policy.LockoutDuration
+		}
+	}
+}
+
+func ipLimitKey(ip string) string {
+	return "ip:" + ip
+}
+
+// accountHash identify an account in the limiter keys and the logs without keeping its email
+func accountHash(account string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(strings.ToLower(account))))
+}
+
+func accountLimitKey(account string) string {
+	return "account:" + accountHash(account)
+}
+
+// failureLimitKey count the failures of an account from a single IP, so the failures made from
+// the other IPs do not lock the owner of the account out
+func failureLimitKey(ip string, account string) string {
+	return "account-ip:" + accountHash(account) + ":" + ip
+}
+
+// Attempt record an attempt of an IP on behalf of an account, `code` is fiber.StatusOK when it is allowed.
+// Otherwise `retryAfter` tell how long the client should wait. The account may be empty when it is unknown
+func (s *RateLimitService) Attempt(ip string, account string) (
+	retryAfter time.Duration,
+	res structs.StdResponse,
+	code int,
+) {
+	if account != "" {
+		left, err := s.limiter.LockedFor(failureLimitKey(ip, account))
+		if err != nil {
+			s.log.Error(err.Error())
+			res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+			code = fiber.StatusInternalServerError
+			return
+		}
+		retryAfter = left
+	}
+	if retryAfter == 0 {
+		retryAfter, res, code = s.hit(ipLimitKey(ip), s.policy.IPLimit, s.policy.IPWindow)
+		if code != fiber.StatusOK {
+			return
+		}
+	}
+	if retryAfter == 0 && account != "" {
+		retryAfter, res, code = s.hit(accountLimitKey(account), s.policy.AccountLimit, s.policy.AccountWindow)
+		if code != fiber.StatusOK {
+			return
+		}
+	}
+	if retryAfter > 0 {
+		res = structs.StdResponse{
+			Message: "RATE_LIMITED",
+			Data:    fmt.Sprintf("too many attempts, retry in %s seconds", RetryAfterSeconds(retryAfter)),
+		}
+		code = fiber.StatusTooManyRequests
+		return
+	}
+	code = fiber.StatusOK
+	return
+}
+
+// hit record an attempt of a key, `retryAfter` is the window once the attempts within it exceed the limit
+func (s *RateLimitService) hit(key string, limit int64, window time.Duration) (
+	retryAfter time.Duration,
+	res structs.StdResponse,
+	code int,
+) {
+	count, err := s.limiter.Hit(key, window)
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	if count > limit {
+		retryAfter = window
+	}
+	code = fiber.StatusOK
+	return
+}
+
+// Fail record a failed credential of an account from an IP, the account is delayed for the IP
+// and locked out of it once it failed too often
+func (s *RateLimitService) Fail(ip string, account string) {
+	if account == "" {
+		return
+	}
+	key := failureLimitKey(ip, account)
+	count, err := s.limiter.Hit(key, s.policy.FailureWindow)
+	if err == nil {
+		switch {
+		case count >= s.policy.MaxFailures:
+			s.log.Error(fmt.Sprintf(
+				"account %s is locked out of %s after %d failed attempts", accountHash(account), ip, count,
+			))
+			err = s.limiter.Lock(key, s.policy.LockoutDuration)
+		case count > s.policy.FreeFailures:
+			delay := time.Second << min(count-s.policy.FreeFailures-1, 16)
+			if delay > s.policy.MaxDelay {
+				delay = s.policy.MaxDelay
+			}
+			err = s.limiter.Lock(key, delay)
+		}
+	}
+	if err != nil {
+		s.log.Error(err.Error())
+	}
+}
+
+// Succeed forget the failed attempts of an account from an IP
+func (s *RateLimitService) Succeed(ip string, account string) {
+	if account == "" {
+		return
+	}
+	if err := s.limiter.Reset(failureLimitKey(ip, account)); err != nil {
+		s.log.Error(err.Error())
+	}
+}
+
+// RetryAfterSeconds format a wait for the Retry-After header, rounded up to the second
+func RetryAfterSeconds(wait time.Duration) string {
+	return fmt.Sprint(int64(math.Ceil(wait.Seconds())))
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"github.com/Novando/pintartek/pkg/logger"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func initTestRateLimitService() *RateLimitService {
+	return NewRateLimitService(
+		WithRateLimitRedis(nil, logger.InitZerolog(logger.Config{ConsoleLoggingEnabled: true})),
+		WithRateLimitPolicy(RateLimitPolicy{
+			IPLimit:         3,
+			AccountLimit:    5,
+			FreeFailures:    1,
+			MaxDelay:        time.Second * 30,
+			MaxFailures:     4,
+			LockoutDuration: time.Minute * 5,
+		}),
+	)
+}
+
+func TestRateLimitService_Attempt_IPLimit(t *testing.T) {
+	serv := initTestRateLimitService()
+	// every attempt is counted, whether it succeed or not
+	for i := 0; i < 3; i++ {
+		_, _, code := serv.Attempt("127.0.0.1", "")
+		assert.Equal(t, http.StatusOK, code)
+	}
+	retryAfter, res, code := serv.Attempt("127.0.0.1", "")
+	assert.Equal(t, "RATE_LIMITED", res.Message)
+	assert.Equal(t, http.StatusTooManyRequests, code)
+	assert.Equal(t, "900", RetryAfterSeconds(retryAfter))
+	_, _, code = serv.Attempt("127.0.0.2", "")
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestRateLimitService_Attempt_AccountLimit(t *testing.T) {
+	serv := initTestRateLimitService()
+	ips := []string{"127.0.0.1", "127.0.0.2", "127.0.0.3", "127.0.0.4", "127.0.0.5"}
+	for _, ip := range ips {
+		_, _, code := serv.Attempt(ip, "test@test.com")
+		assert.Equal(t, http.StatusOK, code)
+	}
+	// the attempts on behalf of an account are counted from every IP
+	_, res, code := serv.Attempt("127.0.0.6", "test@test.com")
+	assert.Equal(t, "RATE_LIMITED", res.Message)
+	assert.Equal(t, http.StatusTooManyRequests, code)
+	_, _, code = serv.Attempt("127.0.0.6", "other@test.com")
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestRateLimitService_Fail_Delay(t *testing.T) {
+	serv := initTestRateLimitService()
+	serv.Fail("127.0.0.1", "test@test.com")
+	_, _, code := serv.Attempt("127.0.0.1", "test@test.com")
+	assert.Equal(t, http.StatusOK, code)
+
+	// the failure after the free ones delay the account, but not the other accounts of the IP
+	serv.Fail("127.0.0.1", "test@test.com")
+	retryAfter, res, code := serv.Attempt("127.0.0.1", "test@test.com")
+	assert.Equal(t, "RATE_LIMITED", res.Message)
+	assert.Equal(t, http.StatusTooManyRequests, code)
+	assert.Equal(t, "1", RetryAfterSeconds(retryAfter))
+	_, _, code = serv.Attempt("127.0.0.1", "other@test.com")
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestRateLimitService_Fail_Lockout(t *testing.T) {
+	serv := initTestRateLimitService()
+	for i := 0; i < 4; i++ {
+		serv.Fail("127.0.0.1", "test@test.com")
+	}
+	retryAfter, res, code := serv.Attempt("127.0.0.1", "test@test.com")
+	assert.Equal(t, "RATE_LIMITED", res.Message)
+	assert.Equal(t, http.StatusTooManyRequests, code)
+	assert.Equal(t, "300", RetryAfterSeconds(retryAfter))
+
+	// the owner is not locked out by the failures made from another IP
+	_, _, code = serv.Attempt("127.0.0.2", "test@test.com")
+	assert.Equal(t, http.StatusOK, code)
+
+	// a completed login forget the failures
+	serv.Succeed("127.0.0.1", "test@test.com")
+	_, _, code = serv.Attempt("127.0.0.1", "test@test.com")
+	assert.Equal(t, http.StatusOK, code)
+}
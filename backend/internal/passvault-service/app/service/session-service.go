@@ -0,0 +1,94 @@
+package service
+
+import (
+	"fmt"
+	sessionEntity "github.com/Novando/pintartek/internal/passvault-service/domain/session/entity"
+	sessionRepo "github.com/Novando/pintartek/internal/passvault-service/domain/session/repository"
+	"github.com/Novando/pintartek/pkg/common/consts"
+	"github.com/Novando/pintartek/pkg/common/structs"
+	"github.com/Novando/pintartek/pkg/logger"
+	"github.com/Novando/pintartek/pkg/uuid"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgtype"
+	"time"
+)
+
+// rotationGrace is how long a rotated token is still accepted,
+// for the requests that were sent concurrently with the one rotating it
+const rotationGrace = time.Second * 10
+
+// authenticateSession retrieve the session of a token, `code` is fiber.StatusOK when the session is valid.
+// A session waiting for the second factor is rejected. A rotated token used after the grace window
+// is treated as stolen, so the whole session family is revoked
+func authenticateSession(log *logger.Logger, repo sessionRepo.Session, token string) (
+	sessionUuid pgtype.UUID,
+	sessionData sessionEntity.Session,
+	res structs.StdResponse,
+	code int,
+) {
+	tokenBytes, err := uuid.ParseUUID(token)
+	if err != nil {
+		log.Error(err.Error())
+		res = structs.StdResponse{Message: "REQUEST_ERROR", Data: err.Error()}
+		code = fiber.StatusBadRequest
+		return
+	}
+	sessionUuid = pgtype.UUID{Bytes: tokenBytes, Valid: true}
+	sessionData, err = repo.GetByID(sessionUuid)
+	if err != nil {
+		if err.Error() == consts.ErrNoData.Error() {
+			res = structs.StdResponse{Message: "ACCESS_DENIED", Data: err.Error()}
+			code = fiber.StatusUnauthorized
+		} else {
+			log.Error(err.Error())
+			res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+			code = fiber.StatusInternalServerError
+		}
+		return
+	}
+	if sessionData.ReplacedBy.Valid && time.Since(sessionData.RotatedAt.Time) > rotationGrace {
+		log.Error(fmt.Sprintf("rotated session %x is reused, revoking session family %x", sessionUuid.Bytes, sessionData.FamilyID.Bytes))
+		if err = repo.PermanentDeleteByFamilyID(sessionData.FamilyID); err != nil {
+			log.Error(err.Error())
+			res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+			code = fiber.StatusInternalServerError
+			return
+		}
+		res = structs.StdResponse{Message: "ACCESS_DENIED", Data: "token is already rotated, the session is revoked"}
+		code = fiber.StatusUnauthorized
+		return
+	}
+	if sessionData.Scope != "" {
+		res = structs.StdResponse{Message: "ACCESS_DENIED", Data: "second factor required"}
+		code = fiber.StatusUnauthorized
+		return
+	}
+	code = fiber.StatusOK
+	return
+}
+
+// rotateSession replace the session of a token by a new one of the same family, the new token is the `Data`
+// of the response. Rotating a token twice give the same replacement, so concurrent requests agree on it
+func rotateSession(log *logger.Logger, repo sessionRepo.Session, token string) (res structs.StdResponse, code int) {
+	tokenBytes, err := uuid.ParseUUID(token)
+	if err != nil {
+		res = structs.StdResponse{Message: "REQUEST_ERROR", Data: err.Error()}
+		code = fiber.StatusBadRequest
+		return
+	}
+	replacedBy, err := repo.Rotate(pgtype.UUID{Bytes: tokenBytes, Valid: true}, uuid.GenerateUUID())
+	if err != nil {
+		if err.Error() == consts.ErrNoData.Error() {
+			res = structs.StdResponse{Message: "ACCESS_DENIED", Data: err.Error()}
+			code = fiber.StatusUnauthorized
+		} else {
+			log.Error(err.Error())
+			res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+			code = fiber.StatusInternalServerError
+		}
+		return
+	}
+	res = structs.StdResponse{Message: "UPDATED", Data: fmt.Sprintf("%x", replacedBy.Bytes)}
+	code = fiber.StatusOK
+	return
+}
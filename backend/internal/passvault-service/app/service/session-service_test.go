@@ -0,0 +1,57 @@
+package service
+
+import (
+	"fmt"
+	sessionEntity "github.com/Novando/pintartek/internal/passvault-service/domain/session/entity"
+	sessionRepo "github.com/Novando/pintartek/internal/passvault-service/domain/session/repository"
+	"github.com/Novando/pintartek/pkg/logger"
+	"github.com/Novando/pintartek/pkg/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// newRotatedSession build a session that was rotated `since` ago
+func newRotatedSession(since time.Duration) sessionEntity.Session {
+	sessionUuid := uuid.GenerateUUID()
+	return sessionEntity.Session{
+		ID:         sessionUuid,
+		UserID:     uuid.GenerateUUID(),
+		FamilyID:   sessionUuid,
+		ReplacedBy: uuid.GenerateUUID(),
+		RotatedAt:  pgtype.Timestamptz{Time: time.Now().Add(-since), Valid: true},
+	}
+}
+
+func TestAuthenticateSession_RotatedWithinGrace(t *testing.T) {
+	ms := sessionRepo.NewMockSessionRepository(t)
+	sessionData := newRotatedSession(time.Second)
+
+	ms.Mock.On("GetByID", sessionData.ID).Return(sessionData, nil)
+
+	_, _, _, code := authenticateSession(
+		logger.InitZerolog(logger.Config{ConsoleLoggingEnabled: true}),
+		ms,
+		fmt.Sprintf("%x", sessionData.ID.Bytes),
+	)
+	assert.Equal(t, http.StatusOK, code)
+	ms.Mock.AssertNotCalled(t, "PermanentDeleteByFamilyID", sessionData.FamilyID)
+}
+
+func TestAuthenticateSession_ReusedAfterGrace(t *testing.T) {
+	ms := sessionRepo.NewMockSessionRepository(t)
+	sessionData := newRotatedSession(rotationGrace + time.Second)
+
+	ms.Mock.On("GetByID", sessionData.ID).Return(sessionData, nil)
+	ms.Mock.On("PermanentDeleteByFamilyID", sessionData.FamilyID).Return(nil)
+
+	_, _, res, code := authenticateSession(
+		logger.InitZerolog(logger.Config{ConsoleLoggingEnabled: true}),
+		ms,
+		fmt.Sprintf("%x", sessionData.ID.Bytes),
+	)
+	assert.Equal(t, "ACCESS_DENIED", res.Message)
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
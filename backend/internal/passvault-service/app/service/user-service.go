@@ -2,13 +2,19 @@ package service
 
 import (
 	"context"
+	"crypto/ed25519"
 	"encoding/json"
 	"fmt"
 	dtoUser "github.com/Novando/pintartek/internal/passvault-service/app/dto/user"
+	auditEntity "github.com/Novando/pintartek/internal/passvault-service/domain/audit/entity"
+	auditRepo "github.com/Novando/pintartek/internal/passvault-service/domain/audit/repository"
 	clientRepo "github.com/Novando/pintartek/internal/passvault-service/domain/client/repository"
 	sessionEntity "github.com/Novando/pintartek/internal/passvault-service/domain/session/entity"
 	sessionRepo "github.com/Novando/pintartek/internal/passvault-service/domain/session/repository"
+	userEntity "github.com/Novando/pintartek/internal/passvault-service/domain/user/entity"
 	userRepo "github.com/Novando/pintartek/internal/passvault-service/domain/user/repository"
+	vaultEntity "github.com/Novando/pintartek/internal/passvault-service/domain/vault/entity"
+	webauthnRepo "github.com/Novando/pintartek/internal/passvault-service/domain/webauthn/repository"
 	"github.com/Novando/pintartek/pkg/common/consts"
 	"github.com/Novando/pintartek/pkg/common/structs"
 	"github.com/Novando/pintartek/pkg/crypto"
@@ -17,6 +23,7 @@ import (
 	"github.com/Novando/pintartek/pkg/postgresql/pgx"
 	"github.com/Novando/pintartek/pkg/redis"
 	"github.com/Novando/pintartek/pkg/uuid"
+	"github.com/Novando/pintartek/pkg/webauthn"
 	"github.com/gofiber/fiber/v2"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -26,10 +33,19 @@ import (
 type UserConfig func(su *UserService)
 
 type UserService struct {
-	log         *logger.Logger
-	userRepo    userRepo.User
-	clientRepo  clientRepo.Client
-	sessionRepo sessionRepo.Session
+	log          *logger.Logger
+	userRepo     userRepo.User
+	auditRepo    auditRepo.Audit
+	auditKey     ed25519.PrivateKey
+	clientRepo   clientRepo.Client
+	sessionRepo  sessionRepo.Session
+	webauthnRepo webauthnRepo.Webauthn
+	relyingParty webauthn.RelyingParty
+	policy       SessionPolicy
+	kdfTime      uint32
+	kdfMemory    uint32
+	kdfThreads   uint8
+	rateLimit    *RateLimitService
 }
 
 // NewUserService Initialize user service
@@ -42,13 +58,21 @@ func NewUserService(config UserConfig, cfgs ...UserConfig) *UserService {
 	return serv
 }
 
-// WithMock Using Postgres to store data
-func WithMock(ur *userRepo.UserMock, sr *sessionRepo.SessionMock, cr *clientRepo.ClientMock) UserConfig {
+// WithMock Using mocked repositories to store data
+func WithMock(
+	ur *userRepo.UserMock,
+	sr *sessionRepo.SessionMock,
+	cr *clientRepo.ClientMock,
+	ar *auditRepo.AuditMock,
+	wr *webauthnRepo.WebauthnMock,
+) UserConfig {
 	return func(su *UserService) {
-		su.log = logger.InitZerolog()
+		su.log = logger.InitZerolog(logger.Config{ConsoleLoggingEnabled: true})
 		su.userRepo = ur
 		su.sessionRepo = sr
 		su.clientRepo = cr
+		su.auditRepo = ar
+		su.webauthnRepo = wr
 	}
 }
 
@@ -57,8 +81,10 @@ func WithUserPostgres(c context.Context, q *pgx.Queries, db *pgxpool.Pool, l *lo
 	return func(su *UserService) {
 		su.log = l
 		su.userRepo = userRepo.NewPostgresUserRepository(c, q, db)
+		su.auditRepo = auditRepo.NewPostgresAuditRepository(c, q, db)
 		su.clientRepo = clientRepo.NewPostgresClientRepository(c, q, db)
 		su.sessionRepo = sessionRepo.NewPostgresSessionRepository(c, q, db)
+		su.webauthnRepo = webauthnRepo.NewPostgresWebauthnRepository(c, q, db)
 	}
 }
 
@@ -69,6 +95,44 @@ func WithUserRedis(r *redis.Redis) UserConfig {
 	}
 }
 
+// WithUserKDF Tune the Argon2id cost used to derive the access token key from a password,
+// zero values fall back to the defaults
+func WithUserKDF(time, memory uint32, threads uint8) UserConfig {
+	return func(su *UserService) {
+		su.kdfTime = time
+		su.kdfMemory = memory
+		su.kdfThreads = threads
+	}
+}
+
+// WithUserAuditKey Set the key signing the audit events
+func WithUserAuditKey(key ed25519.PrivateKey) UserConfig {
+	return func(su *UserService) {
+		su.auditKey = key
+	}
+}
+
+// WithUserSessionPolicy Set how long the sessions live, zero values fall back to the defaults
+func WithUserSessionPolicy(policy SessionPolicy) UserConfig {
+	return func(su *UserService) {
+		su.policy = policy
+	}
+}
+
+// WithUserWebAuthn Set the relying party that WebAuthn credentials are registered to
+func WithUserWebAuthn(rp webauthn.RelyingParty) UserConfig {
+	return func(su *UserService) {
+		su.relyingParty = rp
+	}
+}
+
+// WithUserRateLimit Set the rate limit whose failed attempts of an account are forgotten once a login completes
+func WithUserRateLimit(srl *RateLimitService) UserConfig {
+	return func(su *UserService) {
+		su.rateLimit = srl
+	}
+}
+
 // Register create a new user, which duplicate email is forbidden.
 // Create an access token that will be used to decrypt vault
 func (s *UserService) Register(params dtoUser.RegisterRequest) (res structs.StdResponse, code int) {
@@ -102,11 +166,18 @@ func (s *UserService) Register(params dtoUser.RegisterRequest) (res structs.StdR
 		return
 	}
 	pvtStr := fmt.Sprintf("%x", pvt)
-	newUuid := uuid.GenerateUUID()
-	cipher := helper.AbsoluteCharLen(params.Password+fmt.Sprintf("%x", newUuid.Bytes), 16)
+
+	// The session secret is random, so the vault keys derived from it do not depend on the password
+	secret, err := crypto.GenerateRandomKey(32)
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
 	sessionData, err := json.Marshal(sessionEntity.Session{
 		UserID:    newUserUuid,
-		SecretKey: cipher,
+		SecretKey: fmt.Sprintf("%x", secret),
 	})
 	if err != nil {
 		s.log.Error(err.Error())
@@ -114,14 +185,15 @@ func (s *UserService) Register(params dtoUser.RegisterRequest) (res structs.StdR
 		code = fiber.StatusInternalServerError
 		return
 	}
-	accessToken, err := crypto.EncryptAES(string(sessionData), helper.AbsoluteCharLen(params.Password, 16))
+	accessToken, kdfParams, err := s.sealAccessToken(string(sessionData), params.Password)
 	if err != nil {
 		s.log.Error(err.Error())
 		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
 		code = fiber.StatusInternalServerError
 		return
 	}
-	backupToken, err := crypto.EncryptAES(string(sessionData), helper.AbsoluteCharLen(pvtStr, 32))
+	// the backup token is sealed to the public key, so it is opened by the whole private key on recovery
+	backupToken, err := crypto.SealAnonymousX25519(string(sessionData), pub)
 	if err != nil {
 		s.log.Error(err.Error())
 		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
@@ -136,6 +208,7 @@ func (s *UserService) Register(params dtoUser.RegisterRequest) (res structs.StdR
 		PublicKey:   fmt.Sprintf("%x", pub),
 		AccessToken: accessToken,
 		BackupToken: backupToken,
+		KdfParams:   kdfParams,
 	})
 	if err != nil {
 		s.log.Error(err.Error())
@@ -150,6 +223,7 @@ func (s *UserService) Register(params dtoUser.RegisterRequest) (res structs.StdR
 		code = fiber.StatusInternalServerError
 		return
 	}
+	s.audit(auditEntity.ActionRegister, userId, "", structs.StdClient{IP: params.IP, UserAgent: params.UserAgent})
 	res = structs.StdResponse{Message: "CREATED", Data: dtoUser.RegisterResponse{
 		PrivateKey: pvtStr,
 	}}
@@ -159,6 +233,7 @@ func (s *UserService) Register(params dtoUser.RegisterRequest) (res structs.StdR
 
 // Login create a new session, which allow user to access their respective vaults
 func (s *UserService) Login(params dtoUser.LoginRequest) (res structs.StdResponse, code int) {
+	client := structs.StdClient{IP: params.IP, UserAgent: params.UserAgent}
 	userData, err := s.userRepo.GetByEmail(params.Email)
 	if err != nil {
 		msg := "CREDENTIAL_ERROR"
@@ -167,16 +242,19 @@ func (s *UserService) Login(params dtoUser.LoginRequest) (res structs.StdRespons
 			s.log.Error(err.Error())
 			msg = "REQUEST_ERROR"
 			code = fiber.StatusBadRequest
+		} else {
+			s.audit(auditEntity.ActionLoginFailure, pgtype.UUID{}, "", client)
 		}
 		res = structs.StdResponse{Message: msg, Data: err.Error()}
 		return
 	}
 	if err = bcrypt.CompareHashAndPassword([]byte(userData.Password), []byte(params.Password)); err != nil {
+		s.audit(auditEntity.ActionLoginFailure, userData.ID, "", client)
 		res = structs.StdResponse{Message: "CREDENTIAL_ERROR", Data: "invalid credential"}
 		code = fiber.StatusUnauthorized
 		return
 	}
-	tokenData, err := crypto.DecryptAES(userData.AccessToken, helper.AbsoluteCharLen(params.Password, 16))
+	tokenData, err := s.openAccessToken(userData, params.Password)
 	if err != nil {
 		s.log.Error(err.Error())
 		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
@@ -190,10 +268,378 @@ func (s *UserService) Login(params dtoUser.LoginRequest) (res structs.StdRespons
 		code = fiber.StatusInternalServerError
 		return
 	}
+	var pendingSecret string
+	if isLegacySecret(sessionData.SecretKey) {
+		// the secret derived from the password is replaced by a random one, which also upgrade the access token.
+		// The password is only known here, so the replacement is sealed now but applied once the login completes.
+		// The login goes on using the old secret when it fails, the next login try again
+		replacement, err := s.sealSecret(userData, params.Password)
+		if err == nil {
+			pendingSecret, err = replacement.encode()
+		}
+		if err != nil {
+			s.log.Error(err.Error())
+		}
+	} else if userData.KdfParams == "" {
+		// upgrade the access token encrypted by the padded password
+		accessToken, kdfParams, err := s.sealAccessToken(tokenData, params.Password)
+		if err == nil {
+			err = s.userRepo.UpdatePassword(userRepo.UpdatePasswordParam{
+				ID:          userData.ID,
+				Password:    userData.Password,
+				AccessToken: accessToken,
+				KdfParams:   kdfParams,
+			})
+		}
+		if err != nil {
+			s.log.Error(err.Error())
+		}
+	}
+	// the legacy secret is only replaced once the second factor is verified
+	required, res, code := s.requireSecondFactor(userData, sessionData, sessionEntity.ScopeMFA, pendingSecret, client)
+	if required || code != fiber.StatusOK {
+		// the failed attempts are kept until the second factor is verified as well
+		return
+	}
+	sessionData = s.applyPendingSecret(userData, sessionData, pendingSecret)
+	res, code = s.startSession(sessionData, client, params.RememberDevice, auditEntity.ActionLoginSuccess)
+	if code == fiber.StatusOK {
+		s.forgetFailures(params.IP, userData.Email)
+	}
+	return
+}
+
+// Logout delete an active session of current user
+func (s *UserService) Logout(token string, client structs.StdClient) (res structs.StdResponse, code int) {
+	tokenBytes, err := uuid.ParseUUID(token)
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "REQUEST_ERROR", Data: err.Error()}
+		code = fiber.StatusBadRequest
+		return
+	}
+	// the tokens the session was rotated from or into are revoked as well
+	sessionData, err := s.sessionRepo.GetByID(pgtype.UUID{Bytes: tokenBytes, Valid: true})
+	if err == nil {
+		err = s.sessionRepo.PermanentDeleteByFamilyID(sessionData.FamilyID)
+		s.audit(auditEntity.ActionLogout, sessionData.UserID, "", client)
+	} else if err.Error() == consts.ErrNoData.Error() {
+		err = nil
+	}
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	res = structs.StdResponse{Message: "SUCCESS", Data: "logged out"}
+	code = fiber.StatusOK
+	return
+}
+
+// ChangePassword replace the master password of current user. The user is given a new session secret,
+// so a leaked access token or session secret can not open the vaults anymore. Every session is revoked
+// as it keeps the old secret, and a new session is started instead of the current one
+func (s *UserService) ChangePassword(
+	token string,
+	params dtoUser.ChangePasswordRequest,
+	client structs.StdClient,
+) (res structs.StdResponse, code int) {
+	_, sessionData, res, code := s.authenticate(token)
+	if code != fiber.StatusOK {
+		return
+	}
+	userData, res, code := s.getSessionUser(sessionData)
+	if code != fiber.StatusOK {
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(userData.Password), []byte(params.OldPassword)); err != nil {
+		res = structs.StdResponse{Message: "CREDENTIAL_ERROR", Data: "invalid credential"}
+		code = fiber.StatusUnauthorized
+		return
+	}
+	hashedPass, err := bcrypt.GenerateFromPassword([]byte(params.NewPassword), 10)
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	pending, err := s.sealSecret(userData, params.NewPassword)
+	var newSessionData sessionEntity.Session
+	if err == nil {
+		newSessionData, err = s.replaceSecret(userData, sessionData, pending, string(hashedPass))
+	}
+	if err != nil {
+		if err.Error() == consts.ErrConflict.Error() {
+			res = structs.StdResponse{Message: "CONFLICT", Data: err.Error()}
+			code = fiber.StatusConflict
+			return
+		}
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	if err = s.sessionRepo.PermanentDeleteByUserID(userData.ID); err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	res, code = s.startSession(newSessionData, client, false, auditEntity.ActionPasswordChange)
+	return
+}
+
+// Recover reset the master password using the private key given during registration.
+// The backup token is decrypted to retrieve the session secret, which then
+// re-encrypted as the access token using the new password
+func (s *UserService) Recover(params dtoUser.RecoverRequest) (res structs.StdResponse, code int) {
+	userData, err := s.userRepo.GetByEmail(params.Email)
+	if err != nil {
+		msg := "CREDENTIAL_ERROR"
+		code = fiber.StatusUnauthorized
+		if err.Error() != consts.ErrNoData.Error() {
+			s.log.Error(err.Error())
+			msg = "REQUEST_ERROR"
+			code = fiber.StatusBadRequest
+		}
+		res = structs.StdResponse{Message: msg, Data: err.Error()}
+		return
+	}
+	pub, err := crypto.ParsePublicKeyEd25519(userData.PublicKey)
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	pvt, err := crypto.ParsePrivateKeyEd25519(params.PrivateKey)
+	if err != nil {
+		res = structs.StdResponse{Message: "CREDENTIAL_ERROR", Data: "invalid credential"}
+		code = fiber.StatusUnauthorized
+		return
+	}
+	if err = crypto.ValidateKeyPairEd25519(pub, pvt); err != nil {
+		res = structs.StdResponse{Message: "CREDENTIAL_ERROR", Data: "invalid credential"}
+		code = fiber.StatusUnauthorized
+		return
+	}
+	// a backup token that can not be opened by the private key means the key is not the one it was sealed by.
+	// The backup token is sealed to the public key since the session secret is replaced on password change
+	var sessionData sessionEntity.Session
+	tokenData, err := crypto.OpenAnonymousX25519(userData.BackupToken, pvt)
+	if err != nil {
+		tokenData, err = crypto.DecryptAES(userData.BackupToken, helper.AbsoluteCharLen(fmt.Sprintf("%x", pvt), 32))
+	}
+	if err == nil {
+		err = json.Unmarshal([]byte(tokenData), &sessionData)
+	}
+	if err != nil {
+		res = structs.StdResponse{Message: "CREDENTIAL_ERROR", Data: "invalid credential"}
+		code = fiber.StatusUnauthorized
+		return
+	}
+	client := structs.StdClient{IP: params.IP, UserAgent: params.UserAgent}
+	if params.MfaToken == "" {
+		// the private key alone does not recover an account having a second factor, like the password alone
+		required, res, code := s.requireSecondFactor(userData, sessionData, sessionEntity.ScopeRecover, "", client)
+		if required || code != fiber.StatusOK {
+			return res, code
+		}
+	} else {
+		res, code = s.verifyRecoverFactor(userData, sessionData, params, client)
+		if code != fiber.StatusOK {
+			return
+		}
+	}
+	accessToken, kdfParams, err := s.sealAccessToken(tokenData, params.NewPassword)
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	hashedPass, err := bcrypt.GenerateFromPassword([]byte(params.NewPassword), 10)
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	err = s.userRepo.UpdatePassword(userRepo.UpdatePasswordParam{
+		ID:          userData.ID,
+		Password:    string(hashedPass),
+		AccessToken: accessToken,
+		KdfParams:   kdfParams,
+	})
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	if err = s.sessionRepo.PermanentDeleteByUserID(sessionData.UserID); err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	s.audit(auditEntity.ActionRecover, userData.ID, "", client)
+	s.forgetFailures(params.IP, userData.Email)
+	res = structs.StdResponse{Message: "UPDATED", Data: "account recovered, please login using the new password"}
+	code = fiber.StatusOK
+	return
+}
+
+// requireSecondFactor give a token of `scope` to a user who has a second factor enabled, which is only allowed
+// to submit the second factor. `required` tell whether the response is MFA_REQUIRED
+func (s *UserService) requireSecondFactor(
+	userData userEntity.User,
+	sessionData sessionEntity.Session,
+	scope string,
+	pendingSecret string,
+	client structs.StdClient,
+) (required bool, res structs.StdResponse, code int) {
+	credentials, err := s.webauthnRepo.GetAllByUserID(userData.ID)
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	var methods []string
+	if userData.TotpEnabledAt.Valid {
+		methods = append(methods, "totp", "recovery")
+	}
+	if len(credentials) > 0 {
+		methods = append(methods, "webauthn")
+	}
+	if len(methods) == 0 {
+		code = fiber.StatusOK
+		return
+	}
+	mfaId, err := s.sessionRepo.Create(sessionRepo.CreateParam{
+		ID:            uuid.GenerateUUID(),
+		UserID:        sessionData.UserID,
+		SecretKey:     sessionData.SecretKey,
+		Scope:         scope,
+		IP:            client.IP,
+		UserAgent:     client.UserAgent,
+		TTL:           mfaSessionTTL,
+		MaxTTL:        mfaSessionTTL,
+		PendingSecret: pendingSecret,
+	})
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	required = true
+	res = structs.StdResponse{
+		Message: "MFA_REQUIRED",
+		Data:    dtoUser.LoginMfaResponse{MfaToken: fmt.Sprintf("%x", mfaId.Bytes), Methods: methods},
+	}
+	code = fiber.StatusOK
+	return
+}
+
+// verifyRecoverFactor check the second factor of a recovery against the token given by Recover.
+// The token is single use, a wrong code require the recovery to start again
+func (s *UserService) verifyRecoverFactor(
+	userData userEntity.User,
+	sessionData sessionEntity.Session,
+	params dtoUser.RecoverRequest,
+	client structs.StdClient,
+) (res structs.StdResponse, code int) {
+	mfaUuid, mfaData, res, code := s.getMfaSession(params.MfaToken, sessionEntity.ScopeRecover)
+	if code != fiber.StatusOK {
+		return
+	}
+	if err := s.sessionRepo.PermanentDelete(mfaUuid); err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	if mfaData.UserID != userData.ID {
+		res = structs.StdResponse{Message: "ACCESS_DENIED", Data: "invalid token"}
+		code = fiber.StatusUnauthorized
+		return
+	}
+	res, code = s.verifySecondFactor(userData, sessionData, params.Code, params.RecoveryCode, params.Assertion)
+	if code == fiber.StatusUnauthorized {
+		s.audit(auditEntity.ActionLoginMfaFailure, userData.ID, "", client)
+	}
+	return
+}
+
+// authenticate retrieve the session of a token, `code` is fiber.StatusOK when the session is valid.
+// A session waiting for the second factor is rejected
+func (s *UserService) authenticate(token string) (
+	sessionUuid pgtype.UUID,
+	sessionData sessionEntity.Session,
+	res structs.StdResponse,
+	code int,
+) {
+	return authenticateSession(s.log, s.sessionRepo, token)
+}
+
+// forgetFailures forget the failed attempts of an account from an IP once it is fully authenticated
+func (s *UserService) forgetFailures(ip string, email string) {
+	if s.rateLimit != nil {
+		s.rateLimit.Succeed(ip, email)
+	}
+}
+
+// getSessionUser retrieve the user who own a session
+func (s *UserService) getSessionUser(sessionData sessionEntity.Session) (
+	userData userEntity.User,
+	res structs.StdResponse,
+	code int,
+) {
+	userData, err := s.userRepo.GetByID(sessionData.UserID)
+	if err != nil {
+		if err.Error() == consts.ErrNoData.Error() {
+			res = structs.StdResponse{Message: "ACCESS_DENIED", Data: err.Error()}
+			code = fiber.StatusUnauthorized
+		} else {
+			s.log.Error(err.Error())
+			res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+			code = fiber.StatusInternalServerError
+		}
+		return
+	}
+	code = fiber.StatusOK
+	return
+}
+
+// startSession create a fully authenticated session for the decrypted session data, audited as `action`.
+// A remembered device get the long-lived session when the policy allow it
+func (s *UserService) startSession(
+	sessionData sessionEntity.Session,
+	client structs.StdClient,
+	remember bool,
+	action string,
+) (res structs.StdResponse, code int) {
+	userAgent := client.UserAgent
+	if len(userAgent) > maxUserAgentLen {
+		userAgent = userAgent[:maxUserAgentLen]
+	}
+	ttl, maxTTL := s.policy.IdleTimeout, s.policy.MaxLifetime
+	if remember && s.policy.RememberTimeout > 0 {
+		ttl, maxTTL = s.policy.RememberTimeout, s.policy.RememberTimeout
+	}
 	sessionId, err := s.sessionRepo.Create(sessionRepo.CreateParam{
 		ID:        uuid.GenerateUUID(),
 		UserID:    sessionData.UserID,
 		SecretKey: sessionData.SecretKey,
+		IP:        client.IP,
+		UserAgent: userAgent,
+		Device:    helper.DeviceFromUserAgent(userAgent),
+		TTL:       ttl,
+		MaxTTL:    maxTTL,
 	})
 	if err != nil {
 		s.log.Error(err.Error())
@@ -201,6 +647,8 @@ func (s *UserService) Login(params dtoUser.LoginRequest) (res structs.StdRespons
 		code = fiber.StatusInternalServerError
 		return
 	}
+	// the session ID is the token itself, so it is not kept in the audit
+	s.audit(action, sessionData.UserID, "", client)
 	res = structs.StdResponse{
 		Message: "SUCCESS",
 		Data:    dtoUser.LoginResponse{AccessToken: fmt.Sprintf("%x", sessionId.Bytes)},
@@ -209,23 +657,183 @@ func (s *UserService) Login(params dtoUser.LoginRequest) (res structs.StdRespons
 	return
 }
 
-// Logout delete an active session of current user
-func (s *UserService) Logout(token string) (res structs.StdResponse, code int) {
-	tokenBytes, err := uuid.ParseUUID(token)
+// audit record an event of a user, the target is the session or the credential the event is about
+func (s *UserService) audit(action string, userID pgtype.UUID, targetID string, client structs.StdClient) {
+	recordAudit(s.log, s.auditRepo, client, auditRepo.CreateParam{
+		UserID:     userID,
+		Action:     action,
+		TargetID:   targetID,
+		SigningKey: s.auditKey,
+	})
+}
+
+// pendingSecret is a new random session secret with the access token sealed for it,
+// so that it can be applied by replaceSecret without knowing the password
+type pendingSecret struct {
+	SecretKey   string `json:"secretKey"`
+	AccessToken string `json:"accessToken"`
+	KdfParams   string `json:"kdfParams"`
+}
+
+func (p pendingSecret) encode() (string, error) {
+	encoded, err := json.Marshal(p)
+	return string(encoded), err
+}
+
+// sealSecret generate a new random session secret for the user, `password` is the password the access token is
+// encrypted by. Nothing is written, the secret is given to the user by replaceSecret
+func (s *UserService) sealSecret(userData userEntity.User, password string) (pending pendingSecret, err error) {
+	secret, err := crypto.GenerateRandomKey(32)
 	if err != nil {
-		s.log.Error(err.Error())
-		res = structs.StdResponse{Message: "REQUEST_ERROR", Data: err.Error()}
-		code = fiber.StatusBadRequest
 		return
 	}
-	err = s.sessionRepo.PermanentDelete(pgtype.UUID{Bytes: tokenBytes, Valid: true})
+	pending.SecretKey = fmt.Sprintf("%x", secret)
+	tokenData, err := json.Marshal(sessionEntity.Session{UserID: userData.ID, SecretKey: pending.SecretKey})
+	if err != nil {
+		return
+	}
+	pending.AccessToken, pending.KdfParams, err = s.sealAccessToken(string(tokenData), password)
+	return
+}
+
+// applyPendingSecret give the user the legacy secret replacement `encoded` sealed on login and revoke the sessions
+// keeping the old secret. The session data is returned unchanged when there is no replacement or it fails,
+// the next login try again
+func (s *UserService) applyPendingSecret(
+	userData userEntity.User,
+	sessionData sessionEntity.Session,
+	encoded string,
+) sessionEntity.Session {
+	if encoded == "" {
+		return sessionData
+	}
+	var pending pendingSecret
+	err := json.Unmarshal([]byte(encoded), &pending)
+	var newSessionData sessionEntity.Session
+	if err == nil {
+		newSessionData, err = s.replaceSecret(userData, sessionData, pending, userData.Password)
+	}
+	if err == nil {
+		// the other sessions keep the old secret, which no longer open the vaults
+		err = s.sessionRepo.PermanentDeleteByUserID(userData.ID)
+	}
 	if err != nil {
 		s.log.Error(err.Error())
-		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
-		code = fiber.StatusInternalServerError
+		return sessionData
+	}
+	return newSessionData
+}
+
+// replaceSecret give the user the new session secret `pending`, `passwordHash` is the bcrypt hash of the password
+// the access token of `pending` is encrypted by. Every value encrypted by a key derived from the old secret
+// is re-encrypted in the same transaction, the sessions keeping the old secret must be revoked afterward.
+// consts.ErrConflict is returned when any of the values changed meanwhile
+func (s *UserService) replaceSecret(
+	userData userEntity.User,
+	sessionData sessionEntity.Session,
+	pending pendingSecret,
+	passwordHash string,
+) (newSessionData sessionEntity.Session, err error) {
+	newSessionData = sessionEntity.Session{UserID: userData.ID, SecretKey: pending.SecretKey}
+	tokenData, err := json.Marshal(newSessionData)
+	if err != nil {
 		return
 	}
-	res = structs.StdResponse{Message: "SUCCESS", Data: "logged out"}
-	code = fiber.StatusOK
+	arg := userRepo.ReplaceSecretParam{
+		ID:          userData.ID,
+		Password:    passwordHash,
+		AccessToken: userRepo.SealedValue{Old: userData.AccessToken, New: pending.AccessToken},
+		KdfParams:   pending.KdfParams,
+		TotpSecret:  userRepo.SealedValue{Old: userData.TotpSecret},
+		VaultKeys:   map[uint64]userRepo.SealedValue{},
+		Credentials: map[pgtype.UUID]userRepo.SealedValue{},
+	}
+	// the private key is not known here, so the backup token is sealed to the public key
+	pub, err := crypto.ParsePublicKeyEd25519(userData.PublicKey)
+	if err != nil {
+		return
+	}
+	if arg.BackupToken, err = crypto.SealAnonymousX25519(string(tokenData), pub); err != nil {
+		return
+	}
+	if userData.TotpSecret != "" {
+		totpSecret, err := s.openTotpSecret(sessionData, userData.TotpSecret)
+		if err != nil {
+			return newSessionData, err
+		}
+		if arg.TotpSecret.New, err = s.sealTotpSecret(newSessionData, totpSecret); err != nil {
+			return newSessionData, err
+		}
+	}
+	vaultKeys, err := s.userRepo.GetAllVaultKey(userData.ID)
+	if err != nil {
+		return
+	}
+	for _, item := range vaultKeys {
+		if item.VaultKey == "" && item.InviteKey != "" {
+			// a pending invitation is sealed to the public key, which does not change
+			continue
+		}
+		var vaultKey []byte
+		if item.VaultKey == "" {
+			// a vault created before vault keys existed is moved to a new vault key
+			credentials, err := crypto.DecryptAES(item.Credential, sessionData.SecretKey)
+			if err != nil {
+				return newSessionData, err
+			}
+			if vaultKey, err = crypto.GenerateRandomKey(32); err != nil {
+				return newSessionData, err
+			}
+			credential, err := crypto.SealEnvelope(
+				crypto.AlgorithmAESGCM,
+				vaultKey,
+				"",
+				[]byte(credentials),
+				vaultAAD(vaultEntity.Vault{ID: item.VaultID}, sealCredentialBlob),
+			)
+			if err != nil {
+				return newSessionData, err
+			}
+			arg.Credentials[item.VaultID] = userRepo.SealedValue{Old: item.Credential, New: credential}
+		} else if vaultKey, err = unwrapVaultKey(sessionData, item.VaultKey); err != nil {
+			return
+		}
+		wrappedKey, err := wrapVaultKey(newSessionData, vaultKey)
+		if err != nil {
+			return newSessionData, err
+		}
+		arg.VaultKeys[item.ID] = userRepo.SealedValue{Old: item.VaultKey, New: wrappedKey}
+	}
+	err = s.userRepo.ReplaceSecret(arg)
 	return
 }
+
+// isLegacySecret tell whether a session secret is the one derived from the password, before it was random
+func isLegacySecret(secret string) bool {
+	return len(secret) < 64
+}
+
+// sealAccessToken encrypt the session data using a key derived from the password with a fresh salt
+func (s *UserService) sealAccessToken(tokenData, password string) (accessToken string, kdfParams string, err error) {
+	params, err := crypto.NewArgon2idParams(s.kdfTime, s.kdfMemory, s.kdfThreads)
+	if err != nil {
+		return
+	}
+	accessToken, err = crypto.Seal([]byte(tokenData), crypto.DeriveKeyArgon2id(password, params))
+	kdfParams = params.String()
+	return
+}
+
+// openAccessToken decrypt the session data of a user using the password.
+// A user without KDF parameters has the access token encrypted by the padded password
+func (s *UserService) openAccessToken(userData userEntity.User, password string) (string, error) {
+	if userData.KdfParams == "" {
+		return crypto.DecryptAES(userData.AccessToken, helper.AbsoluteCharLen(password, 16))
+	}
+	params, err := crypto.ParseArgon2idParams(userData.KdfParams)
+	if err != nil {
+		return "", err
+	}
+	tokenData, err := crypto.Open(userData.AccessToken, crypto.DeriveKeyArgon2id(password, params))
+	return string(tokenData), err
+}
@@ -1,37 +1,79 @@
 package service
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/Novando/pintartek/internal/passvault-service/app/dto/user"
+	auditRepo "github.com/Novando/pintartek/internal/passvault-service/domain/audit/repository"
 	clientRepo "github.com/Novando/pintartek/internal/passvault-service/domain/client/repository"
+	sessionEntity "github.com/Novando/pintartek/internal/passvault-service/domain/session/entity"
 	sessionRepo "github.com/Novando/pintartek/internal/passvault-service/domain/session/repository"
 	userEntity "github.com/Novando/pintartek/internal/passvault-service/domain/user/entity"
 	userRepo "github.com/Novando/pintartek/internal/passvault-service/domain/user/repository"
+	webauthnEntity "github.com/Novando/pintartek/internal/passvault-service/domain/webauthn/entity"
+	webauthnRepo "github.com/Novando/pintartek/internal/passvault-service/domain/webauthn/repository"
 	"github.com/Novando/pintartek/pkg/common/consts"
-	"github.com/Novando/pintartek/pkg/helper"
+	"github.com/Novando/pintartek/pkg/common/structs"
+	"github.com/Novando/pintartek/pkg/crypto"
 	"github.com/Novando/pintartek/pkg/uuid"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"golang.org/x/crypto/bcrypt"
 	"net/http"
 	"testing"
+	"time"
 )
 
+// testSecretKey is a random session secret, as given at registration
+const testSecretKey = "114886bb644e4ef09113952e2bb56b75114886bb644e4ef09113952e2bb56b75"
+
 type testUserService struct {
-	serv        *UserService
-	userMock    *userRepo.UserMock
-	clientMock  *clientRepo.ClientMock
-	sessionMock *sessionRepo.SessionMock
+	serv         *UserService
+	userMock     *userRepo.UserMock
+	clientMock   *clientRepo.ClientMock
+	sessionMock  *sessionRepo.SessionMock
+	webauthnMock *webauthnRepo.WebauthnMock
 }
 
 func initTestUserService(t *testing.T) testUserService {
 	ms := sessionRepo.NewMockSessionRepository(t)
 	mu := userRepo.NewMockUserRepository(t)
 	mc := clientRepo.NewMockClientRepository(t)
+	mw := webauthnRepo.NewMockWebauthnRepository(t)
+	ma := auditRepo.NewMockAuditRepository(t)
+	ma.Mock.On("Create", mock.Anything).Return(nil).Maybe()
 	return testUserService{
-		userMock:    mu,
-		clientMock:  mc,
-		sessionMock: ms,
-		serv:        NewUserService(WithMock(mu, ms, mc)),
+		userMock:     mu,
+		clientMock:   mc,
+		sessionMock:  ms,
+		webauthnMock: mw,
+		// a cheap KDF keep the tests fast
+		serv: NewUserService(WithMock(mu, ms, mc, ma, mw), WithUserKDF(1, 8*1024, 1)),
+	}
+}
+
+// newTestUser build a registered user whose access token hold `sessionData`
+func newTestUser(t *testing.T, ts testUserService, password string, sessionData sessionEntity.Session) userEntity.User {
+	hashedPass, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenData, err := json.Marshal(sessionData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accessToken, kdfParams, err := ts.serv.sealAccessToken(string(tokenData), password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return userEntity.User{
+		ID:          sessionData.UserID,
+		Email:       "test@test.com",
+		Password:    string(hashedPass),
+		AccessToken: accessToken,
+		KdfParams:   kdfParams,
 	}
 }
 
@@ -43,26 +85,24 @@ func TestUserService_Register_Success(t *testing.T) {
 		Password:        "passwordpassword",
 		ConfirmPassword: "passwordpassword",
 	}
-	userCreateParam := userRepo.CreateParam{
-		ID:          pgtype.UUID{},
-		Email:       registerUserParam.Email,
-		Password:    "hashedPassword",
-		PublicKey:   "publicKey",
-		AccessToken: "accessToken",
-		BackupToken: "backupToken",
-	}
+	userId := uuid.GenerateUUID()
+	userCreateParam := mock.MatchedBy(func(arg userRepo.CreateParam) bool {
+		return arg.Email == registerUserParam.Email &&
+			bcrypt.CompareHashAndPassword([]byte(arg.Password), []byte(registerUserParam.Password)) == nil &&
+			arg.PublicKey != "" && arg.AccessToken != "" && arg.BackupToken != "" && arg.KdfParams != ""
+	})
 
 	ts.userMock.Mock.On("GetByEmail", registerUserParam.Email).Return(userEntity.User{}, consts.ErrNoData)
-	ts.userMock.Mock.On("Create", userCreateParam).Return(pgtype.UUID{}, nil)
-	ts.clientMock.Mock.On("Create", registerUserParam.FullName, pgtype.UUID{}).Return(pgtype.UUID{}, nil)
+	ts.userMock.Mock.On("Create", userCreateParam).Return(userId, nil)
+	ts.clientMock.Mock.On("Create", registerUserParam.FullName, userId).Return(pgtype.UUID{}, nil)
 
 	res, code := ts.serv.Register(registerUserParam)
-	iface, err := helper.InterfaceToMapInterface(res.Data)
-	if err != nil {
-		t.Fatal(err)
+	data, ok := res.Data.(user.RegisterResponse)
+	if !ok {
+		t.Fatalf("unexpected response %v", res.Data)
 	}
 	assert.Equal(t, "CREATED", res.Message)
-	assert.NotEmpty(t, iface["privateKey"])
+	assert.NotEmpty(t, data.PrivateKey)
 	assert.Equal(t, http.StatusOK, code)
 }
 
@@ -90,17 +130,9 @@ func TestUserService_Register_FailCreateUser(t *testing.T) {
 		Password:        "passwordpassword",
 		ConfirmPassword: "passwordpassword",
 	}
-	userCreateParam := userRepo.CreateParam{
-		ID:          pgtype.UUID{},
-		Email:       registerUserParam.Email,
-		Password:    "hashedPassword",
-		PublicKey:   "publicKey",
-		AccessToken: "accessToken",
-		BackupToken: "backupToken",
-	}
 
 	ts.userMock.Mock.On("GetByEmail", registerUserParam.Email).Return(userEntity.User{}, consts.ErrNoData)
-	ts.userMock.Mock.On("Create", userCreateParam).Return(pgtype.UUID{}, errors.New("err"))
+	ts.userMock.Mock.On("Create", mock.Anything).Return(pgtype.UUID{}, errors.New("err"))
 
 	res, code := ts.serv.Register(registerUserParam)
 	assert.Equal(t, "PROCESS_ERROR", res.Message)
@@ -115,17 +147,9 @@ func TestUserService_Register_FailCreateSession(t *testing.T) {
 		Password:        "passwordpassword",
 		ConfirmPassword: "passwordpassword",
 	}
-	userCreateParam := userRepo.CreateParam{
-		ID:          pgtype.UUID{},
-		Email:       registerUserParam.Email,
-		Password:    "hashedPassword",
-		PublicKey:   "publicKey",
-		AccessToken: "accessToken",
-		BackupToken: "backupToken",
-	}
 
 	ts.userMock.Mock.On("GetByEmail", registerUserParam.Email).Return(userEntity.User{}, consts.ErrNoData)
-	ts.userMock.Mock.On("Create", userCreateParam).Return(pgtype.UUID{}, nil)
+	ts.userMock.Mock.On("Create", mock.Anything).Return(pgtype.UUID{}, nil)
 	ts.clientMock.Mock.On("Create", registerUserParam.FullName, pgtype.UUID{}).Return(pgtype.UUID{}, errors.New("err"))
 
 	res, code := ts.serv.Register(registerUserParam)
@@ -178,8 +202,9 @@ func TestUserService_Login_PasswordMismatch(t *testing.T) {
 		Email:    "test@test.com",
 		Password: "password",
 	}
+	userData := newTestUser(t, ts, "passwordpassword", sessionEntity.Session{UserID: uuid.GenerateUUID()})
 
-	ts.userMock.Mock.On("GetByEmail", registerUserParam.Email).Return(userEntity.User{}, nil)
+	ts.userMock.Mock.On("GetByEmail", registerUserParam.Email).Return(userData, nil)
 
 	res, code := ts.serv.Login(registerUserParam)
 	assert.Equal(t, "CREDENTIAL_ERROR", res.Message)
@@ -192,14 +217,12 @@ func TestUserService_Login_FailCreateSession(t *testing.T) {
 		Email:    "test@test.com",
 		Password: "passwordpassword",
 	}
-	createSessionParam := sessionRepo.CreateParam{
-		ID:        pgtype.UUID{},
-		UserID:    pgtype.UUID{},
-		SecretKey: "secretKey",
-	}
+	sessionData := sessionEntity.Session{UserID: uuid.GenerateUUID(), SecretKey: testSecretKey}
+	userData := newTestUser(t, ts, registerUserParam.Password, sessionData)
 
-	ts.userMock.Mock.On("GetByEmail", registerUserParam.Email).Return(userEntity.User{}, nil)
-	ts.sessionMock.Mock.On("Create", createSessionParam).Return(userEntity.User{}, errors.New("err"))
+	ts.userMock.Mock.On("GetByEmail", registerUserParam.Email).Return(userData, nil)
+	ts.webauthnMock.Mock.On("GetAllByUserID", userData.ID).Return([]webauthnEntity.Credential{}, nil)
+	ts.sessionMock.Mock.On("Create", mock.Anything).Return(pgtype.UUID{}, errors.New("err"))
 
 	res, code := ts.serv.Login(registerUserParam)
 	assert.Equal(t, "PROCESS_ERROR", res.Message)
@@ -212,28 +235,129 @@ func TestUserService_Login_Success(t *testing.T) {
 		Email:    "test@test.com",
 		Password: "passwordpassword",
 	}
-	createSessionParam := sessionRepo.CreateParam{
-		ID:        pgtype.UUID{},
-		UserID:    pgtype.UUID{},
-		SecretKey: "secretKey",
-	}
+	sessionData := sessionEntity.Session{UserID: uuid.GenerateUUID(), SecretKey: testSecretKey}
+	userData := newTestUser(t, ts, registerUserParam.Password, sessionData)
+	createSessionParam := mock.MatchedBy(func(arg sessionRepo.CreateParam) bool {
+		return arg.UserID == sessionData.UserID && arg.SecretKey == sessionData.SecretKey && arg.Scope == ""
+	})
 
-	ts.userMock.Mock.On("GetByEmail", registerUserParam.Email).Return(userEntity.User{}, nil)
-	ts.sessionMock.Mock.On("Create", createSessionParam).Return(userEntity.User{}, nil)
+	ts.userMock.Mock.On("GetByEmail", registerUserParam.Email).Return(userData, nil)
+	ts.webauthnMock.Mock.On("GetAllByUserID", userData.ID).Return([]webauthnEntity.Credential{}, nil)
+	ts.sessionMock.Mock.On("Create", createSessionParam).Return(uuid.GenerateUUID(), nil)
 
 	res, code := ts.serv.Login(registerUserParam)
 	assert.Equal(t, "SUCCESS", res.Message)
 	assert.Equal(t, http.StatusOK, code)
 }
 
+func TestUserService_Login_LegacySecret(t *testing.T) {
+	ts := initTestUserService(t)
+	pub, _, err := crypto.GenerateKeyPairEd25519()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loginParam := user.LoginRequest{
+		Email:    "test@test.com",
+		Password: "passwordpassword",
+	}
+	// the secret derived from the password before the secrets were random
+	sessionData := sessionEntity.Session{UserID: uuid.GenerateUUID(), SecretKey: "passwordpassword"}
+	userData := newTestUser(t, ts, loginParam.Password, sessionData)
+	userData.PublicKey = fmt.Sprintf("%x", pub)
+	legacyCredential, err := crypto.EncryptAES("{}", sessionData.SecretKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vaultKeys := []userEntity.VaultKey{{ID: 1, VaultID: uuid.GenerateUUID(), Credential: legacyCredential}}
+	var newSecretKey string
+	replaceSecretParam := mock.MatchedBy(func(arg userRepo.ReplaceSecretParam) bool {
+		return arg.Password == userData.Password &&
+			arg.AccessToken.Old == userData.AccessToken &&
+			arg.VaultKeys[1].Old == "" && arg.VaultKeys[1].New != "" &&
+			arg.Credentials[vaultKeys[0].VaultID].Old == legacyCredential
+	})
+	createSessionParam := mock.MatchedBy(func(arg sessionRepo.CreateParam) bool {
+		newSecretKey = arg.SecretKey
+		return arg.UserID == sessionData.UserID
+	})
+
+	ts.userMock.Mock.On("GetByEmail", loginParam.Email).Return(userData, nil)
+	ts.userMock.Mock.On("GetAllVaultKey", userData.ID).Return(vaultKeys, nil)
+	ts.userMock.Mock.On("ReplaceSecret", replaceSecretParam).Return(nil)
+	ts.sessionMock.Mock.On("PermanentDeleteByUserID", userData.ID, mock.Anything).Return(nil)
+	ts.webauthnMock.Mock.On("GetAllByUserID", userData.ID).Return([]webauthnEntity.Credential{}, nil)
+	ts.sessionMock.Mock.On("Create", createSessionParam).Return(uuid.GenerateUUID(), nil)
+
+	res, code := ts.serv.Login(loginParam)
+	assert.Equal(t, "SUCCESS", res.Message)
+	assert.Equal(t, http.StatusOK, code)
+	assert.False(t, isLegacySecret(newSecretKey))
+}
+
+func TestUserService_Login_LegacySecretConflict(t *testing.T) {
+	ts := initTestUserService(t)
+	pub, _, err := crypto.GenerateKeyPairEd25519()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loginParam := user.LoginRequest{
+		Email:    "test@test.com",
+		Password: "passwordpassword",
+	}
+	sessionData := sessionEntity.Session{UserID: uuid.GenerateUUID(), SecretKey: "passwordpassword"}
+	userData := newTestUser(t, ts, loginParam.Password, sessionData)
+	userData.PublicKey = fmt.Sprintf("%x", pub)
+	createSessionParam := mock.MatchedBy(func(arg sessionRepo.CreateParam) bool {
+		return arg.SecretKey == sessionData.SecretKey
+	})
+
+	ts.userMock.Mock.On("GetByEmail", loginParam.Email).Return(userData, nil)
+	ts.userMock.Mock.On("GetAllVaultKey", userData.ID).Return([]userEntity.VaultKey{}, nil)
+	ts.userMock.Mock.On("ReplaceSecret", mock.Anything).Return(consts.ErrConflict)
+	ts.webauthnMock.Mock.On("GetAllByUserID", userData.ID).Return([]webauthnEntity.Credential{}, nil)
+	ts.sessionMock.Mock.On("Create", createSessionParam).Return(uuid.GenerateUUID(), nil)
+
+	// the login goes on using the old secret
+	res, code := ts.serv.Login(loginParam)
+	assert.Equal(t, "SUCCESS", res.Message)
+	assert.Equal(t, http.StatusOK, code)
+	ts.sessionMock.Mock.AssertNotCalled(t, "PermanentDeleteByUserID", userData.ID, mock.Anything)
+}
+
+func TestUserService_Login_LegacySecretMfa(t *testing.T) {
+	ts := initTestUserService(t)
+	loginParam := user.LoginRequest{
+		Email:    "test@test.com",
+		Password: "passwordpassword",
+	}
+	sessionData := sessionEntity.Session{UserID: uuid.GenerateUUID(), SecretKey: "passwordpassword"}
+	userData := newTestUser(t, ts, loginParam.Password, sessionData)
+	createSessionParam := mock.MatchedBy(func(arg sessionRepo.CreateParam) bool {
+		return arg.SecretKey == sessionData.SecretKey && arg.Scope == sessionEntity.ScopeMFA && arg.PendingSecret != ""
+	})
+
+	ts.userMock.Mock.On("GetByEmail", loginParam.Email).Return(userData, nil)
+	ts.webauthnMock.Mock.On("GetAllByUserID", userData.ID).Return([]webauthnEntity.Credential{{ID: 1}}, nil)
+	ts.sessionMock.Mock.On("Create", createSessionParam).Return(uuid.GenerateUUID(), nil)
+
+	// the secret is only replaced once the second factor is verified
+	res, code := ts.serv.Login(loginParam)
+	assert.Equal(t, "MFA_REQUIRED", res.Message)
+	assert.Equal(t, http.StatusOK, code)
+	ts.userMock.Mock.AssertNotCalled(t, "ReplaceSecret", mock.Anything)
+	ts.sessionMock.Mock.AssertNotCalled(t, "PermanentDeleteByUserID", userData.ID, mock.Anything)
+}
+
 func TestUserService_Logout_Success(t *testing.T) {
 	ts := initTestUserService(t)
 	token := "114886bb644e4ef09113952e2bb56b75"
 	tokenBytes, _ := uuid.ParseUUID(token)
+	sessionData := sessionEntity.Session{UserID: uuid.GenerateUUID(), FamilyID: uuid.GenerateUUID()}
 
-	ts.sessionMock.Mock.On("PermanentDelete", pgtype.UUID{Bytes: tokenBytes, Valid: true}).Return(userEntity.User{}, nil)
+	ts.sessionMock.Mock.On("GetByID", pgtype.UUID{Bytes: tokenBytes, Valid: true}).Return(sessionData, nil)
+	ts.sessionMock.Mock.On("PermanentDeleteByFamilyID", sessionData.FamilyID).Return(nil)
 
-	res, code := ts.serv.Logout(token)
+	res, code := ts.serv.Logout(token, structs.StdClient{})
 	assert.Equal(t, "SUCCESS", res.Message)
 	assert.Equal(t, http.StatusOK, code)
 }
@@ -241,7 +365,7 @@ func TestUserService_Logout_Success(t *testing.T) {
 func TestUserService_Logout_FailParseToken(t *testing.T) {
 	ts := initTestUserService(t)
 	token := "114886bb644e"
-	res, code := ts.serv.Logout(token)
+	res, code := ts.serv.Logout(token, structs.StdClient{})
 	assert.Equal(t, "REQUEST_ERROR", res.Message)
 	assert.Equal(t, http.StatusBadRequest, code)
 }
@@ -250,10 +374,178 @@ func TestUserService_Logout_FailDeleteSession(t *testing.T) {
 	ts := initTestUserService(t)
 	token := "114886bb644e4ef09113952e2bb56b75"
 	tokenBytes, _ := uuid.ParseUUID(token)
+	sessionData := sessionEntity.Session{UserID: uuid.GenerateUUID(), FamilyID: uuid.GenerateUUID()}
 
-	ts.sessionMock.Mock.On("PermanentDelete", pgtype.UUID{Bytes: tokenBytes, Valid: true}).Return(userEntity.User{}, errors.New("err"))
+	ts.sessionMock.Mock.On("GetByID", pgtype.UUID{Bytes: tokenBytes, Valid: true}).Return(sessionData, nil)
+	ts.sessionMock.Mock.On("PermanentDeleteByFamilyID", sessionData.FamilyID).Return(errors.New("err"))
 
-	res, code := ts.serv.Logout(token)
+	res, code := ts.serv.Logout(token, structs.StdClient{})
 	assert.Equal(t, "PROCESS_ERROR", res.Message)
 	assert.Equal(t, http.StatusInternalServerError, code)
 }
+
+func TestUserService_Recover_InvalidBackupToken(t *testing.T) {
+	ts := initTestUserService(t)
+	pub, pvt, err := crypto.GenerateKeyPairEd25519()
+	if err != nil {
+		t.Fatal(err)
+	}
+	recoverParam := user.RecoverRequest{
+		Email:       "test@test.com",
+		PrivateKey:  fmt.Sprintf("%x", pvt),
+		NewPassword: "passwordpassword",
+	}
+	userData := userEntity.User{
+		ID:          uuid.GenerateUUID(),
+		Email:       recoverParam.Email,
+		PublicKey:   fmt.Sprintf("%x", pub),
+		BackupToken: "invalid",
+	}
+
+	ts.userMock.Mock.On("GetByEmail", recoverParam.Email).Return(userData, nil)
+
+	res, code := ts.serv.Recover(recoverParam)
+	assert.Equal(t, "CREDENTIAL_ERROR", res.Message)
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
+
+func TestUserService_Recover_MfaRequired(t *testing.T) {
+	ts := initTestUserService(t)
+	pub, pvt, err := crypto.GenerateKeyPairEd25519()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessionData := sessionEntity.Session{UserID: uuid.GenerateUUID(), SecretKey: testSecretKey}
+	tokenData, err := json.Marshal(sessionData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backupToken, err := crypto.SealAnonymousX25519(string(tokenData), pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recoverParam := user.RecoverRequest{
+		Email:       "test@test.com",
+		PrivateKey:  fmt.Sprintf("%x", pvt),
+		NewPassword: "passwordpassword",
+	}
+	userData := userEntity.User{
+		ID:            sessionData.UserID,
+		Email:         recoverParam.Email,
+		PublicKey:     fmt.Sprintf("%x", pub),
+		BackupToken:   backupToken,
+		TotpEnabledAt: pgtype.Timestamptz{Time: time.Now(), Valid: true},
+	}
+	createSessionParam := mock.MatchedBy(func(arg sessionRepo.CreateParam) bool {
+		return arg.UserID == userData.ID && arg.Scope == sessionEntity.ScopeRecover
+	})
+
+	ts.userMock.Mock.On("GetByEmail", recoverParam.Email).Return(userData, nil)
+	ts.webauthnMock.Mock.On("GetAllByUserID", userData.ID).Return([]webauthnEntity.Credential{}, nil)
+	ts.sessionMock.Mock.On("Create", createSessionParam).Return(uuid.GenerateUUID(), nil)
+
+	// the private key alone does not reset the password
+	res, code := ts.serv.Recover(recoverParam)
+	assert.Equal(t, "MFA_REQUIRED", res.Message)
+	assert.Equal(t, http.StatusOK, code)
+	ts.userMock.Mock.AssertNotCalled(t, "UpdatePassword", mock.Anything)
+}
+
+func TestUserService_ChangePassword_Success(t *testing.T) {
+	ts := initTestUserService(t)
+	pub, pvt, err := crypto.GenerateKeyPairEd25519()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessionUuid := uuid.GenerateUUID()
+	sessionData := sessionEntity.Session{
+		ID:        sessionUuid,
+		UserID:    uuid.GenerateUUID(),
+		FamilyID:  sessionUuid,
+		SecretKey: testSecretKey,
+	}
+	changePasswordParam := user.ChangePasswordRequest{OldPassword: "passwordpassword", NewPassword: "newpasswordnewpassword"}
+	userData := newTestUser(t, ts, changePasswordParam.OldPassword, sessionEntity.Session{
+		UserID:    sessionData.UserID,
+		SecretKey: sessionData.SecretKey,
+	})
+	userData.PublicKey = fmt.Sprintf("%x", pub)
+	vaultKey, err := crypto.GenerateRandomKey(32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrappedKey, err := wrapVaultKey(sessionData, vaultKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vaultKeys := []userEntity.VaultKey{
+		{ID: 1, VaultID: uuid.GenerateUUID(), VaultKey: wrappedKey},
+		{ID: 2, VaultID: uuid.GenerateUUID(), InviteKey: "pending"},
+	}
+	var replaceSecretArg userRepo.ReplaceSecretParam
+
+	ts.sessionMock.Mock.On("GetByID", sessionUuid).Return(sessionData, nil)
+	ts.userMock.Mock.On("GetByID", userData.ID).Return(userData, nil)
+	ts.userMock.Mock.On("GetAllVaultKey", userData.ID).Return(vaultKeys, nil)
+	ts.userMock.Mock.On("ReplaceSecret", mock.MatchedBy(func(arg userRepo.ReplaceSecretParam) bool {
+		replaceSecretArg = arg
+		return true
+	})).Return(nil)
+	ts.sessionMock.Mock.On("PermanentDeleteByUserID", userData.ID, mock.Anything).Return(nil)
+	ts.sessionMock.Mock.On("Create", mock.Anything).Return(uuid.GenerateUUID(), nil)
+
+	res, code := ts.serv.ChangePassword(fmt.Sprintf("%x", sessionUuid.Bytes), changePasswordParam, structs.StdClient{})
+	assert.Equal(t, "SUCCESS", res.Message)
+	assert.Equal(t, http.StatusOK, code)
+
+	// the new password open a new session secret, which wrap the same vault key
+	tokenData, err := ts.serv.openAccessToken(userEntity.User{
+		AccessToken: replaceSecretArg.AccessToken.New,
+		KdfParams:   replaceSecretArg.KdfParams,
+	}, changePasswordParam.NewPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var newSessionData sessionEntity.Session
+	if err = json.Unmarshal([]byte(tokenData), &newSessionData); err != nil {
+		t.Fatal(err)
+	}
+	assert.NotEqual(t, sessionData.SecretKey, newSessionData.SecretKey)
+	assert.Len(t, replaceSecretArg.VaultKeys, 1)
+	newVaultKey, err := unwrapVaultKey(newSessionData, replaceSecretArg.VaultKeys[1].New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, vaultKey, newVaultKey)
+	assert.Equal(t, wrappedKey, replaceSecretArg.VaultKeys[1].Old)
+
+	// the private key still open the backup token
+	backupTokenData, err := crypto.OpenAnonymousX25519(replaceSecretArg.BackupToken, pvt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, tokenData, backupTokenData)
+}
+
+func TestUserService_ChangePassword_Conflict(t *testing.T) {
+	ts := initTestUserService(t)
+	pub, _, err := crypto.GenerateKeyPairEd25519()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessionUuid := uuid.GenerateUUID()
+	sessionData := sessionEntity.Session{ID: sessionUuid, UserID: uuid.GenerateUUID(), FamilyID: sessionUuid}
+	changePasswordParam := user.ChangePasswordRequest{OldPassword: "passwordpassword", NewPassword: "newpasswordnewpassword"}
+	userData := newTestUser(t, ts, changePasswordParam.OldPassword, sessionData)
+	userData.PublicKey = fmt.Sprintf("%x", pub)
+
+	ts.sessionMock.Mock.On("GetByID", sessionUuid).Return(sessionData, nil)
+	ts.userMock.Mock.On("GetByID", userData.ID).Return(userData, nil)
+	ts.userMock.Mock.On("GetAllVaultKey", userData.ID).Return([]userEntity.VaultKey{}, nil)
+	ts.userMock.Mock.On("ReplaceSecret", mock.Anything).Return(consts.ErrConflict)
+
+	res, code := ts.serv.ChangePassword(fmt.Sprintf("%x", sessionUuid.Bytes), changePasswordParam, structs.StdClient{})
+	assert.Equal(t, "CONFLICT", res.Message)
+	assert.Equal(t, http.StatusConflict, code)
+	ts.sessionMock.Mock.AssertNotCalled(t, "PermanentDeleteByUserID", userData.ID, mock.Anything)
+}
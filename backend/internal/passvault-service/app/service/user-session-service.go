@@ -0,0 +1,139 @@
+package service
+
+import (
+	"fmt"
+	dtoUser "github.com/Novando/pintartek/internal/passvault-service/app/dto/user"
+	auditEntity "github.com/Novando/pintartek/internal/passvault-service/domain/audit/entity"
+	"github.com/Novando/pintartek/pkg/common/consts"
+	"github.com/Novando/pintartek/pkg/common/structs"
+	"github.com/Novando/pintartek/pkg/uuid"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgtype"
+	"time"
+)
+
+// maxUserAgentLen is the longest user agent kept with a session
+const maxUserAgentLen = 512
+
+// SessionPolicy decide how long a session live, zero values fall back to the defaults of the session repository
+type SessionPolicy struct {
+	// IdleTimeout expire a session that is not used for that long
+	IdleTimeout time.Duration
+	// MaxLifetime expire a session regardless of its use, rotating its token does not extend it
+	MaxLifetime time.Duration
+	// RememberTimeout is both the idle timeout and the lifetime of a session on a remembered device,
+	// remembering a device is disabled when it is zero
+	RememberTimeout time.Duration
+}
+
+// SessionAccount tell the email of the user a session belong to, so the attempts made using a session are counted
+// against the account. Looking it up does not extend the session, it is empty when the session is unknown
+func (s *UserService) SessionAccount(token string) string {
+	tokenBytes, err := uuid.ParseUUID(token)
+	if err != nil {
+		return ""
+	}
+	sessionData, err := s.sessionRepo.PeekByID(pgtype.UUID{Bytes: tokenBytes, Valid: true})
+	if err != nil || sessionData.Scope != "" {
+		return ""
+	}
+	userData, _, code := s.getSessionUser(sessionData)
+	if code != fiber.StatusOK {
+		return ""
+	}
+	return userData.Email
+}
+
+// GetAllSession list the active sessions of current user
+func (s *UserService) GetAllSession(token string) (res structs.StdResponse, code int) {
+	_, sessionData, res, code := s.authenticate(token)
+	if code != fiber.StatusOK {
+		return
+	}
+	sessions, err := s.sessionRepo.GetAllByUserID(sessionData.UserID)
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	dto := []dtoUser.SessionResponse{}
+	for _, item := range sessions {
+		if item.Scope != "" {
+			// a login waiting for the second factor is not a session yet
+			continue
+		}
+		dto = append(dto, dtoUser.SessionResponse{
+			ID:         fmt.Sprintf("%x", item.ID.Bytes),
+			Device:     item.Device,
+			IP:         item.IP,
+			UserAgent:  item.UserAgent,
+			Current:    item.FamilyID == sessionData.FamilyID,
+			CreatedAt:  item.CreatedAt.Time,
+			LastSeenAt: item.LastSeenAt.Time,
+		})
+	}
+	res = structs.StdResponse{Message: "FETCHED", Data: dto, Count: int64(len(dto))}
+	code = fiber.StatusOK
+	return
+}
+
+// DeleteSession revoke a session of current user
+func (s *UserService) DeleteSession(
+	token string,
+	sessionId string,
+	client structs.StdClient,
+) (res structs.StdResponse, code int) {
+	_, sessionData, res, code := s.authenticate(token)
+	if code != fiber.StatusOK {
+		return
+	}
+	targetBytes, err := uuid.ParseUUID(sessionId)
+	if err != nil {
+		res = structs.StdResponse{Message: "REQUEST_ERROR", Data: err.Error()}
+		code = fiber.StatusBadRequest
+		return
+	}
+	targetUuid := pgtype.UUID{Bytes: targetBytes, Valid: true}
+	// the session is only looked up, so it is not extended before being revoked
+	targetData, err := s.sessionRepo.PeekByID(targetUuid)
+	if err != nil && err.Error() != consts.ErrNoData.Error() {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	if err != nil || targetData.UserID != sessionData.UserID {
+		res = structs.StdResponse{Message: "NOT_FOUND", Data: consts.ErrNoData.Error()}
+		code = fiber.StatusNotFound
+		return
+	}
+	if err = s.sessionRepo.PermanentDeleteByFamilyID(targetData.FamilyID); err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	s.audit(auditEntity.ActionSessionRevoke, sessionData.UserID, sessionId, client)
+	res = structs.StdResponse{Message: "DELETED", Data: "session revoked"}
+	code = fiber.StatusOK
+	return
+}
+
+// DeleteAllSession log current user out everywhere, including the current session
+func (s *UserService) DeleteAllSession(token string, client structs.StdClient) (res structs.StdResponse, code int) {
+	_, sessionData, res, code := s.authenticate(token)
+	if code != fiber.StatusOK {
+		return
+	}
+	if err := s.sessionRepo.PermanentDeleteByUserID(sessionData.UserID); err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	s.audit(auditEntity.ActionSessionRevokeAll, sessionData.UserID, "", client)
+	res = structs.StdResponse{Message: "DELETED", Data: "logged out from every session"}
+	code = fiber.StatusOK
+	return
+}
@@ -0,0 +1,379 @@
+package service
+
+import (
+	dtoUser "github.com/Novando/pintartek/internal/passvault-service/app/dto/user"
+	auditEntity "github.com/Novando/pintartek/internal/passvault-service/domain/audit/entity"
+	sessionEntity "github.com/Novando/pintartek/internal/passvault-service/domain/session/entity"
+	userEntity "github.com/Novando/pintartek/internal/passvault-service/domain/user/entity"
+	userRepo "github.com/Novando/pintartek/internal/passvault-service/domain/user/repository"
+	"github.com/Novando/pintartek/pkg/auth"
+	"github.com/Novando/pintartek/pkg/common/consts"
+	"github.com/Novando/pintartek/pkg/common/structs"
+	"github.com/Novando/pintartek/pkg/crypto"
+	"github.com/Novando/pintartek/pkg/uuid"
+	"github.com/Novando/pintartek/pkg/webauthn"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgtype"
+	"golang.org/x/crypto/bcrypt"
+	"slices"
+	"time"
+)
+
+const (
+	totpIssuer = "Pasuwado"
+	// mfaSessionTTL is how long the second factor is awaited after the password is verified
+	mfaSessionTTL = time.Minute * 5
+)
+
+// LoginMfa exchange the token given by Login and a second factor for a session.
+// The token is single use, a wrong code require the user to login again
+func (s *UserService) LoginMfa(params dtoUser.LoginMfaRequest) (res structs.StdResponse, code int) {
+	mfaUuid, sessionData, res, code := s.getMfaSession(params.MfaToken, sessionEntity.ScopeMFA)
+	if code != fiber.StatusOK {
+		return
+	}
+	if err := s.sessionRepo.PermanentDelete(mfaUuid); err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	userData, res, code := s.getSessionUser(sessionData)
+	if code != fiber.StatusOK {
+		return
+	}
+	client := structs.StdClient{IP: params.IP, UserAgent: params.UserAgent}
+	res, code = s.verifySecondFactor(userData, sessionData, params.Code, params.RecoveryCode, params.Assertion)
+	if code != fiber.StatusOK {
+		if code == fiber.StatusUnauthorized {
+			s.audit(auditEntity.ActionLoginMfaFailure, userData.ID, "", client)
+		}
+		return
+	}
+	sessionData = s.applyPendingSecret(userData, sessionData, sessionData.PendingSecret)
+	res, code = s.startSession(sessionData, client, params.RememberDevice, auditEntity.ActionLoginMfaSuccess)
+	if code == fiber.StatusOK {
+		s.forgetFailures(params.IP, userData.Email)
+	}
+	return
+}
+
+// MfaAccount tell the email of the user a pending MFA token was given to, so the failed second factors are
+// counted against the account rather than the single use token. It is empty when the token is unknown
+func (s *UserService) MfaAccount(token string) string {
+	_, sessionData, _, code := s.getMfaSession(token, sessionEntity.ScopeMFA, sessionEntity.ScopeRecover)
+	if code != fiber.StatusOK {
+		return ""
+	}
+	userData, _, code := s.getSessionUser(sessionData)
+	if code != fiber.StatusOK {
+		return ""
+	}
+	return userData.Email
+}
+
+// SetupTotp generate a pending TOTP secret for current user, which is enabled once confirmed
+func (s *UserService) SetupTotp(token string) (res structs.StdResponse, code int) {
+	_, sessionData, res, code := s.authenticate(token)
+	if code != fiber.StatusOK {
+		return
+	}
+	userData, res, code := s.getSessionUser(sessionData)
+	if code != fiber.StatusOK {
+		return
+	}
+	if userData.TotpEnabledAt.Valid {
+		res = structs.StdResponse{Message: "DATA_EXISTS", Data: "two-factor authentication already enabled"}
+		code = fiber.StatusBadRequest
+		return
+	}
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	encryptedSecret, err := s.sealTotpSecret(sessionData, secret)
+	if err == nil {
+		err = s.userRepo.UpdateTotp(userRepo.UpdateTotpParam{ID: userData.ID, TotpSecret: encryptedSecret})
+	}
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	res = structs.StdResponse{Message: "CREATED", Data: dtoUser.TotpSetupResponse{
+		Secret:          secret,
+		ProvisioningURI: auth.TOTPProvisioningURI(totpIssuer, userData.Email, secret),
+	}}
+	code = fiber.StatusOK
+	return
+}
+
+// ConfirmTotp enable the pending TOTP secret once the user prove the authenticator works,
+// and give the recovery codes. The codes are only shown once
+func (s *UserService) ConfirmTotp(
+	token string,
+	params dtoUser.TotpConfirmRequest,
+	client structs.StdClient,
+) (res structs.StdResponse, code int) {
+	_, sessionData, res, code := s.authenticate(token)
+	if code != fiber.StatusOK {
+		return
+	}
+	userData, res, code := s.getSessionUser(sessionData)
+	if code != fiber.StatusOK {
+		return
+	}
+	if userData.TotpEnabledAt.Valid {
+		res = structs.StdResponse{Message: "DATA_EXISTS", Data: "two-factor authentication already enabled"}
+		code = fiber.StatusBadRequest
+		return
+	}
+	if userData.TotpSecret == "" {
+		res = structs.StdResponse{Message: "REQUEST_ERROR", Data: "two-factor authentication is not set up"}
+		code = fiber.StatusBadRequest
+		return
+	}
+	secret, err := s.openTotpSecret(sessionData, userData.TotpSecret)
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	counter, valid := auth.ValidateTOTP(secret, params.Code, time.Now())
+	if !valid {
+		res = structs.StdResponse{Message: "CREDENTIAL_ERROR", Data: "invalid code"}
+		code = fiber.StatusUnauthorized
+		return
+	}
+	codes, hashes, err := auth.GenerateRecoveryCodes()
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	if err = s.userRepo.ReplaceRecoveryCodes(userData.ID, hashes); err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	err = s.userRepo.UpdateTotp(userRepo.UpdateTotpParam{ID: userData.ID, TotpSecret: userData.TotpSecret, Enabled: true})
+	if err == nil {
+		err = s.userRepo.UpdateTotpCounter(userData.ID, int64(counter))
+	}
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	s.audit(auditEntity.ActionTotpEnable, userData.ID, "", client)
+	res = structs.StdResponse{Message: "UPDATED", Data: dtoUser.TotpConfirmResponse{RecoveryCodes: codes}}
+	code = fiber.StatusOK
+	return
+}
+
+// DisableTotp turn off the two-factor authentication, which require the password and a second factor
+func (s *UserService) DisableTotp(
+	token string,
+	params dtoUser.TotpDisableRequest,
+	client structs.StdClient,
+) (res structs.StdResponse, code int) {
+	_, sessionData, res, code := s.authenticate(token)
+	if code != fiber.StatusOK {
+		return
+	}
+	userData, res, code := s.getSessionUser(sessionData)
+	if code != fiber.StatusOK {
+		return
+	}
+	if !userData.TotpEnabledAt.Valid {
+		res = structs.StdResponse{Message: "REQUEST_ERROR", Data: "two-factor authentication is not enabled"}
+		code = fiber.StatusBadRequest
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(userData.Password), []byte(params.Password)); err != nil {
+		res = structs.StdResponse{Message: "CREDENTIAL_ERROR", Data: "invalid credential"}
+		code = fiber.StatusUnauthorized
+		return
+	}
+	if res, code = s.verifySecondFactor(userData, sessionData, params.Code, params.RecoveryCode, nil); code != fiber.StatusOK {
+		return
+	}
+	err := s.userRepo.UpdateTotp(userRepo.UpdateTotpParam{ID: userData.ID})
+	if err == nil {
+		err = s.userRepo.ReplaceRecoveryCodes(userData.ID, nil)
+	}
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	s.audit(auditEntity.ActionTotpDisable, userData.ID, "", client)
+	res = structs.StdResponse{Message: "UPDATED", Data: "two-factor authentication disabled"}
+	code = fiber.StatusOK
+	return
+}
+
+// getMfaSession retrieve the session given by Login or Recover to a user who has to submit the second factor,
+// the session must have one of `scopes`
+func (s *UserService) getMfaSession(token string, scopes ...string) (
+	mfaUuid pgtype.UUID,
+	sessionData sessionEntity.Session,
+	res structs.StdResponse,
+	code int,
+) {
+	tokenBytes, err := uuid.ParseUUID(token)
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "REQUEST_ERROR", Data: err.Error()}
+		code = fiber.StatusBadRequest
+		return
+	}
+	mfaUuid = pgtype.UUID{Bytes: tokenBytes, Valid: true}
+	// looking up the pending MFA session, as every rate limited request does, must not extend it
+	sessionData, err = s.sessionRepo.PeekByID(mfaUuid)
+	if err != nil {
+		if err.Error() == consts.ErrNoData.Error() {
+			res = structs.StdResponse{Message: "ACCESS_DENIED", Data: err.Error()}
+			code = fiber.StatusUnauthorized
+		} else {
+			s.log.Error(err.Error())
+			res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+			code = fiber.StatusInternalServerError
+		}
+		return
+	}
+	if !slices.Contains(scopes, sessionData.Scope) {
+		res = structs.StdResponse{Message: "ACCESS_DENIED", Data: "invalid token"}
+		code = fiber.StatusUnauthorized
+		return
+	}
+	code = fiber.StatusOK
+	return
+}
+
+// reauthenticate confirm a change of the second factors using the password, and a second factor
+// once the user has one enabled
+func (s *UserService) reauthenticate(
+	userData userEntity.User,
+	sessionData sessionEntity.Session,
+	params dtoUser.WebauthnReauthRequest,
+) (res structs.StdResponse, code int) {
+	if err := bcrypt.CompareHashAndPassword([]byte(userData.Password), []byte(params.Password)); err != nil {
+		res = structs.StdResponse{Message: "CREDENTIAL_ERROR", Data: "invalid credential"}
+		code = fiber.StatusUnauthorized
+		return
+	}
+	credentials, _, res, code := s.getWebauthnCredentials(userData)
+	if code != fiber.StatusOK {
+		return
+	}
+	if !userData.TotpEnabledAt.Valid && len(credentials) == 0 {
+		code = fiber.StatusOK
+		return
+	}
+	if !userData.TotpEnabledAt.Valid && params.Assertion == nil {
+		res = structs.StdResponse{Message: "CREDENTIAL_ERROR", Data: "an assertion of a registered authenticator is required"}
+		code = fiber.StatusUnauthorized
+		return
+	}
+	if params.Assertion == nil && params.Code == "" && params.RecoveryCode == "" {
+		res = structs.StdResponse{Message: "CREDENTIAL_ERROR", Data: "a second factor is required"}
+		code = fiber.StatusUnauthorized
+		return
+	}
+	return s.verifySecondFactor(userData, sessionData, params.Code, params.RecoveryCode, params.Assertion)
+}
+
+// verifySecondFactor check a WebAuthn assertion, a recovery code or a TOTP code, in that order
+// depending on which one is given. All of them are single use
+func (s *UserService) verifySecondFactor(
+	userData userEntity.User,
+	sessionData sessionEntity.Session,
+	totpCode string,
+	recoveryCode string,
+	assertion *webauthn.AssertionResponse,
+) (res structs.StdResponse, code int) {
+	if assertion != nil {
+		return s.verifyWebauthn(userData, *assertion)
+	}
+	if !userData.TotpEnabledAt.Valid {
+		res = structs.StdResponse{Message: "CREDENTIAL_ERROR", Data: "two-factor authentication is not enabled"}
+		code = fiber.StatusUnauthorized
+		return
+	}
+	if recoveryCode != "" {
+		err := s.userRepo.UseRecoveryCode(userData.ID, auth.HashRecoveryCode(recoveryCode))
+		if err != nil {
+			if err.Error() == consts.ErrNoData.Error() {
+				res = structs.StdResponse{Message: "CREDENTIAL_ERROR", Data: "invalid recovery code"}
+				code = fiber.StatusUnauthorized
+			} else {
+				s.log.Error(err.Error())
+				res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+				code = fiber.StatusInternalServerError
+			}
+			return
+		}
+		code = fiber.StatusOK
+		return
+	}
+
+	secret, err := s.openTotpSecret(sessionData, userData.TotpSecret)
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	counter, valid := auth.ValidateTOTP(secret, totpCode, time.Now())
+	if !valid {
+		res = structs.StdResponse{Message: "CREDENTIAL_ERROR", Data: "invalid code"}
+		code = fiber.StatusUnauthorized
+		return
+	}
+	if err = s.userRepo.UpdateTotpCounter(userData.ID, int64(counter)); err != nil {
+		if err.Error() == consts.ErrNoData.Error() {
+			res = structs.StdResponse{Message: "CREDENTIAL_ERROR", Data: "code has been used"}
+			code = fiber.StatusUnauthorized
+		} else {
+			s.log.Error(err.Error())
+			res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+			code = fiber.StatusInternalServerError
+		}
+		return
+	}
+	code = fiber.StatusOK
+	return
+}
+
+// totpKey derive the key that encrypt the TOTP secret from the session secret,
+// so the secret is readable once the password is verified and before the second factor
+func (s *UserService) totpKey(sessionData sessionEntity.Session) ([]byte, error) {
+	return crypto.DeriveKeyHKDF([]byte(sessionData.SecretKey), sessionData.UserID.Bytes[:], "pasuwado-totp-secret", 32)
+}
+
+func (s *UserService) sealTotpSecret(sessionData sessionEntity.Session, secret string) (string, error) {
+	key, err := s.totpKey(sessionData)
+	if err != nil {
+		return "", err
+	}
+	return crypto.SealEnvelope(crypto.AlgorithmAESGCM, key, "", []byte(secret), sessionData.UserID.Bytes[:])
+}
+
+func (s *UserService) openTotpSecret(sessionData sessionEntity.Session, encryptedSecret string) (string, error) {
+	key, err := s.totpKey(sessionData)
+	if err != nil {
+		return "", err
+	}
+	secret, err := crypto.OpenEnvelopeStrict(encryptedSecret, key, sessionData.UserID.Bytes[:])
+	return string(secret), err
+}
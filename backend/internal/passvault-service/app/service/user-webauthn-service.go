@@ -0,0 +1,322 @@
+package service
+
+import (
+	"fmt"
+	dtoUser "github.com/Novando/pintartek/internal/passvault-service/app/dto/user"
+	auditEntity "github.com/Novando/pintartek/internal/passvault-service/domain/audit/entity"
+	sessionEntity "github.com/Novando/pintartek/internal/passvault-service/domain/session/entity"
+	userEntity "github.com/Novando/pintartek/internal/passvault-service/domain/user/entity"
+	webauthnEntity "github.com/Novando/pintartek/internal/passvault-service/domain/webauthn/entity"
+	webauthnRepo "github.com/Novando/pintartek/internal/passvault-service/domain/webauthn/repository"
+	"github.com/Novando/pintartek/pkg/common/consts"
+	"github.com/Novando/pintartek/pkg/common/structs"
+	"github.com/Novando/pintartek/pkg/webauthn"
+	"github.com/gofiber/fiber/v2"
+	"strconv"
+	"time"
+)
+
+// webauthnChallengeTTL is how long a challenge is valid, a bit longer than the browser wait for the authenticator
+const webauthnChallengeTTL = time.Millisecond*webauthn.Timeout + time.Minute
+
+// BeginWebauthnRegistration give the options to register a new authenticator for current user
+func (s *UserService) BeginWebauthnRegistration(token string) (res structs.StdResponse, code int) {
+	_, sessionData, res, code := s.authenticate(token)
+	if code != fiber.StatusOK {
+		return
+	}
+	userData, res, code := s.getSessionUser(sessionData)
+	if code != fiber.StatusOK {
+		return
+	}
+	_, credentialIDs, res, code := s.getWebauthnCredentials(userData)
+	if code != fiber.StatusOK {
+		return
+	}
+	challenge, err := webauthn.NewChallenge()
+	if err == nil {
+		err = s.webauthnRepo.CreateChallenge(userData.ID, webauthnEntity.CeremonyRegister, challenge, webauthnChallengeTTL)
+	}
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	res = structs.StdResponse{
+		Message: "CREATED",
+		Data:    s.relyingParty.NewCreationOptions(challenge, userData.ID.Bytes[:], userData.Email, credentialIDs),
+	}
+	code = fiber.StatusOK
+	return
+}
+
+// FinishWebauthnRegistration verify the new authenticator of current user and store its public key,
+// once the user confirmed it using the password and a second factor
+func (s *UserService) FinishWebauthnRegistration(
+	token string,
+	params dtoUser.WebauthnRegisterRequest,
+	client structs.StdClient,
+) (res structs.StdResponse, code int) {
+	_, sessionData, res, code := s.authenticate(token)
+	if code != fiber.StatusOK {
+		return
+	}
+	userData, res, code := s.getSessionUser(sessionData)
+	if code != fiber.StatusOK {
+		return
+	}
+	if res, code = s.reauthenticate(userData, sessionData, params.WebauthnReauthRequest); code != fiber.StatusOK {
+		return
+	}
+	challenge, err := s.webauthnRepo.ConsumeChallenge(sessionData.UserID, webauthnEntity.CeremonyRegister)
+	if err != nil {
+		if err.Error() == consts.ErrNoData.Error() {
+			res = structs.StdResponse{Message: "REQUEST_ERROR", Data: "registration is not started or has expired"}
+			code = fiber.StatusBadRequest
+		} else {
+			s.log.Error(err.Error())
+			res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+			code = fiber.StatusInternalServerError
+		}
+		return
+	}
+	credential, err := s.relyingParty.VerifyRegistration(challenge, params.Credential)
+	if err != nil {
+		res = structs.StdResponse{Message: "CREDENTIAL_ERROR", Data: err.Error()}
+		code = fiber.StatusUnauthorized
+		return
+	}
+	err = s.webauthnRepo.Create(webauthnRepo.CreateParam{
+		UserID:       sessionData.UserID,
+		CredentialID: webauthn.EncodeBase64(credential.ID),
+		PublicKey:    webauthn.EncodeBase64(credential.PublicKey),
+		Name:         params.Name,
+		SignCount:    int64(credential.SignCount),
+	})
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	s.audit(auditEntity.ActionWebauthnRegister, sessionData.UserID, webauthn.EncodeBase64(credential.ID), client)
+	res = structs.StdResponse{Message: "CREATED", Data: "authenticator registered"}
+	code = fiber.StatusOK
+	return
+}
+
+// GetAllWebauthn list the authenticators of current user
+func (s *UserService) GetAllWebauthn(token string) (res structs.StdResponse, code int) {
+	_, sessionData, res, code := s.authenticate(token)
+	if code != fiber.StatusOK {
+		return
+	}
+	credentials, err := s.webauthnRepo.GetAllByUserID(sessionData.UserID)
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	dto := []dtoUser.WebauthnCredentialResponse{}
+	for _, item := range credentials {
+		resItem := dtoUser.WebauthnCredentialResponse{
+			ID:        item.ID,
+			Name:      item.Name,
+			CreatedAt: item.CreatedAt.Time,
+		}
+		if item.LastUsedAt.Valid {
+			resItem.LastUsedAt = &item.LastUsedAt.Time
+		}
+		dto = append(dto, resItem)
+	}
+	res = structs.StdResponse{Message: "FETCHED", Data: dto, Count: int64(len(dto))}
+	code = fiber.StatusOK
+	return
+}
+
+// DeleteWebauthn remove an authenticator of current user, once the user confirmed it using the password
+// and a second factor
+func (s *UserService) DeleteWebauthn(
+	token string,
+	credentialId string,
+	params dtoUser.WebauthnDeleteRequest,
+	client structs.StdClient,
+) (res structs.StdResponse, code int) {
+	_, sessionData, res, code := s.authenticate(token)
+	if code != fiber.StatusOK {
+		return
+	}
+	id, err := strconv.ParseUint(credentialId, 10, 64)
+	if err != nil {
+		res = structs.StdResponse{Message: "REQUEST_ERROR", Data: err.Error()}
+		code = fiber.StatusBadRequest
+		return
+	}
+	userData, res, code := s.getSessionUser(sessionData)
+	if code != fiber.StatusOK {
+		return
+	}
+	if res, code = s.reauthenticate(userData, sessionData, params.WebauthnReauthRequest); code != fiber.StatusOK {
+		return
+	}
+	if err = s.webauthnRepo.PermanentDelete(sessionData.UserID, id); err != nil {
+		if err.Error() == consts.ErrNoData.Error() {
+			res = structs.StdResponse{Message: "NOT_FOUND", Data: err.Error()}
+			code = fiber.StatusNotFound
+		} else {
+			s.log.Error(err.Error())
+			res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+			code = fiber.StatusInternalServerError
+		}
+		return
+	}
+	s.audit(auditEntity.ActionWebauthnRemove, sessionData.UserID, credentialId, client)
+	res = structs.StdResponse{Message: "DELETED", Data: "authenticator removed"}
+	code = fiber.StatusOK
+	return
+}
+
+// BeginWebauthnLogin give the options to assert one of the authenticators of the user who is logging in,
+// or recovering the account
+func (s *UserService) BeginWebauthnLogin(params dtoUser.WebauthnLoginRequest) (res structs.StdResponse, code int) {
+	_, sessionData, res, code := s.getMfaSession(params.MfaToken, sessionEntity.ScopeMFA, sessionEntity.ScopeRecover)
+	if code != fiber.StatusOK {
+		return
+	}
+	userData, res, code := s.getSessionUser(sessionData)
+	if code != fiber.StatusOK {
+		return
+	}
+	return s.beginWebauthnAssertion(userData)
+}
+
+// BeginWebauthnAssertion give the options to assert one of the authenticators of current user,
+// confirming a change of the authenticators
+func (s *UserService) BeginWebauthnAssertion(token string) (res structs.StdResponse, code int) {
+	_, sessionData, res, code := s.authenticate(token)
+	if code != fiber.StatusOK {
+		return
+	}
+	userData, res, code := s.getSessionUser(sessionData)
+	if code != fiber.StatusOK {
+		return
+	}
+	return s.beginWebauthnAssertion(userData)
+}
+
+// beginWebauthnAssertion create the challenge asserting one of the authenticators of a user
+func (s *UserService) beginWebauthnAssertion(userData userEntity.User) (res structs.StdResponse, code int) {
+	_, credentialIDs, res, code := s.getWebauthnCredentials(userData)
+	if code != fiber.StatusOK {
+		return
+	}
+	if len(credentialIDs) == 0 {
+		res = structs.StdResponse{Message: "REQUEST_ERROR", Data: "no authenticator registered"}
+		code = fiber.StatusBadRequest
+		return
+	}
+	challenge, err := webauthn.NewChallenge()
+	if err == nil {
+		err = s.webauthnRepo.CreateChallenge(userData.ID, webauthnEntity.CeremonyLogin, challenge, webauthnChallengeTTL)
+	}
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	res = structs.StdResponse{Message: "CREATED", Data: s.relyingParty.NewRequestOptions(challenge, credentialIDs)}
+	code = fiber.StatusOK
+	return
+}
+
+// verifyWebauthn check an assertion against the pending login challenge of a user
+func (s *UserService) verifyWebauthn(userData userEntity.User, assertion webauthn.AssertionResponse) (
+	res structs.StdResponse,
+	code int,
+) {
+	challenge, err := s.webauthnRepo.ConsumeChallenge(userData.ID, webauthnEntity.CeremonyLogin)
+	if err != nil {
+		if err.Error() == consts.ErrNoData.Error() {
+			res = structs.StdResponse{Message: "CREDENTIAL_ERROR", Data: "authentication is not started or has expired"}
+			code = fiber.StatusUnauthorized
+		} else {
+			s.log.Error(err.Error())
+			res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+			code = fiber.StatusInternalServerError
+		}
+		return
+	}
+	credentialData, err := s.webauthnRepo.GetByCredentialID(userData.ID, assertion.ID)
+	if err != nil {
+		if err.Error() == consts.ErrNoData.Error() {
+			res = structs.StdResponse{Message: "CREDENTIAL_ERROR", Data: "unknown authenticator"}
+			code = fiber.StatusUnauthorized
+		} else {
+			s.log.Error(err.Error())
+			res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+			code = fiber.StatusInternalServerError
+		}
+		return
+	}
+	credential, err := toWebauthnCredential(credentialData)
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	signCount, err := s.relyingParty.VerifyAssertion(challenge, credential, assertion)
+	if err != nil {
+		res = structs.StdResponse{Message: "CREDENTIAL_ERROR", Data: err.Error()}
+		code = fiber.StatusUnauthorized
+		return
+	}
+	if err = s.webauthnRepo.UpdateSignCount(credentialData.ID, int64(signCount)); err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	code = fiber.StatusOK
+	return
+}
+
+// getWebauthnCredentials retrieve the authenticators of a user together with their raw credential IDs
+func (s *UserService) getWebauthnCredentials(userData userEntity.User) (
+	credentials []webauthnEntity.Credential,
+	credentialIDs [][]byte,
+	res structs.StdResponse,
+	code int,
+) {
+	credentials, err := s.webauthnRepo.GetAllByUserID(userData.ID)
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	for _, item := range credentials {
+		id, err := webauthn.DecodeBase64(item.CredentialID)
+		if err != nil {
+			s.log.Error(fmt.Sprintf("webauthn credential %d: %v", item.ID, err))
+			continue
+		}
+		credentialIDs = append(credentialIDs, id)
+	}
+	code = fiber.StatusOK
+	return
+}
+
+func toWebauthnCredential(data webauthnEntity.Credential) (credential webauthn.Credential, err error) {
+	if credential.ID, err = webauthn.DecodeBase64(data.CredentialID); err != nil {
+		return
+	}
+	if credential.PublicKey, err = webauthn.DecodeBase64(data.PublicKey); err != nil {
+		return
+	}
+	credential.SignCount = uint32(data.SignCount)
+	return
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	vaultDto "github.com/Novando/pintartek/internal/passvault-service/app/dto/vault"
+	auditEntity "github.com/Novando/pintartek/internal/passvault-service/domain/audit/entity"
+	vaultGroupEntity "github.com/Novando/pintartek/internal/passvault-service/domain/vault-group/entity"
+	"github.com/Novando/pintartek/pkg/common/structs"
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetAllCredential list the credentials of a vault by their name and URL, the secrets are left out
+func (s *VaultService) GetAllCredential(token, vaultId string) (res structs.StdResponse, code int) {
+	sessionData, res, code := s.authenticate(token)
+	if code != fiber.StatusOK {
+		return
+	}
+	vaultData, member, res, code := s.authorize(sessionData.UserID, vaultId, vaultGroupEntity.PermissionRead)
+	if code != fiber.StatusOK {
+		return
+	}
+	vaultKey, res, code := s.unlockKey(sessionData, &vaultData, member)
+	if code != fiber.StatusOK {
+		return
+	}
+	rows, err := s.credentialRepo.GetAllByVaultID(vaultData.ID)
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	dto := []vaultDto.CredentialListResponse{}
+	for _, item := range rows {
+		var plainText []byte
+		plainText, res, code = s.openCredential(vaultKey, vaultData, item)
+		if code != fiber.StatusOK {
+			return
+		}
+		var credential vaultDto.Credential
+		if err = json.Unmarshal(plainText, &credential); err != nil {
+			s.log.Error(err.Error())
+			res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+			code = fiber.StatusInternalServerError
+			return
+		}
+		dto = append(dto, vaultDto.CredentialListResponse{
+			ID:        fmt.Sprintf("%x", item.ID.Bytes),
+			Type:      itemTypeOf(credential),
+			Name:      credential.Name,
+			Url:       credential.Url,
+			CreatedAt: item.CreatedAt.Time,
+			UpdatedAt: item.UpdatedAt.Time,
+		})
+	}
+	res = structs.StdResponse{Message: "FETCHED", Data: dto, Count: int64(len(dto))}
+	code = fiber.StatusOK
+	return
+}
+
+// GetCredential decrypt a single credential of a vault, the other credentials are left encrypted
+func (s *VaultService) GetCredential(
+	token string,
+	vaultId string,
+	credentialId string,
+	client structs.StdClient,
+) (res structs.StdResponse, code int) {
+	sessionData, res, code := s.authenticate(token)
+	if code != fiber.StatusOK {
+		return
+	}
+	vaultData, member, res, code := s.authorize(sessionData.UserID, vaultId, vaultGroupEntity.PermissionRead)
+	if code != fiber.StatusOK {
+		return
+	}
+	id, credentialId, res, code := parseCredentialId(credentialId)
+	if code != fiber.StatusOK {
+		return
+	}
+	vaultKey, res, code := s.unlockKey(sessionData, &vaultData, member)
+	if code != fiber.StatusOK {
+		return
+	}
+	item, plainText, res, code := s.loadCredential(vaultKey, vaultData, id)
+	if code != fiber.StatusOK {
+		return
+	}
+	var credential vaultDto.Credential
+	if err := json.Unmarshal(plainText, &credential); err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	credential.Type = itemTypeOf(credential)
+	s.audit(auditEntity.ActionCredentialRead, sessionData.UserID, vaultData.ID, credentialId, client)
+	res = structs.StdResponse{Message: "FETCHED", Data: vaultDto.CredentialResponse{
+		ID:         credentialId,
+		Credential: credential,
+		CreatedAt:  item.CreatedAt.Time,
+		UpdatedAt:  item.UpdatedAt.Time,
+	}}
+	code = fiber.StatusOK
+	return
+}
@@ -0,0 +1,309 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	vaultDto "github.com/Novando/pintartek/internal/passvault-service/app/dto/vault"
+	auditEntity "github.com/Novando/pintartek/internal/passvault-service/domain/audit/entity"
+	credentialHistoryRepo "github.com/Novando/pintartek/internal/passvault-service/domain/credential-history/repository"
+	credentialRepo "github.com/Novando/pintartek/internal/passvault-service/domain/credential/repository"
+	vaultGroupEntity "github.com/Novando/pintartek/internal/passvault-service/domain/vault-group/entity"
+	vaultEntity "github.com/Novando/pintartek/internal/passvault-service/domain/vault/entity"
+	"github.com/Novando/pintartek/pkg/common/consts"
+	"github.com/Novando/pintartek/pkg/common/structs"
+	"github.com/Novando/pintartek/pkg/crypto"
+	"github.com/gofiber/fiber/v2"
+	"strconv"
+)
+
+// credentialHistoryKeep is the number of replaced passwords kept for each credential
+const credentialHistoryKeep = 20
+
+// GetAllCredentialHistory decrypt the passwords a credential had before, the latest first
+func (s *VaultService) GetAllCredentialHistory(
+	token string,
+	vaultId string,
+	credentialId string,
+	client structs.StdClient,
+) (res structs.StdResponse, code int) {
+	sessionData, res, code := s.authenticate(token)
+	if code != fiber.StatusOK {
+		return
+	}
+	vaultData, member, res, code := s.authorize(sessionData.UserID, vaultId, vaultGroupEntity.PermissionRead)
+	if code != fiber.StatusOK {
+		return
+	}
+	_, credentialId, res, code = parseCredentialId(credentialId)
+	if code != fiber.StatusOK {
+		return
+	}
+	vaultKey, res, code := s.unlockKey(sessionData, &vaultData, member)
+	if code != fiber.StatusOK {
+		return
+	}
+	histories, err := s.credentialHistoryRepo.GetAllByCredentialID(vaultData.ID, credentialId)
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	dto := []vaultDto.CredentialHistoryResponse{}
+	for _, item := range histories {
+		password, err := crypto.OpenEnvelopeStrict(item.Password, vaultKey, credentialHistoryAAD(vaultData, credentialId, item.Field, item.Custom))
+		if err != nil {
+			s.log.Error(fmt.Sprintf("credential history %d: %v", item.ID, err))
+			res = structs.StdResponse{Message: "INTEGRITY_ERROR", Data: consts.ErrIntegrity.Error()}
+			code = fiber.StatusInternalServerError
+			return
+		}
+		dto = append(dto, vaultDto.CredentialHistoryResponse{
+			ID:        item.ID,
+			Field:     item.Field,
+			Custom:    item.Custom,
+			Password:  string(password),
+			CreatedAt: item.CreatedAt.Time,
+		})
+	}
+	s.audit(auditEntity.ActionCredentialHistoryRead, sessionData.UserID, vaultData.ID, credentialId, client)
+	res = structs.StdResponse{Message: "FETCHED", Data: dto, Count: int64(len(dto))}
+	code = fiber.StatusOK
+	return
+}
+
+// RestoreCredentialHistory put back a password a credential had before,
+// the password being replaced is kept in the history as well
+func (s *VaultService) RestoreCredentialHistory(
+	token string,
+	vaultId string,
+	credentialId string,
+	historyId string,
+	client structs.StdClient,
+) (res structs.StdResponse, code int) {
+	sessionData, res, code := s.authenticate(token)
+	if code != fiber.StatusOK {
+		return
+	}
+	vaultData, member, res, code := s.authorize(sessionData.UserID, vaultId, vaultGroupEntity.PermissionEdit)
+	if code != fiber.StatusOK {
+		return
+	}
+	credentialUUID, credentialId, res, code := parseCredentialId(credentialId)
+	if code != fiber.StatusOK {
+		return
+	}
+	vaultKey, res, code := s.unlockKey(sessionData, &vaultData, member)
+	if code != fiber.StatusOK {
+		return
+	}
+	id, err := strconv.ParseInt(historyId, 10, 64)
+	if err != nil {
+		res = structs.StdResponse{Message: "REQUEST_ERROR", Data: err.Error()}
+		code = fiber.StatusBadRequest
+		return
+	}
+	history, err := s.credentialHistoryRepo.GetByID(id)
+	if err == nil && (history.VaultID != vaultData.ID || history.CredentialID != credentialId) {
+		// the history of another credential is reported as missing
+		err = consts.ErrNoData
+	}
+	if err != nil {
+		if err.Error() == consts.ErrNoData.Error() {
+			res = structs.StdResponse{Message: "NOT_FOUND", Data: err.Error()}
+			code = fiber.StatusNotFound
+		} else {
+			s.log.Error(err.Error())
+			res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+			code = fiber.StatusInternalServerError
+		}
+		return
+	}
+	password, err := crypto.OpenEnvelopeStrict(
+		history.Password,
+		vaultKey,
+		credentialHistoryAAD(vaultData, credentialId, history.Field, history.Custom),
+	)
+	if err != nil {
+		s.log.Error(fmt.Sprintf("credential history %d: %v", history.ID, err))
+		res = structs.StdResponse{Message: "INTEGRITY_ERROR", Data: consts.ErrIntegrity.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	_, previous, res, code := s.loadCredential(vaultKey, vaultData, credentialUUID)
+	if code != fiber.StatusOK {
+		return
+	}
+	var credential vaultDto.Credential
+	if err = json.Unmarshal(previous, &credential); err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	if err = restoreField(&credential, history.Field, history.Custom, string(password)); err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	histories, err := s.credentialHistoryOf(vaultKey, vaultData, credentialId, previous, credential)
+	var encrypted, revision string
+	if err == nil {
+		encrypted, err = s.encryptCredential(credential, vaultKey, vaultData, credentialId)
+	}
+	if err == nil {
+		revision, err = s.revisionOf(vaultKey, vaultData, credentialUUID, previous)
+	}
+	if err == nil {
+		err = s.credentialRepo.Update(credentialRepo.UpsertParam{
+			ID:          credentialUUID,
+			VaultID:     vaultData.ID,
+			Data:        encrypted,
+			SealVersion: vaultData.SealVersion,
+			Revision:    revision,
+			Histories:   histories,
+		})
+	}
+	if err != nil {
+		res, code = s.writeError(err)
+		return
+	}
+	s.audit(auditEntity.ActionCredentialRestore, sessionData.UserID, vaultData.ID, credentialId, client)
+	res = structs.StdResponse{Message: "UPDATED", Data: credentialId}
+	code = fiber.StatusOK
+	return
+}
+
+// restoreField put back the value of a field, the key of a field of the item type or the label of a custom field
+// when `custom` is set. The hidden custom field is added again when it was removed
+func restoreField(credential *vaultDto.Credential, field string, custom bool, value string) error {
+	if !custom {
+		if field == "" {
+			field = passwordField
+		}
+		encoded, err := json.Marshal(credential)
+		if err != nil {
+			return err
+		}
+		values := make(map[string]interface{})
+		if err = json.Unmarshal(encoded, &values); err != nil {
+			return err
+		}
+		values[field] = value
+		if encoded, err = json.Marshal(values); err != nil {
+			return err
+		}
+		return json.Unmarshal(encoded, credential)
+	}
+	for i := range credential.Fields {
+		if credential.Fields[i].Label == field {
+			credential.Fields[i].Value = value
+			return nil
+		}
+	}
+	credential.Fields = append(credential.Fields, vaultDto.CustomField{
+		Label: field,
+		Type:  vaultDto.CustomFieldHidden,
+		Value: value,
+	})
+	return nil
+}
+
+// credentialHistoryOf seal the secret fields of the item type and the secret custom fields of the JSON
+// of a credential before it is replaced by `updated`, they are recorded within the transaction writing `updated`.
+// Nothing is kept for absentCredential or a value that does not change, a secret field that is removed is kept as well
+func (s *VaultService) credentialHistoryOf(
+	vaultKey []byte,
+	vaultData vaultEntity.Vault,
+	credentialId string,
+	previous []byte,
+	updated vaultDto.Credential,
+) ([]credentialHistoryRepo.CreateParam, error) {
+	if string(previous) == absentCredential {
+		return nil, nil
+	}
+	var current vaultDto.Credential
+	var currentValues, updatedValues map[string]interface{}
+	if err := json.Unmarshal(previous, &current); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(previous, &currentValues); err != nil {
+		return nil, err
+	}
+	encoded, err := json.Marshal(updated)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(encoded, &updatedValues); err != nil {
+		return nil, err
+	}
+
+	var replaced []credentialHistoryRepo.CreateParam
+	for _, key := range secretFieldsOf(itemTypeOf(current)) {
+		value, _ := currentValues[key].(string)
+		updatedValue, _ := updatedValues[key].(string)
+		if value == "" || value == updatedValue {
+			continue
+		}
+		field := key
+		if key == passwordField {
+			// the password keeps the field it had before the other secret fields were kept
+			field = ""
+		}
+		replaced = append(replaced, credentialHistoryRepo.CreateParam{Field: field, Password: value})
+	}
+	values := make(map[string]string)
+	for _, field := range updated.Fields {
+		if isSecretField(field) {
+			values[field.Label] = field.Value
+		}
+	}
+	for _, field := range current.Fields {
+		if !isSecretField(field) || field.Value == "" {
+			continue
+		}
+		if value, ok := values[field.Label]; !ok || value != field.Value {
+			replaced = append(replaced, credentialHistoryRepo.CreateParam{
+				Field:    field.Label,
+				Custom:   true,
+				Password: field.Value,
+			})
+		}
+	}
+	for i := range replaced {
+		replaced[i].Password, err = crypto.SealEnvelope(
+			crypto.AlgorithmAESGCM,
+			vaultKey,
+			"",
+			[]byte(replaced[i].Password),
+			credentialHistoryAAD(vaultData, credentialId, replaced[i].Field, replaced[i].Custom),
+		)
+		if err != nil {
+			return nil, err
+		}
+		replaced[i].VaultID = vaultData.ID
+		replaced[i].CredentialID = credentialId
+		replaced[i].SealVersion = vaultData.SealVersion
+		replaced[i].Keep = credentialHistoryKeep
+	}
+	return replaced, nil
+}
+
+// credentialHistoryAAD bind a replaced password to its credential and field
+func credentialHistoryAAD(vaultData vaultEntity.Vault, credentialId string, field string, custom bool) []byte {
+	return vaultAAD(vaultData, credentialHistoryPurpose(field, custom), credentialId, field)
+}
+
+// credentialHistoryPurpose tell the purpose a replaced value is sealed for: the password, a secret field
+// of the item type or a custom field, so a custom field is never opened as a field of the item type
+func credentialHistoryPurpose(field string, custom bool) string {
+	switch {
+	case custom:
+		return sealCustomHistory
+	case field != "":
+		return sealItemHistory
+	default:
+		return sealHistory
+	}
+}
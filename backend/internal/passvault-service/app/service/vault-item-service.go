@@ -0,0 +1,158 @@
+package service
+
+import (
+	"fmt"
+	vaultDto "github.com/Novando/pintartek/internal/passvault-service/app/dto/vault"
+	"github.com/Novando/pintartek/pkg/auth"
+	"github.com/Novando/pintartek/pkg/common/structs"
+	"github.com/gofiber/fiber/v2"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// itemTypes is the schema of every item type, the required fields match the validation of vaultDto.Credential
+var itemTypes = []vaultDto.ItemTypeResponse{
+	{
+		Type:  vaultDto.ItemTypeLogin,
+		Label: "Login",
+		Icon:  "key",
+		Fields: []vaultDto.ItemFieldResponse{
+			{Key: "name", Label: "Name", Required: true},
+			{Key: "credential", Label: "Username"},
+			{Key: "password", Label: "Password", Required: true, Secret: true},
+			{Key: "url", Label: "Website"},
+			{Key: "note", Label: "Note", Multiline: true},
+		},
+	},
+	{
+		Type:  vaultDto.ItemTypeNote,
+		Label: "Secure Note",
+		Icon:  "note",
+		Fields: []vaultDto.ItemFieldResponse{
+			{Key: "name", Label: "Name", Required: true},
+			{Key: "note", Label: "Note", Required: true, Secret: true, Multiline: true},
+		},
+	},
+	{
+		Type:  vaultDto.ItemTypeCard,
+		Label: "Payment Card",
+		Icon:  "credit-card",
+		Fields: []vaultDto.ItemFieldResponse{
+			{Key: "name", Label: "Name", Required: true},
+			{Key: "cardholderName", Label: "Cardholder Name"},
+			{Key: "cardNumber", Label: "Card Number", Required: true, Secret: true},
+			{Key: "cardExpiry", Label: "Expiry (MM/YY)"},
+			{Key: "cardCvv", Label: "Security Code", Secret: true},
+			{Key: "note", Label: "Note", Multiline: true},
+		},
+	},
+	{
+		Type:  vaultDto.ItemTypeIdentity,
+		Label: "Identity",
+		Icon:  "id-card",
+		Fields: []vaultDto.ItemFieldResponse{
+			{Key: "name", Label: "Name", Required: true},
+			{Key: "fullName", Label: "Full Name", Required: true},
+			{Key: "email", Label: "Email"},
+			{Key: "phone", Label: "Phone"},
+			{Key: "address", Label: "Address", Multiline: true},
+			{Key: "note", Label: "Note", Multiline: true},
+		},
+	},
+	{
+		Type:  vaultDto.ItemTypeSSHKey,
+		Label: "SSH Key",
+		Icon:  "terminal",
+		Fields: []vaultDto.ItemFieldResponse{
+			{Key: "name", Label: "Name", Required: true},
+			{Key: "privateKey", Label: "Private Key", Required: true, Secret: true, Multiline: true},
+			{Key: "publicKey", Label: "Public Key", Multiline: true},
+			{Key: "passphrase", Label: "Passphrase", Secret: true},
+			{Key: "note", Label: "Note", Multiline: true},
+		},
+	},
+	{
+		Type:  vaultDto.ItemTypeAPIToken,
+		Label: "API Token",
+		Icon:  "code",
+		Fields: []vaultDto.ItemFieldResponse{
+			{Key: "name", Label: "Name", Required: true},
+			{Key: "token", Label: "Token", Required: true, Secret: true},
+			{Key: "url", Label: "Endpoint"},
+			{Key: "note", Label: "Note", Multiline: true},
+		},
+	},
+}
+
+// GetAllItemType list the item types a vault can hold and how each of them is displayed
+func (s *VaultService) GetAllItemType() (res structs.StdResponse, code int) {
+	res = structs.StdResponse{Message: "FETCHED", Data: itemTypes, Count: int64(len(itemTypes))}
+	code = fiber.StatusOK
+	return
+}
+
+// checkCustomFields check the value of each custom field against its type, an empty value is accepted.
+// A blank label is rejected, as the label identify the field in the history.
+// The error is formatted like the one of validator.Validate, without the value of a secret field
+func checkCustomFields(fields []vaultDto.CustomField) error {
+	for _, field := range fields {
+		if strings.TrimSpace(field.Label) == "" {
+			return fmt.Errorf("[%s]: '%v' | Needs to implement '%s'", "Label", field.Label, "required")
+		}
+		if field.Value == "" {
+			continue
+		}
+		var err error
+		switch field.Type {
+		case vaultDto.CustomFieldBoolean:
+			_, err = strconv.ParseBool(field.Value)
+		case vaultDto.CustomFieldUrl:
+			var link *url.URL
+			if link, err = url.ParseRequestURI(field.Value); err == nil && link.Host == "" {
+				err = fmt.Errorf("missing host")
+			}
+		case vaultDto.CustomFieldTotp:
+			_, err = auth.TOTPCode(field.Value, 0)
+		}
+		if err != nil {
+			value := field.Value
+			if isSecretField(field) {
+				value = ""
+			}
+			return fmt.Errorf("[%s]: '%v' | Needs to implement '%s'", field.Label, value, field.Type)
+		}
+	}
+	return nil
+}
+
+// passwordField is the key of the password in the fields of an item type
+const passwordField = "password"
+
+// secretFieldsOf list the keys of the secret fields of an item type, they are kept in the history like a password
+func secretFieldsOf(itemType string) (keys []string) {
+	for _, item := range itemTypes {
+		if item.Type != itemType {
+			continue
+		}
+		for _, field := range item.Fields {
+			if field.Secret {
+				keys = append(keys, field.Key)
+			}
+		}
+	}
+	return
+}
+
+// isSecretField tell whether a custom field is kept in the history like a password
+func isSecretField(field vaultDto.CustomField) bool {
+	return field.Type == vaultDto.CustomFieldHidden || field.Type == vaultDto.CustomFieldTotp
+}
+
+// itemTypeOf tell the type of an item, an item stored before the item types existed is a login
+func itemTypeOf(credential vaultDto.Credential) string {
+	if credential.Type == "" {
+		return vaultDto.ItemTypeLogin
+	}
+	return credential.Type
+}
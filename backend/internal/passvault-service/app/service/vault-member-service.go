@@ -0,0 +1,500 @@
+package service
+
+import (
+	"encoding/hex"
+	"fmt"
+	vaultDto "github.com/Novando/pintartek/internal/passvault-service/app/dto/vault"
+	auditEntity "github.com/Novando/pintartek/internal/passvault-service/domain/audit/entity"
+	sessionEntity "github.com/Novando/pintartek/internal/passvault-service/domain/session/entity"
+	vaultGroupEntity "github.com/Novando/pintartek/internal/passvault-service/domain/vault-group/entity"
+	vaultGroupRepo "github.com/Novando/pintartek/internal/passvault-service/domain/vault-group/repository"
+	vaultEntity "github.com/Novando/pintartek/internal/passvault-service/domain/vault/entity"
+	vaultRepo "github.com/Novando/pintartek/internal/passvault-service/domain/vault/repository"
+	"github.com/Novando/pintartek/pkg/common/consts"
+	"github.com/Novando/pintartek/pkg/common/structs"
+	"github.com/Novando/pintartek/pkg/crypto"
+	"github.com/Novando/pintartek/pkg/uuid"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgtype"
+	"time"
+)
+
+// InviteMember share a vault to another user. The vault key is sealed to the public key
+// of the invitee, so it can only be opened using the private key of the invitee
+func (s *VaultService) InviteMember(
+	token string,
+	vaultId string,
+	param vaultDto.MemberInviteRequest,
+	client structs.StdClient,
+) (res structs.StdResponse, code int) {
+	sessionData, res, code := s.authenticate(token)
+	if code != fiber.StatusOK {
+		return
+	}
+	vaultData, member, res, code := s.authorize(sessionData.UserID, vaultId, vaultGroupEntity.PermissionManage)
+	if code != fiber.StatusOK {
+		return
+	}
+	vaultKey, res, code := s.unlockKey(sessionData, &vaultData, member)
+	if code != fiber.StatusOK {
+		return
+	}
+	invitee, err := s.userRepo.GetByEmail(param.Email)
+	if err != nil {
+		msg := "PROCESS_ERROR"
+		code = fiber.StatusInternalServerError
+		if err.Error() == consts.ErrNoData.Error() {
+			msg = "NOT_FOUND"
+			code = fiber.StatusNotFound
+		} else {
+			s.log.Error(err.Error())
+		}
+		res = structs.StdResponse{Message: msg, Data: err.Error()}
+		return
+	}
+	_, err = s.vaultGroupRepo.GetMember(invitee.ID, vaultData.ID)
+	if err == nil {
+		res = structs.StdResponse{Message: "DATA_EXISTS", Data: "User already a member of the vault"}
+		code = fiber.StatusBadRequest
+		return
+	}
+	if err.Error() != consts.ErrNoData.Error() {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	pub, err := crypto.ParsePublicKeyEd25519(invitee.PublicKey)
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	inviteKey, err := crypto.SealAnonymousX25519(hex.EncodeToString(vaultKey), pub)
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	err = s.vaultGroupRepo.Create(vaultGroupRepo.CreateParam{
+		VaultID:     vaultData.ID,
+		UserID:      invitee.ID,
+		Role:        param.Role,
+		InviteKey:   inviteKey,
+		SealVersion: vaultData.SealVersion,
+	})
+	if err != nil {
+		res, code = s.writeError(err)
+		return
+	}
+	s.audit(auditEntity.ActionMemberInvite, sessionData.UserID, vaultData.ID, fmt.Sprintf("%x", invitee.ID.Bytes), client)
+	res = structs.StdResponse{Message: "CREATED", Data: fmt.Sprintf("%v has been invited", param.Email)}
+	code = fiber.StatusOK
+	return
+}
+
+// GetAllMember return all member of a vault, including the pending invitation.
+// The list expose the email of every member, so only a member allowed to manage the vault can see it
+func (s *VaultService) GetAllMember(token, vaultId string) (res structs.StdResponse, code int) {
+	sessionData, res, code := s.authenticate(token)
+	if code != fiber.StatusOK {
+		return
+	}
+	vaultData, _, res, code := s.authorize(sessionData.UserID, vaultId, vaultGroupEntity.PermissionManage)
+	if code != fiber.StatusOK {
+		return
+	}
+	memberData, err := s.vaultGroupRepo.GetAllMemberByVaultID(vaultData.ID)
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	dto := []vaultDto.MemberResponse{}
+	for _, item := range memberData {
+		var acceptedAt *time.Time
+		if item.AcceptedAt.Valid {
+			acceptedAt = &item.AcceptedAt.Time
+		}
+		dto = append(dto, vaultDto.MemberResponse{
+			UserID:     fmt.Sprintf("%x", item.UserID.Bytes),
+			Email:      item.Email,
+			Role:       item.Role,
+			Pending:    !item.AcceptedAt.Valid,
+			CreatedAt:  item.CreatedAt.Time,
+			AcceptedAt: acceptedAt,
+		})
+	}
+	res = structs.StdResponse{Message: "FETCHED", Data: dto, Count: int64(len(dto))}
+	code = fiber.StatusOK
+	return
+}
+
+// RemoveMember revoke the access of a user to a vault, or cancel a pending invitation.
+// Only owner can remove other member, while any member can leave the vault and a pending invitee can decline.
+// The vault key is replaced when the target held it, so the other members must accept the vault again using
+// their private key
+func (s *VaultService) RemoveMember(
+	token string,
+	vaultId string,
+	userId string,
+	client structs.StdClient,
+) (res structs.StdResponse, code int) {
+	sessionData, res, code := s.authenticate(token)
+	if code != fiber.StatusOK {
+		return
+	}
+	vaultData, member, res, code := s.authorize(sessionData.UserID, vaultId, vaultGroupEntity.PermissionRead)
+	// a pending invitee is only allowed to remove itself
+	pending := code == fiber.StatusForbidden && member.ID != 0 && !member.AcceptedAt.Valid
+	if code != fiber.StatusOK && !pending {
+		return
+	}
+	target, res, code := s.getTargetMember(vaultData.ID, userId)
+	if code != fiber.StatusOK {
+		return
+	}
+	if target.ID != member.ID && (pending || !member.Can(vaultGroupEntity.PermissionManage)) {
+		res = structs.StdResponse{Message: "ACCESS_DENIED", Data: fmt.Sprintf("role %v is not allowed for this operation", member.Role)}
+		code = fiber.StatusForbidden
+		return
+	}
+	res, code = s.checkLastOwner(vaultData.ID, target)
+	if code != fiber.StatusOK {
+		return
+	}
+	// the vault key is kept when the target never accepted it, or when a member leave before accepting
+	// the replaced one, so the member leaving need not unlock it
+	var err error
+	if !target.AcceptedAt.Valid || (target.ID == member.ID && member.VaultKey == "" && member.InviteKey != "") {
+		err = s.vaultGroupRepo.PermanentDelete(vaultData.ID, target.ID)
+	} else {
+		var vaultKey []byte
+		vaultKey, res, code = s.unlockKey(sessionData, &vaultData, member)
+		if code != fiber.StatusOK {
+			return
+		}
+		err = s.rekeyVault(sessionData, vaultData, member, target, vaultKey)
+	}
+	if err != nil {
+		res, code = s.memberWriteError(err)
+		return
+	}
+	s.audit(auditEntity.ActionMemberRemove, sessionData.UserID, vaultData.ID, fmt.Sprintf("%x", target.UserID.Bytes), client)
+	res = structs.StdResponse{Message: "DELETED", Data: fmt.Sprintf("userId %v has been removed", userId)}
+	code = fiber.StatusOK
+	return
+}
+
+// UpdateMemberRole change the role of a member in a vault
+func (s *VaultService) UpdateMemberRole(
+	token string,
+	vaultId string,
+	userId string,
+	param vaultDto.MemberRoleRequest,
+	client structs.StdClient,
+) (res structs.StdResponse, code int) {
+	sessionData, res, code := s.authenticate(token)
+	if code != fiber.StatusOK {
+		return
+	}
+	vaultData, _, res, code := s.authorize(sessionData.UserID, vaultId, vaultGroupEntity.PermissionManage)
+	if code != fiber.StatusOK {
+		return
+	}
+	target, res, code := s.getTargetMember(vaultData.ID, userId)
+	if code != fiber.StatusOK {
+		return
+	}
+	if param.Role != vaultGroupEntity.RoleOwner {
+		res, code = s.checkLastOwner(vaultData.ID, target)
+		if code != fiber.StatusOK {
+			return
+		}
+	}
+	if err := s.vaultGroupRepo.UpdateRole(vaultGroupRepo.UpdateRoleParam{
+		ID:      target.ID,
+		VaultID: vaultData.ID,
+		Role:    param.Role,
+	}); err != nil {
+		res, code = s.memberWriteError(err)
+		return
+	}
+	s.audit(auditEntity.ActionMemberRoleUpdate, sessionData.UserID, vaultData.ID, fmt.Sprintf("%x", target.UserID.Bytes), client)
+	res = structs.StdResponse{Message: "UPDATED", Data: fmt.Sprintf("userId %v is now %v", userId, param.Role)}
+	code = fiber.StatusOK
+	return
+}
+
+// AcceptInvitation open the vault key sealed to the current user using the private key,
+// then wrap it using the session secret so the vault is accessible on the next sessions.
+// A member accept the vault again the same way once its vault key is replaced
+func (s *VaultService) AcceptInvitation(
+	token string,
+	vaultId string,
+	param vaultDto.MemberAcceptRequest,
+	client structs.StdClient,
+) (res structs.StdResponse, code int) {
+	sessionData, res, code := s.authenticate(token)
+	if code != fiber.StatusOK {
+		return
+	}
+	vaultBytes, err := uuid.ParseUUID(vaultId)
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "REQUEST_ERROR", Data: err.Error()}
+		code = fiber.StatusBadRequest
+		return
+	}
+	member, err := s.vaultGroupRepo.GetMember(sessionData.UserID, pgtype.UUID{Bytes: vaultBytes, Valid: true})
+	if err != nil {
+		msg := "PROCESS_ERROR"
+		code = fiber.StatusInternalServerError
+		if err.Error() == consts.ErrNoData.Error() {
+			msg = "NOT_FOUND"
+			code = fiber.StatusNotFound
+		} else {
+			s.log.Error(err.Error())
+		}
+		res = structs.StdResponse{Message: msg, Data: err.Error()}
+		return
+	}
+	if member.VaultKey != "" || member.InviteKey == "" {
+		res = structs.StdResponse{Message: "DATA_EXISTS", Data: "Invitation already accepted"}
+		code = fiber.StatusBadRequest
+		return
+	}
+	userData, err := s.userRepo.GetByID(sessionData.UserID)
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	pub, err := crypto.ParsePublicKeyEd25519(userData.PublicKey)
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	pvt, err := crypto.ParsePrivateKeyEd25519(param.PrivateKey)
+	if err != nil {
+		res = structs.StdResponse{Message: "CREDENTIAL_ERROR", Data: "invalid credential"}
+		code = fiber.StatusUnauthorized
+		return
+	}
+	if err = crypto.ValidateKeyPairEd25519(pub, pvt); err != nil {
+		res = structs.StdResponse{Message: "CREDENTIAL_ERROR", Data: "invalid credential"}
+		code = fiber.StatusUnauthorized
+		return
+	}
+	keyHex, err := crypto.OpenAnonymousX25519(member.InviteKey, pvt)
+	if err != nil {
+		res = structs.StdResponse{Message: "ACCESS_DENIED", Data: err.Error()}
+		code = fiber.StatusUnauthorized
+		return
+	}
+	vaultKey, err := hex.DecodeString(keyHex)
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	wrappedKey, err := wrapVaultKey(sessionData, vaultKey)
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	vaultData, err := s.vaultRepo.GetByID(member.VaultID)
+	if err == nil {
+		err = s.vaultGroupRepo.UpdateVaultKey(vaultGroupRepo.UpdateVaultKeyParam{
+			ID:          member.ID,
+			VaultID:     member.VaultID,
+			VaultKey:    wrappedKey,
+			SealVersion: vaultData.SealVersion,
+		})
+	}
+	if err != nil {
+		res, code = s.writeError(err)
+		return
+	}
+	s.audit(auditEntity.ActionMemberAccept, sessionData.UserID, member.VaultID, "", client)
+	res = structs.StdResponse{Message: "UPDATED", Data: fmt.Sprintf("vaultId %v has been accepted", vaultId)}
+	code = fiber.StatusOK
+	return
+}
+
+// rekeyVault seal every encrypted value of a vault again using a new vault key, and remove the membership
+// `target` at once. The new vault key is wrapped for `member`, and sealed to the public key of the other members
+// like an invitation. consts.ErrIntegrity is returned when a value does not open using `vaultKey`
+func (s *VaultService) rekeyVault(
+	sessionData sessionEntity.Session,
+	vaultData vaultEntity.Vault,
+	member vaultGroupEntity.UserVault,
+	target vaultGroupEntity.UserVault,
+	vaultKey []byte,
+) error {
+	newKey, err := crypto.GenerateRandomKey(32)
+	if err != nil {
+		return err
+	}
+	reseal := func(value string, aad []byte) (sealed vaultRepo.SealedValue, err error) {
+		plainText, err := crypto.OpenEnvelopeStrict(value, vaultKey, aad)
+		if err != nil {
+			s.log.Error(fmt.Sprintf("vault %x: %v", vaultData.ID.Bytes, err))
+			err = consts.ErrIntegrity
+			return
+		}
+		sealed.Old = value
+		sealed.New, err = crypto.SealEnvelope(crypto.AlgorithmAESGCM, newKey, "", plainText, aad)
+		return
+	}
+
+	arg := vaultRepo.RekeyParam{
+		ID:              vaultData.ID,
+		SealVersion:     vaultData.SealVersion,
+		Credentials:     make(map[pgtype.UUID]vaultRepo.SealedValue),
+		Revisions:       make(map[int32]vaultRepo.SealedValue),
+		Histories:       make(map[int64]vaultRepo.SealedValue),
+		Members:         make(map[uint64]vaultRepo.MemberKey),
+		RemovedMemberID: target.ID,
+	}
+	credentials, err := s.credentialRepo.GetAllByVaultID(vaultData.ID)
+	if err != nil {
+		return err
+	}
+	for _, item := range credentials {
+		if arg.Credentials[item.ID], err = reseal(item.Data, credentialAAD(vaultData, fmt.Sprintf("%x", item.ID.Bytes))); err != nil {
+			return err
+		}
+	}
+	revisions, err := s.vaultRepo.GetAllRevision(vaultData.ID)
+	if err != nil {
+		return err
+	}
+	for _, item := range revisions {
+		if arg.Revisions[item.Revision], err = reseal(item.Credential, revisionAAD(vaultData, item.CredentialID)); err != nil {
+			return err
+		}
+	}
+	histories, err := s.credentialHistoryRepo.GetAllByVaultID(vaultData.ID)
+	if err != nil {
+		return err
+	}
+	for _, item := range histories {
+		aad := credentialHistoryAAD(vaultData, item.CredentialID, item.Field, item.Custom)
+		if arg.Histories[item.ID], err = reseal(item.Password, aad); err != nil {
+			return err
+		}
+	}
+	memberKeys, err := s.vaultGroupRepo.GetAllMemberKeyByVaultID(vaultData.ID)
+	if err != nil {
+		return err
+	}
+	for _, item := range memberKeys {
+		if item.ID == target.ID {
+			continue
+		}
+		if item.ID == member.ID {
+			wrappedKey, err := wrapVaultKey(sessionData, newKey)
+			if err != nil {
+				return err
+			}
+			arg.Members[item.ID] = vaultRepo.MemberKey{VaultKey: wrappedKey}
+			continue
+		}
+		pub, err := crypto.ParsePublicKeyEd25519(item.PublicKey)
+		if err != nil {
+			return err
+		}
+		inviteKey, err := crypto.SealAnonymousX25519(hex.EncodeToString(newKey), pub)
+		if err != nil {
+			return err
+		}
+		arg.Members[item.ID] = vaultRepo.MemberKey{InviteKey: inviteKey}
+	}
+	return s.vaultRepo.Rekey(arg)
+}
+
+// getTargetMember retrieve the membership of the user that is targeted by a member management
+func (s *VaultService) getTargetMember(
+	vaultID pgtype.UUID,
+	userId string,
+) (target vaultGroupEntity.UserVault, res structs.StdResponse, code int) {
+	userBytes, err := uuid.ParseUUID(userId)
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "REQUEST_ERROR", Data: err.Error()}
+		code = fiber.StatusBadRequest
+		return
+	}
+	target, err = s.vaultGroupRepo.GetMember(pgtype.UUID{Bytes: userBytes, Valid: true}, vaultID)
+	if err != nil {
+		msg := "PROCESS_ERROR"
+		code = fiber.StatusInternalServerError
+		if err.Error() == consts.ErrNoData.Error() {
+			msg = "NOT_FOUND"
+			code = fiber.StatusNotFound
+		} else {
+			s.log.Error(err.Error())
+		}
+		res = structs.StdResponse{Message: msg, Data: err.Error()}
+		return
+	}
+	code = fiber.StatusOK
+	return
+}
+
+// checkLastOwner prevent a vault from losing its last owner, when the target is removed or demoted.
+// It only reject early, the repository check it again within the write, see memberWriteError
+func (s *VaultService) checkLastOwner(vaultID pgtype.UUID, target vaultGroupEntity.UserVault) (res structs.StdResponse, code int) {
+	if target.Role != vaultGroupEntity.RoleOwner || !target.AcceptedAt.Valid {
+		code = fiber.StatusOK
+		return
+	}
+	memberData, err := s.vaultGroupRepo.GetAllMemberByVaultID(vaultID)
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	var owners int
+	for _, item := range memberData {
+		if item.Role == vaultGroupEntity.RoleOwner && item.AcceptedAt.Valid {
+			owners++
+		}
+	}
+	if owners <= 1 {
+		res = structs.StdResponse{Message: "REQUEST_ERROR", Data: "A vault requires at least one owner"}
+		code = fiber.StatusBadRequest
+		return
+	}
+	code = fiber.StatusOK
+	return
+}
+
+// memberWriteError build the response of a failed removal or role change of a member
+func (s *VaultService) memberWriteError(err error) (res structs.StdResponse, code int) {
+	switch err.Error() {
+	case consts.ErrLastOwner.Error():
+		res = structs.StdResponse{Message: "REQUEST_ERROR", Data: "A vault requires at least one owner"}
+		code = fiber.StatusBadRequest
+	case consts.ErrNoData.Error():
+		res = structs.StdResponse{Message: "NOT_FOUND", Data: err.Error()}
+		code = fiber.StatusNotFound
+	case consts.ErrIntegrity.Error():
+		res = structs.StdResponse{Message: "INTEGRITY_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+	default:
+		res, code = s.writeError(err)
+	}
+	return
+}
@@ -0,0 +1,205 @@
+package service
+
+import (
+	"fmt"
+	vaultDto "github.com/Novando/pintartek/internal/passvault-service/app/dto/vault"
+	"github.com/Novando/pintartek/internal/passvault-service/domain/vault-group/aggregate"
+	vaultGroupEntity "github.com/Novando/pintartek/internal/passvault-service/domain/vault-group/entity"
+	vaultGroupRepo "github.com/Novando/pintartek/internal/passvault-service/domain/vault-group/repository"
+	"github.com/Novando/pintartek/pkg/common/consts"
+	"github.com/Novando/pintartek/pkg/common/structs"
+	"github.com/Novando/pintartek/pkg/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestVaultService_Authorize_RoleMatrix(t *testing.T) {
+	matrix := []struct {
+		role       string
+		permission vaultGroupEntity.Permission
+		code       int
+	}{
+		{vaultGroupEntity.RoleOwner, vaultGroupEntity.PermissionRead, http.StatusOK},
+		{vaultGroupEntity.RoleOwner, vaultGroupEntity.PermissionEdit, http.StatusOK},
+		{vaultGroupEntity.RoleOwner, vaultGroupEntity.PermissionManage, http.StatusOK},
+		{vaultGroupEntity.RoleEditor, vaultGroupEntity.PermissionRead, http.StatusOK},
+		{vaultGroupEntity.RoleEditor, vaultGroupEntity.PermissionEdit, http.StatusOK},
+		{vaultGroupEntity.RoleEditor, vaultGroupEntity.PermissionManage, http.StatusForbidden},
+		{vaultGroupEntity.RoleViewer, vaultGroupEntity.PermissionRead, http.StatusOK},
+		{vaultGroupEntity.RoleViewer, vaultGroupEntity.PermissionEdit, http.StatusForbidden},
+		{vaultGroupEntity.RoleViewer, vaultGroupEntity.PermissionManage, http.StatusForbidden},
+		{"unknown", vaultGroupEntity.PermissionRead, http.StatusForbidden},
+	}
+	for _, item := range matrix {
+		ts := initTestVaultService(t)
+		vaultData := newTestVault()
+
+		ts.vaultMock.Mock.On("GetByID", vaultData.ID).Return(vaultData, nil)
+		ts.vaultGroupMock.Mock.On("GetMember", ts.sessionData.UserID, vaultData.ID).
+			Return(newTestMember(ts, vaultData, item.role), nil)
+
+		_, _, res, code := ts.serv.authorize(ts.sessionData.UserID, fmt.Sprintf("%x", vaultData.ID.Bytes), item.permission)
+		assert.Equal(t, item.code, code, "role %v with permission %v", item.role, item.permission)
+		if item.code == http.StatusForbidden {
+			assert.Equal(t, "ACCESS_DENIED", res.Message)
+		}
+	}
+}
+
+func TestVaultService_GetAllMember_Owner(t *testing.T) {
+	ts := initTestVaultService(t)
+	vaultData := newTestVault()
+	memberData := []aggregate.VaultMember{
+		{UserID: ts.sessionData.UserID, Email: "owner@test.com", Role: vaultGroupEntity.RoleOwner},
+	}
+
+	ts.vaultMock.Mock.On("GetByID", vaultData.ID).Return(vaultData, nil)
+	ts.vaultGroupMock.Mock.On("GetMember", ts.sessionData.UserID, vaultData.ID).
+		Return(newTestMember(ts, vaultData, vaultGroupEntity.RoleOwner), nil)
+	ts.vaultGroupMock.Mock.On("GetAllMemberByVaultID", vaultData.ID).Return(memberData, nil)
+
+	res, code := ts.serv.GetAllMember(ts.token, fmt.Sprintf("%x", vaultData.ID.Bytes))
+	assert.Equal(t, "FETCHED", res.Message)
+	assert.Equal(t, http.StatusOK, code)
+	data, ok := res.Data.([]vaultDto.MemberResponse)
+	if !ok {
+		t.Fatalf("unexpected response %v", res.Data)
+	}
+	assert.Equal(t, "owner@test.com", data[0].Email)
+}
+
+func TestVaultService_GetAllMember_Editor(t *testing.T) {
+	ts := initTestVaultService(t)
+	vaultData := newTestVault()
+
+	ts.vaultMock.Mock.On("GetByID", vaultData.ID).Return(vaultData, nil)
+	ts.vaultGroupMock.Mock.On("GetMember", ts.sessionData.UserID, vaultData.ID).
+		Return(newTestMember(ts, vaultData, vaultGroupEntity.RoleEditor), nil)
+
+	res, code := ts.serv.GetAllMember(ts.token, fmt.Sprintf("%x", vaultData.ID.Bytes))
+	assert.Equal(t, "ACCESS_DENIED", res.Message)
+	assert.Equal(t, http.StatusForbidden, code)
+}
+
+func TestVaultService_GetAllMember_Viewer(t *testing.T) {
+	ts := initTestVaultService(t)
+	vaultData := newTestVault()
+
+	ts.vaultMock.Mock.On("GetByID", vaultData.ID).Return(vaultData, nil)
+	ts.vaultGroupMock.Mock.On("GetMember", ts.sessionData.UserID, vaultData.ID).
+		Return(newTestMember(ts, vaultData, vaultGroupEntity.RoleViewer), nil)
+
+	res, code := ts.serv.GetAllMember(ts.token, fmt.Sprintf("%x", vaultData.ID.Bytes))
+	assert.Equal(t, "ACCESS_DENIED", res.Message)
+	assert.Equal(t, http.StatusForbidden, code)
+}
+
+func TestVaultService_RemoveMember_PendingInviteeDecline(t *testing.T) {
+	ts := initTestVaultService(t)
+	vaultData := newTestVault()
+	member := newTestMember(ts, vaultData, vaultGroupEntity.RoleViewer)
+	member.AcceptedAt = pgtype.Timestamptz{}
+	member.InviteKey = "sealed"
+
+	ts.vaultMock.Mock.On("GetByID", vaultData.ID).Return(vaultData, nil)
+	ts.vaultGroupMock.Mock.On("GetMember", ts.sessionData.UserID, vaultData.ID).Return(member, nil)
+	ts.vaultGroupMock.Mock.On("PermanentDelete", vaultData.ID, member.ID).Return(nil)
+
+	res, code := ts.serv.RemoveMember(
+		ts.token,
+		fmt.Sprintf("%x", vaultData.ID.Bytes),
+		fmt.Sprintf("%x", ts.sessionData.UserID.Bytes),
+		structs.StdClient{},
+	)
+	assert.Equal(t, "DELETED", res.Message)
+	assert.Equal(t, http.StatusOK, code)
+	ts.vaultGroupMock.Mock.AssertNotCalled(t, "GetAllMemberKeyByVaultID", vaultData.ID)
+}
+
+func TestVaultService_RemoveMember_PendingInviteeOther(t *testing.T) {
+	ts := initTestVaultService(t)
+	vaultData := newTestVault()
+	member := newTestMember(ts, vaultData, vaultGroupEntity.RoleOwner)
+	member.AcceptedAt = pgtype.Timestamptz{}
+	member.InviteKey = "sealed"
+	otherUserID := uuid.GenerateUUID()
+	target := vaultGroupEntity.UserVault{ID: 2, UserID: otherUserID, VaultID: vaultData.ID, Role: vaultGroupEntity.RoleViewer}
+
+	ts.vaultMock.Mock.On("GetByID", vaultData.ID).Return(vaultData, nil)
+	ts.vaultGroupMock.Mock.On("GetMember", ts.sessionData.UserID, vaultData.ID).Return(member, nil)
+	ts.vaultGroupMock.Mock.On("GetMember", otherUserID, vaultData.ID).Return(target, nil)
+
+	res, code := ts.serv.RemoveMember(
+		ts.token,
+		fmt.Sprintf("%x", vaultData.ID.Bytes),
+		fmt.Sprintf("%x", otherUserID.Bytes),
+		structs.StdClient{},
+	)
+	assert.Equal(t, "ACCESS_DENIED", res.Message)
+	assert.Equal(t, http.StatusForbidden, code)
+	ts.vaultGroupMock.Mock.AssertNotCalled(t, "PermanentDelete", vaultData.ID, target.ID)
+}
+
+func TestVaultService_RemoveMember_NeverAccepted(t *testing.T) {
+	ts := initTestVaultService(t)
+	vaultData := newTestVault()
+	otherUserID := uuid.GenerateUUID()
+	target := vaultGroupEntity.UserVault{ID: 2, UserID: otherUserID, VaultID: vaultData.ID, Role: vaultGroupEntity.RoleViewer, InviteKey: "sealed"}
+
+	ts.vaultMock.Mock.On("GetByID", vaultData.ID).Return(vaultData, nil)
+	ts.vaultGroupMock.Mock.On("GetMember", ts.sessionData.UserID, vaultData.ID).
+		Return(newTestMember(ts, vaultData, vaultGroupEntity.RoleOwner), nil)
+	ts.vaultGroupMock.Mock.On("GetMember", otherUserID, vaultData.ID).Return(target, nil)
+	ts.vaultGroupMock.Mock.On("PermanentDelete", vaultData.ID, target.ID).Return(nil)
+
+	// the invitation is cancelled without replacing the vault key
+	res, code := ts.serv.RemoveMember(
+		ts.token,
+		fmt.Sprintf("%x", vaultData.ID.Bytes),
+		fmt.Sprintf("%x", otherUserID.Bytes),
+		structs.StdClient{},
+	)
+	assert.Equal(t, "DELETED", res.Message)
+	assert.Equal(t, http.StatusOK, code)
+	ts.vaultGroupMock.Mock.AssertNotCalled(t, "GetAllMemberKeyByVaultID", vaultData.ID)
+}
+
+func TestVaultService_UpdateMemberRole_LastOwnerRace(t *testing.T) {
+	ts := initTestVaultService(t)
+	vaultData := newTestVault()
+	member := newTestMember(ts, vaultData, vaultGroupEntity.RoleOwner)
+	otherUserID := uuid.GenerateUUID()
+	target := vaultGroupEntity.UserVault{
+		ID:         2,
+		UserID:     otherUserID,
+		VaultID:    vaultData.ID,
+		Role:       vaultGroupEntity.RoleOwner,
+		AcceptedAt: member.AcceptedAt,
+	}
+
+	ts.vaultMock.Mock.On("GetByID", vaultData.ID).Return(vaultData, nil)
+	ts.vaultGroupMock.Mock.On("GetMember", ts.sessionData.UserID, vaultData.ID).Return(member, nil)
+	ts.vaultGroupMock.Mock.On("GetMember", otherUserID, vaultData.ID).Return(target, nil)
+	ts.vaultGroupMock.Mock.On("GetAllMemberByVaultID", vaultData.ID).Return([]aggregate.VaultMember{
+		{ID: member.ID, Role: member.Role, AcceptedAt: member.AcceptedAt},
+		{ID: target.ID, Role: target.Role, AcceptedAt: target.AcceptedAt},
+	}, nil)
+	// the other owner is demoted meanwhile, so the repository refuse to demote the last one
+	ts.vaultGroupMock.Mock.On("UpdateRole", vaultGroupRepo.UpdateRoleParam{
+		ID:      target.ID,
+		VaultID: vaultData.ID,
+		Role:    vaultGroupEntity.RoleViewer,
+	}).Return(consts.ErrLastOwner)
+
+	res, code := ts.serv.UpdateMemberRole(
+		ts.token,
+		fmt.Sprintf("%x", vaultData.ID.Bytes),
+		fmt.Sprintf("%x", otherUserID.Bytes),
+		vaultDto.MemberRoleRequest{Role: vaultGroupEntity.RoleViewer},
+		structs.StdClient{},
+	)
+	assert.Equal(t, "REQUEST_ERROR", res.Message)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
@@ -0,0 +1,257 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	vaultDto "github.com/Novando/pintartek/internal/passvault-service/app/dto/vault"
+	auditEntity "github.com/Novando/pintartek/internal/passvault-service/domain/audit/entity"
+	credentialRepo "github.com/Novando/pintartek/internal/passvault-service/domain/credential/repository"
+	vaultGroupEntity "github.com/Novando/pintartek/internal/passvault-service/domain/vault-group/entity"
+	vaultEntity "github.com/Novando/pintartek/internal/passvault-service/domain/vault/entity"
+	"github.com/Novando/pintartek/pkg/common/consts"
+	"github.com/Novando/pintartek/pkg/common/structs"
+	"github.com/Novando/pintartek/pkg/crypto"
+	"github.com/Novando/pintartek/pkg/uuid"
+	"github.com/gofiber/fiber/v2"
+	"github.com/jackc/pgx/v5/pgtype"
+	"strconv"
+)
+
+// GetAllRevision list the credentials a vault had before, the latest first
+func (s *VaultService) GetAllRevision(token, vaultId string) (res structs.StdResponse, code int) {
+	sessionData, res, code := s.authenticate(token)
+	if code != fiber.StatusOK {
+		return
+	}
+	vaultData, _, res, code := s.authorize(sessionData.UserID, vaultId, vaultGroupEntity.PermissionRead)
+	if code != fiber.StatusOK {
+		return
+	}
+	revisions, err := s.vaultRepo.GetAllRevision(vaultData.ID)
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	dto := []vaultDto.VaultRevisionResponse{}
+	for _, item := range revisions {
+		revision := vaultDto.VaultRevisionResponse{
+			Revision:   item.Revision,
+			ReplacedAt: item.ReplacedAt.Time,
+		}
+		if item.CredentialID.Valid {
+			revision.CredentialID = fmt.Sprintf("%x", item.CredentialID.Bytes)
+		}
+		dto = append(dto, revision)
+	}
+	res = structs.StdResponse{Message: "FETCHED", Data: dto, Count: int64(len(dto))}
+	code = fiber.StatusOK
+	return
+}
+
+// RestoreRevision put back the credential a vault had at a revision, only the credential of the revision
+// is replaced when it holds a single one. The credential being replaced become a revision as well,
+// so a restore can be undone
+func (s *VaultService) RestoreRevision(
+	token string,
+	vaultId string,
+	revision string,
+	client structs.StdClient,
+) (res structs.StdResponse, code int) {
+	sessionData, res, code := s.authenticate(token)
+	if code != fiber.StatusOK {
+		return
+	}
+	vaultData, member, res, code := s.authorize(sessionData.UserID, vaultId, vaultGroupEntity.PermissionEdit)
+	if code != fiber.StatusOK {
+		return
+	}
+	vaultKey, res, code := s.unlockKey(sessionData, &vaultData, member)
+	if code != fiber.StatusOK {
+		return
+	}
+	number, err := strconv.ParseInt(revision, 10, 32)
+	if err != nil {
+		res = structs.StdResponse{Message: "REQUEST_ERROR", Data: err.Error()}
+		code = fiber.StatusBadRequest
+		return
+	}
+	revisionData, err := s.vaultRepo.GetRevision(vaultData.ID, int32(number))
+	if err != nil {
+		if err.Error() == consts.ErrNoData.Error() {
+			res = structs.StdResponse{Message: "NOT_FOUND", Data: err.Error()}
+			code = fiber.StatusNotFound
+		} else {
+			s.log.Error(err.Error())
+			res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+			code = fiber.StatusInternalServerError
+		}
+		return
+	}
+	plainText, err := crypto.OpenEnvelopeStrict(
+		revisionData.Credential,
+		vaultKey,
+		revisionAAD(vaultData, revisionData.CredentialID),
+	)
+	if err != nil {
+		s.log.Error(fmt.Sprintf("vault %x revision %d: %v", vaultData.ID.Bytes, revisionData.Revision, err))
+		res = structs.StdResponse{Message: "INTEGRITY_ERROR", Data: consts.ErrIntegrity.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	if revisionData.CredentialID.Valid {
+		err = s.restoreCredentialRevision(vaultKey, vaultData, revisionData.CredentialID, plainText)
+	} else {
+		res, code = s.restoreVaultRevision(vaultKey, vaultData, plainText)
+		if code != fiber.StatusOK {
+			return
+		}
+	}
+	if err != nil {
+		if err.Error() == consts.ErrIntegrity.Error() {
+			res = structs.StdResponse{Message: "INTEGRITY_ERROR", Data: err.Error()}
+			code = fiber.StatusInternalServerError
+			return
+		}
+		res, code = s.writeError(err)
+		return
+	}
+	s.audit(auditEntity.ActionVaultRestore, sessionData.UserID, vaultData.ID, revision, client)
+	res = structs.StdResponse{Message: "UPDATED", Data: fmt.Sprintf("vaultId %v has been restored to revision %v", vaultId, number)}
+	code = fiber.StatusOK
+	return
+}
+
+// restoreCredentialRevision put back the JSON a single credential had at a revision,
+// the credential is removed when the revision is absentCredential
+func (s *VaultService) restoreCredentialRevision(
+	vaultKey []byte,
+	vaultData vaultEntity.Vault,
+	id pgtype.UUID,
+	restored []byte,
+) error {
+	credentialId := fmt.Sprintf("%x", id.Bytes)
+	previous := []byte(absentCredential)
+	item, err := s.credentialRepo.GetByID(vaultData.ID, id)
+	if err == nil {
+		previous, err = crypto.OpenEnvelopeStrict(item.Data, vaultKey, credentialAAD(vaultData, credentialId))
+		if err != nil {
+			s.log.Error(fmt.Sprintf("vault %x credential %s: %v", vaultData.ID.Bytes, credentialId, err))
+			return consts.ErrIntegrity
+		}
+	} else if err.Error() != consts.ErrNoData.Error() {
+		return err
+	}
+	revision, err := s.revisionOf(vaultKey, vaultData, id, previous)
+	if err != nil {
+		return err
+	}
+	if string(restored) == absentCredential {
+		return s.credentialRepo.PermanentDelete(credentialRepo.DeleteParam{
+			ID:          id,
+			VaultID:     vaultData.ID,
+			SealVersion: vaultData.SealVersion,
+			Revision:    revision,
+		})
+	}
+	var credential vaultDto.Credential
+	if err = json.Unmarshal(restored, &credential); err != nil {
+		return err
+	}
+	histories, err := s.credentialHistoryOf(vaultKey, vaultData, credentialId, previous, credential)
+	if err != nil {
+		return err
+	}
+	encrypted, err := s.encryptCredential(credential, vaultKey, vaultData, credentialId)
+	if err != nil {
+		return err
+	}
+	arg := credentialRepo.UpsertParam{
+		ID:          id,
+		VaultID:     vaultData.ID,
+		Data:        encrypted,
+		SealVersion: vaultData.SealVersion,
+		Revision:    revision,
+		Histories:   histories,
+	}
+	if string(previous) == absentCredential {
+		return s.credentialRepo.Create(arg)
+	}
+	return s.credentialRepo.Update(arg)
+}
+
+// restoreVaultRevision put back every credential a vault had at a revision, each of them is encrypted again
+// into its own row. Every current credential is kept as a single revision, and the history of each credential
+// replaced by the revision is recorded
+func (s *VaultService) restoreVaultRevision(
+	vaultKey []byte,
+	vaultData vaultEntity.Vault,
+	restored []byte,
+) (res structs.StdResponse, code int) {
+	credentials, res, code := s.loadCredentials(vaultKey, vaultData)
+	if code != fiber.StatusOK {
+		return
+	}
+	rows, err := s.sealCredentials(vaultKey, vaultData, string(restored))
+	if err == nil {
+		err = s.revisionHistoryOf(vaultKey, vaultData, credentials, restored, rows)
+	}
+	var revision string
+	if err == nil {
+		revision, err = s.revisionOf(vaultKey, vaultData, pgtype.UUID{}, []byte(credentials))
+	}
+	if err == nil {
+		err = s.credentialRepo.ReplaceAll(vaultData.ID, vaultData.SealVersion, revision, rows)
+	}
+	if err != nil {
+		res, code = s.writeError(err)
+		return
+	}
+	code = fiber.StatusOK
+	return
+}
+
+// revisionHistoryOf give each row of `rows` the history of the credential of the JSON map `credentials` it replaces,
+// the rows are sealed from the JSON map `restored` and replace the credential with the same ID
+func (s *VaultService) revisionHistoryOf(
+	vaultKey []byte,
+	vaultData vaultEntity.Vault,
+	credentials string,
+	restored []byte,
+	rows []credentialRepo.UpsertParam,
+) error {
+	var current, replacing map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(credentials), &current); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(restored, &replacing); err != nil {
+		return err
+	}
+	for credentialId, value := range replacing {
+		idBytes, err := uuid.ParseUUID(credentialId)
+		if err != nil {
+			// a credential without a UUID is given a new one, nothing is replaced
+			continue
+		}
+		credentialId = fmt.Sprintf("%x", idBytes)
+		previous, ok := current[credentialId]
+		if !ok {
+			continue
+		}
+		var credential vaultDto.Credential
+		if err = json.Unmarshal(value, &credential); err != nil {
+			return err
+		}
+		histories, err := s.credentialHistoryOf(vaultKey, vaultData, credentialId, previous, credential)
+		if err != nil {
+			return err
+		}
+		for i := range rows {
+			if fmt.Sprintf("%x", rows[i].ID.Bytes) == credentialId {
+				rows[i].Histories = histories
+			}
+		}
+	}
+	return nil
+}
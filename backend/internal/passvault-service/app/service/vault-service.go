@@ -2,12 +2,23 @@ package service
 
 import (
 	"context"
+	"crypto/ed25519"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	vaultDto "github.com/Novando/pintartek/internal/passvault-service/app/dto/vault"
+	auditEntity "github.com/Novando/pintartek/internal/passvault-service/domain/audit/entity"
+	auditRepo "github.com/Novando/pintartek/internal/passvault-service/domain/audit/repository"
+	credentialHistoryRepo "github.com/Novando/pintartek/internal/passvault-service/domain/credential-history/repository"
+	credentialEntity "github.com/Novando/pintartek/internal/passvault-service/domain/credential/entity"
+	credentialRepo "github.com/Novando/pintartek/internal/passvault-service/domain/credential/repository"
+	sessionEntity "github.com/Novando/pintartek/internal/passvault-service/domain/session/entity"
 	sessionRepo "github.com/Novando/pintartek/internal/passvault-service/domain/session/repository"
+	userRepo "github.com/Novando/pintartek/internal/passvault-service/domain/user/repository"
+	vaultGroupEntity "github.com/Novando/pintartek/internal/passvault-service/domain/vault-group/entity"
 	vaultGroupRepo "github.com/Novando/pintartek/internal/passvault-service/domain/vault-group/repository"
+	vaultEntity "github.com/Novando/pintartek/internal/passvault-service/domain/vault/entity"
 	vaultRepo "github.com/Novando/pintartek/internal/passvault-service/domain/vault/repository"
 	"github.com/Novando/pintartek/pkg/common/consts"
 	"github.com/Novando/pintartek/pkg/common/structs"
@@ -19,20 +30,27 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
 )
 
 type VaultConfig func(su *VaultService)
 
 type VaultService struct {
-	log            *logger.Logger
-	vaultRepo      vaultRepo.Vault
-	sessionRepo    sessionRepo.Session
-	vaultGroupRepo vaultGroupRepo.VaultGroup
+	log                   *logger.Logger
+	vaultRepo             vaultRepo.Vault
+	sessionRepo           sessionRepo.Session
+	userRepo              userRepo.User
+	auditRepo             auditRepo.Audit
+	auditKey              ed25519.PrivateKey
+	credentialRepo        credentialRepo.Credential
+	credentialHistoryRepo credentialHistoryRepo.CredentialHistory
+	vaultGroupRepo        vaultGroupRepo.VaultGroup
+	trashRetention        time.Duration
 }
 
 // NewVaultService Initialize user service
 func NewVaultService(config VaultConfig, cfgs ...VaultConfig) *VaultService {
-	serv := &VaultService{}
+	serv := &VaultService{trashRetention: defaultVaultRetention}
 	cfgs = append([]VaultConfig{config}, cfgs...)
 	for _, cfg := range cfgs {
 		cfg(serv)
@@ -46,10 +64,30 @@ func WithVaultPostgres(c context.Context, q *pgx.Queries, db *pgxpool.Pool, l *l
 		sv.log = l
 		sv.vaultRepo = vaultRepo.NewPostgresVaultRepository(c, q, db)
 		sv.sessionRepo = sessionRepo.NewPostgresSessionRepository(c, q, db)
+		sv.userRepo = userRepo.NewPostgresUserRepository(c, q, db)
+		sv.auditRepo = auditRepo.NewPostgresAuditRepository(c, q, db)
+		sv.credentialRepo = credentialRepo.NewPostgresCredentialRepository(c, q, db)
+		sv.credentialHistoryRepo = credentialHistoryRepo.NewPostgresCredentialHistoryRepository(c, q, db)
 		sv.vaultGroupRepo = vaultGroupRepo.NewPostgresVaultGroupRepository(c, q, db)
 	}
 }
 
+// WithVaultMock Using mocked repositories to store data
+func WithVaultMock(
+	vr *vaultRepo.VaultMock,
+	vgr *vaultGroupRepo.VaultGroupMock,
+	sr *sessionRepo.SessionMock,
+	ar *auditRepo.AuditMock,
+) VaultConfig {
+	return func(sv *VaultService) {
+		sv.log = logger.InitZerolog(logger.Config{ConsoleLoggingEnabled: true})
+		sv.vaultRepo = vr
+		sv.vaultGroupRepo = vgr
+		sv.sessionRepo = sr
+		sv.auditRepo = ar
+	}
+}
+
 // WithVaultRedis Using redis to store session data
 func WithVaultRedis(r *redis.Redis) VaultConfig {
 	return func(sv *VaultService) {
@@ -57,29 +95,61 @@ func WithVaultRedis(r *redis.Redis) VaultConfig {
 	}
 }
 
+// WithVaultTrashRetention Set how long a vault stay in the trash, only used to tell when it will be purged
+func WithVaultTrashRetention(retention time.Duration) VaultConfig {
+	return func(sv *VaultService) {
+		if retention > 0 {
+			sv.trashRetention = retention
+		}
+	}
+}
+
+// WithVaultAuditKey Set the key signing the audit events
+func WithVaultAuditKey(key ed25519.PrivateKey) VaultConfig {
+	return func(sv *VaultService) {
+		sv.auditKey = key
+	}
+}
+
 // Create build a new vault that contain secret credentials
-func (s *VaultService) Create(sessionToken string, param vaultDto.VaultRequest) (res structs.StdResponse, code int) {
-	tokenBytes, err := uuid.ParseUUID(sessionToken)
+func (s *VaultService) Create(
+	sessionToken string,
+	param vaultDto.VaultRequest,
+	client structs.StdClient,
+) (res structs.StdResponse, code int) {
+	sessionData, res, code := s.authenticate(sessionToken)
+	if code != fiber.StatusOK {
+		return
+	}
+	param.Credential.Type = itemTypeOf(param.Credential)
+	if err := checkCustomFields(param.Credential.Fields); err != nil {
+		res = structs.StdResponse{Message: "VALIDATION_ERROR", Data: err.Error()}
+		code = fiber.StatusBadRequest
+		return
+	}
+
+	key, err := crypto.GenerateRandomKey(32)
 	if err != nil {
 		s.log.Error(err.Error())
-		res = structs.StdResponse{Message: "REQUEST_ERROR", Data: err.Error()}
-		code = fiber.StatusBadRequest
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
 		return
 	}
-	sessionData, err := s.sessionRepo.GetByID(pgtype.UUID{Bytes: tokenBytes, Valid: true})
+	wrappedKey, err := wrapVaultKey(sessionData, key)
 	if err != nil {
-		if err.Error() == consts.ErrNoData.Error() {
-			res = structs.StdResponse{Message: "ACCESS_DENIED", Data: err.Error()}
-			code = fiber.StatusUnauthorized
-		} else {
-			s.log.Error(err.Error())
-			res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
-			code = fiber.StatusInternalServerError
-		}
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
 		return
 	}
-
-	mapRes, credential, err := s.processJson(param.Credential, sessionData.SecretKey, fmt.Sprintf("%x", uuid.GenerateUUID().Bytes))
+	vaultData := vaultEntity.Vault{
+		ID:      pgtype.UUID{Bytes: uuid.GenerateUUID().Bytes, Valid: true},
+		OwnerID: sessionData.UserID,
+		// each value is bound to its purpose from the start, see resealVault
+		SealVersion: 1,
+	}
+	credentialId := uuid.GenerateUUID()
+	credential, err := s.encryptCredential(param.Credential, key, vaultData, fmt.Sprintf("%x", credentialId.Bytes))
 	if err != nil {
 		s.log.Error(err.Error())
 		msg := "PROCESS_ERROR"
@@ -93,49 +163,32 @@ func (s *VaultService) Create(sessionToken string, param vaultDto.VaultRequest)
 	}
 
 	vaultId, err := s.vaultRepo.Create(vaultRepo.UpsertParam{
-		Name:       param.Name,
-		Credential: credential,
+		ID:          vaultData.ID,
+		OwnerID:     vaultData.OwnerID,
+		Name:        param.Name,
+		SealVersion: vaultData.SealVersion,
+		OwnerKey:    wrappedKey,
+		Credentials: map[pgtype.UUID]string{credentialId: credential},
 	})
 	if err != nil {
 		s.log.Error(err.Error())
 		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
 		code = fiber.StatusInternalServerError
-	}
-	if err = s.vaultGroupRepo.Create(vaultGroupRepo.CreateParam{VaultID: vaultId, UserID: sessionData.UserID}); err != nil {
-		s.log.Error(err.Error())
-		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
-		code = fiber.StatusInternalServerError
 		return
 	}
-	_, err = s.sessionRepo.Create(sessionRepo.CreateParam{
-		ID:        pgtype.UUID{Bytes: uuid.GenerateUUID().Bytes, Valid: true},
-		UserID:    sessionData.UserID,
-		SecretKey: sessionData.SecretKey,
-	})
-	res = structs.StdResponse{Message: "CREATED", Data: mapRes}
+	s.audit(auditEntity.ActionVaultCreate, sessionData.UserID, vaultId, "", client)
+	res = structs.StdResponse{Message: "CREATED", Data: vaultDto.CredentialWriteResponse{
+		ID:         fmt.Sprintf("%x", credentialId.Bytes),
+		Credential: param.Credential,
+	}}
 	code = fiber.StatusOK
 	return
 }
 
 // GetAll return all vault owned by a user
 func (s *VaultService) GetAll(token string) (res structs.StdResponse, code int) {
-	tokenBytes, err := uuid.ParseUUID(token)
-	if err != nil {
-		s.log.Error(err.Error())
-		res = structs.StdResponse{Message: "REQUEST_ERROR", Data: err.Error()}
-		code = fiber.StatusBadRequest
-		return
-	}
-	sessionData, err := s.sessionRepo.GetByID(pgtype.UUID{Bytes: tokenBytes, Valid: true})
-	if err != nil {
-		if err.Error() == consts.ErrNoData.Error() {
-			res = structs.StdResponse{Message: "ACCESS_DENIED", Data: err.Error()}
-			code = fiber.StatusUnauthorized
-		} else {
-			s.log.Error(err.Error())
-			res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
-			code = fiber.StatusInternalServerError
-		}
+	sessionData, res, code := s.authenticate(token)
+	if code != fiber.StatusOK {
 		return
 	}
 	vaultData, err := s.vaultGroupRepo.GetAllVaultByUserID(sessionData.UserID, structs.StdPagination{Page: 0, Size: 1000})
@@ -148,68 +201,35 @@ func (s *VaultService) GetAll(token string) (res structs.StdResponse, code int)
 	dto := []vaultDto.VaultResponse{}
 	for _, item := range vaultData {
 		dto = append(dto, vaultDto.VaultResponse{
-			ID:        fmt.Sprintf("%x", item.ID.Bytes),
-			Name:      item.Name,
-			CreatedAt: item.CreatedAt.Time,
-			UpdatedAt: item.UpdatedAt.Time,
+			ID:          fmt.Sprintf("%x", item.ID.Bytes),
+			Name:        item.Name,
+			Role:        item.Role,
+			Pending:     !item.AcceptedAt.Valid,
+			KeyReplaced: item.KeyReplaced,
+			CreatedAt:   item.CreatedAt.Time,
+			UpdatedAt:   item.UpdatedAt.Time,
 		})
 	}
-	_, err = s.sessionRepo.Create(sessionRepo.CreateParam{
-		ID:        pgtype.UUID{Bytes: uuid.GenerateUUID().Bytes, Valid: true},
-		UserID:    sessionData.UserID,
-		SecretKey: sessionData.SecretKey,
-	})
 	res = structs.StdResponse{Message: "FETCHED", Data: dto, Count: int64(len(dto))}
 	code = fiber.StatusOK
 	return
 }
 
 // GetOne decrypt the credential of a vault
-func (s *VaultService) GetOne(token, vaultId string) (res structs.StdResponse, code int) {
-	tokenBytes, err := uuid.ParseUUID(token)
-	if err != nil {
-		s.log.Error(err.Error())
-		res = structs.StdResponse{Message: "REQUEST_ERROR", Data: err.Error()}
-		code = fiber.StatusBadRequest
-		return
-	}
-	sessionData, err := s.sessionRepo.GetByID(pgtype.UUID{Bytes: tokenBytes, Valid: true})
-	if err != nil {
-		if err.Error() == consts.ErrNoData.Error() {
-			res = structs.StdResponse{Message: "ACCESS_DENIED", Data: err.Error()}
-			code = fiber.StatusUnauthorized
-		} else {
-			s.log.Error(err.Error())
-			res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
-			code = fiber.StatusInternalServerError
-		}
-		return
-	}
-	vaultBytes, err := uuid.ParseUUID(vaultId)
-	if err != nil {
-		s.log.Error(err.Error())
-		res = structs.StdResponse{Message: "REQUEST_ERROR", Data: err.Error()}
-		code = fiber.StatusBadRequest
+func (s *VaultService) GetOne(token, vaultId string, client structs.StdClient) (res structs.StdResponse, code int) {
+	sessionData, res, code := s.authenticate(token)
+	if code != fiber.StatusOK {
 		return
 	}
-	vaultData, err := s.vaultRepo.GetByID(pgtype.UUID{Bytes: vaultBytes, Valid: true})
-	if err != nil {
-		s.log.Error(err.Error())
-		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
-		code = fiber.StatusInternalServerError
+	vaultData, member, res, code := s.authorize(sessionData.UserID, vaultId, vaultGroupEntity.PermissionRead)
+	if code != fiber.StatusOK {
 		return
 	}
-	credentials, err := crypto.DecryptAES(vaultData.Credential, sessionData.SecretKey)
-	if err != nil {
-		res = structs.StdResponse{Message: "ACCESS_DENIED", Data: err.Error()}
-		code = fiber.StatusUnauthorized
+	_, credentials, res, code := s.unlock(sessionData, &vaultData, member)
+	if code != fiber.StatusOK {
 		return
 	}
-	_, err = s.sessionRepo.Create(sessionRepo.CreateParam{
-		ID:        pgtype.UUID{Bytes: uuid.GenerateUUID().Bytes, Valid: true},
-		UserID:    sessionData.UserID,
-		SecretKey: sessionData.SecretKey,
-	})
+	s.audit(auditEntity.ActionVaultRead, sessionData.UserID, vaultData.ID, "", client)
 	res = structs.StdResponse{Message: "FETCHED", Data: base64.StdEncoding.EncodeToString([]byte(credentials))}
 	code = fiber.StatusOK
 	return
@@ -220,44 +240,24 @@ func (s *VaultService) UpdateVaultName(
 	token,
 	vaultId string,
 	param vaultDto.VaultEditRequest,
+	client structs.StdClient,
 ) (res structs.StdResponse, code int) {
-	tokenBytes, err := uuid.ParseUUID(token)
-	if err != nil {
-		s.log.Error(err.Error())
-		res = structs.StdResponse{Message: "REQUEST_ERROR", Data: err.Error()}
-		code = fiber.StatusBadRequest
+	sessionData, res, code := s.authenticate(token)
+	if code != fiber.StatusOK {
 		return
 	}
-	sessionData, err := s.sessionRepo.GetByID(pgtype.UUID{Bytes: tokenBytes, Valid: true})
-	if err != nil {
-		if err.Error() == consts.ErrNoData.Error() {
-			res = structs.StdResponse{Message: "ACCESS_DENIED", Data: err.Error()}
-			code = fiber.StatusUnauthorized
-		} else {
-			s.log.Error(err.Error())
-			res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
-			code = fiber.StatusInternalServerError
-		}
+	vaultData, _, res, code := s.authorize(sessionData.UserID, vaultId, vaultGroupEntity.PermissionManage)
+	if code != fiber.StatusOK {
 		return
 	}
-	vaultBytes, err := uuid.ParseUUID(vaultId)
+	err := s.vaultRepo.UpdateName(vaultData.ID, param.Name)
 	if err != nil {
-		s.log.Error(err.Error())
-		res = structs.StdResponse{Message: "REQUEST_ERROR", Data: err.Error()}
-		code = fiber.StatusBadRequest
-		return
-	}
-	if err = s.vaultRepo.UpdateName(pgtype.UUID{Bytes: vaultBytes, Valid: true}, param.Name); err != nil {
 		s.log.Error(err.Error())
 		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
 		code = fiber.StatusInternalServerError
 		return
 	}
-	_, err = s.sessionRepo.Create(sessionRepo.CreateParam{
-		ID:        pgtype.UUID{Bytes: uuid.GenerateUUID().Bytes, Valid: true},
-		UserID:    sessionData.UserID,
-		SecretKey: sessionData.SecretKey,
-	})
+	s.audit(auditEntity.ActionVaultRename, sessionData.UserID, vaultData.ID, "", client)
 	res = structs.StdResponse{Message: "UPDATED"}
 	code = fiber.StatusOK
 	return
@@ -269,48 +269,40 @@ func (s *VaultService) UpdateCredential(
 	vaultId string,
 	credentialId string,
 	param vaultDto.Credential,
+	client structs.StdClient,
 ) (res structs.StdResponse, code int) {
-	tokenBytes, err := uuid.ParseUUID(token)
-	if err != nil {
-		s.log.Error(err.Error())
-		res = structs.StdResponse{Message: "REQUEST_ERROR", Data: err.Error()}
+	sessionData, res, code := s.authenticate(token)
+	if code != fiber.StatusOK {
+		return
+	}
+	param.Type = itemTypeOf(param)
+	if err := checkCustomFields(param.Fields); err != nil {
+		res = structs.StdResponse{Message: "VALIDATION_ERROR", Data: err.Error()}
 		code = fiber.StatusBadRequest
 		return
 	}
-	sessionData, err := s.sessionRepo.GetByID(pgtype.UUID{Bytes: tokenBytes, Valid: true})
-	if err != nil {
-		if err.Error() == consts.ErrNoData.Error() {
-			res = structs.StdResponse{Message: "ACCESS_DENIED", Data: err.Error()}
-			code = fiber.StatusUnauthorized
-		} else {
-			s.log.Error(err.Error())
-			res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
-			code = fiber.StatusInternalServerError
-		}
+	vaultData, member, res, code := s.authorize(sessionData.UserID, vaultId, vaultGroupEntity.PermissionEdit)
+	if code != fiber.StatusOK {
 		return
 	}
-	vaultBytes, err := uuid.ParseUUID(vaultId)
-	if err != nil {
-		s.log.Error(err.Error())
-		res = structs.StdResponse{Message: "REQUEST_ERROR", Data: err.Error()}
-		code = fiber.StatusBadRequest
+	id, credentialId, res, code := parseCredentialId(credentialId)
+	if code != fiber.StatusOK {
 		return
 	}
-	vaultUuid := pgtype.UUID{Bytes: vaultBytes, Valid: true}
-	vaultData, err := s.vaultRepo.GetByID(vaultUuid)
-	if err != nil {
-		s.log.Error(err.Error())
-		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
-		code = fiber.StatusInternalServerError
+	vaultKey, res, code := s.unlockKey(sessionData, &vaultData, member)
+	if code != fiber.StatusOK {
 		return
 	}
-	credentials, err := crypto.DecryptAES(vaultData.Credential, sessionData.SecretKey)
+	_, previous, res, code := s.loadCredential(vaultKey, vaultData, id)
+	if code != fiber.StatusOK {
+		return
+	}
+	histories, err := s.credentialHistoryOf(vaultKey, vaultData, credentialId, previous, param)
 	if err != nil {
-		res = structs.StdResponse{Message: "ACCESS_DENIED", Data: err.Error()}
-		code = fiber.StatusUnauthorized
+		res, code = s.writeError(err)
 		return
 	}
-	mapRes, credential, err := s.processJson(param, sessionData.SecretKey, credentialId, credentials)
+	credential, err := s.encryptCredential(param, vaultKey, vaultData, credentialId)
 	if err != nil {
 		s.log.Error(err.Error())
 		msg := "PROCESS_ERROR"
@@ -322,18 +314,26 @@ func (s *VaultService) UpdateCredential(
 		res = structs.StdResponse{Message: msg, Data: err.Error()}
 		return
 	}
-	if err = s.vaultRepo.UpdateCredential(vaultUuid, credential); err != nil {
-		s.log.Error(err.Error())
-		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
-		code = fiber.StatusInternalServerError
+	revision, err := s.revisionOf(vaultKey, vaultData, id, previous)
+	if err == nil {
+		err = s.credentialRepo.Update(credentialRepo.UpsertParam{
+			ID:          id,
+			VaultID:     vaultData.ID,
+			Data:        credential,
+			SealVersion: vaultData.SealVersion,
+			Revision:    revision,
+			Histories:   histories,
+		})
+	}
+	if err != nil {
+		res, code = s.writeError(err)
 		return
 	}
-	_, err = s.sessionRepo.Create(sessionRepo.CreateParam{
-		ID:        pgtype.UUID{Bytes: uuid.GenerateUUID().Bytes, Valid: true},
-		UserID:    sessionData.UserID,
-		SecretKey: sessionData.SecretKey,
-	})
-	res = structs.StdResponse{Message: "UPDATED", Data: mapRes}
+	s.audit(auditEntity.ActionCredentialUpdate, sessionData.UserID, vaultData.ID, credentialId, client)
+	res = structs.StdResponse{Message: "UPDATED", Data: vaultDto.CredentialWriteResponse{
+		ID:         credentialId,
+		Credential: param,
+	}}
 	code = fiber.StatusOK
 	return
 }
@@ -343,53 +343,29 @@ func (s *VaultService) CreateCredential(
 	token string,
 	vaultId string,
 	param vaultDto.Credential,
+	client structs.StdClient,
 ) (res structs.StdResponse, code int) {
-	tokenBytes, err := uuid.ParseUUID(token)
-	if err != nil {
-		s.log.Error(err.Error())
-		res = structs.StdResponse{Message: "REQUEST_ERROR", Data: err.Error()}
-		code = fiber.StatusBadRequest
-		return
-	}
-	sessionData, err := s.sessionRepo.GetByID(pgtype.UUID{Bytes: tokenBytes, Valid: true})
-	if err != nil {
-		if err.Error() == consts.ErrNoData.Error() {
-			res = structs.StdResponse{Message: "ACCESS_DENIED", Data: err.Error()}
-			code = fiber.StatusUnauthorized
-		} else {
-			s.log.Error(err.Error())
-			res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
-			code = fiber.StatusInternalServerError
-		}
+	sessionData, res, code := s.authenticate(token)
+	if code != fiber.StatusOK {
 		return
 	}
-	vaultBytes, err := uuid.ParseUUID(vaultId)
-	if err != nil {
-		s.log.Error(err.Error())
-		res = structs.StdResponse{Message: "REQUEST_ERROR", Data: err.Error()}
+	param.Type = itemTypeOf(param)
+	if err := checkCustomFields(param.Fields); err != nil {
+		res = structs.StdResponse{Message: "VALIDATION_ERROR", Data: err.Error()}
 		code = fiber.StatusBadRequest
 		return
 	}
-	vaultUuid := pgtype.UUID{Bytes: vaultBytes, Valid: true}
-	vaultData, err := s.vaultRepo.GetByID(vaultUuid)
-	if err != nil {
-		s.log.Error(err.Error())
-		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
-		code = fiber.StatusInternalServerError
+	vaultData, member, res, code := s.authorize(sessionData.UserID, vaultId, vaultGroupEntity.PermissionEdit)
+	if code != fiber.StatusOK {
 		return
 	}
-	credentials, err := crypto.DecryptAES(vaultData.Credential, sessionData.SecretKey)
-	if err != nil {
-		res = structs.StdResponse{Message: "ACCESS_DENIED", Data: err.Error()}
-		code = fiber.StatusUnauthorized
+	vaultKey, res, code := s.unlockKey(sessionData, &vaultData, member)
+	if code != fiber.StatusOK {
 		return
 	}
-	mapRes, credential, err := s.processJson(
-		param,
-		sessionData.SecretKey,
-		fmt.Sprintf("%x", uuid.GenerateUUID().Bytes),
-		credentials,
-	)
+	id := uuid.GenerateUUID()
+	credentialId := fmt.Sprintf("%x", id.Bytes)
+	credential, err := s.encryptCredential(param, vaultKey, vaultData, credentialId)
 	if err != nil {
 		s.log.Error(err.Error())
 		msg := "PROCESS_ERROR"
@@ -401,18 +377,25 @@ func (s *VaultService) CreateCredential(
 		res = structs.StdResponse{Message: msg, Data: err.Error()}
 		return
 	}
-	if err = s.vaultRepo.UpdateCredential(vaultUuid, credential); err != nil {
-		s.log.Error(err.Error())
-		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
-		code = fiber.StatusInternalServerError
+	revision, err := s.revisionOf(vaultKey, vaultData, id, []byte(absentCredential))
+	if err == nil {
+		err = s.credentialRepo.Create(credentialRepo.UpsertParam{
+			ID:          id,
+			VaultID:     vaultData.ID,
+			Data:        credential,
+			SealVersion: vaultData.SealVersion,
+			Revision:    revision,
+		})
+	}
+	if err != nil {
+		res, code = s.writeError(err)
 		return
 	}
-	_, err = s.sessionRepo.Create(sessionRepo.CreateParam{
-		ID:        pgtype.UUID{Bytes: uuid.GenerateUUID().Bytes, Valid: true},
-		UserID:    sessionData.UserID,
-		SecretKey: sessionData.SecretKey,
-	})
-	res = structs.StdResponse{Message: "CREATED", Data: mapRes}
+	s.audit(auditEntity.ActionCredentialCreate, sessionData.UserID, vaultData.ID, credentialId, client)
+	res = structs.StdResponse{Message: "CREATED", Data: vaultDto.CredentialWriteResponse{
+		ID:         credentialId,
+		Credential: param,
+	}}
 	code = fiber.StatusOK
 	return
 }
@@ -422,19 +405,142 @@ func (s *VaultService) DeleteCredential(
 	token string,
 	vaultId string,
 	credentialId string,
+	client structs.StdClient,
 ) (res structs.StdResponse, code int) {
-	tokenBytes, err := uuid.ParseUUID(token)
+	sessionData, res, code := s.authenticate(token)
+	if code != fiber.StatusOK {
+		return
+	}
+	vaultData, member, res, code := s.authorize(sessionData.UserID, vaultId, vaultGroupEntity.PermissionEdit)
+	if code != fiber.StatusOK {
+		return
+	}
+	id, credentialId, res, code := parseCredentialId(credentialId)
+	if code != fiber.StatusOK {
+		return
+	}
+	vaultKey, res, code := s.unlockKey(sessionData, &vaultData, member)
+	if code != fiber.StatusOK {
+		return
+	}
+	_, previous, res, code := s.loadCredential(vaultKey, vaultData, id)
+	if code != fiber.StatusOK {
+		return
+	}
+	revision, err := s.revisionOf(vaultKey, vaultData, id, previous)
+	if err == nil {
+		err = s.credentialRepo.PermanentDelete(credentialRepo.DeleteParam{
+			ID:          id,
+			VaultID:     vaultData.ID,
+			SealVersion: vaultData.SealVersion,
+			Revision:    revision,
+		})
+	}
+	if err != nil {
+		res, code = s.writeError(err)
+		return
+	}
+	s.audit(auditEntity.ActionCredentialDelete, sessionData.UserID, vaultData.ID, credentialId, client)
+	res = structs.StdResponse{Message: "DELETED", Data: credentialId}
+	code = fiber.StatusOK
+	return
+}
+
+// Delete move a vault to the trash, it is permanently deleted once the retention period is over
+func (s *VaultService) Delete(
+	token string,
+	vaultId string,
+	client structs.StdClient,
+) (res structs.StdResponse, code int) {
+	sessionData, res, code := s.authenticate(token)
+	if code != fiber.StatusOK {
+		return
+	}
+	vaultData, _, res, code := s.authorize(sessionData.UserID, vaultId, vaultGroupEntity.PermissionManage)
+	if code != fiber.StatusOK {
+		return
+	}
+	if err := s.vaultRepo.Delete(vaultData.ID); err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	s.audit(auditEntity.ActionVaultDelete, sessionData.UserID, vaultData.ID, "", client)
+	res = structs.StdResponse{Message: "DELETED", Data: fmt.Sprintf("vaultId %v has been moved to the trash", vaultId)}
+	code = fiber.StatusOK
+	return
+}
+
+// audit record an event of a user on a vault, the target is the credential or the member the event is about
+func (s *VaultService) audit(
+	action string,
+	userID pgtype.UUID,
+	vaultID pgtype.UUID,
+	targetID string,
+	client structs.StdClient,
+) {
+	recordAudit(s.log, s.auditRepo, client, auditRepo.CreateParam{
+		UserID:     userID,
+		Action:     action,
+		VaultID:    vaultID,
+		TargetID:   targetID,
+		SigningKey: s.auditKey,
+	})
+}
+
+// authenticate retrieve the session of a token, `code` is fiber.StatusOK when the session is valid
+func (s *VaultService) authenticate(token string) (sessionData sessionEntity.Session, res structs.StdResponse, code int) {
+	_, sessionData, res, code = authenticateSession(s.log, s.sessionRepo, token)
+	return
+}
+
+// RotateSession replace the session token once a request succeed, the new token is the `Data` of the response
+func (s *VaultService) RotateSession(token string) (res structs.StdResponse, code int) {
+	return rotateSession(s.log, s.sessionRepo, token)
+}
+
+// authorize retrieve a vault that the user is a member of, `code` is fiber.StatusOK when access is granted.
+// A vault that does not exist or is in the trash result in 404, while a vault of another user or a member
+// whose role is not granted the permission result in 403
+func (s *VaultService) authorize(
+	userID pgtype.UUID,
+	vaultId string,
+	permission vaultGroupEntity.Permission,
+) (vaultData vaultEntity.Vault, member vaultGroupEntity.UserVault, res structs.StdResponse, code int) {
+	return s.authorizeVault(userID, vaultId, permission, false)
+}
+
+// authorizeTrash retrieve a vault in the trash like authorize, a vault out of the trash result in 404
+func (s *VaultService) authorizeTrash(
+	userID pgtype.UUID,
+	vaultId string,
+	permission vaultGroupEntity.Permission,
+) (vaultData vaultEntity.Vault, member vaultGroupEntity.UserVault, res structs.StdResponse, code int) {
+	return s.authorizeVault(userID, vaultId, permission, true)
+}
+
+func (s *VaultService) authorizeVault(
+	userID pgtype.UUID,
+	vaultId string,
+	permission vaultGroupEntity.Permission,
+	trashed bool,
+) (vaultData vaultEntity.Vault, member vaultGroupEntity.UserVault, res structs.StdResponse, code int) {
+	vaultBytes, err := uuid.ParseUUID(vaultId)
 	if err != nil {
 		s.log.Error(err.Error())
 		res = structs.StdResponse{Message: "REQUEST_ERROR", Data: err.Error()}
 		code = fiber.StatusBadRequest
 		return
 	}
-	sessionData, err := s.sessionRepo.GetByID(pgtype.UUID{Bytes: tokenBytes, Valid: true})
+	vaultData, err = s.vaultRepo.GetByID(pgtype.UUID{Bytes: vaultBytes, Valid: true})
+	if err == nil && vaultData.DeletedAt.Valid != trashed {
+		err = consts.ErrNoData
+	}
 	if err != nil {
 		if err.Error() == consts.ErrNoData.Error() {
-			res = structs.StdResponse{Message: "ACCESS_DENIED", Data: err.Error()}
-			code = fiber.StatusUnauthorized
+			res = structs.StdResponse{Message: "NOT_FOUND", Data: err.Error()}
+			code = fiber.StatusNotFound
 		} else {
 			s.log.Error(err.Error())
 			res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
@@ -442,72 +548,289 @@ func (s *VaultService) DeleteCredential(
 		}
 		return
 	}
-	vaultBytes, err := uuid.ParseUUID(vaultId)
+	member, err = s.vaultGroupRepo.GetMember(userID, vaultData.ID)
 	if err != nil {
-		s.log.Error(err.Error())
-		res = structs.StdResponse{Message: "REQUEST_ERROR", Data: err.Error()}
-		code = fiber.StatusBadRequest
+		if err.Error() == consts.ErrNoData.Error() {
+			res = structs.StdResponse{Message: "ACCESS_DENIED", Data: consts.ErrForbidden.Error()}
+			code = fiber.StatusForbidden
+		} else {
+			s.log.Error(err.Error())
+			res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+			code = fiber.StatusInternalServerError
+		}
 		return
 	}
-	vaultUuid := pgtype.UUID{Bytes: vaultBytes, Valid: true}
-	vaultData, err := s.vaultRepo.GetByID(vaultUuid)
-	if err != nil {
-		s.log.Error(err.Error())
-		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
-		code = fiber.StatusInternalServerError
+	if !member.AcceptedAt.Valid {
+		res = structs.StdResponse{Message: "ACCESS_DENIED", Data: "vault invitation has not been accepted"}
+		code = fiber.StatusForbidden
 		return
 	}
-	credentials, err := crypto.DecryptAES(vaultData.Credential, sessionData.SecretKey)
-	if err != nil {
-		res = structs.StdResponse{Message: "ACCESS_DENIED", Data: err.Error()}
-		code = fiber.StatusUnauthorized
+	if !member.Can(permission) {
+		res = structs.StdResponse{Message: "ACCESS_DENIED", Data: fmt.Sprintf("role %v is not allowed for this operation", member.Role)}
+		code = fiber.StatusForbidden
 		return
 	}
-	mapRes, credential, err := s.processJson(nil, sessionData.SecretKey, credentialId, credentials)
-	if err != nil {
-		s.log.Error(err.Error())
-		msg := "PROCESS_ERROR"
-		code = fiber.StatusInternalServerError
-		if err.Error() == consts.ErrCrypto.Error() {
-			msg = "ACCESS_DENIED"
+	code = fiber.StatusOK
+	return
+}
+
+// unlock unwrap the vault key of a member and decrypt the credentials of the vault as a JSON map
+// of the credentials by their ID
+func (s *VaultService) unlock(
+	sessionData sessionEntity.Session,
+	vaultData *vaultEntity.Vault,
+	member vaultGroupEntity.UserVault,
+) (vaultKey []byte, credentials string, res structs.StdResponse, code int) {
+	vaultKey, res, code = s.unlockKey(sessionData, vaultData, member)
+	if code != fiber.StatusOK {
+		return
+	}
+	credentials, res, code = s.loadCredentials(vaultKey, *vaultData)
+	return
+}
+
+// unlockKey unwrap the vault key of a member without decrypting any credential. A vault sealed
+// by an older seal version is sealed again, and a vault still holding its credentials in a single blob
+// is imported, `vaultData` is retrieved again afterward
+func (s *VaultService) unlockKey(
+	sessionData sessionEntity.Session,
+	vaultData *vaultEntity.Vault,
+	member vaultGroupEntity.UserVault,
+) (vaultKey []byte, res structs.StdResponse, code int) {
+	var credentials string
+	var err error
+	if member.VaultKey == "" && member.InviteKey != "" {
+		res = structs.StdResponse{
+			Message: "ACCESS_DENIED",
+			Data:    "vault key has been replaced, the vault must be accepted again using the private key",
+		}
+		code = fiber.StatusForbidden
+		return
+	}
+	if member.VaultKey == "" {
+		vaultKey, credentials, res, code = s.migrateVaultKey(sessionData, *vaultData, member)
+		if code != fiber.StatusOK {
+			return
+		}
+	} else {
+		vaultKey, err = unwrapVaultKey(sessionData, member.VaultKey)
+		if err != nil {
+			res = structs.StdResponse{Message: "ACCESS_DENIED", Data: err.Error()}
 			code = fiber.StatusUnauthorized
+			return
 		}
-		res = structs.StdResponse{Message: msg, Data: err.Error()}
+		if !crypto.IsVersioned(member.VaultKey) {
+			// vault key wrapped before the key encryption key existed, wrap it again
+			wrappedKey, err := wrapVaultKey(sessionData, vaultKey)
+			if err != nil {
+				s.log.Error(err.Error())
+				res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+				code = fiber.StatusInternalServerError
+				return
+			}
+			err = s.vaultGroupRepo.UpdateVaultKey(vaultGroupRepo.UpdateVaultKeyParam{
+				ID:          member.ID,
+				VaultID:     vaultData.ID,
+				VaultKey:    wrappedKey,
+				SealVersion: vaultData.SealVersion,
+			})
+			if err != nil {
+				res, code = s.writeError(err)
+				return
+			}
+		}
+	}
+	if vaultData.SealVersion == 0 {
+		err = s.resealVault(vaultKey, vaultData.ID)
+		if err == nil {
+			*vaultData, err = s.vaultRepo.GetByID(vaultData.ID)
+		}
+		if err != nil {
+			res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+			code = fiber.StatusInternalServerError
+			if err.Error() == consts.ErrIntegrity.Error() {
+				res.Message = "INTEGRITY_ERROR"
+			} else {
+				s.log.Error(err.Error())
+			}
+			return
+		}
+	}
+	if vaultData.Credential == "" {
+		code = fiber.StatusOK
 		return
 	}
-	if err = s.vaultRepo.UpdateCredential(vaultUuid, credential); err != nil {
-		s.log.Error(err.Error())
-		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
-		code = fiber.StatusInternalServerError
+	if member.VaultKey != "" {
+		plainText, err := crypto.OpenEnvelopeStrict(vaultData.Credential, vaultKey, vaultAAD(*vaultData, sealCredentialBlob))
+		if err != nil {
+			// the vault key is valid, so the credential was tampered or copied from another vault
+			s.log.Error(fmt.Sprintf("vault %x: %v", vaultData.ID.Bytes, err))
+			res = structs.StdResponse{Message: "INTEGRITY_ERROR", Data: consts.ErrIntegrity.Error()}
+			code = fiber.StatusInternalServerError
+			return
+		}
+		credentials = string(plainText)
+	}
+	if err = s.importCredentials(vaultKey, *vaultData, credentials); err != nil {
+		res, code = s.writeError(err)
 		return
 	}
-	_, err = s.sessionRepo.Create(sessionRepo.CreateParam{
-		ID:        pgtype.UUID{Bytes: uuid.GenerateUUID().Bytes, Valid: true},
-		UserID:    sessionData.UserID,
-		SecretKey: sessionData.SecretKey,
-	})
-	res = structs.StdResponse{Message: "DELETED", Data: mapRes}
 	code = fiber.StatusOK
 	return
 }
 
-// Delete delete a vault permanently
-func (s *VaultService) Delete(
-	token string,
-	vaultId string,
-) (res structs.StdResponse, code int) {
-	tokenBytes, err := uuid.ParseUUID(token)
+// writeError build the response of a failed write to a vault. A write sealed using a vault key
+// replaced meanwhile is a conflict, the request can be sent again
+func (s *VaultService) writeError(err error) (res structs.StdResponse, code int) {
+	if err.Error() == consts.ErrConflict.Error() {
+		res = structs.StdResponse{Message: "CONFLICT", Data: err.Error()}
+		code = fiber.StatusConflict
+		return
+	}
+	s.log.Error(err.Error())
+	res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+	code = fiber.StatusInternalServerError
+	return
+}
+
+// resealVault seal again every encrypted value of a vault sealed before seal version 1,
+// binding them to their purpose instead of the owner of the vault. consts.ErrIntegrity is returned
+// when a value opens with neither binding
+func (s *VaultService) resealVault(vaultKey []byte, vaultId pgtype.UUID) error {
+	vaultData, err := s.vaultRepo.GetByID(vaultId)
+	if err != nil || vaultData.SealVersion != 0 {
+		return err
+	}
+	reseal := func(value string, purpose string, parts ...string) (sealed vaultRepo.SealedValue, ok bool, err error) {
+		aad := vaultAAD(vaultData, purpose, parts...)
+		if _, err = crypto.OpenEnvelopeStrict(value, vaultKey, aad); err == nil {
+			// sealed by a request that already bind it to its purpose
+			return
+		}
+		// the only place accepting the formats ignoring the associated data, as they are sealed again
+		var plainText []byte
+		ownerAad, err := ownerVaultAAD(vaultData, purpose, parts...)
+		if err == nil {
+			plainText, err = crypto.OpenEnvelope(value, vaultKey, ownerAad)
+		}
+		if err != nil {
+			s.log.Error(fmt.Sprintf("vault %x %s %v: %v", vaultData.ID.Bytes, purpose, parts, err))
+			err = consts.ErrIntegrity
+			return
+		}
+		sealed.Old = value
+		sealed.New, err = crypto.SealEnvelope(crypto.AlgorithmAESGCM, vaultKey, "", plainText, aad)
+		return sealed, err == nil, err
+	}
+
+	arg := vaultRepo.ResealParam{
+		ID:          vaultData.ID,
+		SealVersion: vaultData.SealVersion,
+		Credentials: make(map[pgtype.UUID]vaultRepo.SealedValue),
+		Revisions:   make(map[int32]vaultRepo.SealedValue),
+		Histories:   make(map[int64]vaultRepo.SealedValue),
+	}
+	if vaultData.Credential != "" {
+		if arg.Credential, _, err = reseal(vaultData.Credential, sealCredentialBlob); err != nil {
+			return err
+		}
+	}
+	credentials, err := s.credentialRepo.GetAllByVaultID(vaultData.ID)
+	if err != nil {
+		return err
+	}
+	for _, item := range credentials {
+		sealed, ok, err := reseal(item.Data, sealCredential, fmt.Sprintf("%x", item.ID.Bytes))
+		if err != nil {
+			return err
+		}
+		if ok {
+			arg.Credentials[item.ID] = sealed
+		}
+	}
+	revisions, err := s.vaultRepo.GetAllRevision(vaultData.ID)
+	if err != nil {
+		return err
+	}
+	for _, item := range revisions {
+		var parts []string
+		if item.CredentialID.Valid {
+			parts = append(parts, fmt.Sprintf("%x", item.CredentialID.Bytes))
+		}
+		sealed, ok, err := reseal(item.Credential, sealRevision, parts...)
+		if err != nil {
+			return err
+		}
+		if ok {
+			arg.Revisions[item.Revision] = sealed
+		}
+	}
+	histories, err := s.credentialHistoryRepo.GetAllByVaultID(vaultData.ID)
+	if err != nil {
+		return err
+	}
+	for _, item := range histories {
+		sealed, ok, err := reseal(
+			item.Password,
+			credentialHistoryPurpose(item.Field, item.Custom),
+			item.CredentialID,
+			item.Field,
+		)
+		if err != nil {
+			return err
+		}
+		if ok {
+			arg.Histories[item.ID] = sealed
+		}
+	}
+	return s.vaultRepo.Reseal(arg)
+}
+
+// loadCredentials decrypt every credential row of a vault into a JSON map of the credentials by their ID
+func (s *VaultService) loadCredentials(
+	vaultKey []byte,
+	vaultData vaultEntity.Vault,
+) (credentials string, res structs.StdResponse, code int) {
+	rows, err := s.credentialRepo.GetAllByVaultID(vaultData.ID)
 	if err != nil {
 		s.log.Error(err.Error())
-		res = structs.StdResponse{Message: "REQUEST_ERROR", Data: err.Error()}
-		code = fiber.StatusBadRequest
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	mapRes := make(map[string]json.RawMessage)
+	for _, item := range rows {
+		var plainText []byte
+		plainText, res, code = s.openCredential(vaultKey, vaultData, item)
+		if code != fiber.StatusOK {
+			return
+		}
+		mapRes[fmt.Sprintf("%x", item.ID.Bytes)] = plainText
+	}
+	encoded, err := json.Marshal(mapRes)
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
 		return
 	}
-	_, err = s.sessionRepo.GetByID(pgtype.UUID{Bytes: tokenBytes, Valid: true})
+	credentials = string(encoded)
+	code = fiber.StatusOK
+	return
+}
+
+// loadCredential decrypt a single credential row of a vault into the JSON of its fields,
+// the other credentials are left encrypted
+func (s *VaultService) loadCredential(
+	vaultKey []byte,
+	vaultData vaultEntity.Vault,
+	id pgtype.UUID,
+) (item credentialEntity.Credential, plainText []byte, res structs.StdResponse, code int) {
+	item, err := s.credentialRepo.GetByID(vaultData.ID, id)
 	if err != nil {
 		if err.Error() == consts.ErrNoData.Error() {
-			res = structs.StdResponse{Message: "ACCESS_DENIED", Data: err.Error()}
-			code = fiber.StatusUnauthorized
+			res = structs.StdResponse{Message: "NOT_FOUND", Data: "credential does not exist"}
+			code = fiber.StatusNotFound
 		} else {
 			s.log.Error(err.Error())
 			res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
@@ -515,64 +838,274 @@ func (s *VaultService) Delete(
 		}
 		return
 	}
-	vaultBytes, err := uuid.ParseUUID(vaultId)
+	plainText, res, code = s.openCredential(vaultKey, vaultData, item)
+	return
+}
+
+// openCredential decrypt a credential row into the JSON of its fields
+func (s *VaultService) openCredential(
+	vaultKey []byte,
+	vaultData vaultEntity.Vault,
+	item credentialEntity.Credential,
+) (plainText []byte, res structs.StdResponse, code int) {
+	credentialId := fmt.Sprintf("%x", item.ID.Bytes)
+	plainText, err := crypto.OpenEnvelopeStrict(item.Data, vaultKey, credentialAAD(vaultData, credentialId))
+	if err != nil {
+		// the vault key is valid, so the credential was tampered or copied from another row
+		s.log.Error(fmt.Sprintf("vault %x credential %s: %v", vaultData.ID.Bytes, credentialId, err))
+		res = structs.StdResponse{Message: "INTEGRITY_ERROR", Data: consts.ErrIntegrity.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	code = fiber.StatusOK
+	return
+}
+
+// importCredentials move the credentials of a vault blob into their own rows, each encrypted on its own.
+// The blob is kept as a revision, so the import can be undone
+func (s *VaultService) importCredentials(vaultKey []byte, vaultData vaultEntity.Vault, credentials string) error {
+	rows, err := s.sealCredentials(vaultKey, vaultData, credentials)
+	if err != nil {
+		return err
+	}
+	revision, err := s.revisionOf(vaultKey, vaultData, pgtype.UUID{}, []byte(credentials))
+	if err != nil {
+		return err
+	}
+	return s.credentialRepo.Import(vaultData.ID, vaultData.SealVersion, revision, rows)
+}
+
+// sealCredentials encrypt each credential of a JSON map of the credentials by their ID into a row.
+// A credential whose ID is not a UUID, which only a blob may hold, is given a new ID
+func (s *VaultService) sealCredentials(
+	vaultKey []byte,
+	vaultData vaultEntity.Vault,
+	credentials string,
+) (rows []credentialRepo.UpsertParam, err error) {
+	mapRes := make(map[string]json.RawMessage)
+	if err = json.Unmarshal([]byte(credentials), &mapRes); err != nil {
+		return
+	}
+	for credentialId, value := range mapRes {
+		id := uuid.GenerateUUID()
+		if idBytes, err := uuid.ParseUUID(credentialId); err == nil {
+			id = pgtype.UUID{Bytes: idBytes, Valid: true}
+		}
+		credentialId = fmt.Sprintf("%x", id.Bytes)
+		data, err := crypto.SealEnvelope(crypto.AlgorithmAESGCM, vaultKey, "", value, credentialAAD(vaultData, credentialId))
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, credentialRepo.UpsertParam{ID: id, VaultID: vaultData.ID, Data: data})
+	}
+	return
+}
+
+// absentCredential is the previous version of a credential that did not exist yet
+const absentCredential = "null"
+
+// revisionOf seal the JSON of a credential before it is replaced as a revision, or absentCredential
+// when the credential is created. An invalid `credentialId` keeps the JSON map of every credential by their ID
+func (s *VaultService) revisionOf(
+	vaultKey []byte,
+	vaultData vaultEntity.Vault,
+	credentialId pgtype.UUID,
+	previous []byte,
+) (string, error) {
+	return crypto.SealEnvelope(crypto.AlgorithmAESGCM, vaultKey, "", previous, revisionAAD(vaultData, credentialId))
+}
+
+// revisionAAD bind a revision to its vault, and to its credential when it holds a single one
+func revisionAAD(vaultData vaultEntity.Vault, credentialId pgtype.UUID) []byte {
+	if !credentialId.Valid {
+		return vaultAAD(vaultData, sealRevision)
+	}
+	return vaultAAD(vaultData, sealRevision, fmt.Sprintf("%x", credentialId.Bytes))
+}
+
+// migrateVaultKey handle a vault created before vault keys existed, which is encrypted
+// using the session secret. The vault is re-encrypted using a new random vault key
+func (s *VaultService) migrateVaultKey(
+	sessionData sessionEntity.Session,
+	vaultData vaultEntity.Vault,
+	member vaultGroupEntity.UserVault,
+) (vaultKey []byte, credentials string, res structs.StdResponse, code int) {
+	credentials, err := crypto.DecryptAES(vaultData.Credential, sessionData.SecretKey)
+	if err != nil {
+		res = structs.StdResponse{Message: "ACCESS_DENIED", Data: err.Error()}
+		code = fiber.StatusUnauthorized
+		return
+	}
+	vaultKey, err = crypto.GenerateRandomKey(32)
 	if err != nil {
 		s.log.Error(err.Error())
-		res = structs.StdResponse{Message: "REQUEST_ERROR", Data: err.Error()}
-		code = fiber.StatusBadRequest
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	wrappedKey, err := wrapVaultKey(sessionData, vaultKey)
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
 		return
 	}
-	if err = s.vaultRepo.PermanentDelete(pgtype.UUID{Bytes: vaultBytes, Valid: true}); err != nil {
+	credential, err := crypto.SealEnvelope(
+		crypto.AlgorithmAESGCM,
+		vaultKey,
+		"",
+		[]byte(credentials),
+		vaultAAD(vaultData, sealCredentialBlob),
+	)
+	if err != nil {
 		s.log.Error(err.Error())
 		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
 		code = fiber.StatusInternalServerError
 		return
 	}
-	res = structs.StdResponse{Message: "DELETED", Data: fmt.Sprintf("vaultId %v has been deleted", vaultId)}
+	err = s.vaultGroupRepo.UpdateVaultKey(vaultGroupRepo.UpdateVaultKeyParam{
+		ID:          member.ID,
+		VaultID:     vaultData.ID,
+		VaultKey:    wrappedKey,
+		Credential:  credential,
+		SealVersion: vaultData.SealVersion,
+	})
+	if err != nil {
+		res, code = s.writeError(err)
+		return
+	}
 	code = fiber.StatusOK
 	return
 }
 
-// processJson restructure the JSON and append/update new credential value,
-// and encrypt the credential. pass nil to `credential` to delete a field
-func (s *VaultService) processJson(
-	credential interface{},
-	cipher,
-	credentialId string,
-	existingCredential ...string,
-) (encoded string, res string, err error) {
-	// restructure using named JSON
-	mapRes := make(map[string]interface{})
-	if len(existingCredential) > 0 {
-		err = json.Unmarshal([]byte(existingCredential[0]), &mapRes)
+// keyEncryptionKey derive the 256-bit key that wrap every vault key of a user from the session secret
+func keyEncryptionKey(sessionData sessionEntity.Session) ([]byte, error) {
+	return crypto.DeriveKeyHKDF([]byte(sessionData.SecretKey), sessionData.UserID.Bytes[:], "pasuwado-vault-key-encryption", 32)
+}
+
+// wrapVaultKey encrypt a vault key using the key encryption key of the user
+func wrapVaultKey(sessionData sessionEntity.Session, vaultKey []byte) (string, error) {
+	kek, err := keyEncryptionKey(sessionData)
+	if err != nil {
+		return "", err
+	}
+	return crypto.Seal(vaultKey, kek)
+}
+
+// unwrapVaultKey decrypt a vault key, including the one wrapped directly by the session secret
+func unwrapVaultKey(sessionData sessionEntity.Session, wrappedKey string) ([]byte, error) {
+	if !crypto.IsVersioned(wrappedKey) {
+		keyHex, err := crypto.DecryptAES(wrappedKey, sessionData.SecretKey)
 		if err != nil {
-			return
+			return nil, err
 		}
+		return hex.DecodeString(keyHex)
 	}
-	paramJson, err := json.Marshal(credential)
+	kek, err := keyEncryptionKey(sessionData)
 	if err != nil {
-		return
+		return nil, err
 	}
-	var mapJson map[string]interface{}
-	if err = json.Unmarshal(paramJson, &mapJson); err != nil {
-		return
+	return crypto.Open(wrappedKey, kek)
+}
+
+// the purposes of the encrypted values of a vault, each value is bound to its purpose by vaultAAD
+const (
+	sealCredentialBlob = "blob"
+	sealCredential     = "credential"
+	sealRevision       = "revision"
+	sealHistory        = "history"
+	sealItemHistory    = "item-history"
+	sealCustomHistory  = "custom-history"
+)
+
+// vaultAAD bind an encrypted value to its vault, its purpose and the row it is stored in through `parts`,
+// so a value copied to another vault, row or purpose is rejected on decryption.
+// Only the last part may contain a NUL byte
+func vaultAAD(vaultData vaultEntity.Vault, purpose string, parts ...string) []byte {
+	aad := append([]byte{}, vaultData.ID.Bytes[:]...)
+	aad = append(aad, purpose...)
+	for _, part := range parts {
+		aad = append(aad, 0)
+		aad = append(aad, part...)
 	}
-	if credential != nil {
-		mapRes[credentialId] = mapJson
-	} else {
-		delete(mapRes, credentialId)
+	return aad
+}
+
+// ownerVaultAAD is the binding of the values sealed before seal version 1, which bound them to the owner
+// of the vault instead of their purpose. Only used to seal them again, an error is returned for the purposes
+// that were not sealed before seal version 1 or when a part of the binding is missing
+func ownerVaultAAD(vaultData vaultEntity.Vault, purpose string, parts ...string) ([]byte, error) {
+	aad := append([]byte{}, vaultData.ID.Bytes[:]...)
+	aad = append(aad, vaultData.SealedOwnerID.Bytes[:]...)
+	switch purpose {
+	case sealCredentialBlob:
+		return aad, nil
+	case sealCredential:
+		if len(parts) < 1 {
+			break
+		}
+		return append(aad, "credential:"+parts[0]...), nil
+	case sealRevision:
+		if len(parts) < 1 {
+			return aad, nil
+		}
+		// a revision holding a single credential
+		return append(aad, "revision:"+parts[0]...), nil
+	case sealHistory, sealCustomHistory:
+		if len(parts) < 2 {
+			break
+		}
+		aad = append(aad, parts[0]...)
+		if parts[1] != "" {
+			aad = append(aad, "field:"+parts[1]...)
+		}
+		return aad, nil
+	default:
+		return nil, fmt.Errorf("no binding before seal version 1 for %q", purpose)
 	}
-	paramJson, err = json.Marshal(mapRes)
+	return nil, fmt.Errorf("missing part of the binding of %q", purpose)
+}
+
+// encryptCredential encrypt the fields of a credential alone, bound to its vault and ID
+func (s *VaultService) encryptCredential(
+	credential vaultDto.Credential,
+	vaultKey []byte,
+	vaultData vaultEntity.Vault,
+	credentialId string,
+) (res string, err error) {
+	paramJson, err := json.Marshal(credential)
 	if err != nil {
 		return
 	}
-	encoded = base64.StdEncoding.EncodeToString(paramJson)
-
-	// encrypt the credentials
-	res, err = crypto.EncryptAES(string(paramJson), cipher)
+	res, err = crypto.SealEnvelope(crypto.AlgorithmAESGCM, vaultKey, "", paramJson, credentialAAD(vaultData, credentialId))
 	if err != nil {
 		s.log.Error(err.Error())
 		err = consts.ErrCrypto
 	}
 	return
 }
+
+// credentialAAD bind a credential row to its vault and ID
+func credentialAAD(vaultData vaultEntity.Vault, credentialId string) []byte {
+	return vaultAAD(vaultData, sealCredential, credentialId)
+}
+
+// parseCredentialId parse a credential ID of a path, returning its canonical form as well.
+// `code` is fiber.StatusOK when the ID is valid
+func parseCredentialId(credentialId string) (
+	id pgtype.UUID,
+	canonical string,
+	res structs.StdResponse,
+	code int,
+) {
+	idBytes, err := uuid.ParseUUID(credentialId)
+	if err != nil {
+		res = structs.StdResponse{Message: "REQUEST_ERROR", Data: err.Error()}
+		code = fiber.StatusBadRequest
+		return
+	}
+	id = pgtype.UUID{Bytes: idBytes, Valid: true}
+	canonical = fmt.Sprintf("%x", idBytes)
+	code = fiber.StatusOK
+	return
+}
@@ -0,0 +1,157 @@
+package service
+
+import (
+	"fmt"
+	vaultDto "github.com/Novando/pintartek/internal/passvault-service/app/dto/vault"
+	auditRepo "github.com/Novando/pintartek/internal/passvault-service/domain/audit/repository"
+	sessionEntity "github.com/Novando/pintartek/internal/passvault-service/domain/session/entity"
+	sessionRepo "github.com/Novando/pintartek/internal/passvault-service/domain/session/repository"
+	vaultGroupEntity "github.com/Novando/pintartek/internal/passvault-service/domain/vault-group/entity"
+	vaultGroupRepo "github.com/Novando/pintartek/internal/passvault-service/domain/vault-group/repository"
+	vaultEntity "github.com/Novando/pintartek/internal/passvault-service/domain/vault/entity"
+	vaultRepo "github.com/Novando/pintartek/internal/passvault-service/domain/vault/repository"
+	"github.com/Novando/pintartek/pkg/common/consts"
+	"github.com/Novando/pintartek/pkg/common/structs"
+	"github.com/Novando/pintartek/pkg/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type testVaultService struct {
+	serv           *VaultService
+	vaultMock      *vaultRepo.VaultMock
+	vaultGroupMock *vaultGroupRepo.VaultGroupMock
+	sessionMock    *sessionRepo.SessionMock
+	token          string
+	sessionData    sessionEntity.Session
+}
+
+// initTestVaultService build a vault service whose `token` is an authenticated session of `sessionData`
+func initTestVaultService(t *testing.T) testVaultService {
+	mv := vaultRepo.NewMockVaultRepository(t)
+	mvg := vaultGroupRepo.NewMockVaultGroupRepository(t)
+	ms := sessionRepo.NewMockSessionRepository(t)
+	ma := auditRepo.NewMockAuditRepository(t)
+	ma.Mock.On("Create", mock.Anything).Return(nil).Maybe()
+	sessionUuid := uuid.GenerateUUID()
+	sessionData := sessionEntity.Session{ID: sessionUuid, UserID: uuid.GenerateUUID(), FamilyID: sessionUuid}
+	ms.Mock.On("GetByID", sessionUuid).Return(sessionData, nil).Maybe()
+	return testVaultService{
+		serv:           NewVaultService(WithVaultMock(mv, mvg, ms, ma)),
+		vaultMock:      mv,
+		vaultGroupMock: mvg,
+		sessionMock:    ms,
+		token:          fmt.Sprintf("%x", sessionUuid.Bytes),
+		sessionData:    sessionData,
+	}
+}
+
+// newTestVault build a vault that is out of the trash
+func newTestVault() vaultEntity.Vault {
+	return vaultEntity.Vault{ID: uuid.GenerateUUID(), Name: "test", SealVersion: 1}
+}
+
+// newTestMember build an accepted membership of the session user
+func newTestMember(ts testVaultService, vaultData vaultEntity.Vault, role string) vaultGroupEntity.UserVault {
+	return vaultGroupEntity.UserVault{
+		ID:         1,
+		UserID:     ts.sessionData.UserID,
+		VaultID:    vaultData.ID,
+		AcceptedAt: pgtype.Timestamptz{Time: time.Now(), Valid: true},
+		Role:       role,
+	}
+}
+
+func TestVaultService_GetOne_NoVault(t *testing.T) {
+	ts := initTestVaultService(t)
+	vaultData := newTestVault()
+
+	ts.vaultMock.Mock.On("GetByID", vaultData.ID).Return(vaultEntity.Vault{}, consts.ErrNoData)
+
+	res, code := ts.serv.GetOne(ts.token, fmt.Sprintf("%x", vaultData.ID.Bytes), structs.StdClient{})
+	assert.Equal(t, "NOT_FOUND", res.Message)
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestVaultService_GetOne_TrashedVault(t *testing.T) {
+	ts := initTestVaultService(t)
+	vaultData := newTestVault()
+	vaultData.DeletedAt = pgtype.Timestamptz{Time: time.Now(), Valid: true}
+
+	ts.vaultMock.Mock.On("GetByID", vaultData.ID).Return(vaultData, nil)
+
+	res, code := ts.serv.GetOne(ts.token, fmt.Sprintf("%x", vaultData.ID.Bytes), structs.StdClient{})
+	assert.Equal(t, "NOT_FOUND", res.Message)
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestVaultService_GetOne_NotMember(t *testing.T) {
+	ts := initTestVaultService(t)
+	vaultData := newTestVault()
+
+	ts.vaultMock.Mock.On("GetByID", vaultData.ID).Return(vaultData, nil)
+	ts.vaultGroupMock.Mock.On("GetMember", ts.sessionData.UserID, vaultData.ID).
+		Return(vaultGroupEntity.UserVault{}, consts.ErrNoData)
+
+	res, code := ts.serv.GetOne(ts.token, fmt.Sprintf("%x", vaultData.ID.Bytes), structs.StdClient{})
+	assert.Equal(t, "ACCESS_DENIED", res.Message)
+	assert.Equal(t, http.StatusForbidden, code)
+}
+
+func TestVaultService_GetOne_InvitationNotAccepted(t *testing.T) {
+	ts := initTestVaultService(t)
+	vaultData := newTestVault()
+	member := newTestMember(ts, vaultData, vaultGroupEntity.RoleOwner)
+	member.AcceptedAt = pgtype.Timestamptz{}
+
+	ts.vaultMock.Mock.On("GetByID", vaultData.ID).Return(vaultData, nil)
+	ts.vaultGroupMock.Mock.On("GetMember", ts.sessionData.UserID, vaultData.ID).Return(member, nil)
+
+	res, code := ts.serv.GetOne(ts.token, fmt.Sprintf("%x", vaultData.ID.Bytes), structs.StdClient{})
+	assert.Equal(t, "ACCESS_DENIED", res.Message)
+	assert.Equal(t, http.StatusForbidden, code)
+}
+
+func TestCredentialHistoryAAD_Distinct(t *testing.T) {
+	vaultData := newTestVault()
+	credentialId := fmt.Sprintf("%x", uuid.GenerateUUID().Bytes)
+	password := credentialHistoryAAD(vaultData, credentialId, "", false)
+	item := credentialHistoryAAD(vaultData, credentialId, "password", false)
+	custom := credentialHistoryAAD(vaultData, credentialId, "password", true)
+	emptyCustom := credentialHistoryAAD(vaultData, credentialId, "", true)
+	assert.NotEqual(t, password, emptyCustom)
+	assert.NotEqual(t, item, custom)
+}
+
+func TestCheckCustomFields_BlankLabel(t *testing.T) {
+	assert.Error(t, checkCustomFields([]vaultDto.CustomField{{Label: " ", Type: vaultDto.CustomFieldHidden}}))
+	assert.NoError(t, checkCustomFields([]vaultDto.CustomField{{Label: "PIN", Type: vaultDto.CustomFieldHidden}}))
+}
+
+func TestOwnerVaultAAD_Purposes(t *testing.T) {
+	vaultData := newTestVault()
+	tests := []struct {
+		purpose string
+		parts   []string
+		wantErr bool
+	}{
+		{purpose: sealCredentialBlob},
+		{purpose: sealRevision},
+		{purpose: sealRevision, parts: []string{"id"}},
+		{purpose: sealCredential, parts: []string{"id"}},
+		{purpose: sealCredential, wantErr: true},
+		{purpose: sealHistory, parts: []string{"id", ""}},
+		{purpose: sealHistory, parts: []string{"id"}, wantErr: true},
+		{purpose: sealCustomHistory, parts: []string{"id", "PIN"}},
+		{purpose: sealItemHistory, parts: []string{"id", "password"}, wantErr: true},
+		{purpose: "unknown", wantErr: true},
+	}
+	for _, tt := range tests {
+		_, err := ownerVaultAAD(vaultData, tt.purpose, tt.parts...)
+		assert.Equal(t, tt.wantErr, err != nil, "%s %v", tt.purpose, tt.parts)
+	}
+}
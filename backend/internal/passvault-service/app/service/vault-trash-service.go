@@ -0,0 +1,93 @@
+package service
+
+import (
+	"fmt"
+	vaultDto "github.com/Novando/pintartek/internal/passvault-service/app/dto/vault"
+	auditEntity "github.com/Novando/pintartek/internal/passvault-service/domain/audit/entity"
+	vaultGroupEntity "github.com/Novando/pintartek/internal/passvault-service/domain/vault-group/entity"
+	"github.com/Novando/pintartek/pkg/common/structs"
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetAllTrash return the vaults in the trash that current user is a member of
+func (s *VaultService) GetAllTrash(token string) (res structs.StdResponse, code int) {
+	sessionData, res, code := s.authenticate(token)
+	if code != fiber.StatusOK {
+		return
+	}
+	vaultData, err := s.vaultGroupRepo.GetAllDeletedVaultByUserID(sessionData.UserID)
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	dto := []vaultDto.TrashResponse{}
+	for _, item := range vaultData {
+		dto = append(dto, vaultDto.TrashResponse{
+			ID:        fmt.Sprintf("%x", item.ID.Bytes),
+			Name:      item.Name,
+			Role:      item.Role,
+			DeletedAt: item.DeletedAt.Time,
+			PurgeAt:   item.DeletedAt.Time.Add(s.trashRetention),
+		})
+	}
+	res = structs.StdResponse{Message: "FETCHED", Data: dto, Count: int64(len(dto))}
+	code = fiber.StatusOK
+	return
+}
+
+// RestoreTrash take a vault out of the trash
+func (s *VaultService) RestoreTrash(token, vaultId string, client structs.StdClient) (res structs.StdResponse, code int) {
+	sessionData, res, code := s.authenticate(token)
+	if code != fiber.StatusOK {
+		return
+	}
+	vaultData, _, res, code := s.authorizeTrash(sessionData.UserID, vaultId, vaultGroupEntity.PermissionManage)
+	if code != fiber.StatusOK {
+		return
+	}
+	if err := s.vaultRepo.Restore(vaultData.ID); err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	s.audit(auditEntity.ActionVaultTrashRestore, sessionData.UserID, vaultData.ID, "", client)
+	res = structs.StdResponse{Message: "UPDATED", Data: fmt.Sprintf("vaultId %v has been restored", vaultId)}
+	code = fiber.StatusOK
+	return
+}
+
+// EmptyTrash permanently delete the vaults in the trash that current user is allowed to manage,
+// the vaults managed by another member are left in the trash
+func (s *VaultService) EmptyTrash(token string, client structs.StdClient) (res structs.StdResponse, code int) {
+	sessionData, res, code := s.authenticate(token)
+	if code != fiber.StatusOK {
+		return
+	}
+	vaultData, err := s.vaultGroupRepo.GetAllDeletedVaultByUserID(sessionData.UserID)
+	if err != nil {
+		s.log.Error(err.Error())
+		res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+		code = fiber.StatusInternalServerError
+		return
+	}
+	var count int64
+	for _, item := range vaultData {
+		if !(vaultGroupEntity.UserVault{Role: item.Role}).Can(vaultGroupEntity.PermissionManage) {
+			continue
+		}
+		if err = s.vaultRepo.PermanentDelete(item.ID); err != nil {
+			s.log.Error(err.Error())
+			res = structs.StdResponse{Message: "PROCESS_ERROR", Data: err.Error()}
+			code = fiber.StatusInternalServerError
+			return
+		}
+		s.audit(auditEntity.ActionVaultPurge, sessionData.UserID, item.ID, "", client)
+		count++
+	}
+	res = structs.StdResponse{Message: "DELETED", Data: fmt.Sprintf("%d vaults have been deleted permanently", count), Count: count}
+	code = fiber.StatusOK
+	return
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"fmt"
+	vaultGroupEntity "github.com/Novando/pintartek/internal/passvault-service/domain/vault-group/entity"
+	vaultEntity "github.com/Novando/pintartek/internal/passvault-service/domain/vault/entity"
+	"github.com/Novando/pintartek/pkg/common/structs"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// newTestTrashedVault build a vault that is in the trash
+func newTestTrashedVault() vaultEntity.Vault {
+	vaultData := newTestVault()
+	vaultData.DeletedAt = pgtype.Timestamptz{Time: time.Now(), Valid: true}
+	return vaultData
+}
+
+func TestVaultService_RestoreTrash_Success(t *testing.T) {
+	ts := initTestVaultService(t)
+	vaultData := newTestTrashedVault()
+
+	ts.vaultMock.Mock.On("GetByID", vaultData.ID).Return(vaultData, nil)
+	ts.vaultGroupMock.Mock.On("GetMember", ts.sessionData.UserID, vaultData.ID).
+		Return(newTestMember(ts, vaultData, vaultGroupEntity.RoleOwner), nil)
+	ts.vaultMock.Mock.On("Restore", vaultData.ID).Return(nil)
+
+	res, code := ts.serv.RestoreTrash(ts.token, fmt.Sprintf("%x", vaultData.ID.Bytes), structs.StdClient{})
+	assert.Equal(t, "UPDATED", res.Message)
+	assert.Equal(t, http.StatusOK, code)
+}
+
+func TestVaultService_RestoreTrash_NotTrashed(t *testing.T) {
+	ts := initTestVaultService(t)
+	vaultData := newTestVault()
+
+	ts.vaultMock.Mock.On("GetByID", vaultData.ID).Return(vaultData, nil)
+
+	res, code := ts.serv.RestoreTrash(ts.token, fmt.Sprintf("%x", vaultData.ID.Bytes), structs.StdClient{})
+	assert.Equal(t, "NOT_FOUND", res.Message)
+	assert.Equal(t, http.StatusNotFound, code)
+	ts.vaultMock.Mock.AssertNotCalled(t, "Restore", vaultData.ID)
+}
+
+func TestVaultService_RestoreTrash_Editor(t *testing.T) {
+	ts := initTestVaultService(t)
+	vaultData := newTestTrashedVault()
+
+	ts.vaultMock.Mock.On("GetByID", vaultData.ID).Return(vaultData, nil)
+	ts.vaultGroupMock.Mock.On("GetMember", ts.sessionData.UserID, vaultData.ID).
+		Return(newTestMember(ts, vaultData, vaultGroupEntity.RoleEditor), nil)
+
+	res, code := ts.serv.RestoreTrash(ts.token, fmt.Sprintf("%x", vaultData.ID.Bytes), structs.StdClient{})
+	assert.Equal(t, "ACCESS_DENIED", res.Message)
+	assert.Equal(t, http.StatusForbidden, code)
+	ts.vaultMock.Mock.AssertNotCalled(t, "Restore", vaultData.ID)
+}
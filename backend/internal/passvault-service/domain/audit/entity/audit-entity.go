@@ -0,0 +1,96 @@
+package entity
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"github.com/Novando/pintartek/pkg/crypto"
+	"github.com/jackc/pgx/v5/pgtype"
+	"strconv"
+)
+
+const (
+	ActionRegister              = "user.register"
+	ActionLoginSuccess          = "login.success"
+	ActionLoginFailure          = "login.failure"
+	ActionLoginMfaSuccess       = "login.mfa.success"
+	ActionLoginMfaFailure       = "login.mfa.failure"
+	ActionLogout                = "session.logout"
+	ActionSessionRevoke         = "session.revoke"
+	ActionSessionRevokeAll      = "session.revoke_all"
+	ActionPasswordChange        = "user.password_change"
+	ActionRecover               = "user.recover"
+	ActionTotpEnable            = "2fa.totp.enable"
+	ActionTotpDisable           = "2fa.totp.disable"
+	ActionWebauthnRegister      = "2fa.webauthn.register"
+	ActionWebauthnRemove        = "2fa.webauthn.remove"
+	ActionVaultCreate           = "vault.create"
+	ActionVaultRead             = "vault.read"
+	ActionVaultRename           = "vault.rename"
+	ActionVaultDelete           = "vault.delete"
+	ActionVaultRestore          = "vault.restore"
+	ActionVaultTrashRestore     = "vault.trash.restore"
+	ActionVaultPurge            = "vault.purge"
+	ActionCredentialCreate      = "credential.create"
+	ActionCredentialRead        = "credential.read"
+	ActionCredentialUpdate      = "credential.update"
+	ActionCredentialDelete      = "credential.delete"
+	ActionCredentialHistoryRead = "credential.history.read"
+	ActionCredentialRestore     = "credential.restore"
+	ActionMemberInvite          = "member.invite"
+	ActionMemberAccept          = "member.accept"
+	ActionMemberRoleUpdate      = "member.role_update"
+	ActionMemberRemove          = "member.remove"
+)
+
+// Event is a security relevant action of a user. A failed login of an unknown email has neither a user
+// nor a target, the email is not kept as the events could never be erased.
+// A sealed event carry the hash of the event before it and is signed, the events recorded
+// before the chain was introduced are not sealed
+type Event struct {
+	ID        int64
+	UserID    pgtype.UUID
+	Action    string
+	VaultID   pgtype.UUID
+	TargetID  string
+	IP        string
+	UserAgent string
+	CreatedAt pgtype.Timestamptz
+	PrevHash  []byte
+	Hash      []byte
+	Signature []byte
+	KeyID     string
+}
+
+// Sealed tell whether the event is part of the hash chain
+func (e Event) Sealed() bool {
+	return e.Hash != nil
+}
+
+// Digest hash every field of the event but its seal together with the hash of the previous event
+func (e Event) Digest() []byte {
+	return crypto.ChainHash(e.PrevHash,
+		strconv.FormatInt(e.ID, 10),
+		uuidHex(e.UserID),
+		e.Action,
+		uuidHex(e.VaultID),
+		e.TargetID,
+		e.IP,
+		e.UserAgent,
+		strconv.FormatInt(e.CreatedAt.Time.UnixMicro(), 10),
+	)
+}
+
+// Seal chain the event to the hash of the previous event and sign it
+func (e *Event) Seal(prevHash []byte, key ed25519.PrivateKey) {
+	e.PrevHash = prevHash
+	e.Hash = e.Digest()
+	e.Signature = ed25519.Sign(key, e.Hash)
+	e.KeyID = crypto.FingerprintEd25519(key.Public().(ed25519.PublicKey))
+}
+
+func uuidHex(id pgtype.UUID) string {
+	if !id.Valid {
+		return ""
+	}
+	return fmt.Sprintf("%x", id.Bytes)
+}
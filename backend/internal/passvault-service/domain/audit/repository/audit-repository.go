@@ -0,0 +1,25 @@
+package repository
+
+import (
+	"crypto/ed25519"
+	"github.com/Novando/pintartek/internal/passvault-service/domain/audit/entity"
+	"github.com/Novando/pintartek/pkg/common/structs"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+type CreateParam struct {
+	UserID    pgtype.UUID
+	Action    string
+	VaultID   pgtype.UUID
+	TargetID  string
+	IP        string
+	UserAgent string
+	// SigningKey seal the event into the hash chain
+	SigningKey ed25519.PrivateKey
+}
+
+type Audit interface {
+	Create(arg CreateParam) error
+	GetAllByUserID(userID pgtype.UUID, arg structs.StdPagination) ([]entity.Event, error)
+	GetChain(afterID int64, limit int32) ([]entity.Event, error)
+}
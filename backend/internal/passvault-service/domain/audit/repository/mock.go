@@ -0,0 +1,35 @@
+package repository
+
+import (
+	"github.com/Novando/pintartek/internal/passvault-service/domain/audit/entity"
+	"github.com/Novando/pintartek/pkg/common/structs"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/mock"
+	"testing"
+)
+
+type AuditMock struct {
+	Mock mock.Mock
+}
+
+// NewMockAuditRepository Initialize a mocked audit repository, the expectations are asserted once the test end
+func NewMockAuditRepository(t *testing.T) *AuditMock {
+	r := &AuditMock{}
+	r.Mock.Test(t)
+	t.Cleanup(func() { r.Mock.AssertExpectations(t) })
+	return r
+}
+
+func (r *AuditMock) Create(arg CreateParam) error {
+	return r.Mock.Called(arg).Error(0)
+}
+
+func (r *AuditMock) GetAllByUserID(userID pgtype.UUID, arg structs.StdPagination) ([]entity.Event, error) {
+	args := r.Mock.Called(userID, arg)
+	return args.Get(0).([]entity.Event), args.Error(1)
+}
+
+func (r *AuditMock) GetChain(afterID int64, limit int32) ([]entity.Event, error) {
+	args := r.Mock.Called(afterID, limit)
+	return args.Get(0).([]entity.Event), args.Error(1)
+}
@@ -0,0 +1,188 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/Novando/pintartek/internal/passvault-service/domain/audit/entity"
+	"github.com/Novando/pintartek/pkg/common/structs"
+	"github.com/Novando/pintartek/pkg/postgresql/pgx"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+)
+
+type PostgresAudit struct {
+	ctx   context.Context
+	query *pgx.Queries
+	db    *pgxpool.Pool
+}
+
+func NewPostgresAuditRepository(
+	c context.Context,
+	q *pgx.Queries,
+	db *pgxpool.Pool,
+) *PostgresAudit {
+	return &PostgresAudit{
+		ctx:   c,
+		query: q,
+		db:    db,
+	}
+}
+
+const lockChainPostgresAudit = `-- name: Lock the audit chain until the transaction end :exec
+	SELECT pg_advisory_xact_lock(hashtext('audit_events'))
+`
+
+const getLastHashPostgresAudit = `-- name: Get the hash of the last audit event :one
+	SELECT hash FROM audit_events ORDER BY id DESC LIMIT 1
+`
+
+const nextIDPostgresAudit = `-- name: Get the next audit event ID :one
+	SELECT nextval(pg_get_serial_sequence('audit_events', 'id'))
+`
+
+const createPostgresAudit = `-- name: Create audit event :exec
+	INSERT INTO audit_events (
+		id, user_id, action, vault_id, target_id, ip, user_agent, created_at, prev_hash, hash, signature, key_id
+	)
+	VALUES (
+		$1::bigint, $2::uuid, $3::varchar, $4::uuid, $5::varchar, $6::varchar, $7::varchar, $8::timestamptz,
+		$9::bytea, $10::bytea, $11::bytea, $12::varchar
+	)
+`
+
+// Create append a sealed event to the chain. The chain is locked while the event is sealed,
+// so the events are chained in the order of their ID
+func (r *PostgresAudit) Create(arg CreateParam) error {
+	if arg.SigningKey == nil {
+		return errors.New("audit signing key is not configured")
+	}
+	tx, err := r.db.Begin(r.ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(r.ctx)
+	if _, err = tx.Exec(r.ctx, lockChainPostgresAudit); err != nil {
+		return err
+	}
+	var prevHash []byte
+	err = tx.QueryRow(r.ctx, getLastHashPostgresAudit).Scan(&prevHash)
+	if err != nil && err.Error() != pgx.ErrNoRows() {
+		return err
+	}
+	event := entity.Event{
+		UserID:    arg.UserID,
+		Action:    arg.Action,
+		VaultID:   arg.VaultID,
+		TargetID:  arg.TargetID,
+		IP:        arg.IP,
+		UserAgent: arg.UserAgent,
+		// Postgres keep the time up to the microsecond, the digest must survive the round trip
+		CreatedAt: pgtype.Timestamptz{Time: time.Now().Truncate(time.Microsecond), Valid: true},
+	}
+	if err = tx.QueryRow(r.ctx, nextIDPostgresAudit).Scan(&event.ID); err != nil {
+		return err
+	}
+	event.Seal(prevHash, arg.SigningKey)
+	_, err = tx.Exec(r.ctx, createPostgresAudit,
+		event.ID,
+		event.UserID,
+		event.Action,
+		event.VaultID,
+		event.TargetID,
+		event.IP,
+		event.UserAgent,
+		event.CreatedAt,
+		event.PrevHash,
+		event.Hash,
+		event.Signature,
+		event.KeyID,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(r.ctx)
+}
+
+const getAllByUserIDPostgresAudit = `-- name: Get all audit event of a user :many
+	SELECT id, user_id, action, vault_id, target_id, ip, user_agent, created_at
+	FROM audit_events
+	WHERE user_id = $1::uuid
+	ORDER BY id DESC
+	LIMIT $2::int OFFSET $3::int
+`
+
+// GetAllByUserID retrieve the events of a user, the latest first. The page start from 0
+func (r *PostgresAudit) GetAllByUserID(userID pgtype.UUID, arg structs.StdPagination) (data []entity.Event, err error) {
+	rows, err := r.db.Query(r.ctx, getAllByUserIDPostgresAudit,
+		userID,
+		arg.Size,
+		arg.Page*arg.Size,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var i entity.Event
+		if err = rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Action,
+			&i.VaultID,
+			&i.TargetID,
+			&i.IP,
+			&i.UserAgent,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		data = append(data, i)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+const getChainPostgresAudit = `-- name: Get the audit events in the chain order :many
+	SELECT id, user_id, action, vault_id, target_id, ip, user_agent, created_at,
+		prev_hash, hash, signature, COALESCE(key_id, '')
+	FROM audit_events
+	WHERE id > $1::bigint
+	ORDER BY id
+	LIMIT $2::int
+`
+
+// GetChain retrieve the events following `afterID` in the order they are chained
+func (r *PostgresAudit) GetChain(afterID int64, limit int32) (data []entity.Event, err error) {
+	rows, err := r.db.Query(r.ctx, getChainPostgresAudit, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var i entity.Event
+		if err = rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Action,
+			&i.VaultID,
+			&i.TargetID,
+			&i.IP,
+			&i.UserAgent,
+			&i.CreatedAt,
+			&i.PrevHash,
+			&i.Hash,
+			&i.Signature,
+			&i.KeyID,
+		); err != nil {
+			return nil, err
+		}
+		data = append(data, i)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return
+}
@@ -1,13 +1,42 @@
 package repository
 
 import (
+	"github.com/Novando/pintartek/internal/passvault-service/domain/client/entity"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/stretchr/testify/mock"
+	"testing"
 )
 
-type PostgresMock struct {
+type ClientMock struct {
 	Mock mock.Mock
 }
 
-//func (r *PostgresMock) Create(name string, userId pgtype.UUID) (id pgtype.UUID, err error) {
-//	r.Mock.Called()
-//}
+// NewMockClientRepository Initialize a mocked client repository, the expectations are asserted once the test end
+func NewMockClientRepository(t *testing.T) *ClientMock {
+	r := &ClientMock{}
+	r.Mock.Test(t)
+	t.Cleanup(func() { r.Mock.AssertExpectations(t) })
+	return r
+}
+
+func (r *ClientMock) Create(name string, userId pgtype.UUID) (id pgtype.UUID, err error) {
+	args := r.Mock.Called(name, userId)
+	return args.Get(0).(pgtype.UUID), args.Error(1)
+}
+
+func (r *ClientMock) GetByID(id pgtype.UUID) (data entity.Client, err error) {
+	args := r.Mock.Called(id)
+	return args.Get(0).(entity.Client), args.Error(1)
+}
+
+func (r *ClientMock) Update(id pgtype.UUID, name string) error {
+	return r.Mock.Called(id, name).Error(0)
+}
+
+func (r *ClientMock) Delete(id pgtype.UUID) error {
+	return r.Mock.Called(id).Error(0)
+}
+
+func (r *ClientMock) PermanentDelete(id pgtype.UUID) error {
+	return r.Mock.Called(id).Error(0)
+}
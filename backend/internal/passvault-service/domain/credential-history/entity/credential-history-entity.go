@@ -0,0 +1,17 @@
+package entity
+
+import "github.com/jackc/pgx/v5/pgtype"
+
+// CredentialHistory is a password a credential had before it was replaced at CreatedAt,
+// the password is encrypted using the vault key. Field is the key of the secret field of the item type
+// the password belonged to, or the label of the custom field when Custom is set.
+// It is empty for the password of the credential itself
+type CredentialHistory struct {
+	ID           int64
+	VaultID      pgtype.UUID
+	CredentialID string
+	Field        string
+	Custom       bool
+	Password     string
+	CreatedAt    pgtype.Timestamptz
+}
@@ -0,0 +1,27 @@
+package repository
+
+import (
+	"github.com/Novando/pintartek/internal/passvault-service/domain/credential-history/entity"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+type CreateParam struct {
+	VaultID      pgtype.UUID
+	CredentialID string
+	// Field is the key of a secret field of the item type, or the label of a custom field when Custom is set.
+	// It is empty for the password of the credential
+	Field    string
+	Custom   bool
+	Password string
+	// SealVersion is the seal version of the vault Password is sealed at
+	SealVersion int32
+	// Keep is the number of the latest passwords kept for each field of the credential, the older are deleted
+	Keep int32
+}
+
+type CredentialHistory interface {
+	Create(arg CreateParam) error
+	GetByID(id int64) (entity.CredentialHistory, error)
+	GetAllByCredentialID(vaultID pgtype.UUID, credentialID string) ([]entity.CredentialHistory, error)
+	GetAllByVaultID(vaultID pgtype.UUID) ([]entity.CredentialHistory, error)
+}
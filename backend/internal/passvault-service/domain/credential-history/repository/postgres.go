@@ -0,0 +1,188 @@
+package repository
+
+import (
+	"context"
+	"github.com/Novando/pintartek/internal/passvault-service/domain/credential-history/entity"
+	vaultRepo "github.com/Novando/pintartek/internal/passvault-service/domain/vault/repository"
+	"github.com/Novando/pintartek/pkg/common/consts"
+	"github.com/Novando/pintartek/pkg/postgresql/pgx"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type PostgresCredentialHistory struct {
+	ctx   context.Context
+	query *pgx.Queries
+	db    *pgxpool.Pool
+}
+
+func NewPostgresCredentialHistoryRepository(
+	c context.Context,
+	q *pgx.Queries,
+	db *pgxpool.Pool,
+) *PostgresCredentialHistory {
+	return &PostgresCredentialHistory{
+		ctx:   c,
+		query: q,
+		db:    db,
+	}
+}
+
+const createPostgresCredentialHistory = `-- name: Create credential history :exec
+	INSERT INTO credential_histories (vault_id, credential_id, field, custom, password)
+	VALUES ($1::uuid, $2::varchar, $3::varchar, $4::bool, $5::text)
+`
+
+const pruneCredentialHistory = `-- name: Delete the oldest history of a credential field :exec
+	DELETE FROM credential_histories
+	WHERE vault_id = $1::uuid AND credential_id = $2::varchar AND field = $3::varchar AND custom = $4::bool
+		AND id NOT IN (
+			SELECT id FROM credential_histories
+			WHERE vault_id = $1::uuid AND credential_id = $2::varchar AND field = $3::varchar AND custom = $4::bool
+			ORDER BY id DESC
+			LIMIT $5::int
+		)
+`
+
+// Create record a replaced password and delete the passwords of the field older than the kept ones.
+// consts.ErrConflict is returned when the vault is no longer at the seal version
+func (r *PostgresCredentialHistory) Create(arg CreateParam) error {
+	tx, err := r.db.Begin(r.ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(r.ctx)
+	if err = vaultRepo.LockSealVersion(r.ctx, tx, arg.VaultID, arg.SealVersion); err != nil {
+		return err
+	}
+	if err = RecordHistory(r.ctx, tx, arg); err != nil {
+		return err
+	}
+	return tx.Commit(r.ctx)
+}
+
+// RecordHistory record a replaced password and delete the passwords of the field older than the kept ones.
+// It runs within the transaction `tx` replacing the credential, so the password is only kept when the credential
+// is replaced. The seal version of the vault must be locked by `tx`
+func RecordHistory(ctx context.Context, tx pgx.DBTX, arg CreateParam) error {
+	_, err := tx.Exec(
+		ctx,
+		createPostgresCredentialHistory,
+		arg.VaultID,
+		arg.CredentialID,
+		arg.Field,
+		arg.Custom,
+		arg.Password,
+	)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, pruneCredentialHistory, arg.VaultID, arg.CredentialID, arg.Field, arg.Custom, arg.Keep)
+	return err
+}
+
+const getByIDPostgresCredentialHistory = `-- name: Get credential history by the ID :one
+	SELECT id, vault_id, credential_id, field, custom, password, created_at
+	FROM credential_histories
+	WHERE id = $1::bigint
+`
+
+func (r *PostgresCredentialHistory) GetByID(id int64) (data entity.CredentialHistory, err error) {
+	err = r.db.QueryRow(r.ctx, getByIDPostgresCredentialHistory, id).Scan(
+		&data.ID,
+		&data.VaultID,
+		&data.CredentialID,
+		&data.Field,
+		&data.Custom,
+		&data.Password,
+		&data.CreatedAt,
+	)
+	if err != nil && err.Error() == pgx.ErrNoRows() {
+		err = consts.ErrNoData
+	}
+	return
+}
+
+const getAllByCredentialIDPostgresCredentialHistory = `-- name: Get all history of a credential :many
+	SELECT id, vault_id, credential_id, field, custom, password, created_at
+	FROM credential_histories
+	WHERE vault_id = $1::uuid AND credential_id = $2::varchar
+	ORDER BY id DESC
+`
+
+// GetAllByCredentialID retrieve the replaced passwords of a credential, the latest first
+func (r *PostgresCredentialHistory) GetAllByCredentialID(
+	vaultID pgtype.UUID,
+	credentialID string,
+) (data []entity.CredentialHistory, err error) {
+	rows, err := r.db.Query(r.ctx, getAllByCredentialIDPostgresCredentialHistory, vaultID, credentialID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var i entity.CredentialHistory
+		if err = rows.Scan(
+			&i.ID,
+			&i.VaultID,
+			&i.CredentialID,
+			&i.Field,
+			&i.Custom,
+			&i.Password,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		data = append(data, i)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+const getAllByVaultIDPostgresCredentialHistory = `-- name: Get all history of a vault :many
+	SELECT id, vault_id, credential_id, field, custom, password, created_at
+	FROM credential_histories
+	WHERE vault_id = $1::uuid
+	ORDER BY id DESC
+`
+
+// GetAllByVaultID retrieve the replaced passwords of every credential of a vault, the latest first
+func (r *PostgresCredentialHistory) GetAllByVaultID(vaultID pgtype.UUID) (data []entity.CredentialHistory, err error) {
+	rows, err := r.db.Query(r.ctx, getAllByVaultIDPostgresCredentialHistory, vaultID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var i entity.CredentialHistory
+		if err = rows.Scan(
+			&i.ID,
+			&i.VaultID,
+			&i.CredentialID,
+			&i.Field,
+			&i.Custom,
+			&i.Password,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		data = append(data, i)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+const permanentDeleteByCredentialIDPostgresCredentialHistory = `-- name: Permanent delete the history of a credential :exec
+	DELETE FROM credential_histories WHERE vault_id = $1::uuid AND credential_id = $2::varchar
+`
+
+// PermanentDeleteByCredentialID delete the history of a credential. It runs within the transaction `tx`
+// deleting the credential, so the history is never left behind
+func PermanentDeleteByCredentialID(ctx context.Context, tx pgx.DBTX, vaultID pgtype.UUID, credentialID string) error {
+	_, err := tx.Exec(ctx, permanentDeleteByCredentialIDPostgresCredentialHistory, vaultID, credentialID)
+	return err
+}
@@ -2,13 +2,12 @@ package entity
 
 import "github.com/jackc/pgx/v5/pgtype"
 
+// Credential is an entry of a vault. Its fields are encrypted together as JSON in Data using the vault key,
+// bound to the vault and the credential ID
 type Credential struct {
-	OwnerID    pgtype.UUID
-	CreatedAt  pgtype.Timestamptz
-	UpdatedAt  pgtype.Timestamptz
-	Name       string
-	Credential string
-	Password   string
-	Url        string
-	Note       string
+	ID        pgtype.UUID
+	VaultID   pgtype.UUID
+	CreatedAt pgtype.Timestamptz
+	UpdatedAt pgtype.Timestamptz
+	Data      string
 }
@@ -0,0 +1,37 @@
+package repository
+
+import (
+	credentialHistoryRepo "github.com/Novando/pintartek/internal/passvault-service/domain/credential-history/repository"
+	"github.com/Novando/pintartek/internal/passvault-service/domain/credential/entity"
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+type UpsertParam struct {
+	ID      pgtype.UUID
+	VaultID pgtype.UUID
+	Data    string
+	// SealVersion is the seal version of the vault Data is sealed at
+	SealVersion int32
+	// Revision is the replaced credential sealed as a revision, archived within the same transaction when set
+	Revision string
+	// Histories are the replaced passwords of the credential, recorded within the same transaction
+	Histories []credentialHistoryRepo.CreateParam
+}
+
+type DeleteParam struct {
+	ID          pgtype.UUID
+	VaultID     pgtype.UUID
+	SealVersion int32
+	// Revision is the deleted credential sealed as a revision, archived within the same transaction when set
+	Revision string
+}
+
+type Credential interface {
+	Create(arg UpsertParam) error
+	GetAllByVaultID(vaultID pgtype.UUID) ([]entity.Credential, error)
+	GetByID(vaultID pgtype.UUID, id pgtype.UUID) (entity.Credential, error)
+	Update(arg UpsertParam) error
+	PermanentDelete(arg DeleteParam) error
+	ReplaceAll(vaultID pgtype.UUID, sealVersion int32, revision string, args []UpsertParam) error
+	Import(vaultID pgtype.UUID, sealVersion int32, revision string, args []UpsertParam) error
+}
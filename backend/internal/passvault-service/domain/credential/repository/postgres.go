@@ -0,0 +1,274 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	credentialHistoryRepo "github.com/Novando/pintartek/internal/passvault-service/domain/credential-history/repository"
+	"github.com/Novando/pintartek/internal/passvault-service/domain/credential/entity"
+	vaultRepo "github.com/Novando/pintartek/internal/passvault-service/domain/vault/repository"
+	"github.com/Novando/pintartek/pkg/common/consts"
+	"github.com/Novando/pintartek/pkg/postgresql/pgx"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type PostgresCredential struct {
+	ctx   context.Context
+	query *pgx.Queries
+	db    *pgxpool.Pool
+}
+
+func NewPostgresCredentialRepository(
+	c context.Context,
+	q *pgx.Queries,
+	db *pgxpool.Pool,
+) *PostgresCredential {
+	return &PostgresCredential{
+		ctx:   c,
+		query: q,
+		db:    db,
+	}
+}
+
+const createPostgresCredential = `-- name: Create credential :exec
+	INSERT INTO credentials (vault_id, id, data)
+	VALUES ($1::uuid, $2::uuid, $3::text)
+`
+
+// Create add a credential to a vault, consts.ErrConflict is returned when the vault is no longer at the seal version
+func (r *PostgresCredential) Create(arg UpsertParam) error {
+	tx, err := r.db.Begin(r.ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(r.ctx)
+	if err = lockVault(r.ctx, tx, arg.VaultID, arg.ID, arg.SealVersion, arg.Revision); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(r.ctx, createPostgresCredential, arg.VaultID, arg.ID, arg.Data); err != nil {
+		return err
+	}
+	if err = recordHistories(r.ctx, tx, arg.Histories); err != nil {
+		return err
+	}
+	return tx.Commit(r.ctx)
+}
+
+const getAllByVaultIDPostgresCredential = `-- name: Get all credential of a vault :many
+	SELECT vault_id, id, data, created_at, updated_at
+	FROM credentials
+	WHERE vault_id = $1::uuid
+	ORDER BY created_at, id
+`
+
+// GetAllByVaultID retrieve the credentials of a vault, the oldest first
+func (r *PostgresCredential) GetAllByVaultID(vaultID pgtype.UUID) (data []entity.Credential, err error) {
+	rows, err := r.db.Query(r.ctx, getAllByVaultIDPostgresCredential, vaultID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var i entity.Credential
+		if err = rows.Scan(
+			&i.VaultID,
+			&i.ID,
+			&i.Data,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		data = append(data, i)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+const getByIDPostgresCredential = `-- name: Get a credential of a vault :one
+	SELECT vault_id, id, data, created_at, updated_at
+	FROM credentials
+	WHERE vault_id = $1::uuid AND id = $2::uuid
+`
+
+// GetByID retrieve a credential of a vault, consts.ErrNoData is returned when the credential does not exist
+func (r *PostgresCredential) GetByID(vaultID pgtype.UUID, id pgtype.UUID) (data entity.Credential, err error) {
+	err = r.db.QueryRow(r.ctx, getByIDPostgresCredential, vaultID, id).Scan(
+		&data.VaultID,
+		&data.ID,
+		&data.Data,
+		&data.CreatedAt,
+		&data.UpdatedAt,
+	)
+	if err != nil && err.Error() == pgx.ErrNoRows() {
+		err = consts.ErrNoData
+	}
+	return
+}
+
+const updatePostgresCredential = `-- name: Update credential data :execrows
+	UPDATE credentials SET
+		data = $3::text,
+		updated_at = NOW()
+	WHERE vault_id = $1::uuid AND id = $2::uuid
+`
+
+// Update replace the data of a credential, consts.ErrNoData is returned when the credential does not exist
+// and consts.ErrConflict when the vault is no longer at the seal version
+func (r *PostgresCredential) Update(arg UpsertParam) error {
+	tx, err := r.db.Begin(r.ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(r.ctx)
+	if err = lockVault(r.ctx, tx, arg.VaultID, arg.ID, arg.SealVersion, arg.Revision); err != nil {
+		return err
+	}
+	tag, err := tx.Exec(r.ctx, updatePostgresCredential, arg.VaultID, arg.ID, arg.Data)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return consts.ErrNoData
+	}
+	if err = recordHistories(r.ctx, tx, arg.Histories); err != nil {
+		return err
+	}
+	return tx.Commit(r.ctx)
+}
+
+const permanentDeletePostgresCredential = `-- name: Permanent delete a credential :exec
+	DELETE FROM credentials WHERE vault_id = $1::uuid AND id = $2::uuid
+`
+
+// PermanentDelete delete a credential of a vault together with its history, consts.ErrConflict is returned
+// when the vault is no longer at the seal version
+func (r *PostgresCredential) PermanentDelete(arg DeleteParam) error {
+	tx, err := r.db.Begin(r.ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(r.ctx)
+	if err = lockVault(r.ctx, tx, arg.VaultID, arg.ID, arg.SealVersion, arg.Revision); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(r.ctx, permanentDeletePostgresCredential, arg.VaultID, arg.ID); err != nil {
+		return err
+	}
+	credentialID := fmt.Sprintf("%x", arg.ID.Bytes)
+	if err = credentialHistoryRepo.PermanentDeleteByCredentialID(r.ctx, tx, arg.VaultID, credentialID); err != nil {
+		return err
+	}
+	return tx.Commit(r.ctx)
+}
+
+const permanentDeleteByVaultIDPostgresCredential = `-- name: Permanent delete all credential of a vault :exec
+	DELETE FROM credentials WHERE vault_id = $1::uuid
+`
+
+// ReplaceAll replace every credential of a vault at once, the replaced credentials are archived as `revision`
+// when it is set and the histories of each row are recorded. consts.ErrConflict is returned when the vault
+// is no longer at `sealVersion`
+func (r *PostgresCredential) ReplaceAll(vaultID pgtype.UUID, sealVersion int32, revision string, args []UpsertParam) error {
+	tx, err := r.db.Begin(r.ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(r.ctx)
+	if err = lockVault(r.ctx, tx, vaultID, pgtype.UUID{}, sealVersion, revision); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(r.ctx, permanentDeleteByVaultIDPostgresCredential, vaultID); err != nil {
+		return err
+	}
+	for _, arg := range args {
+		if _, err = tx.Exec(r.ctx, createPostgresCredential, vaultID, arg.ID, arg.Data); err != nil {
+			return err
+		}
+		if err = recordHistories(r.ctx, tx, arg.Histories); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(r.ctx)
+}
+
+const lockBlobPostgresCredential = `-- name: Get the credential blob of a vault for update :one
+	SELECT credential FROM vaults WHERE id = $1::uuid FOR UPDATE
+`
+
+const importPostgresCredential = `-- name: Import a credential of a vault blob :exec
+	INSERT INTO credentials (vault_id, id, data)
+	VALUES ($1::uuid, $2::uuid, $3::text)
+	ON CONFLICT (vault_id, id) DO NOTHING
+`
+
+const clearBlobPostgresCredential = `-- name: Clear the credential blob of a vault :exec
+	UPDATE vaults SET credential = '' WHERE id = $1::uuid
+`
+
+// Import move the credentials of a vault blob into their own rows and clear the blob, the blob is archived
+// as `revision`. Nothing is done when a concurrent request already imported the blob, consts.ErrConflict
+// is returned when the vault is no longer at `sealVersion`
+func (r *PostgresCredential) Import(vaultID pgtype.UUID, sealVersion int32, revision string, args []UpsertParam) error {
+	tx, err := r.db.Begin(r.ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(r.ctx)
+	var blob string
+	if err = tx.QueryRow(r.ctx, lockBlobPostgresCredential, vaultID).Scan(&blob); err != nil {
+		if err.Error() == pgx.ErrNoRows() {
+			err = consts.ErrNoData
+		}
+		return err
+	}
+	if blob == "" {
+		return nil
+	}
+	if err = lockVault(r.ctx, tx, vaultID, pgtype.UUID{}, sealVersion, revision); err != nil {
+		return err
+	}
+	for _, arg := range args {
+		if _, err = tx.Exec(r.ctx, importPostgresCredential, vaultID, arg.ID, arg.Data); err != nil {
+			return err
+		}
+	}
+	if _, err = tx.Exec(r.ctx, clearBlobPostgresCredential, vaultID); err != nil {
+		return err
+	}
+	return tx.Commit(r.ctx)
+}
+
+// lockVault lock the seal version of the vault the credentials are written to until the transaction `tx` ends.
+// The credentials of `credentialID` replaced, or of the whole vault when it is invalid, are archived as `revision`
+// when it is set
+func lockVault(
+	ctx context.Context,
+	tx pgx.DBTX,
+	vaultID pgtype.UUID,
+	credentialID pgtype.UUID,
+	sealVersion int32,
+	revision string,
+) error {
+	if revision == "" {
+		return vaultRepo.LockSealVersion(ctx, tx, vaultID, sealVersion)
+	}
+	return vaultRepo.ArchiveRevision(ctx, tx, vaultRepo.CreateRevisionParam{
+		ID:           vaultID,
+		CredentialID: credentialID,
+		SealVersion:  sealVersion,
+		Credential:   revision,
+	})
+}
+
+// recordHistories record the replaced passwords of a credential within the transaction `tx` writing it
+func recordHistories(ctx context.Context, tx pgx.DBTX, args []credentialHistoryRepo.CreateParam) error {
+	for _, arg := range args {
+		if err := credentialHistoryRepo.RecordHistory(ctx, tx, arg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
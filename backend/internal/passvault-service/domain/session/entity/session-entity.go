@@ -2,7 +2,32 @@ package entity
 
 import "github.com/jackc/pgx/v5/pgtype"
 
+const (
+	// ScopeMFA is given to a session that passed the password check but still wait for the second factor,
+	// a scoped session is not allowed to access anything but its own scope
+	ScopeMFA = "mfa"
+	// ScopeRecover is given to a recovery that passed the private key check but still wait for the second factor
+	ScopeRecover = "recover"
+)
+
+// Session is also the payload of the access and backup token,
+// so only the user and the secret are serialized
 type Session struct {
-	UserID    pgtype.UUID `json:"userId"`
-	SecretKey string      `json:"secretKey"`
+	ID         pgtype.UUID        `json:"-"`
+	UserID     pgtype.UUID        `json:"userId"`
+	SecretKey  string             `json:"secretKey"`
+	Scope      string             `json:"scope,omitempty"`
+	IP         string             `json:"-"`
+	UserAgent  string             `json:"-"`
+	Device     string             `json:"-"`
+	CreatedAt  pgtype.Timestamptz `json:"-"`
+	LastSeenAt pgtype.Timestamptz `json:"-"`
+	// FamilyID is shared by a session and every session it was rotated into
+	FamilyID pgtype.UUID `json:"-"`
+	// ReplacedBy is set once the session is rotated, the token stay usable only for a short grace window
+	ReplacedBy pgtype.UUID        `json:"-"`
+	RotatedAt  pgtype.Timestamptz `json:"-"`
+	// PendingSecret is the replacement of a legacy secret sealed on login, a session with ScopeMFA only apply it
+	// once the second factor is verified
+	PendingSecret string `json:"-"`
 }
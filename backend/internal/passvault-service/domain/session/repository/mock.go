@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"github.com/Novando/pintartek/internal/passvault-service/domain/session/entity"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/mock"
+	"testing"
+)
+
+type SessionMock struct {
+	Mock mock.Mock
+}
+
+// NewMockSessionRepository Initialize a mocked session repository, the expectations are asserted once the test end
+func NewMockSessionRepository(t *testing.T) *SessionMock {
+	r := &SessionMock{}
+	r.Mock.Test(t)
+	t.Cleanup(func() { r.Mock.AssertExpectations(t) })
+	return r
+}
+
+func (r *SessionMock) Create(arg CreateParam) (pgtype.UUID, error) {
+	args := r.Mock.Called(arg)
+	return args.Get(0).(pgtype.UUID), args.Error(1)
+}
+
+func (r *SessionMock) GetByID(id pgtype.UUID) (entity.Session, error) {
+	args := r.Mock.Called(id)
+	return args.Get(0).(entity.Session), args.Error(1)
+}
+
+func (r *SessionMock) PeekByID(id pgtype.UUID) (entity.Session, error) {
+	args := r.Mock.Called(id)
+	return args.Get(0).(entity.Session), args.Error(1)
+}
+
+func (r *SessionMock) GetAllByUserID(userID pgtype.UUID) ([]entity.Session, error) {
+	args := r.Mock.Called(userID)
+	return args.Get(0).([]entity.Session), args.Error(1)
+}
+
+func (r *SessionMock) Rotate(id pgtype.UUID, newID pgtype.UUID) (pgtype.UUID, error) {
+	args := r.Mock.Called(id, newID)
+	return args.Get(0).(pgtype.UUID), args.Error(1)
+}
+
+func (r *SessionMock) PermanentDelete(id pgtype.UUID) error {
+	return r.Mock.Called(id).Error(0)
+}
+
+func (r *SessionMock) PermanentDeleteByFamilyID(familyID pgtype.UUID) error {
+	return r.Mock.Called(familyID).Error(0)
+}
+
+func (r *SessionMock) PurgeExpired() (int64, error) {
+	args := r.Mock.Called()
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (r *SessionMock) PermanentDeleteByUserID(userID pgtype.UUID, exceptIDs ...pgtype.UUID) error {
+	return r.Mock.Called(userID, exceptIDs).Error(0)
+}
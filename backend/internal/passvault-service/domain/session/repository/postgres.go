@@ -3,12 +3,15 @@ package repository
 import (
 	"context"
 	"github.com/Novando/pintartek/internal/passvault-service/domain/session/entity"
+	"github.com/Novando/pintartek/pkg/common/consts"
 	"github.com/Novando/pintartek/pkg/postgresql/pgx"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
-	"time"
 )
 
+// purgeBatchSize is the number of expired sessions deleted at once
+const purgeBatchSize = 1000
+
 type PostgresSession struct {
 	ctx   context.Context
 	query *pgx.Queries
@@ -28,35 +31,190 @@ func NewPostgresSessionRepository(
 }
 
 const createPostgresSession = `-- name: Create session :one
-	INSERT INTO sessions (id, user_id, secret_key, expired_at)
-	VALUES ($1::uuid, $2::uuid, $3::varchar, $4)
+	INSERT INTO sessions (
+		id, user_id, secret_key, scope, ip, user_agent, device, family_id,
+		idle_timeout, expired_at, max_expired_at, created_at, last_seen_at, pending_secret
+	)
+	VALUES (
+		$1::uuid, $2::uuid, $3::varchar, $4::varchar, $5::varchar, $6::varchar, $7::varchar,
+		COALESCE($8::uuid, $1::uuid), $9::integer,
+		NOW() + make_interval(secs => $9::integer), NOW() + make_interval(secs => $10::integer), NOW(), NOW(),
+		$11::text
+	)
 	RETURNING id
 `
 
 func (r *PostgresSession) Create(arg CreateParam) (id pgtype.UUID, err error) {
-	expiry := time.Now().Add(time.Minute * 30)
+	ttl, maxTTL := arg.lifetime()
 	row := r.db.QueryRow(r.ctx, createPostgresSession,
 		arg.ID,
 		arg.UserID,
 		arg.SecretKey,
-		pgtype.Timestamptz{Time: expiry, Valid: true},
+		arg.Scope,
+		arg.IP,
+		arg.UserAgent,
+		arg.Device,
+		arg.FamilyID,
+		int32(ttl.Seconds()),
+		int32(maxTTL.Seconds()),
+		arg.PendingSecret,
 	)
 	err = row.Scan(&id)
 	return
 }
 
-const getPostgresSessionByID = `-- name: Get session by the ID :one
-	SELECT user_id, secret_key
-	FROM sessions
+const getPostgresSessionByID = `-- name: Get session by the ID, mark it as seen and extend its idle timeout :one
+	UPDATE sessions SET
+		last_seen_at = NOW(),
+		expired_at = CASE
+			WHEN replaced_by IS NULL THEN LEAST(NOW() + make_interval(secs => idle_timeout), max_expired_at)
+			ELSE expired_at
+		END
 	WHERE id = $1::uuid AND expired_at >= NOW()
+	RETURNING id, user_id, secret_key, scope, ip, user_agent, device, created_at, last_seen_at,
+		family_id, replaced_by, rotated_at, pending_secret
 `
 
+// GetByID retrieve an active session, which also update the time it was last seen and extend its idle timeout.
+// A rotated session is still returned so that the reuse of its token can be detected, but it is not extended
 func (r *PostgresSession) GetByID(id pgtype.UUID) (data entity.Session, err error) {
 	row := r.db.QueryRow(r.ctx, getPostgresSessionByID, id)
 	err = row.Scan(
+		&data.ID,
+		&data.UserID,
+		&data.SecretKey,
+		&data.Scope,
+		&data.IP,
+		&data.UserAgent,
+		&data.Device,
+		&data.CreatedAt,
+		&data.LastSeenAt,
+		&data.FamilyID,
+		&data.ReplacedBy,
+		&data.RotatedAt,
+		&data.PendingSecret,
+	)
+	if err != nil && err.Error() == pgx.ErrNoRows() {
+		err = consts.ErrNoData
+	}
+	return
+}
+
+const peekPostgresSessionByID = `-- name: Get session by the ID without extending it :one
+	SELECT id, user_id, secret_key, scope, ip, user_agent, device, created_at, last_seen_at,
+		family_id, replaced_by, rotated_at, pending_secret
+	FROM sessions
+	WHERE id = $1::uuid AND expired_at >= NOW()
+`
+
+// PeekByID retrieve an active session like GetByID, without marking it as seen nor extending its idle timeout.
+// It is used to look up a session that is not the one presented by the user
+func (r *PostgresSession) PeekByID(id pgtype.UUID) (data entity.Session, err error) {
+	row := r.db.QueryRow(r.ctx, peekPostgresSessionByID, id)
+	err = row.Scan(
+		&data.ID,
 		&data.UserID,
 		&data.SecretKey,
+		&data.Scope,
+		&data.IP,
+		&data.UserAgent,
+		&data.Device,
+		&data.CreatedAt,
+		&data.LastSeenAt,
+		&data.FamilyID,
+		&data.ReplacedBy,
+		&data.RotatedAt,
+		&data.PendingSecret,
 	)
+	if err != nil && err.Error() == pgx.ErrNoRows() {
+		err = consts.ErrNoData
+	}
+	return
+}
+
+const getAllByUserIDPostgresSession = `-- name: Get all active session of a user :many
+	SELECT id, user_id, scope, ip, user_agent, device, created_at, last_seen_at, family_id
+	FROM sessions
+	WHERE user_id = $1::uuid AND expired_at >= NOW() AND replaced_by IS NULL
+	ORDER BY last_seen_at DESC
+`
+
+// GetAllByUserID retrieve the active sessions of a user, without their secret nor the rotated ones
+func (r *PostgresSession) GetAllByUserID(userID pgtype.UUID) (data []entity.Session, err error) {
+	rows, err := r.db.Query(r.ctx, getAllByUserIDPostgresSession, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var i entity.Session
+		if err = rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Scope,
+			&i.IP,
+			&i.UserAgent,
+			&i.Device,
+			&i.CreatedAt,
+			&i.LastSeenAt,
+			&i.FamilyID,
+		); err != nil {
+			return nil, err
+		}
+		data = append(data, i)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+const markRotatedPostgresSession = `-- name: Mark a session as rotated :exec
+	UPDATE sessions SET replaced_by = $2::uuid, rotated_at = NOW()
+	WHERE id = $1::uuid AND replaced_by IS NULL AND expired_at >= NOW()
+`
+
+const getReplacementPostgresSession = `-- name: Get the session that replaced a rotated session :one
+	SELECT replaced_by FROM sessions WHERE id = $1::uuid AND replaced_by IS NOT NULL AND expired_at >= NOW()
+`
+
+const createRotatedPostgresSession = `-- name: Create the replacement of a rotated session :exec
+	INSERT INTO sessions (
+		id, user_id, secret_key, scope, ip, user_agent, device, family_id,
+		idle_timeout, expired_at, max_expired_at, created_at, last_seen_at
+	)
+	SELECT $2::uuid, user_id, secret_key, scope, ip, user_agent, device, family_id,
+		idle_timeout, LEAST(NOW() + make_interval(secs => idle_timeout), max_expired_at), max_expired_at, created_at, NOW()
+	FROM sessions WHERE id = $1::uuid
+`
+
+// Rotate replace a session by a new one of the same family, the replacement
+// is returned instead when the session was already rotated
+func (r *PostgresSession) Rotate(id pgtype.UUID, newID pgtype.UUID) (replacedBy pgtype.UUID, err error) {
+	tx, err := r.db.Begin(r.ctx)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback(r.ctx)
+	// a concurrent rotation wait on the row lock, then see the session is no longer claimable
+	tag, err := tx.Exec(r.ctx, markRotatedPostgresSession, id, newID)
+	if err != nil {
+		return
+	}
+	if tag.RowsAffected() == 0 {
+		err = tx.QueryRow(r.ctx, getReplacementPostgresSession, id).Scan(&replacedBy)
+		if err != nil && err.Error() == pgx.ErrNoRows() {
+			err = consts.ErrNoData
+		}
+		return
+	}
+	if _, err = tx.Exec(r.ctx, createRotatedPostgresSession, id, newID); err != nil {
+		return
+	}
+	if err = tx.Commit(r.ctx); err != nil {
+		return
+	}
+	replacedBy = newID
 	return
 }
 
@@ -68,3 +226,65 @@ func (r *PostgresSession) PermanentDelete(id pgtype.UUID) error {
 	_, err := r.db.Exec(r.ctx, permanentDeletePostgresSession, id)
 	return err
 }
+
+const permanentDeleteByUserIDPostgresSession = `-- name: Permanent delete all session of a user :exec
+	DELETE FROM sessions WHERE user_id = $1::uuid AND NOT (id = ANY($2::uuid[]))
+`
+
+// PermanentDeleteByUserID delete every session of a user, except the listed session IDs
+func (r *PostgresSession) PermanentDeleteByUserID(userID pgtype.UUID, exceptIDs ...pgtype.UUID) error {
+	if exceptIDs == nil {
+		exceptIDs = []pgtype.UUID{}
+	}
+	_, err := r.db.Exec(r.ctx, permanentDeleteByUserIDPostgresSession, userID, exceptIDs)
+	return err
+}
+
+const permanentDeleteByFamilyIDPostgresSession = `-- name: Permanent delete a session family :exec
+	DELETE FROM sessions WHERE family_id = $1::uuid
+`
+
+// PermanentDeleteByFamilyID delete a session together with every session it was rotated from or into
+func (r *PostgresSession) PermanentDeleteByFamilyID(familyID pgtype.UUID) error {
+	_, err := r.db.Exec(r.ctx, permanentDeleteByFamilyIDPostgresSession, familyID)
+	return err
+}
+
+const purgeExpiredPostgresSession = `-- name: Permanent delete a batch of expired session :execrows
+	DELETE FROM sessions WHERE id IN (
+		SELECT id FROM sessions WHERE expired_at < NOW() LIMIT $1::integer
+	)
+`
+
+// PurgeExpired permanently delete the expired sessions in batches, so the table is not locked for long.
+// It stop once another replica is purging them
+func (r *PostgresSession) PurgeExpired() (total int64, err error) {
+	for {
+		deleted, locked, err := r.purgeExpiredBatch()
+		if err != nil || !locked {
+			return total, err
+		}
+		total += deleted
+		if deleted < purgeBatchSize {
+			return total, nil
+		}
+	}
+}
+
+// purgeExpiredBatch delete a batch of expired sessions, `locked` is false when another replica is purging them
+func (r *PostgresSession) purgeExpiredBatch() (deleted int64, locked bool, err error) {
+	tx, err := r.db.Begin(r.ctx)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback(r.ctx)
+	if locked, err = pgx.TryAdvisoryXactLock(r.ctx, tx, "maintenance:sessions"); err != nil || !locked {
+		return
+	}
+	tag, err := tx.Exec(r.ctx, purgeExpiredPostgresSession, purgeBatchSize)
+	if err != nil {
+		return
+	}
+	err = tx.Commit(r.ctx)
+	return tag.RowsAffected(), locked, err
+}
@@ -6,32 +6,138 @@ import (
 	"github.com/Novando/pintartek/internal/passvault-service/domain/session/entity"
 	"github.com/Novando/pintartek/pkg/common/consts"
 	"github.com/Novando/pintartek/pkg/redis"
+	"github.com/Novando/pintartek/pkg/uuid"
 	"github.com/jackc/pgx/v5/pgtype"
 	"time"
 )
 
+// lastSeenPrecision avoid rewriting a session on every request only to update the time it was last seen
+// and extend its idle timeout, so a session may expire up to lastSeenPrecision sooner than its idle timeout
+const lastSeenPrecision = time.Minute
+
 type RedisSession struct {
 	rds *redis.Redis
 }
 
+// redisSession is the value stored in redis, the entity itself only serialize the token payload
+type redisSession struct {
+	UserID     pgtype.UUID `json:"userId"`
+	SecretKey  string      `json:"secretKey"`
+	Scope      string      `json:"scope,omitempty"`
+	IP         string      `json:"ip,omitempty"`
+	UserAgent  string      `json:"userAgent,omitempty"`
+	Device     string      `json:"device,omitempty"`
+	CreatedAt  time.Time   `json:"createdAt"`
+	LastSeenAt time.Time   `json:"lastSeenAt"`
+	FamilyID   pgtype.UUID `json:"familyId"`
+	// IdleTimeout is how long the key live after each use, but not past MaxExpiredAt
+	IdleTimeout   time.Duration `json:"idleTimeout"`
+	MaxExpiredAt  time.Time     `json:"maxExpiredAt"`
+	PendingSecret string        `json:"pendingSecret,omitempty"`
+}
+
+// ttl is how long the session live from now on when it is used
+func (s redisSession) ttl() time.Duration {
+	ttl := time.Until(s.MaxExpiredAt)
+	if s.IdleTimeout < ttl {
+		return s.IdleTimeout
+	}
+	return ttl
+}
+
+// redisRotation is stored apart from the session once it is rotated,
+// so that updating the time a session was last seen can not overwrite it
+type redisRotation struct {
+	ReplacedBy pgtype.UUID `json:"replacedBy"`
+	RotatedAt  time.Time   `json:"rotatedAt"`
+}
+
+func (s redisSession) toEntity(id pgtype.UUID) entity.Session {
+	return entity.Session{
+		ID:         id,
+		UserID:     s.UserID,
+		SecretKey:  s.SecretKey,
+		Scope:      s.Scope,
+		IP:         s.IP,
+		UserAgent:  s.UserAgent,
+		Device:     s.Device,
+		CreatedAt:  pgtype.Timestamptz{Time: s.CreatedAt, Valid: !s.CreatedAt.IsZero()},
+		LastSeenAt: pgtype.Timestamptz{Time: s.LastSeenAt, Valid: !s.LastSeenAt.IsZero()},
+		FamilyID:   s.FamilyID,
+		// only kept by a session waiting for the second factor
+		PendingSecret: s.PendingSecret,
+	}
+}
+
 func NewRedisSessionRepository(r *redis.Redis) *RedisSession {
 	return &RedisSession{rds: r}
 }
 
+// userSessionKey the key of a set that index every session ID of a user
+func userSessionKey(userID pgtype.UUID) string {
+	return fmt.Sprintf("user-session:%x", userID.Bytes)
+}
+
+// familySessionKey the key of a set that index every session ID of a session family
+func familySessionKey(familyID pgtype.UUID) string {
+	return fmt.Sprintf("session-family:%x", familyID.Bytes)
+}
+
+// rotationKey the key holding the replacement of a rotated session
+func rotationKey(id pgtype.UUID) string {
+	return fmt.Sprintf("session-rotated:%x", id.Bytes)
+}
+
 func (r *RedisSession) Create(arg CreateParam) (id pgtype.UUID, err error) {
-	sessionData := entity.Session{UserID: arg.UserID, SecretKey: arg.SecretKey}
-	val, err := json.Marshal(sessionData)
-	if err != nil {
-		return
+	ttl, maxTTL := arg.lifetime()
+	if !arg.FamilyID.Valid {
+		arg.FamilyID = arg.ID
+	}
+	now := time.Now()
+	sessionData := redisSession{
+		UserID:     arg.UserID,
+		SecretKey:  arg.SecretKey,
+		Scope:      arg.Scope,
+		IP:         arg.IP,
+		UserAgent:  arg.UserAgent,
+		Device:     arg.Device,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		FamilyID:   arg.FamilyID,
+		// the idle timeout is kept with the session so it can be extended without knowing the policy
+		IdleTimeout:   ttl,
+		MaxExpiredAt:  now.Add(maxTTL),
+		PendingSecret: arg.PendingSecret,
 	}
-	if err = r.rds.Set(fmt.Sprintf("%x", arg.ID.Bytes), string(val), time.Minute*30); err != nil {
+	if err = r.store(arg.ID, sessionData); err != nil {
 		return
 	}
 	id = arg.ID
 	return
 }
 
-func (r *RedisSession) GetByID(id pgtype.UUID) (session entity.Session, err error) {
+// store save a session and add it to the index of its user and its family
+func (r *RedisSession) store(id pgtype.UUID, sessionData redisSession) error {
+	ttl := sessionData.ttl()
+	if ttl <= 0 {
+		// a zero expiration would keep the key forever
+		return consts.ErrNoData
+	}
+	val, err := json.Marshal(sessionData)
+	if err != nil {
+		return err
+	}
+	if err = r.rds.Set(fmt.Sprintf("%x", id.Bytes), string(val), ttl); err != nil {
+		return err
+	}
+	indexTTL := time.Until(sessionData.MaxExpiredAt)
+	if err = r.rds.AddSetMemberExtend(userSessionKey(sessionData.UserID), fmt.Sprintf("%x", id.Bytes), indexTTL); err != nil {
+		return err
+	}
+	return r.rds.AddSetMemberExtend(familySessionKey(sessionData.FamilyID), fmt.Sprintf("%x", id.Bytes), indexTTL)
+}
+
+func (r *RedisSession) get(id pgtype.UUID) (session redisSession, err error) {
 	val, err := r.rds.Get(fmt.Sprintf("%x", id.Bytes))
 	if err != nil {
 		return
@@ -40,10 +146,218 @@ func (r *RedisSession) GetByID(id pgtype.UUID) (session entity.Session, err erro
 		err = consts.ErrNoData
 		return
 	}
-	err = json.Unmarshal([]byte(val), &session)
+	if err = json.Unmarshal([]byte(val), &session); err != nil {
+		return
+	}
+	if !session.FamilyID.Valid {
+		// stored before the sessions were rotated, so it is the first of its family
+		session.FamilyID = id
+	}
+	if session.IdleTimeout == 0 {
+		// stored before the idle timeout was configurable
+		session.IdleTimeout = DefaultTTL
+		session.MaxExpiredAt = session.CreatedAt.Add(DefaultMaxTTL)
+	}
+	return
+}
+
+// getRotation retrieve the replacement of a session, which is empty when the session is not rotated
+func (r *RedisSession) getRotation(id pgtype.UUID) (rotation redisRotation, err error) {
+	val, err := r.rds.Get(rotationKey(id))
+	if err != nil || val == "" {
+		return
+	}
+	err = json.Unmarshal([]byte(val), &rotation)
+	return
+}
+
+// GetByID retrieve an active session, which also update the time it was last seen and extend its idle timeout.
+// A rotated session is still returned so that the reuse of its token can be detected, but it is not extended
+func (r *RedisSession) GetByID(id pgtype.UUID) (session entity.Session, err error) {
+	sessionData, err := r.get(id)
+	if err != nil {
+		return
+	}
+	rotation, err := r.getRotation(id)
+	if err != nil {
+		return
+	}
+	if time.Since(sessionData.LastSeenAt) > lastSeenPrecision {
+		sessionData.LastSeenAt = time.Now()
+		val, err := json.Marshal(sessionData)
+		if err != nil {
+			return session, err
+		}
+		if ttl := sessionData.ttl(); !rotation.ReplacedBy.Valid && ttl > 0 {
+			err = r.rds.Set(fmt.Sprintf("%x", id.Bytes), string(val), ttl)
+		} else {
+			err = r.rds.SetKeepTTL(fmt.Sprintf("%x", id.Bytes), string(val))
+		}
+		if err != nil {
+			return session, err
+		}
+	}
+	session = sessionData.toEntity(id)
+	session.ReplacedBy = rotation.ReplacedBy
+	session.RotatedAt = pgtype.Timestamptz{Time: rotation.RotatedAt, Valid: !rotation.RotatedAt.IsZero()}
+	return session, nil
+}
+
+// PeekByID retrieve an active session like GetByID, without marking it as seen nor extending its idle timeout.
+// It is used to look up a session that is not the one presented by the user
+func (r *RedisSession) PeekByID(id pgtype.UUID) (session entity.Session, err error) {
+	sessionData, err := r.get(id)
+	if err != nil {
+		return
+	}
+	rotation, err := r.getRotation(id)
+	if err != nil {
+		return
+	}
+	session = sessionData.toEntity(id)
+	session.ReplacedBy = rotation.ReplacedBy
+	session.RotatedAt = pgtype.Timestamptz{Time: rotation.RotatedAt, Valid: !rotation.RotatedAt.IsZero()}
+	return session, nil
+}
+
+// GetAllByUserID retrieve the active sessions of a user, without their secret nor the rotated ones.
+// The expired ones are removed from the index
+func (r *RedisSession) GetAllByUserID(userID pgtype.UUID) (sessions []entity.Session, err error) {
+	members, err := r.rds.GetSetMembers(userSessionKey(userID))
+	if err != nil {
+		return nil, err
+	}
+	for _, member := range members {
+		idBytes, err := uuid.ParseUUID(member)
+		if err != nil {
+			return nil, err
+		}
+		id := pgtype.UUID{Bytes: idBytes, Valid: true}
+		sessionData, err := r.get(id)
+		if err != nil {
+			if err.Error() != consts.ErrNoData.Error() {
+				return nil, err
+			}
+			if err = r.rds.RemoveSetMember(userSessionKey(userID), member); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		rotation, err := r.getRotation(id)
+		if err != nil {
+			return nil, err
+		}
+		if rotation.ReplacedBy.Valid {
+			continue
+		}
+		session := sessionData.toEntity(id)
+		session.SecretKey = ""
+		session.PendingSecret = ""
+		sessions = append(sessions, session)
+	}
+	return
+}
+
+// Rotate replace a session by a new one of the same family, the replacement
+// is returned instead when the session was already rotated
+func (r *RedisSession) Rotate(id pgtype.UUID, newID pgtype.UUID) (replacedBy pgtype.UUID, err error) {
+	sessionData, err := r.get(id)
+	if err != nil {
+		return
+	}
+	// the rotation is kept as long as the old token could be presented
+	rotationTTL := time.Until(sessionData.MaxExpiredAt)
+	if rotationTTL <= 0 {
+		return replacedBy, consts.ErrNoData
+	}
+	val, err := json.Marshal(redisRotation{ReplacedBy: newID, RotatedAt: time.Now()})
+	if err != nil {
+		return
+	}
+	// only one of the concurrent rotations claim the session, the others get its replacement
+	claimed, err := r.rds.SetNX(rotationKey(id), string(val), rotationTTL)
+	if err != nil {
+		return
+	}
+	if !claimed {
+		rotation, err := r.getRotation(id)
+		if err != nil {
+			return replacedBy, err
+		}
+		if !rotation.ReplacedBy.Valid {
+			return replacedBy, consts.ErrNoData
+		}
+		return rotation.ReplacedBy, nil
+	}
+	sessionData.LastSeenAt = time.Now()
+	if err = r.store(newID, sessionData); err != nil {
+		return
+	}
+	replacedBy = newID
 	return
 }
 
 func (r *RedisSession) PermanentDelete(id pgtype.UUID) error {
+	session, err := r.get(id)
+	if err != nil {
+		if err.Error() == consts.ErrNoData.Error() {
+			return nil
+		}
+		return err
+	}
+	if err = r.rds.RemoveSetMember(userSessionKey(session.UserID), fmt.Sprintf("%x", id.Bytes)); err != nil {
+		return err
+	}
 	return r.rds.Delete(fmt.Sprintf("%x", id.Bytes))
 }
+
+// PermanentDeleteByUserID delete every session of a user, except the listed session IDs
+func (r *RedisSession) PermanentDeleteByUserID(userID pgtype.UUID, exceptIDs ...pgtype.UUID) error {
+	members, err := r.rds.GetSetMembers(userSessionKey(userID))
+	if err != nil {
+		return err
+	}
+	excepts := make(map[string]bool, len(exceptIDs))
+	for _, exceptID := range exceptIDs {
+		excepts[fmt.Sprintf("%x", exceptID.Bytes)] = true
+	}
+	for _, member := range members {
+		if excepts[member] {
+			continue
+		}
+		if err = r.rds.Delete(member); err != nil {
+			return err
+		}
+		if err = r.rds.RemoveSetMember(userSessionKey(userID), member); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PermanentDeleteByFamilyID delete a session together with every session it was rotated from or into
+func (r *RedisSession) PermanentDeleteByFamilyID(familyID pgtype.UUID) error {
+	members, err := r.rds.GetSetMembers(familySessionKey(familyID))
+	if err != nil {
+		return err
+	}
+	for _, member := range members {
+		idBytes, err := uuid.ParseUUID(member)
+		if err != nil {
+			return err
+		}
+		id := pgtype.UUID{Bytes: idBytes, Valid: true}
+		if err = r.PermanentDelete(id); err != nil {
+			return err
+		}
+		if err = r.rds.Delete(rotationKey(id)); err != nil {
+			return err
+		}
+	}
+	return r.rds.Delete(familySessionKey(familyID))
+}
+
+// PurgeExpired does nothing, redis remove the expired sessions by itself
+func (r *RedisSession) PurgeExpired() (int64, error) {
+	return 0, nil
+}
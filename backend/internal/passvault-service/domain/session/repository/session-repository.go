@@ -3,16 +3,60 @@ package repository
 import (
 	"github.com/Novando/pintartek/internal/passvault-service/domain/session/entity"
 	"github.com/jackc/pgx/v5/pgtype"
+	"time"
 )
 
 type CreateParam struct {
 	ID        pgtype.UUID
 	UserID    pgtype.UUID
 	SecretKey string
+	Scope     string
+	IP        string
+	UserAgent string
+	Device    string
+	// FamilyID fallback to the session ID when empty, which start a new family
+	FamilyID pgtype.UUID
+	// PendingSecret is kept by a session waiting for the second factor, see entity.Session
+	PendingSecret string
+	// TTL is the idle timeout, every use of the session extend its expiry by TTL up to MaxTTL.
+	// TTL fallback to DefaultTTL and MaxTTL fallback to DefaultMaxTTL when empty
+	TTL    time.Duration
+	MaxTTL time.Duration
+}
+
+const (
+	DefaultTTL    = time.Minute * 30
+	DefaultMaxTTL = time.Hour * 12
+)
+
+// lifetime apply the defaults of the TTLs, the idle timeout can not outlive the session itself
+func (arg CreateParam) lifetime() (ttl time.Duration, maxTTL time.Duration) {
+	ttl, maxTTL = arg.TTL, arg.MaxTTL
+	if ttl == 0 {
+		ttl = DefaultTTL
+	}
+	if maxTTL == 0 {
+		maxTTL = DefaultMaxTTL
+	}
+	if ttl > maxTTL {
+		ttl = maxTTL
+	}
+	return
 }
 
 type Session interface {
 	Create(CreateParam) (pgtype.UUID, error)
+	// GetByID retrieve an active session and extend its idle timeout
 	GetByID(pgtype.UUID) (entity.Session, error)
+	// PeekByID retrieve an active session without marking it as seen nor extending its idle timeout
+	PeekByID(pgtype.UUID) (entity.Session, error)
+	GetAllByUserID(userID pgtype.UUID) ([]entity.Session, error)
+	// Rotate replace a session by a new one of the same family, the replacement
+	// is returned instead when the session was already rotated
+	Rotate(id pgtype.UUID, newID pgtype.UUID) (pgtype.UUID, error)
 	PermanentDelete(pgtype.UUID) error
+	PermanentDeleteByFamilyID(familyID pgtype.UUID) error
+	// PurgeExpired permanently delete the expired sessions, returning how many are deleted
+	PurgeExpired() (int64, error)
+	PermanentDeleteByUserID(userID pgtype.UUID, exceptIDs ...pgtype.UUID) error
 }
@@ -12,4 +12,21 @@ type User struct {
 	PublicKey   string
 	AccessToken string
 	BackupToken string
+	KdfParams   string
+	// TotpSecret is encrypted by a key derived from the session secret,
+	// it is pending until TotpEnabledAt is set
+	TotpSecret    string
+	TotpCounter   int64
+	TotpEnabledAt pgtype.Timestamptz
+}
+
+// VaultKey is a membership of a user together with the vault key wrapped for the user. A membership
+// of a vault created before vault keys existed has no vault key, its vault Credential is encrypted
+// by the session secret itself
+type VaultKey struct {
+	ID         uint64
+	VaultID    pgtype.UUID
+	VaultKey   string
+	InviteKey  string
+	Credential string
 }
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"github.com/Novando/pintartek/internal/passvault-service/domain/user/entity"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/mock"
+	"testing"
+	"time"
+)
+
+type UserMock struct {
+	Mock mock.Mock
+}
+
+// NewMockUserRepository Initialize a mocked user repository, the expectations are asserted once the test end
+func NewMockUserRepository(t *testing.T) *UserMock {
+	r := &UserMock{}
+	r.Mock.Test(t)
+	t.Cleanup(func() { r.Mock.AssertExpectations(t) })
+	return r
+}
+
+func (r *UserMock) Create(arg CreateParam) (id pgtype.UUID, err error) {
+	args := r.Mock.Called(arg)
+	return args.Get(0).(pgtype.UUID), args.Error(1)
+}
+
+func (r *UserMock) GetByID(id pgtype.UUID) (data entity.User, err error) {
+	args := r.Mock.Called(id)
+	return args.Get(0).(entity.User), args.Error(1)
+}
+
+func (r *UserMock) GetByEmail(email string) (data entity.User, err error) {
+	args := r.Mock.Called(email)
+	return args.Get(0).(entity.User), args.Error(1)
+}
+
+func (r *UserMock) UpdatePassword(arg UpdatePasswordParam) error {
+	return r.Mock.Called(arg).Error(0)
+}
+
+func (r *UserMock) GetAllVaultKey(id pgtype.UUID) ([]entity.VaultKey, error) {
+	args := r.Mock.Called(id)
+	return args.Get(0).([]entity.VaultKey), args.Error(1)
+}
+
+func (r *UserMock) ReplaceSecret(arg ReplaceSecretParam) error {
+	return r.Mock.Called(arg).Error(0)
+}
+
+func (r *UserMock) UpdatePublicKey(id pgtype.UUID, pub string) error {
+	return r.Mock.Called(id, pub).Error(0)
+}
+
+func (r *UserMock) UpdateTotp(arg UpdateTotpParam) error {
+	return r.Mock.Called(arg).Error(0)
+}
+
+func (r *UserMock) UpdateTotpCounter(id pgtype.UUID, counter int64) error {
+	return r.Mock.Called(id, counter).Error(0)
+}
+
+func (r *UserMock) ReplaceRecoveryCodes(id pgtype.UUID, codeHashes []string) error {
+	return r.Mock.Called(id, codeHashes).Error(0)
+}
+
+func (r *UserMock) UseRecoveryCode(id pgtype.UUID, codeHash string) error {
+	return r.Mock.Called(id, codeHash).Error(0)
+}
+
+func (r *UserMock) Delete(id pgtype.UUID) error {
+	return r.Mock.Called(id).Error(0)
+}
+
+func (r *UserMock) PermanentDelete(id pgtype.UUID) error {
+	return r.Mock.Called(id).Error(0)
+}
+
+func (r *UserMock) PurgeDeleted(before time.Time) (int64, error) {
+	args := r.Mock.Called(before)
+	return args.Get(0).(int64), args.Error(1)
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/Novando/pintartek/pkg/postgresql/pgx"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
 )
 
 type PostgresUser struct {
@@ -28,8 +29,8 @@ func NewPostgresUserRepository(
 }
 
 const createPostgresUser = `-- name: Create user :one
-	INSERT INTO users (id, email, password, public_key, access_token, backup_token, created_at, updated_at)
-	VALUES ($1::uuid, $2::varchar, $3::varchar, $4::varchar, $5::varchar, $6::varchar, NOW(), NOW())
+	INSERT INTO users (id, email, password, public_key, access_token, backup_token, kdf_params, created_at, updated_at)
+	VALUES ($1::uuid, $2::varchar, $3::varchar, $4::varchar, $5::varchar, $6::varchar, $7::varchar, NOW(), NOW())
 	RETURNING id
 `
 
@@ -41,13 +42,15 @@ func (r *PostgresUser) Create(arg CreateParam) (id pgtype.UUID, err error) {
 		arg.PublicKey,
 		arg.AccessToken,
 		arg.BackupToken,
+		arg.KdfParams,
 	)
 	err = row.Scan(&id)
 	return
 }
 
 const getPostgresUserByID = `-- name: Get user by the ID :one
-	SELECT id, email, password, public_key, access_token, backup_token, created_at, updated_at, deleted_at
+	SELECT id, email, password, public_key, access_token, backup_token, kdf_params,
+		totp_secret, totp_counter, totp_enabled_at, created_at, updated_at, deleted_at
 	FROM users
 	WHERE id = $1::uuid AND deleted_at IS NULL
 `
@@ -61,15 +64,23 @@ func (r *PostgresUser) GetByID(id pgtype.UUID) (data entity.User, err error) {
 		&data.PublicKey,
 		&data.AccessToken,
 		&data.BackupToken,
+		&data.KdfParams,
+		&data.TotpSecret,
+		&data.TotpCounter,
+		&data.TotpEnabledAt,
 		&data.CreatedAt,
 		&data.UpdatedAt,
 		&data.DeletedAt,
 	)
+	if err != nil && err.Error() == pgx.ErrNoRows() {
+		err = consts.ErrNoData
+	}
 	return
 }
 
 const getPostgresUserByEmail = `-- name: Get user by an email :one
-	SELECT id, email, password, public_key, access_token, backup_token, created_at, updated_at, deleted_at
+	SELECT id, email, password, public_key, access_token, backup_token, kdf_params,
+		totp_secret, totp_counter, totp_enabled_at, created_at, updated_at, deleted_at
 	FROM users
 	WHERE email = $1::varchar AND deleted_at IS NULL
 `
@@ -83,6 +94,10 @@ func (r *PostgresUser) GetByEmail(email string) (data entity.User, err error) {
 		&data.PublicKey,
 		&data.AccessToken,
 		&data.BackupToken,
+		&data.KdfParams,
+		&data.TotpSecret,
+		&data.TotpCounter,
+		&data.TotpEnabledAt,
 		&data.CreatedAt,
 		&data.UpdatedAt,
 		&data.DeletedAt,
@@ -94,14 +109,209 @@ func (r *PostgresUser) GetByEmail(email string) (data entity.User, err error) {
 }
 
 const updatePasswordPostgresUser = `-- name: Update password of a user :exec
-	UPDATE users SET password = $1::varchar, updated_at = NOW() WHERE id = $2::uuid
+	UPDATE users SET
+		password = $1::varchar,
+		access_token = $2::varchar,
+		kdf_params = $3::varchar,
+		updated_at = NOW()
+	WHERE id = $4::uuid
+`
+
+// UpdatePassword replace the password hash together with the access token
+// encrypted by that password and its KDF parameters, so all of them are always in sync
+func (r *PostgresUser) UpdatePassword(arg UpdatePasswordParam) error {
+	_, err := r.db.Exec(r.ctx, updatePasswordPostgresUser, arg.Password, arg.AccessToken, arg.KdfParams, arg.ID)
+	return err
+}
+
+const getAllVaultKeyPostgresUser = `-- name: Get the vault key of every membership of a user :many
+	SELECT uvp.id, uvp.vault_id, uvp.vault_key, uvp.invite_key, v.credential
+	FROM user_vault_pivots uvp
+	JOIN vaults v ON uvp.vault_id = v.id
+	WHERE uvp.user_id = $1::uuid
+`
+
+// GetAllVaultKey retrieve the vault key of every membership of a user, including the pending invitations
+// and the vaults in the trash
+func (r *PostgresUser) GetAllVaultKey(id pgtype.UUID) (data []entity.VaultKey, err error) {
+	rows, err := r.db.Query(r.ctx, getAllVaultKeyPostgresUser, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var i entity.VaultKey
+		if err = rows.Scan(
+			&i.ID,
+			&i.VaultID,
+			&i.VaultKey,
+			&i.InviteKey,
+			&i.Credential,
+		); err != nil {
+			return nil, err
+		}
+		data = append(data, i)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+const replaceSecretPostgresUser = `-- name: Replace the values encrypted by the session secret of a user :execrows
+	UPDATE users SET
+		password = $2::varchar,
+		kdf_params = $3::varchar,
+		access_token = $5::varchar,
+		backup_token = $6::varchar,
+		totp_secret = $8::varchar,
+		updated_at = NOW()
+	WHERE id = $1::uuid AND access_token = $4::varchar AND totp_secret = $7::varchar
+`
+
+const replaceVaultKeyPostgresUser = `-- name: Replace the wrapped vault key of a membership :execrows
+	UPDATE user_vault_pivots SET vault_key = $4::varchar
+	WHERE user_id = $1::uuid AND id = $2::bigint AND vault_key = $3::varchar AND invite_key = ''
+`
+
+const replaceVaultCredentialPostgresUser = `-- name: Replace the credential of a vault encrypted by the session secret :execrows
+	UPDATE vaults SET credential = $3::varchar, updated_at = NOW()
+	WHERE id = $1::uuid AND credential = $2::varchar
 `
 
-func (r *PostgresUser) UpdatePassword(id pgtype.UUID, password string) error {
-	_, err := r.db.Exec(r.ctx, updatePasswordPostgresUser, password, id)
+const countVaultKeyPostgresUser = `-- name: Count the memberships of a user wrapping their vault key for the user :one
+	SELECT COUNT(*) FROM user_vault_pivots WHERE user_id = $1::uuid AND invite_key = ''
+`
+
+// ReplaceSecret replace every value encrypted by the session secret of a user at once, so none of them
+// is left encrypted by a secret that is no longer known
+func (r *PostgresUser) ReplaceSecret(arg ReplaceSecretParam) error {
+	tx, err := r.db.Begin(r.ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(r.ctx)
+	// every statement must change a single row, otherwise the value changed since it was read
+	exec := func(sql string, args ...interface{}) error {
+		tag, err := tx.Exec(r.ctx, sql, args...)
+		if err == nil && tag.RowsAffected() != 1 {
+			err = consts.ErrConflict
+		}
+		return err
+	}
+	err = exec(replaceSecretPostgresUser,
+		arg.ID,
+		arg.Password,
+		arg.KdfParams,
+		arg.AccessToken.Old,
+		arg.AccessToken.New,
+		arg.BackupToken,
+		arg.TotpSecret.Old,
+		arg.TotpSecret.New,
+	)
+	if err != nil {
+		return err
+	}
+	for id, value := range arg.VaultKeys {
+		if err = exec(replaceVaultKeyPostgresUser, arg.ID, id, value.Old, value.New); err != nil {
+			return err
+		}
+	}
+	for id, value := range arg.Credentials {
+		if err = exec(replaceVaultCredentialPostgresUser, id, value.Old, value.New); err != nil {
+			return err
+		}
+	}
+	// a vault key wrapped since the keys were read is wrapped using the old secret
+	var vaultKeys int
+	if err = tx.QueryRow(r.ctx, countVaultKeyPostgresUser, arg.ID).Scan(&vaultKeys); err != nil {
+		return err
+	}
+	if vaultKeys != len(arg.VaultKeys) {
+		return consts.ErrConflict
+	}
+	return tx.Commit(r.ctx)
+}
+
+const updateTotpPostgresUser = `-- name: Update TOTP secret of a user :exec
+	UPDATE users SET
+		totp_secret = $1::varchar,
+		totp_counter = 0,
+		totp_enabled_at = CASE WHEN $2::bool THEN NOW() END,
+		updated_at = NOW()
+	WHERE id = $3::uuid
+`
+
+// UpdateTotp store a pending or enabled TOTP secret, pass an empty secret to disable it
+func (r *PostgresUser) UpdateTotp(arg UpdateTotpParam) error {
+	_, err := r.db.Exec(r.ctx, updateTotpPostgresUser, arg.TotpSecret, arg.Enabled, arg.ID)
 	return err
 }
 
+const updateTotpCounterPostgresUser = `-- name: Update the last used TOTP time step of a user :exec
+	UPDATE users SET totp_counter = $1::bigint WHERE id = $2::uuid AND totp_counter < $1::bigint
+`
+
+// UpdateTotpCounter record the time step of a used TOTP code. The counter only move forward,
+// a code that is not newer than the last used one result in consts.ErrNoData
+func (r *PostgresUser) UpdateTotpCounter(id pgtype.UUID, counter int64) error {
+	tag, err := r.db.Exec(r.ctx, updateTotpCounterPostgresUser, counter, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return consts.ErrNoData
+	}
+	return nil
+}
+
+const deleteRecoveryCodesPostgresUser = `-- name: Delete all recovery codes of a user :exec
+	DELETE FROM user_recovery_codes WHERE user_id = $1::uuid
+`
+
+const createRecoveryCodePostgresUser = `-- name: Create a recovery code :exec
+	INSERT INTO user_recovery_codes (user_id, code_hash, created_at)
+	VALUES ($1::uuid, $2::varchar, NOW())
+`
+
+// ReplaceRecoveryCodes delete the recovery codes of a user and store the new ones,
+// pass no code to only delete them
+func (r *PostgresUser) ReplaceRecoveryCodes(id pgtype.UUID, codeHashes []string) error {
+	tx, err := r.db.Begin(r.ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(r.ctx)
+
+	if _, err = tx.Exec(r.ctx, deleteRecoveryCodesPostgresUser, id); err != nil {
+		return err
+	}
+	for _, codeHash := range codeHashes {
+		if _, err = tx.Exec(r.ctx, createRecoveryCodePostgresUser, id, codeHash); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(r.ctx)
+}
+
+const useRecoveryCodePostgresUser = `-- name: Use a recovery code :exec
+	UPDATE user_recovery_codes SET used_at = NOW()
+	WHERE user_id = $1::uuid AND code_hash = $2::varchar AND used_at IS NULL
+`
+
+// UseRecoveryCode mark a recovery code as used, a code that does not exist
+// or has been used result in consts.ErrNoData
+func (r *PostgresUser) UseRecoveryCode(id pgtype.UUID, codeHash string) error {
+	tag, err := r.db.Exec(r.ctx, useRecoveryCodePostgresUser, id, codeHash)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return consts.ErrNoData
+	}
+	return nil
+}
+
 const updatePublicKeyPostgresUser = `-- name: Update private key of a user :exec
 	UPDATE users SET public_key = $1::text, updated_at = NOW() WHERE id = $2::uuid
 `
@@ -128,3 +338,78 @@ func (r *PostgresUser) PermanentDelete(id pgtype.UUID) error {
 	_, err := r.db.Exec(r.ctx, permanentDeletePostgresUser, id)
 	return err
 }
+
+const trashOwnedVaultPostgresUser = `-- name: Move the vaults without another member of the users soft deleted before a time to the trash :exec
+	UPDATE vaults v SET deleted_at = NOW(), updated_at = NOW()
+	WHERE v.deleted_at IS NULL
+		AND v.owner_id IN (SELECT id FROM users WHERE deleted_at IS NOT NULL AND deleted_at < $1::timestamptz)
+		AND NOT EXISTS (
+			SELECT 1 FROM user_vault_pivots p
+			JOIN users u ON u.id = p.user_id
+			WHERE p.vault_id = v.id AND p.accepted_at IS NOT NULL AND u.deleted_at IS NULL
+		)
+`
+
+const promoteOwnedVaultPostgresUser = `-- name: Make the next owner of the vaults of the users soft deleted before a time an owner :exec
+	UPDATE user_vault_pivots SET role = 'owner'
+	WHERE id IN (
+		SELECT (
+			SELECT p.id FROM user_vault_pivots p
+			JOIN users u ON u.id = p.user_id
+			WHERE p.vault_id = v.id AND p.accepted_at IS NOT NULL AND u.deleted_at IS NULL
+			ORDER BY p.role = 'owner' DESC, p.id
+			LIMIT 1
+		)
+		FROM vaults v
+		WHERE v.owner_id IN (SELECT id FROM users WHERE deleted_at IS NOT NULL AND deleted_at < $1::timestamptz)
+	)
+`
+
+const transferOwnedVaultPostgresUser = `-- name: Move the vaults of the users soft deleted before a time to another member :exec
+	UPDATE vaults v SET owner_id = (
+		SELECT p.user_id FROM user_vault_pivots p
+		JOIN users u ON u.id = p.user_id
+		WHERE p.vault_id = v.id AND p.accepted_at IS NOT NULL AND u.deleted_at IS NULL
+		ORDER BY p.role = 'owner' DESC, p.id
+		LIMIT 1
+	)
+	WHERE v.owner_id IN (SELECT id FROM users WHERE deleted_at IS NOT NULL AND deleted_at < $1::timestamptz)
+`
+
+const purgeDeletedPostgresUser = `-- name: Permanent delete the users soft deleted before a time :execrows
+	DELETE FROM users WHERE deleted_at IS NOT NULL AND deleted_at < $1::timestamptz
+`
+
+// PurgeDeleted permanently delete the users soft deleted before a time, together with everything they own.
+// A vault they own is moved to another member first, preferably an owner, which is made an owner otherwise.
+// The vault is moved to the trash when it has no other member. Nothing is done when another replica is purging
+func (r *PostgresUser) PurgeDeleted(before time.Time) (int64, error) {
+	tx, err := r.db.Begin(r.ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(r.ctx)
+	locked, err := pgx.TryAdvisoryXactLock(r.ctx, tx, "maintenance:users")
+	if err != nil || !locked {
+		return 0, err
+	}
+
+	deletedBefore := pgtype.Timestamptz{Time: before, Valid: true}
+	if _, err = tx.Exec(r.ctx, trashOwnedVaultPostgresUser, deletedBefore); err != nil {
+		return 0, err
+	}
+	if _, err = tx.Exec(r.ctx, promoteOwnedVaultPostgresUser, deletedBefore); err != nil {
+		return 0, err
+	}
+	if _, err = tx.Exec(r.ctx, transferOwnedVaultPostgresUser, deletedBefore); err != nil {
+		return 0, err
+	}
+	tag, err := tx.Exec(r.ctx, purgeDeletedPostgresUser, deletedBefore)
+	if err != nil {
+		return 0, err
+	}
+	if err = tx.Commit(r.ctx); err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -3,6 +3,7 @@ package repository
 import (
 	"github.com/Novando/pintartek/internal/passvault-service/domain/user/entity"
 	"github.com/jackc/pgx/v5/pgtype"
+	"time"
 )
 
 type (
@@ -13,6 +14,38 @@ type (
 		PublicKey   string
 		AccessToken string
 		BackupToken string
+		KdfParams   string
+	}
+	UpdatePasswordParam struct {
+		ID          pgtype.UUID
+		Password    string
+		AccessToken string
+		KdfParams   string
+	}
+	UpdateTotpParam struct {
+		ID         pgtype.UUID
+		TotpSecret string
+		Enabled    bool
+	}
+	// SealedValue replace the encrypted value Old of a row by New
+	SealedValue struct {
+		Old string
+		New string
+	}
+	// ReplaceSecretParam replace the session secret of a user, every value encrypted by a key derived
+	// from the secret is replaced by the one encrypted using the new secret. consts.ErrConflict is returned
+	// when any value changed since it was read
+	ReplaceSecretParam struct {
+		ID          pgtype.UUID
+		Password    string
+		KdfParams   string
+		AccessToken SealedValue
+		BackupToken string
+		TotpSecret  SealedValue
+		// VaultKeys is the wrapped vault key of every membership of the user by the ID of the membership
+		VaultKeys map[uint64]SealedValue
+		// Credentials is the credential of the vaults encrypted by the session secret itself by the vault ID
+		Credentials map[pgtype.UUID]SealedValue
 	}
 )
 
@@ -20,8 +53,15 @@ type User interface {
 	Create(arg CreateParam) (id pgtype.UUID, err error)
 	GetByID(id pgtype.UUID) (data entity.User, err error)
 	GetByEmail(email string) (data entity.User, err error)
-	UpdatePassword(id pgtype.UUID, password string) error
+	UpdatePassword(arg UpdatePasswordParam) error
+	GetAllVaultKey(id pgtype.UUID) ([]entity.VaultKey, error)
+	ReplaceSecret(arg ReplaceSecretParam) error
 	UpdatePublicKey(id pgtype.UUID, pub string) error
+	UpdateTotp(arg UpdateTotpParam) error
+	UpdateTotpCounter(id pgtype.UUID, counter int64) error
+	ReplaceRecoveryCodes(id pgtype.UUID, codeHashes []string) error
+	UseRecoveryCode(id pgtype.UUID, codeHash string) error
 	Delete(id pgtype.UUID) error
 	PermanentDelete(id pgtype.UUID) error
+	PurgeDeleted(before time.Time) (int64, error)
 }
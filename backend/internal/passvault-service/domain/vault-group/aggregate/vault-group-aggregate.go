@@ -7,6 +7,30 @@ type VaultList struct {
 	UserID     pgtype.UUID
 	CreatedAt  pgtype.Timestamptz
 	UpdatedAt  pgtype.Timestamptz
+	AcceptedAt pgtype.Timestamptz
+	DeletedAt  pgtype.Timestamptz
 	Name       string
 	Credential string
+	Role       string
+	// KeyReplaced is set once the vault key is replaced, until the member accept the vault again
+	KeyReplaced bool
+}
+
+type VaultMember struct {
+	UserID     pgtype.UUID
+	CreatedAt  pgtype.Timestamptz
+	AcceptedAt pgtype.Timestamptz
+	Email      string
+	Role       string
+	ID         uint64
+}
+
+// VaultMemberKey is the vault key of a member together with the public key of the member,
+// the members whose account is deleted are included
+type VaultMemberKey struct {
+	UserID    pgtype.UUID
+	PublicKey string
+	VaultKey  string
+	InviteKey string
+	ID        uint64
 }
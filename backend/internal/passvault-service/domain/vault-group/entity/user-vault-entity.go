@@ -2,8 +2,43 @@ package entity
 
 import "github.com/jackc/pgx/v5/pgtype"
 
+const (
+	RoleOwner  = "owner"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+type Permission uint8
+
+const (
+	// PermissionRead allow decrypting the vault
+	PermissionRead Permission = iota
+	// PermissionEdit allow creating, updating and deleting credentials of the vault
+	PermissionEdit
+	// PermissionManage allow renaming, deleting the vault and managing its members
+	PermissionManage
+)
+
 type UserVault struct {
-	UserID  pgtype.UUID
-	VaultID pgtype.UUID
-	ID      uint64
+	UserID     pgtype.UUID
+	VaultID    pgtype.UUID
+	CreatedAt  pgtype.Timestamptz
+	AcceptedAt pgtype.Timestamptz
+	Role       string
+	VaultKey   string
+	InviteKey  string
+	ID         uint64
+}
+
+// Can check whether the role of the member is granted the permission
+func (uv UserVault) Can(p Permission) bool {
+	switch uv.Role {
+	case RoleOwner:
+		return true
+	case RoleEditor:
+		return p <= PermissionEdit
+	case RoleViewer:
+		return p <= PermissionRead
+	}
+	return false
 }
@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"github.com/Novando/pintartek/internal/passvault-service/domain/vault-group/aggregate"
+	"github.com/Novando/pintartek/internal/passvault-service/domain/vault-group/entity"
+	"github.com/Novando/pintartek/pkg/common/structs"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/mock"
+	"testing"
+)
+
+type VaultGroupMock struct {
+	Mock mock.Mock
+}
+
+// NewMockVaultGroupRepository Initialize a mocked vault group repository, the expectations are asserted once the test end
+func NewMockVaultGroupRepository(t *testing.T) *VaultGroupMock {
+	r := &VaultGroupMock{}
+	r.Mock.Test(t)
+	t.Cleanup(func() { r.Mock.AssertExpectations(t) })
+	return r
+}
+
+func (r *VaultGroupMock) Create(arg CreateParam) error {
+	return r.Mock.Called(arg).Error(0)
+}
+
+func (r *VaultGroupMock) PermanentDelete(vaultID pgtype.UUID, id uint64) error {
+	return r.Mock.Called(vaultID, id).Error(0)
+}
+
+func (r *VaultGroupMock) GetAllVaultByUserID(userID pgtype.UUID, arg structs.StdPagination) ([]aggregate.VaultList, error) {
+	args := r.Mock.Called(userID, arg)
+	return args.Get(0).([]aggregate.VaultList), args.Error(1)
+}
+
+func (r *VaultGroupMock) GetAllDeletedVaultByUserID(userID pgtype.UUID) ([]aggregate.VaultList, error) {
+	args := r.Mock.Called(userID)
+	return args.Get(0).([]aggregate.VaultList), args.Error(1)
+}
+
+func (r *VaultGroupMock) GetAllMemberByVaultID(vaultID pgtype.UUID) ([]aggregate.VaultMember, error) {
+	args := r.Mock.Called(vaultID)
+	return args.Get(0).([]aggregate.VaultMember), args.Error(1)
+}
+
+func (r *VaultGroupMock) GetAllMemberKeyByVaultID(vaultID pgtype.UUID) ([]aggregate.VaultMemberKey, error) {
+	args := r.Mock.Called(vaultID)
+	return args.Get(0).([]aggregate.VaultMemberKey), args.Error(1)
+}
+
+func (r *VaultGroupMock) GetMember(userID pgtype.UUID, vaultID pgtype.UUID) (entity.UserVault, error) {
+	args := r.Mock.Called(userID, vaultID)
+	return args.Get(0).(entity.UserVault), args.Error(1)
+}
+
+func (r *VaultGroupMock) UpdateVaultKey(arg UpdateVaultKeyParam) error {
+	return r.Mock.Called(arg).Error(0)
+}
+
+func (r *VaultGroupMock) UpdateRole(arg UpdateRoleParam) error {
+	return r.Mock.Called(arg).Error(0)
+}
@@ -3,6 +3,9 @@ package repository
 import (
 	"context"
 	"github.com/Novando/pintartek/internal/passvault-service/domain/vault-group/aggregate"
+	"github.com/Novando/pintartek/internal/passvault-service/domain/vault-group/entity"
+	vaultRepo "github.com/Novando/pintartek/internal/passvault-service/domain/vault/repository"
+	"github.com/Novando/pintartek/pkg/common/consts"
 	"github.com/Novando/pintartek/pkg/common/structs"
 	"github.com/Novando/pintartek/pkg/postgresql/pgx"
 	"github.com/jackc/pgx/v5/pgtype"
@@ -28,22 +31,66 @@ func NewPostgresVaultGroupRepository(
 }
 
 const createPostgresVaultGroup = `-- name: Create user-vault pivot relation :exec
-	INSERT INTO user_vault_pivots(user_id, vault_id)
-	VALUES ($1::uuid, $2::uuid)
+	INSERT INTO user_vault_pivots(user_id, vault_id, role, vault_key, invite_key, accepted_at, created_at)
+	VALUES (
+		$1::uuid,
+		$2::uuid,
+		$3::varchar,
+		$4::varchar,
+		$5::varchar,
+		CASE WHEN $4::varchar = '' THEN NULL ELSE NOW() END,
+		NOW()
+	)
 `
 
+// Create add a member to a vault. A member created without a vault key
+// is a pending invitation, until the vault key is set by UpdateVaultKey.
+// consts.ErrConflict is returned when the vault is no longer at the seal version
 func (r *PostgresVaultGroup) Create(arg CreateParam) error {
-	_, err := r.db.Exec(r.ctx, createPostgresVaultGroup, arg.UserID, arg.VaultID)
-	return err
+	tx, err := r.db.Begin(r.ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(r.ctx)
+	if err = vaultRepo.LockSealVersion(r.ctx, tx, arg.VaultID, arg.SealVersion); err != nil {
+		return err
+	}
+	_, err = tx.Exec(r.ctx, createPostgresVaultGroup,
+		arg.UserID,
+		arg.VaultID,
+		arg.Role,
+		arg.VaultKey,
+		arg.InviteKey,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(r.ctx)
 }
 
-const permanentDeletePostgresVaultGroup = `-- name: Permanent delete a user-vault pivot relation :exec
-	DELETE FROM user_vault_pivots WHERE id = $1::int
+const permanentDeletePostgresVaultGroup = `-- name: Permanent delete a user-vault pivot relation :execrows
+	DELETE FROM user_vault_pivots WHERE vault_id = $1::uuid AND id = $2::bigint
 `
 
-func (r *PostgresVaultGroup) PermanentDelete(id uint64) error {
-	_, err := r.db.Exec(r.ctx, permanentDeletePostgresVaultGroup, id)
-	return err
+// PermanentDelete remove a membership of a vault. consts.ErrLastOwner is returned when it is the last owner,
+// and consts.ErrNoData when it is already removed
+func (r *PostgresVaultGroup) PermanentDelete(vaultID pgtype.UUID, id uint64) error {
+	tx, err := r.db.Begin(r.ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(r.ctx)
+	if err = vaultRepo.KeepOwner(r.ctx, tx, vaultID, id); err != nil {
+		return err
+	}
+	tag, err := tx.Exec(r.ctx, permanentDeletePostgresVaultGroup, vaultID, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() != 1 {
+		return consts.ErrNoData
+	}
+	return tx.Commit(r.ctx)
 }
 
 const getAllVaultByUserIDPostgresVaultGroup = `-- name: Get all vault by user ID :many
@@ -53,11 +100,14 @@ const getAllVaultByUserIDPostgresVaultGroup = `-- name: Get all vault by user ID
 		name, 
 		credential,
 		v.created_at AS created_at,
-		v.updated_at AS updated_at
+		v.updated_at AS updated_at,
+		uvp.accepted_at AS accepted_at,
+		uvp.role AS role,
+		uvp.vault_key = '' AND uvp.invite_key <> '' AND uvp.accepted_at IS NOT NULL AS key_replaced
 	FROM vaults v
 	LEFT JOIN user_vault_pivots uvp ON v.id = uvp.vault_id
 	LEFT JOIN users u ON uvp.user_id = u.id
-	WHERE u.id = $1::uuid
+	WHERE u.id = $1::uuid AND v.deleted_at IS NULL
 	LIMIT $2::int OFFSET $3::int
 `
 
@@ -83,6 +133,9 @@ func (r *PostgresVaultGroup) GetAllVaultByUserID(
 			&i.Credential,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.AcceptedAt,
+			&i.Role,
+			&i.KeyReplaced,
 		); err != nil {
 			return nil, err
 		}
@@ -93,3 +146,208 @@ func (r *PostgresVaultGroup) GetAllVaultByUserID(
 	}
 	return
 }
+
+const getAllDeletedVaultByUserIDPostgresVaultGroup = `-- name: Get all soft deleted vault by user ID :many
+	SELECT
+		v.id AS id,
+		uvp.user_id AS user_id,
+		name,
+		credential,
+		v.created_at AS created_at,
+		v.updated_at AS updated_at,
+		uvp.accepted_at AS accepted_at,
+		v.deleted_at AS deleted_at,
+		uvp.role AS role
+	FROM vaults v
+	JOIN user_vault_pivots uvp ON v.id = uvp.vault_id
+	WHERE uvp.user_id = $1::uuid AND uvp.accepted_at IS NOT NULL AND v.deleted_at IS NOT NULL
+	ORDER BY v.deleted_at DESC
+`
+
+// GetAllDeletedVaultByUserID retrieve the vaults in the trash that a user is a member of, the latest deleted first
+func (r *PostgresVaultGroup) GetAllDeletedVaultByUserID(userID pgtype.UUID) (data []aggregate.VaultList, err error) {
+	rows, err := r.db.Query(r.ctx, getAllDeletedVaultByUserIDPostgresVaultGroup, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var i aggregate.VaultList
+		if err = rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Name,
+			&i.Credential,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.AcceptedAt,
+			&i.DeletedAt,
+			&i.Role,
+		); err != nil {
+			return nil, err
+		}
+		data = append(data, i)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+const getAllMemberByVaultIDPostgresVaultGroup = `-- name: Get all member of a vault :many
+	SELECT uvp.id, uvp.user_id, u.email, uvp.role, uvp.created_at, uvp.accepted_at
+	FROM user_vault_pivots uvp
+	JOIN users u ON uvp.user_id = u.id
+	WHERE uvp.vault_id = $1::uuid AND u.deleted_at IS NULL
+	ORDER BY uvp.created_at
+`
+
+func (r *PostgresVaultGroup) GetAllMemberByVaultID(vaultID pgtype.UUID) (data []aggregate.VaultMember, err error) {
+	rows, err := r.db.Query(r.ctx, getAllMemberByVaultIDPostgresVaultGroup, vaultID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var i aggregate.VaultMember
+		if err = rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.Email,
+			&i.Role,
+			&i.CreatedAt,
+			&i.AcceptedAt,
+		); err != nil {
+			return nil, err
+		}
+		data = append(data, i)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+const getAllMemberKeyByVaultIDPostgresVaultGroup = `-- name: Get the vault key of every member of a vault :many
+	SELECT uvp.id, uvp.user_id, u.public_key, uvp.vault_key, uvp.invite_key
+	FROM user_vault_pivots uvp
+	JOIN users u ON uvp.user_id = u.id
+	WHERE uvp.vault_id = $1::uuid
+	ORDER BY uvp.created_at
+`
+
+// GetAllMemberKeyByVaultID retrieve the vault key of every member of a vault, including the pending invitations
+// and the members whose account is deleted
+func (r *PostgresVaultGroup) GetAllMemberKeyByVaultID(vaultID pgtype.UUID) (data []aggregate.VaultMemberKey, err error) {
+	rows, err := r.db.Query(r.ctx, getAllMemberKeyByVaultIDPostgresVaultGroup, vaultID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var i aggregate.VaultMemberKey
+		if err = rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.PublicKey,
+			&i.VaultKey,
+			&i.InviteKey,
+		); err != nil {
+			return nil, err
+		}
+		data = append(data, i)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+const getMemberPostgresVaultGroup = `-- name: Get membership of a user in a vault :one
+	SELECT id, user_id, vault_id, role, vault_key, invite_key, created_at, accepted_at
+	FROM user_vault_pivots
+	WHERE user_id = $1::uuid AND vault_id = $2::uuid
+`
+
+func (r *PostgresVaultGroup) GetMember(userID pgtype.UUID, vaultID pgtype.UUID) (data entity.UserVault, err error) {
+	row := r.db.QueryRow(r.ctx, getMemberPostgresVaultGroup, userID, vaultID)
+	err = row.Scan(
+		&data.ID,
+		&data.UserID,
+		&data.VaultID,
+		&data.Role,
+		&data.VaultKey,
+		&data.InviteKey,
+		&data.CreatedAt,
+		&data.AcceptedAt,
+	)
+	if err != nil && err.Error() == pgx.ErrNoRows() {
+		err = consts.ErrNoData
+	}
+	return
+}
+
+const updateVaultKeyPostgresVaultGroup = `-- name: Update the wrapped vault key of a member :exec
+	UPDATE user_vault_pivots SET
+		vault_key = $1::varchar,
+		invite_key = '',
+		accepted_at = COALESCE(accepted_at, NOW())
+	WHERE id = $2::bigint
+`
+
+const updateCredentialPostgresVaultGroup = `-- name: Update vault credential encrypted by the new vault key :exec
+	UPDATE vaults SET
+		credential = $1::varchar,
+		updated_at = NOW()
+	WHERE id = $2::uuid
+`
+
+// UpdateVaultKey set the wrapped vault key of a member, which also accept a pending invitation.
+// When `Credential` is given, the vault is re-encrypted within the same transaction.
+// consts.ErrConflict is returned when the vault is no longer at the seal version
+func (r *PostgresVaultGroup) UpdateVaultKey(arg UpdateVaultKeyParam) error {
+	tx, err := r.db.Begin(r.ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(r.ctx)
+	if err = vaultRepo.LockSealVersion(r.ctx, tx, arg.VaultID, arg.SealVersion); err != nil {
+		return err
+	}
+	if _, err = tx.Exec(r.ctx, updateVaultKeyPostgresVaultGroup, arg.VaultKey, arg.ID); err != nil {
+		return err
+	}
+	if arg.Credential != "" {
+		if _, err = tx.Exec(r.ctx, updateCredentialPostgresVaultGroup, arg.Credential, arg.VaultID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(r.ctx)
+}
+
+const updateRolePostgresVaultGroup = `-- name: Update the role of a member :execrows
+	UPDATE user_vault_pivots SET role = $1::varchar WHERE vault_id = $2::uuid AND id = $3::bigint
+`
+
+// UpdateRole change the role of a membership of a vault. consts.ErrLastOwner is returned when the last owner
+// is demoted, and consts.ErrNoData when the membership is removed
+func (r *PostgresVaultGroup) UpdateRole(arg UpdateRoleParam) error {
+	tx, err := r.db.Begin(r.ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(r.ctx)
+	if arg.Role != entity.RoleOwner {
+		if err = vaultRepo.KeepOwner(r.ctx, tx, arg.VaultID, arg.ID); err != nil {
+			return err
+		}
+	}
+	tag, err := tx.Exec(r.ctx, updateRolePostgresVaultGroup, arg.Role, arg.VaultID, arg.ID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() != 1 {
+		return consts.ErrNoData
+	}
+	return tx.Commit(r.ctx)
+}
@@ -2,17 +2,44 @@ package repository
 
 import (
 	"github.com/Novando/pintartek/internal/passvault-service/domain/vault-group/aggregate"
+	"github.com/Novando/pintartek/internal/passvault-service/domain/vault-group/entity"
 	"github.com/Novando/pintartek/pkg/common/structs"
 	"github.com/jackc/pgx/v5/pgtype"
 )
 
-type CreateParam struct {
-	UserID  pgtype.UUID
-	VaultID pgtype.UUID
-}
+type (
+	CreateParam struct {
+		UserID    pgtype.UUID
+		VaultID   pgtype.UUID
+		Role      string
+		VaultKey  string
+		InviteKey string
+		// SealVersion is the seal version of the vault the key belongs to
+		SealVersion int32
+	}
+	UpdateVaultKeyParam struct {
+		ID         uint64
+		VaultID    pgtype.UUID
+		VaultKey   string
+		Credential string
+		// SealVersion is the seal version of the vault the key belongs to
+		SealVersion int32
+	}
+	UpdateRoleParam struct {
+		ID      uint64
+		VaultID pgtype.UUID
+		Role    string
+	}
+)
 
 type VaultGroup interface {
 	Create(arg CreateParam) error
-	PermanentDelete(id uint64) error
+	PermanentDelete(vaultID pgtype.UUID, id uint64) error
 	GetAllVaultByUserID(userID pgtype.UUID, arg structs.StdPagination) ([]aggregate.VaultList, error)
+	GetAllDeletedVaultByUserID(userID pgtype.UUID) ([]aggregate.VaultList, error)
+	GetAllMemberByVaultID(vaultID pgtype.UUID) ([]aggregate.VaultMember, error)
+	GetAllMemberKeyByVaultID(vaultID pgtype.UUID) ([]aggregate.VaultMemberKey, error)
+	GetMember(userID pgtype.UUID, vaultID pgtype.UUID) (entity.UserVault, error)
+	UpdateVaultKey(arg UpdateVaultKeyParam) error
+	UpdateRole(arg UpdateRoleParam) error
 }
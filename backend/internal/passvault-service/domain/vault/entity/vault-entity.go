@@ -3,9 +3,21 @@ package entity
 import "github.com/jackc/pgx/v5/pgtype"
 
 type Vault struct {
-	ID         pgtype.UUID
-	CreatedAt  pgtype.Timestamptz
-	UpdatedAt  pgtype.Timestamptz
+	ID        pgtype.UUID
+	OwnerID   pgtype.UUID
+	CreatedAt pgtype.Timestamptz
+	UpdatedAt pgtype.Timestamptz
+	// DeletedAt is set while the vault is in the trash
+	DeletedAt pgtype.Timestamptz
+	// Credential is the encrypted JSON map holding every credential of a vault created before
+	// the credentials had their own rows, it is empty once the credentials are imported
 	Credential string
 	Name       string
+	// Revision is the number of the current credential, the replaced ones are kept as VaultRevision
+	Revision int32
+	// SealVersion is incremented each time every encrypted value of the vault is sealed again
+	SealVersion int32
+	// SealedOwnerID is the owner the values sealed before seal version 1 are bound to, it is kept
+	// as the vault is moved to another owner when its owner is deleted
+	SealedOwnerID pgtype.UUID
 }
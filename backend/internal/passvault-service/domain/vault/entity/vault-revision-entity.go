@@ -0,0 +1,14 @@
+package entity
+
+import "github.com/jackc/pgx/v5/pgtype"
+
+// VaultRevision is the encrypted credential a vault had before it was replaced at ReplacedAt
+type VaultRevision struct {
+	VaultID pgtype.UUID
+	// CredentialID is set when the revision is the previous version of a single credential,
+	// otherwise the revision holds every credential of the vault
+	CredentialID pgtype.UUID
+	Revision     int32
+	Credential   string
+	ReplacedAt   pgtype.Timestamptz
+}
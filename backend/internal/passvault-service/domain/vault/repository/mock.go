@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"github.com/Novando/pintartek/internal/passvault-service/domain/vault/entity"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/mock"
+	"testing"
+	"time"
+)
+
+type VaultMock struct {
+	Mock mock.Mock
+}
+
+// NewMockVaultRepository Initialize a mocked vault repository, the expectations are asserted once the test end
+func NewMockVaultRepository(t *testing.T) *VaultMock {
+	r := &VaultMock{}
+	r.Mock.Test(t)
+	t.Cleanup(func() { r.Mock.AssertExpectations(t) })
+	return r
+}
+
+func (r *VaultMock) Create(arg UpsertParam) (id pgtype.UUID, err error) {
+	args := r.Mock.Called(arg)
+	return args.Get(0).(pgtype.UUID), args.Error(1)
+}
+
+func (r *VaultMock) GetByID(id pgtype.UUID) (data entity.Vault, err error) {
+	args := r.Mock.Called(id)
+	return args.Get(0).(entity.Vault), args.Error(1)
+}
+
+func (r *VaultMock) UpdateName(id pgtype.UUID, name string) error {
+	return r.Mock.Called(id, name).Error(0)
+}
+
+func (r *VaultMock) GetAllRevision(id pgtype.UUID) ([]entity.VaultRevision, error) {
+	args := r.Mock.Called(id)
+	return args.Get(0).([]entity.VaultRevision), args.Error(1)
+}
+
+func (r *VaultMock) GetRevision(id pgtype.UUID, revision int32) (entity.VaultRevision, error) {
+	args := r.Mock.Called(id, revision)
+	return args.Get(0).(entity.VaultRevision), args.Error(1)
+}
+
+func (r *VaultMock) Reseal(arg ResealParam) error {
+	return r.Mock.Called(arg).Error(0)
+}
+
+func (r *VaultMock) Rekey(arg RekeyParam) error {
+	return r.Mock.Called(arg).Error(0)
+}
+
+func (r *VaultMock) Delete(id pgtype.UUID) error {
+	return r.Mock.Called(id).Error(0)
+}
+
+func (r *VaultMock) Restore(id pgtype.UUID) error {
+	return r.Mock.Called(id).Error(0)
+}
+
+func (r *VaultMock) PermanentDelete(id pgtype.UUID) error {
+	return r.Mock.Called(id).Error(0)
+}
+
+func (r *VaultMock) PurgeDeleted(before time.Time) (int64, error) {
+	args := r.Mock.Called(before)
+	return args.Get(0).(int64), args.Error(1)
+}
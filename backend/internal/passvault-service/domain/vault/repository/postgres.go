@@ -3,11 +3,16 @@ package repository
 import (
 	"context"
 	"github.com/Novando/pintartek/internal/passvault-service/domain/vault/entity"
+	"github.com/Novando/pintartek/pkg/common/consts"
 	"github.com/Novando/pintartek/pkg/postgresql/pgx"
 	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
 )
 
+// MaxRevisions is the number of replaced credentials kept for each vault
+const MaxRevisions = 50
+
 type PostgresVault struct {
 	ctx   context.Context
 	query *pgx.Queries
@@ -27,22 +32,52 @@ func NewPostgresVaultRepository(
 }
 
 const createPostgresVault = `-- name: Create vault :one
-	INSERT INTO vaults(name, credential, created_at, updated_at)
-	VALUES ($1::varchar, $2::varchar, NOW(), NOW())
+	INSERT INTO vaults(id, owner_id, name, credential, seal_version, created_at, updated_at)
+	VALUES ($1::uuid, $2::uuid, $3::varchar, $4::varchar, $5::int, NOW(), NOW())
 	RETURNING id
 `
 
+const createOwnerPostgresVault = `-- name: Add the owner of a new vault :exec
+	INSERT INTO user_vault_pivots(user_id, vault_id, role, vault_key, accepted_at, created_at)
+	VALUES ($1::uuid, $2::uuid, 'owner', $3::varchar, NOW(), NOW())
+`
+
+const createCredentialPostgresVault = `-- name: Add a credential to a new vault :exec
+	INSERT INTO credentials (vault_id, id, data)
+	VALUES ($1::uuid, $2::uuid, $3::text)
+`
+
+// Create add a vault together with its owner and its credentials, so a vault is never left without them
 func (r *PostgresVault) Create(arg UpsertParam) (id pgtype.UUID, err error) {
-	row := r.db.QueryRow(r.ctx, createPostgresVault,
+	tx, err := r.db.Begin(r.ctx)
+	if err != nil {
+		return
+	}
+	defer tx.Rollback(r.ctx)
+	row := tx.QueryRow(r.ctx, createPostgresVault,
+		arg.ID,
+		arg.OwnerID,
 		arg.Name,
 		arg.Credential,
+		arg.SealVersion,
 	)
-	err = row.Scan(&id)
+	if err = row.Scan(&id); err != nil {
+		return
+	}
+	if _, err = tx.Exec(r.ctx, createOwnerPostgresVault, arg.OwnerID, id, arg.OwnerKey); err != nil {
+		return
+	}
+	for credentialId, data := range arg.Credentials {
+		if _, err = tx.Exec(r.ctx, createCredentialPostgresVault, id, credentialId, data); err != nil {
+			return
+		}
+	}
+	err = tx.Commit(r.ctx)
 	return
 }
 
 const getByIDPostgresVault = `-- name: Get vault by the ID :one
-	SELECT id, name, credential, created_at, updated_at
+	SELECT id, owner_id, name, credential, created_at, updated_at, revision, deleted_at, seal_version, sealed_owner_id
 	FROM vaults
 	WHERE id = $1::uuid
 `
@@ -51,11 +86,19 @@ func (r *PostgresVault) GetByID(id pgtype.UUID) (data entity.Vault, err error) {
 	row := r.db.QueryRow(r.ctx, getByIDPostgresVault, id)
 	err = row.Scan(
 		&data.ID,
+		&data.OwnerID,
 		&data.Name,
 		&data.Credential,
 		&data.CreatedAt,
 		&data.UpdatedAt,
+		&data.Revision,
+		&data.DeletedAt,
+		&data.SealVersion,
+		&data.SealedOwnerID,
 	)
+	if err != nil && err.Error() == pgx.ErrNoRows() {
+		err = consts.ErrNoData
+	}
 	return
 }
 
@@ -71,15 +114,314 @@ func (r *PostgresVault) UpdateName(id pgtype.UUID, name string) error {
 	return err
 }
 
-const updateCredentialPostgresVault = `-- name: Update vault data :exec
+const lockRevisionPostgresVault = `-- name: Get the revision of a vault for update :one
+	SELECT revision, seal_version FROM vaults WHERE id = $1::uuid FOR UPDATE
+`
+
+const createRevisionPostgresVault = `-- name: Create vault revision :exec
+	INSERT INTO vault_revisions (vault_id, revision, credential, credential_id)
+	VALUES ($1::uuid, $2::int, $3::text, $4::uuid)
+`
+
+const pruneRevisionPostgresVault = `-- name: Delete the oldest revisions of a vault :exec
+	DELETE FROM vault_revisions WHERE vault_id = $1::uuid AND revision <= $2::int
+`
+
+const incrementRevisionPostgresVault = `-- name: Increment the revision of a vault :exec
 	UPDATE vaults SET
-		credential = $1::varchar,
+		revision = revision + 1,
 		updated_at = NOW()
-	WHERE id = $2::uuid
+	WHERE id = $1::uuid
 `
 
-func (r *PostgresVault) UpdateCredential(id pgtype.UUID, credential string) error {
-	_, err := r.db.Exec(r.ctx, updateCredentialPostgresVault, credential, id)
+// ArchiveRevision keep the encrypted credentials a vault has before they are replaced, numbered after
+// the current revision of the vault. Only the latest MaxRevisions revisions are kept. It runs within
+// the transaction `tx` replacing the credentials, so the revision is only kept when they are replaced.
+// The vault is locked until `tx` ends, which also prevent it from being sealed again like LockSealVersion
+func ArchiveRevision(ctx context.Context, tx pgx.DBTX, arg CreateRevisionParam) error {
+	var revision, sealVersion int32
+	if err := tx.QueryRow(ctx, lockRevisionPostgresVault, arg.ID).Scan(&revision, &sealVersion); err != nil {
+		if err.Error() == pgx.ErrNoRows() {
+			err = consts.ErrNoData
+		}
+		return err
+	}
+	if sealVersion != arg.SealVersion {
+		return consts.ErrConflict
+	}
+	_, err := tx.Exec(ctx, createRevisionPostgresVault, arg.ID, revision, arg.Credential, arg.CredentialID)
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec(ctx, pruneRevisionPostgresVault, arg.ID, revision-MaxRevisions); err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, incrementRevisionPostgresVault, arg.ID)
+	return err
+}
+
+const getAllRevisionPostgresVault = `-- name: Get all revision of a vault :many
+	SELECT vault_id, credential_id, revision, credential, replaced_at
+	FROM vault_revisions
+	WHERE vault_id = $1::uuid
+	ORDER BY revision DESC
+`
+
+// GetAllRevision retrieve the revisions of a vault, the latest first
+func (r *PostgresVault) GetAllRevision(id pgtype.UUID) (data []entity.VaultRevision, err error) {
+	rows, err := r.db.Query(r.ctx, getAllRevisionPostgresVault, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var i entity.VaultRevision
+		if err = rows.Scan(&i.VaultID, &i.CredentialID, &i.Revision, &i.Credential, &i.ReplacedAt); err != nil {
+			return nil, err
+		}
+		data = append(data, i)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+const getRevisionPostgresVault = `-- name: Get a revision of a vault :one
+	SELECT vault_id, credential_id, revision, credential, replaced_at
+	FROM vault_revisions
+	WHERE vault_id = $1::uuid AND revision = $2::int
+`
+
+func (r *PostgresVault) GetRevision(id pgtype.UUID, revision int32) (data entity.VaultRevision, err error) {
+	err = r.db.QueryRow(r.ctx, getRevisionPostgresVault, id, revision).Scan(
+		&data.VaultID,
+		&data.CredentialID,
+		&data.Revision,
+		&data.Credential,
+		&data.ReplacedAt,
+	)
+	if err != nil && err.Error() == pgx.ErrNoRows() {
+		err = consts.ErrNoData
+	}
+	return
+}
+
+const resealCredentialPostgresVault = `-- name: Replace the sealed credential blob of a vault :exec
+	UPDATE vaults SET credential = $3::text WHERE id = $1::uuid AND credential = $2::text
+`
+
+const resealCredentialsPostgresVault = `-- name: Replace a sealed credential of a vault :exec
+	UPDATE credentials SET data = $4::text WHERE vault_id = $1::uuid AND id = $2::uuid AND data = $3::text
+`
+
+const resealRevisionPostgresVault = `-- name: Replace a sealed revision of a vault :exec
+	UPDATE vault_revisions SET credential = $4::text
+	WHERE vault_id = $1::uuid AND revision = $2::int AND credential = $3::text
+`
+
+const resealHistoryPostgresVault = `-- name: Replace a sealed credential history of a vault :exec
+	UPDATE credential_histories SET password = $4::text
+	WHERE vault_id = $1::uuid AND id = $2::bigint AND password = $3::text
+`
+
+const resealVersionPostgresVault = `-- name: Increment the seal version of a vault :execrows
+	UPDATE vaults SET seal_version = $2::int + 1 WHERE id = $1::uuid AND seal_version = $2::int
+`
+
+// Reseal replace every encrypted value of a vault at once. Nothing is done when a concurrent request
+// already sealed the vault again
+func (r *PostgresVault) Reseal(arg ResealParam) error {
+	tx, err := r.db.Begin(r.ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(r.ctx)
+	tag, err := tx.Exec(r.ctx, resealVersionPostgresVault, arg.ID, arg.SealVersion)
+	if err != nil || tag.RowsAffected() == 0 {
+		return err
+	}
+	if arg.Credential.Old != "" {
+		_, err = tx.Exec(r.ctx, resealCredentialPostgresVault, arg.ID, arg.Credential.Old, arg.Credential.New)
+		if err != nil {
+			return err
+		}
+	}
+	for id, value := range arg.Credentials {
+		if _, err = tx.Exec(r.ctx, resealCredentialsPostgresVault, arg.ID, id, value.Old, value.New); err != nil {
+			return err
+		}
+	}
+	for revision, value := range arg.Revisions {
+		if _, err = tx.Exec(r.ctx, resealRevisionPostgresVault, arg.ID, revision, value.Old, value.New); err != nil {
+			return err
+		}
+	}
+	for id, value := range arg.Histories {
+		if _, err = tx.Exec(r.ctx, resealHistoryPostgresVault, arg.ID, id, value.Old, value.New); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(r.ctx)
+}
+
+const rekeyVersionPostgresVault = `-- name: Increment the seal version of a vault without credential blob :execrows
+	UPDATE vaults SET seal_version = $2::int + 1 WHERE id = $1::uuid AND seal_version = $2::int AND credential = ''
+`
+
+const rekeyMemberPostgresVault = `-- name: Replace the vault key of a member :execrows
+	UPDATE user_vault_pivots SET vault_key = $3::varchar, invite_key = $4::varchar
+	WHERE vault_id = $1::uuid AND id = $2::bigint
+`
+
+const removeMemberPostgresVault = `-- name: Remove a member of a vault :execrows
+	DELETE FROM user_vault_pivots WHERE vault_id = $1::uuid AND id = $2::bigint
+`
+
+const countSealedPostgresVault = `-- name: Count the encrypted values of a vault :one
+	SELECT
+		(SELECT COUNT(*) FROM credentials WHERE vault_id = $1::uuid),
+		(SELECT COUNT(*) FROM vault_revisions WHERE vault_id = $1::uuid),
+		(SELECT COUNT(*) FROM credential_histories WHERE vault_id = $1::uuid),
+		(SELECT COUNT(*) FROM user_vault_pivots WHERE vault_id = $1::uuid)
+`
+
+// Rekey replace every encrypted value and the vault key of every member at once. The vault is locked first,
+// so the writes sealed using the replaced vault key either end before, or fail with consts.ErrConflict
+func (r *PostgresVault) Rekey(arg RekeyParam) error {
+	tx, err := r.db.Begin(r.ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(r.ctx)
+	// every statement must change a single row, otherwise the value changed since it was read
+	exec := func(sql string, args ...interface{}) error {
+		tag, err := tx.Exec(r.ctx, sql, args...)
+		if err == nil && tag.RowsAffected() != 1 {
+			err = consts.ErrConflict
+		}
+		return err
+	}
+	if err = exec(rekeyVersionPostgresVault, arg.ID, arg.SealVersion); err != nil {
+		return err
+	}
+	for id, value := range arg.Credentials {
+		if err = exec(resealCredentialsPostgresVault, arg.ID, id, value.Old, value.New); err != nil {
+			return err
+		}
+	}
+	for revision, value := range arg.Revisions {
+		if err = exec(resealRevisionPostgresVault, arg.ID, revision, value.Old, value.New); err != nil {
+			return err
+		}
+	}
+	for id, value := range arg.Histories {
+		if err = exec(resealHistoryPostgresVault, arg.ID, id, value.Old, value.New); err != nil {
+			return err
+		}
+	}
+	if err = KeepOwner(r.ctx, tx, arg.ID, arg.RemovedMemberID); err != nil {
+		return err
+	}
+	if err = exec(removeMemberPostgresVault, arg.ID, arg.RemovedMemberID); err != nil {
+		return err
+	}
+	for id, key := range arg.Members {
+		if err = exec(rekeyMemberPostgresVault, arg.ID, id, key.VaultKey, key.InviteKey); err != nil {
+			return err
+		}
+	}
+	// a value created since the values were read is not sealed using the new vault key
+	var credentials, revisions, histories, members int
+	if err = tx.QueryRow(r.ctx, countSealedPostgresVault, arg.ID).Scan(
+		&credentials,
+		&revisions,
+		&histories,
+		&members,
+	); err != nil {
+		return err
+	}
+	if credentials != len(arg.Credentials) ||
+		revisions != len(arg.Revisions) ||
+		histories != len(arg.Histories) ||
+		members != len(arg.Members) {
+		return consts.ErrConflict
+	}
+	return tx.Commit(r.ctx)
+}
+
+const lockSealVersionPostgresVault = `-- name: Lock the seal version of a vault :one
+	SELECT seal_version FROM vaults WHERE id = $1::uuid FOR SHARE
+`
+
+// LockSealVersion prevent a vault from being sealed again until the transaction `tx` ends. consts.ErrConflict
+// is returned when the vault is no longer at `sealVersion`, as the values written within `tx` are sealed
+// using the vault key of that version
+func LockSealVersion(ctx context.Context, tx pgx.DBTX, vaultID pgtype.UUID, sealVersion int32) error {
+	var current int32
+	if err := tx.QueryRow(ctx, lockSealVersionPostgresVault, vaultID).Scan(&current); err != nil {
+		if err.Error() == pgx.ErrNoRows() {
+			err = consts.ErrNoData
+		}
+		return err
+	}
+	if current != sealVersion {
+		return consts.ErrConflict
+	}
+	return nil
+}
+
+const lockOwnersPostgresVault = `-- name: Lock the owners of a vault :many
+	SELECT id FROM user_vault_pivots
+	WHERE vault_id = $1::uuid AND role = 'owner' AND accepted_at IS NOT NULL
+	FOR UPDATE
+`
+
+// KeepOwner prevent the membership `memberID` from being removed or demoted while it is the last owner
+// of a vault, returning consts.ErrLastOwner. The owners stay locked until the transaction `tx` ends,
+// so two owners are never removed or demoted at once
+func KeepOwner(ctx context.Context, tx pgx.DBTX, vaultID pgtype.UUID, memberID uint64) error {
+	rows, err := tx.Query(ctx, lockOwnersPostgresVault, vaultID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var owners int
+	var isOwner bool
+	for rows.Next() {
+		var id uint64
+		if err = rows.Scan(&id); err != nil {
+			return err
+		}
+		owners++
+		isOwner = isOwner || id == memberID
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	if isOwner && owners <= 1 {
+		return consts.ErrLastOwner
+	}
+	return nil
+}
+
+const deletePostgresVault = `-- name: Soft delete a vault :exec
+	UPDATE vaults SET deleted_at = NOW() WHERE id = $1::uuid AND deleted_at IS NULL
+`
+
+// Delete move a vault to the trash
+func (r *PostgresVault) Delete(id pgtype.UUID) error {
+	_, err := r.db.Exec(r.ctx, deletePostgresVault, id)
+	return err
+}
+
+const restorePostgresVault = `-- name: Restore a soft deleted vault :exec
+	UPDATE vaults SET deleted_at = NULL WHERE id = $1::uuid
+`
+
+// Restore take a vault out of the trash
+func (r *PostgresVault) Restore(id pgtype.UUID) error {
+	_, err := r.db.Exec(r.ctx, restorePostgresVault, id)
 	return err
 }
 
@@ -91,3 +433,29 @@ func (r *PostgresVault) PermanentDelete(id pgtype.UUID) error {
 	_, err := r.db.Exec(r.ctx, permanentDeletePostgresVault, id)
 	return err
 }
+
+const purgeDeletedPostgresVault = `-- name: Permanent delete the vaults soft deleted before a time :execrows
+	DELETE FROM vaults WHERE deleted_at IS NOT NULL AND deleted_at < $1::timestamptz
+`
+
+// PurgeDeleted permanently delete the vaults in the trash since before a time, together with their members.
+// Nothing is done when another replica is purging them
+func (r *PostgresVault) PurgeDeleted(before time.Time) (int64, error) {
+	tx, err := r.db.Begin(r.ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(r.ctx)
+	locked, err := pgx.TryAdvisoryXactLock(r.ctx, tx, "maintenance:vaults")
+	if err != nil || !locked {
+		return 0, err
+	}
+	tag, err := tx.Exec(r.ctx, purgeDeletedPostgresVault, pgtype.Timestamptz{Time: before, Valid: true})
+	if err != nil {
+		return 0, err
+	}
+	if err = tx.Commit(r.ctx); err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -3,17 +3,77 @@ package repository
 import (
 	"github.com/Novando/pintartek/internal/passvault-service/domain/vault/entity"
 	"github.com/jackc/pgx/v5/pgtype"
+	"time"
 )
 
 type UpsertParam struct {
-	Credential string
-	Name       string
+	ID          pgtype.UUID
+	OwnerID     pgtype.UUID
+	Credential  string
+	Name        string
+	SealVersion int32
+	// OwnerKey is the vault key wrapped for the owner, who is added as a member within the same transaction
+	OwnerKey string
+	// Credentials are the encrypted credentials by their ID, added within the same transaction
+	Credentials map[pgtype.UUID]string
+}
+
+// CreateRevisionParam keep the encrypted credential a vault had, sealed at SealVersion. CredentialID is set
+// when only that credential is replaced, an invalid one keeps every credential of the vault
+type CreateRevisionParam struct {
+	ID           pgtype.UUID
+	CredentialID pgtype.UUID
+	SealVersion  int32
+	Credential   string
+}
+
+// SealedValue replace the encrypted value Old of a row by New
+type SealedValue struct {
+	Old string
+	New string
+}
+
+// ResealParam replace the encrypted values of a vault sealed at SealVersion, the vault is then at SealVersion+1.
+// A value that changed since it was read is left alone, as it is already sealed by the current version
+type ResealParam struct {
+	ID          pgtype.UUID
+	SealVersion int32
+	Credential  SealedValue
+	Credentials map[pgtype.UUID]SealedValue
+	Revisions   map[int32]SealedValue
+	Histories   map[int64]SealedValue
+}
+
+// MemberKey is the vault key of a member, either wrapped for the member or sealed to the public key of the member
+type MemberKey struct {
+	VaultKey  string
+	InviteKey string
+}
+
+// RekeyParam replace every encrypted value of a vault sealed at SealVersion by the one sealed using a new vault key,
+// the vault is then at SealVersion+1. consts.ErrConflict is returned when any value changed since it was read
+type RekeyParam struct {
+	ID          pgtype.UUID
+	SealVersion int32
+	Credentials map[pgtype.UUID]SealedValue
+	Revisions   map[int32]SealedValue
+	Histories   map[int64]SealedValue
+	// Members is the new vault key of every remaining member by the ID of the membership
+	Members map[uint64]MemberKey
+	// RemovedMemberID is the membership removed together with the vault key
+	RemovedMemberID uint64
 }
 
 type Vault interface {
 	Create(arg UpsertParam) (id pgtype.UUID, err error)
 	GetByID(id pgtype.UUID) (data entity.Vault, err error)
 	UpdateName(id pgtype.UUID, name string) error
-	UpdateCredential(id pgtype.UUID, credential string) error
+	GetAllRevision(id pgtype.UUID) ([]entity.VaultRevision, error)
+	GetRevision(id pgtype.UUID, revision int32) (entity.VaultRevision, error)
+	Reseal(arg ResealParam) error
+	Rekey(arg RekeyParam) error
+	Delete(id pgtype.UUID) error
+	Restore(id pgtype.UUID) error
 	PermanentDelete(id pgtype.UUID) error
+	PurgeDeleted(before time.Time) (int64, error)
 }
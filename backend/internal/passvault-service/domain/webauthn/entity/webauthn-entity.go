@@ -0,0 +1,21 @@
+package entity
+
+import "github.com/jackc/pgx/v5/pgtype"
+
+// Ceremony of a pending challenge
+const (
+	CeremonyRegister = "register"
+	CeremonyLogin    = "login"
+)
+
+// Credential is a registered authenticator, binary values are base64url encoded
+type Credential struct {
+	UserID       pgtype.UUID
+	CreatedAt    pgtype.Timestamptz
+	LastUsedAt   pgtype.Timestamptz
+	CredentialID string
+	PublicKey    string
+	Name         string
+	SignCount    int64
+	ID           uint64
+}
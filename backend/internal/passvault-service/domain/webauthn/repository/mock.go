@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"github.com/Novando/pintartek/internal/passvault-service/domain/webauthn/entity"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/stretchr/testify/mock"
+	"testing"
+	"time"
+)
+
+type WebauthnMock struct {
+	Mock mock.Mock
+}
+
+// NewMockWebauthnRepository Initialize a mocked WebAuthn repository, the expectations are asserted once the test end
+func NewMockWebauthnRepository(t *testing.T) *WebauthnMock {
+	r := &WebauthnMock{}
+	r.Mock.Test(t)
+	t.Cleanup(func() { r.Mock.AssertExpectations(t) })
+	return r
+}
+
+func (r *WebauthnMock) Create(arg CreateParam) error {
+	return r.Mock.Called(arg).Error(0)
+}
+
+func (r *WebauthnMock) GetAllByUserID(userID pgtype.UUID) (data []entity.Credential, err error) {
+	args := r.Mock.Called(userID)
+	return args.Get(0).([]entity.Credential), args.Error(1)
+}
+
+func (r *WebauthnMock) GetByCredentialID(userID pgtype.UUID, credentialID string) (data entity.Credential, err error) {
+	args := r.Mock.Called(userID, credentialID)
+	return args.Get(0).(entity.Credential), args.Error(1)
+}
+
+func (r *WebauthnMock) UpdateSignCount(id uint64, signCount int64) error {
+	return r.Mock.Called(id, signCount).Error(0)
+}
+
+func (r *WebauthnMock) PermanentDelete(userID pgtype.UUID, id uint64) error {
+	return r.Mock.Called(userID, id).Error(0)
+}
+
+func (r *WebauthnMock) CreateChallenge(userID pgtype.UUID, ceremony string, challenge string, ttl time.Duration) error {
+	return r.Mock.Called(userID, ceremony, challenge, ttl).Error(0)
+}
+
+func (r *WebauthnMock) ConsumeChallenge(userID pgtype.UUID, ceremony string) (challenge string, err error) {
+	args := r.Mock.Called(userID, ceremony)
+	return args.String(0), args.Error(1)
+}
+
+func (r *WebauthnMock) PurgeExpiredChallenges() (int64, error) {
+	args := r.Mock.Called()
+	return args.Get(0).(int64), args.Error(1)
+}
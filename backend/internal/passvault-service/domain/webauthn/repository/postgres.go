@@ -0,0 +1,194 @@
+package repository
+
+import (
+	"context"
+	"github.com/Novando/pintartek/internal/passvault-service/domain/webauthn/entity"
+	"github.com/Novando/pintartek/pkg/common/consts"
+	"github.com/Novando/pintartek/pkg/postgresql/pgx"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+)
+
+type PostgresWebauthn struct {
+	ctx   context.Context
+	query *pgx.Queries
+	db    *pgxpool.Pool
+}
+
+func NewPostgresWebauthnRepository(
+	c context.Context,
+	q *pgx.Queries,
+	db *pgxpool.Pool,
+) *PostgresWebauthn {
+	return &PostgresWebauthn{
+		ctx:   c,
+		query: q,
+		db:    db,
+	}
+}
+
+const createPostgresWebauthn = `-- name: Create WebAuthn credential :exec
+	INSERT INTO webauthn_credentials (user_id, credential_id, public_key, name, sign_count, created_at)
+	VALUES ($1::uuid, $2::varchar, $3::text, $4::varchar, $5::bigint, NOW())
+`
+
+func (r *PostgresWebauthn) Create(arg CreateParam) error {
+	_, err := r.db.Exec(r.ctx, createPostgresWebauthn,
+		arg.UserID,
+		arg.CredentialID,
+		arg.PublicKey,
+		arg.Name,
+		arg.SignCount,
+	)
+	return err
+}
+
+const getAllByUserIDPostgresWebauthn = `-- name: Get all WebAuthn credential of a user :many
+	SELECT id, user_id, credential_id, public_key, name, sign_count, created_at, last_used_at
+	FROM webauthn_credentials
+	WHERE user_id = $1::uuid
+	ORDER BY id
+`
+
+func (r *PostgresWebauthn) GetAllByUserID(userID pgtype.UUID) (data []entity.Credential, err error) {
+	rows, err := r.db.Query(r.ctx, getAllByUserIDPostgresWebauthn, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var i entity.Credential
+		if err = rows.Scan(
+			&i.ID,
+			&i.UserID,
+			&i.CredentialID,
+			&i.PublicKey,
+			&i.Name,
+			&i.SignCount,
+			&i.CreatedAt,
+			&i.LastUsedAt,
+		); err != nil {
+			return nil, err
+		}
+		data = append(data, i)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+const getByCredentialIDPostgresWebauthn = `-- name: Get WebAuthn credential of a user by the credential ID :one
+	SELECT id, user_id, credential_id, public_key, name, sign_count, created_at, last_used_at
+	FROM webauthn_credentials
+	WHERE user_id = $1::uuid AND credential_id = $2::varchar
+`
+
+func (r *PostgresWebauthn) GetByCredentialID(userID pgtype.UUID, credentialID string) (data entity.Credential, err error) {
+	row := r.db.QueryRow(r.ctx, getByCredentialIDPostgresWebauthn, userID, credentialID)
+	err = row.Scan(
+		&data.ID,
+		&data.UserID,
+		&data.CredentialID,
+		&data.PublicKey,
+		&data.Name,
+		&data.SignCount,
+		&data.CreatedAt,
+		&data.LastUsedAt,
+	)
+	if err != nil && err.Error() == pgx.ErrNoRows() {
+		err = consts.ErrNoData
+	}
+	return
+}
+
+const updateSignCountPostgresWebauthn = `-- name: Update the signature counter of a WebAuthn credential :exec
+	UPDATE webauthn_credentials SET sign_count = $1::bigint, last_used_at = NOW() WHERE id = $2::bigint
+`
+
+func (r *PostgresWebauthn) UpdateSignCount(id uint64, signCount int64) error {
+	_, err := r.db.Exec(r.ctx, updateSignCountPostgresWebauthn, signCount, id)
+	return err
+}
+
+const permanentDeletePostgresWebauthn = `-- name: Permanent delete a WebAuthn credential of a user :exec
+	DELETE FROM webauthn_credentials WHERE user_id = $1::uuid AND id = $2::bigint
+`
+
+// PermanentDelete remove a credential of a user, a credential of another user result in consts.ErrNoData
+func (r *PostgresWebauthn) PermanentDelete(userID pgtype.UUID, id uint64) error {
+	tag, err := r.db.Exec(r.ctx, permanentDeletePostgresWebauthn, userID, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return consts.ErrNoData
+	}
+	return nil
+}
+
+const createChallengePostgresWebauthn = `-- name: Create WebAuthn challenge :exec
+	INSERT INTO webauthn_challenges (user_id, ceremony, challenge, expired_at)
+	VALUES ($1::uuid, $2::varchar, $3::varchar, $4)
+	ON CONFLICT (user_id, ceremony) DO UPDATE SET challenge = EXCLUDED.challenge, expired_at = EXCLUDED.expired_at
+`
+
+// CreateChallenge store the challenge of a ceremony, replacing the pending one of the same user
+func (r *PostgresWebauthn) CreateChallenge(userID pgtype.UUID, ceremony string, challenge string, ttl time.Duration) error {
+	expiry := time.Now().Add(ttl)
+	_, err := r.db.Exec(r.ctx, createChallengePostgresWebauthn,
+		userID,
+		ceremony,
+		challenge,
+		pgtype.Timestamptz{Time: expiry, Valid: true},
+	)
+	return err
+}
+
+const consumeChallengePostgresWebauthn = `-- name: Consume WebAuthn challenge :one
+	DELETE FROM webauthn_challenges
+	WHERE user_id = $1::uuid AND ceremony = $2::varchar
+	RETURNING challenge, expired_at >= NOW()
+`
+
+// ConsumeChallenge retrieve and delete the pending challenge of a ceremony, so it is only usable once.
+// A missing or expired challenge result in consts.ErrNoData
+func (r *PostgresWebauthn) ConsumeChallenge(userID pgtype.UUID, ceremony string) (challenge string, err error) {
+	var valid bool
+	row := r.db.QueryRow(r.ctx, consumeChallengePostgresWebauthn, userID, ceremony)
+	err = row.Scan(&challenge, &valid)
+	if err != nil && err.Error() == pgx.ErrNoRows() {
+		err = consts.ErrNoData
+	}
+	if err == nil && !valid {
+		challenge, err = "", consts.ErrNoData
+	}
+	return
+}
+
+const purgeExpiredChallengesPostgresWebauthn = `-- name: Permanent delete the expired challenges :execrows
+	DELETE FROM webauthn_challenges WHERE expired_at < NOW()
+`
+
+// PurgeExpiredChallenges delete the challenges of the ceremonies that were never finished,
+// nothing is done when another replica is purging them
+func (r *PostgresWebauthn) PurgeExpiredChallenges() (int64, error) {
+	tx, err := r.db.Begin(r.ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(r.ctx)
+	locked, err := pgx.TryAdvisoryXactLock(r.ctx, tx, "maintenance:challenges")
+	if err != nil || !locked {
+		return 0, err
+	}
+	tag, err := tx.Exec(r.ctx, purgeExpiredChallengesPostgresWebauthn)
+	if err != nil {
+		return 0, err
+	}
+	if err = tx.Commit(r.ctx); err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,26 @@
+package repository
+
+import (
+	"github.com/Novando/pintartek/internal/passvault-service/domain/webauthn/entity"
+	"github.com/jackc/pgx/v5/pgtype"
+	"time"
+)
+
+type CreateParam struct {
+	UserID       pgtype.UUID
+	CredentialID string
+	PublicKey    string
+	Name         string
+	SignCount    int64
+}
+
+type Webauthn interface {
+	Create(arg CreateParam) error
+	GetAllByUserID(userID pgtype.UUID) (data []entity.Credential, err error)
+	GetByCredentialID(userID pgtype.UUID, credentialID string) (data entity.Credential, err error)
+	UpdateSignCount(id uint64, signCount int64) error
+	PermanentDelete(userID pgtype.UUID, id uint64) error
+	CreateChallenge(userID pgtype.UUID, ceremony string, challenge string, ttl time.Duration) error
+	ConsumeChallenge(userID pgtype.UUID, ceremony string) (challenge string, err error)
+	PurgeExpiredChallenges() (int64, error)
+}
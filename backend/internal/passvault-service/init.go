@@ -2,48 +2,196 @@ package passvaultService
 
 import (
 	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
 	"github.com/Novando/pintartek/internal/passvault-service/app/controller/rest"
+	dtoAudit "github.com/Novando/pintartek/internal/passvault-service/app/dto/audit"
 	"github.com/Novando/pintartek/internal/passvault-service/app/service"
+	"github.com/Novando/pintartek/pkg/crypto"
 	"github.com/Novando/pintartek/pkg/logger"
 	"github.com/Novando/pintartek/pkg/postgresql/pgx"
 	"github.com/Novando/pintartek/pkg/redis"
+	"github.com/Novando/pintartek/pkg/webauthn"
 	"github.com/gofiber/fiber/v2"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/spf13/viper"
 )
 
+// InitPassvaultService register the routes of the passvault service, an error is returned when it is misconfigured
 func InitPassvaultService(
 	app fiber.Router,
 	db *pgx.Queries,
 	pool *pgxpool.Pool,
 	rds *redis.Redis,
 	log *logger.Logger,
-) {
+) error {
 	ctx := context.Background()
+	auditKey, err := InitAuditSigningKey()
+	if err != nil {
+		return err
+	}
 
-	su := service.NewUserService(
-		service.WithUserPostgres(ctx, db, pool, log),
-		service.WithUserRedis(rds),
-	)
-	sv := service.NewVaultService(
-		service.WithVaultPostgres(ctx, db, pool, log),
-		service.WithVaultRedis(rds),
+	srl := service.NewRateLimitService(
+		service.WithRateLimitRedis(rds, log),
+		service.WithRateLimitPolicy(service.RateLimitPolicy{
+			IPLimit:         viper.GetInt64("rateLimit.ipLimit"),
+			IPWindow:        viper.GetDuration("rateLimit.ipWindow"),
+			AccountLimit:    viper.GetInt64("rateLimit.accountLimit"),
+			AccountWindow:   viper.GetDuration("rateLimit.accountWindow"),
+			FreeFailures:    viper.GetInt64("rateLimit.freeFailures"),
+			FailureWindow:   viper.GetDuration("rateLimit.failureWindow"),
+			MaxDelay:        viper.GetDuration("rateLimit.maxDelay"),
+			MaxFailures:     viper.GetInt64("rateLimit.maxFailures"),
+			LockoutDuration: viper.GetDuration("rateLimit.lockoutDuration"),
+		}),
 	)
+	userCfgs := []service.UserConfig{
+		service.WithUserKDF(
+			viper.GetUint32("kdf.time"),
+			viper.GetUint32("kdf.memory"),
+			uint8(viper.GetUint("kdf.threads")),
+		),
+		service.WithUserSessionPolicy(service.SessionPolicy{
+			IdleTimeout:     viper.GetDuration("session.idleTimeout"),
+			MaxLifetime:     viper.GetDuration("session.maxLifetime"),
+			RememberTimeout: viper.GetDuration("session.rememberTimeout"),
+		}),
+		service.WithUserWebAuthn(webauthn.RelyingParty{
+			ID:     viper.GetString("webauthn.rpId"),
+			Name:   viper.GetString("webauthn.rpName"),
+			Origin: viper.GetString("webauthn.origin"),
+		}),
+		service.WithUserAuditKey(auditKey),
+		service.WithUserRateLimit(srl),
+	}
+	vaultCfgs := []service.VaultConfig{
+		service.WithVaultAuditKey(auditKey),
+		service.WithVaultTrashRetention(viper.GetDuration("maintenance.vaultRetention")),
+	}
+	var auditCfgs []service.AuditConfig
+	if rds != nil {
+		userCfgs = append(userCfgs, service.WithUserRedis(rds))
+		vaultCfgs = append(vaultCfgs, service.WithVaultRedis(rds))
+		auditCfgs = append(auditCfgs, service.WithAuditRedis(rds))
+	}
+	su := service.NewUserService(service.WithUserPostgres(ctx, db, pool, log), userCfgs...)
+	sv := service.NewVaultService(service.WithVaultPostgres(ctx, db, pool, log), vaultCfgs...)
+	sa := service.NewAuditService(service.WithAuditPostgres(ctx, db, pool, log), auditCfgs...)
 
 	cu := rest.NewUserRestController(su)
 	cv := rest.NewVaultRestController(sv)
+	crl := rest.NewRateLimitRestController(srl, su)
+	ca := rest.NewAuditRestController(sa)
 
 	user := app.Group("/user")
 	user.Get("/logout", cu.Logout)
-	user.Post("/register", cu.Register)
-	user.Post("/login", cu.Login)
+	user.Post("/register", crl.Protect, cu.Register)
+	user.Post("/login", crl.Protect, cu.Login)
+	user.Post("/login/mfa", crl.Protect, cu.LoginMfa)
+	user.Post("/login/mfa/webauthn", cu.BeginWebauthnLogin)
+	user.Post("/recover", crl.Protect, cu.Recover)
+	user.Put("/password", crl.Protect, cu.ChangePassword)
+	user.Get("/sessions", cu.GetAllSession)
+	user.Delete("/sessions", cu.DeleteAllSession)
+	user.Delete("/sessions/:sessionId", cu.DeleteSession)
+	user.Post("/2fa/totp", cu.SetupTotp)
+	user.Post("/2fa/totp/confirm", cu.ConfirmTotp)
+	user.Post("/2fa/totp/disable", cu.DisableTotp)
+	user.Get("/2fa/webauthn", cu.GetAllWebauthn)
+	user.Post("/2fa/webauthn", cu.BeginWebauthnRegistration)
+	user.Post("/2fa/webauthn/finish", cu.FinishWebauthnRegistration)
+	user.Post("/2fa/webauthn/assert", cu.BeginWebauthnAssertion)
+	user.Delete("/2fa/webauthn/:credentialId", cu.DeleteWebauthn)
 
-	vault := app.Group("/vault")
+	vault := app.Group("/vault", cv.RotateToken)
 	vault.Get("/", cv.GetAll)
+	// the trash is registered before the routes matching any vault ID
+	vault.Get("/trash", cv.GetAllTrash)
+	vault.Get("/item-types", cv.GetAllItemType)
+	vault.Delete("/trash", cv.EmptyTrash)
+	vault.Post("/trash/:vaultId/restore", cv.RestoreTrash)
+	vault.Get("/:vaultId/members", cv.GetAllMember)
+	vault.Post("/:vaultId/members", cv.InviteMember)
+	vault.Post("/:vaultId/members/accept", cv.AcceptInvitation)
+	vault.Put("/:vaultId/members/:userId", cv.UpdateMemberRole)
+	vault.Delete("/:vaultId/members/:userId", cv.RemoveMember)
+	vault.Get("/:vaultId/revisions", cv.GetAllRevision)
+	vault.Post("/:vaultId/revisions/:revision/restore", cv.RestoreRevision)
+	vault.Get("/:vaultId/credentials", cv.GetAllCredential)
 	vault.Get("/:vaultId", cv.GetOne)
+	vault.Get("/:vaultId/:credentialId", cv.GetCredential)
 	vault.Post("/", cv.Create)
 	vault.Post("/:vaultId", cv.CreateCredential)
 	vault.Put("/:vaultId", cv.UpdateVaultName)
 	vault.Put("/:vaultId/:credentialId", cv.UpdateCredential)
 	vault.Delete("/:vaultId", cv.Delete)
 	vault.Delete("/:vaultId/:credentialId", cv.DeleteCredential)
+	vault.Get("/:vaultId/:credentialId/history", cv.GetAllCredentialHistory)
+	vault.Post("/:vaultId/:credentialId/history/:historyId/restore", cv.RestoreCredentialHistory)
+
+	audit := app.Group("/audit")
+	audit.Get("/", ca.GetAll)
+	return nil
+}
+
+// StartMaintenance run the scheduled purges in the background until the context is done,
+// a purge is run by a single replica at a time. The returned channel is closed once every purge has stopped
+func StartMaintenance(ctx context.Context, db *pgx.Queries, pool *pgxpool.Pool, log *logger.Logger) <-chan struct{} {
+	sm := service.NewMaintenanceService(
+		service.WithMaintenancePostgres(ctx, db, pool, log),
+		service.WithMaintenanceSchedule(
+			viper.GetDuration("maintenance.sessionInterval"),
+			viper.GetDuration("maintenance.userInterval"),
+			viper.GetDuration("maintenance.userRetention"),
+		),
+		service.WithMaintenanceVaultTrash(
+			viper.GetDuration("maintenance.vaultInterval"),
+			viper.GetDuration("maintenance.vaultRetention"),
+		),
+	)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sm.Run(ctx)
+	}()
+	return done
+}
+
+// InitAuditSigningKey parse the key signing the audit events, the service does not start without it
+// as the events recorded without a signature could not be verified. A key is generated by `audit-verify -generate-key`
+func InitAuditSigningKey() (ed25519.PrivateKey, error) {
+	if viper.GetString("audit.signingKey") == "" {
+		return nil, errors.New("audit.signingKey is not configured, generate one using `audit-verify -generate-key`")
+	}
+	signingKey, err := crypto.ParsePrivateKeyEd25519(viper.GetString("audit.signingKey"))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", "Invalid audit.signingKey", err)
+	}
+	return signingKey, nil
+}
+
+// InitAuditVerifyKeys parse the public keys trusted to sign the audit events, the one of the current
+// signing key and the retired keys which signed the older events
+func InitAuditVerifyKeys(log *logger.Logger) (verifyKeys []ed25519.PublicKey) {
+	if viper.GetString("audit.publicKey") == "" {
+		log.Panic("audit.publicKey is not configured")
+	}
+	for _, item := range append([]string{viper.GetString("audit.publicKey")}, viper.GetStringSlice("audit.retiredKeys")...) {
+		key, err := crypto.ParsePublicKeyEd25519(item)
+		if err != nil {
+			log.Panic(fmt.Sprintf("%s: %s", "Invalid audit public key", err))
+		}
+		verifyKeys = append(verifyKeys, key)
+	}
+	return
+}
+
+// VerifyAudit walk the audit chain, reporting the first broken link. Only the public keys are needed
+func VerifyAudit(ctx context.Context, db *pgx.Queries, pool *pgxpool.Pool, log *logger.Logger) (dtoAudit.VerifyResponse, error) {
+	sa := service.NewAuditService(
+		service.WithAuditPostgres(ctx, db, pool, log),
+		service.WithAuditKeys(InitAuditVerifyKeys(log)...),
+	)
+	return sa.VerifyChain()
 }
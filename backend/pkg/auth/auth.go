@@ -2,6 +2,9 @@ package auth
 
 import "strings"
 
+// SessionTokenHeader is the response header carrying the rotated session token
+const SessionTokenHeader = "X-Session-Token"
+
 func GetTokenFromBearer(bearer string) string {
 	token := ""
 	if strings.Contains(bearer, "Bearer ") {
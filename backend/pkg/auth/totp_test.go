@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"encoding/base32"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA1 secret of the RFC 6238 test vectors
+var rfc6238Secret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCode_RFC6238(t *testing.T) {
+	// The RFC lists 8 digits codes, the last 6 digits are the same as a 6 digits code
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for unix, expected := range vectors {
+		code, err := TOTPCode(rfc6238Secret, TOTPCounter(time.Unix(unix, 0)))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, code, unix)
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	assert.NoError(t, err)
+	now := time.Now()
+
+	code, _ := TOTPCode(secret, TOTPCounter(now.Add(-TOTPPeriod)))
+	counter, valid := ValidateTOTP(secret, code, now)
+	assert.True(t, valid)
+	assert.Equal(t, TOTPCounter(now)-1, counter)
+
+	code, _ = TOTPCode(secret, TOTPCounter(now.Add(-3*TOTPPeriod)))
+	_, valid = ValidateTOTP(secret, code, now)
+	assert.False(t, valid)
+
+	_, valid = ValidateTOTP("not base32!", "123456", now)
+	assert.False(t, valid)
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	uri := TOTPProvisioningURI("Pasuwado", "user@mail.com", "ABC")
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/Pasuwado:user@mail.com?"))
+	assert.Contains(t, uri, "secret=ABC")
+	assert.Contains(t, uri, "issuer=Pasuwado")
+}
@@ -5,6 +5,10 @@ import (
 )
 
 var (
-	ErrNoData = errors.New("data not found")
-	ErrCrypto = errors.New("crypto error")
+	ErrNoData    = errors.New("data not found")
+	ErrCrypto    = errors.New("crypto error")
+	ErrForbidden = errors.New("access forbidden")
+	ErrIntegrity = errors.New("integrity check failed")
+	ErrConflict  = errors.New("concurrent modification")
+	ErrLastOwner = errors.New("a vault requires at least one owner")
 )
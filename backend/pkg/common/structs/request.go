@@ -4,3 +4,9 @@ type StdPagination struct {
 	Page int32 `json:"page"`
 	Size int32 `json:"size"`
 }
+
+// StdClient describe the client sending a request
+type StdClient struct {
+	IP        string
+	UserAgent string
+}
@@ -5,9 +5,19 @@ import (
 	"crypto/cipher"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"io"
 )
 
+// GenerateRandomKey generates a random key of the given size in bytes.
+func GenerateRandomKey(size int) ([]byte, error) {
+	key := make([]byte, size)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
 // EncryptAES encrypts a plain text string using AES with a given key.
 func EncryptAES(plainText, key string) (string, error) {
 	// Create a new AES cipher using the secret key.
@@ -57,6 +67,9 @@ func DecryptAES(encryptedText, key string) (string, error) {
 
 	// Extract the nonce from the encrypted text.
 	nonceSize := aesGCM.NonceSize()
+	if len(cipherText) < nonceSize+aesGCM.Overhead() {
+		return "", errors.New("ciphertext too short")
+	}
 	nonce, cipherText := cipherText[:nonceSize], cipherText[nonceSize:]
 
 	// Decrypt the text.
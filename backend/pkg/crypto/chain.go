@@ -0,0 +1,23 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// ChainHash hashes the fields of a record together with the hash of the previous record using SHA-256,
+// so editing, removing or reordering a record changes every hash after it.
+// Each value is length prefixed, moving bytes from one field to the next does not keep the hash.
+func ChainHash(prevHash []byte, fields ...string) []byte {
+	h := sha256.New()
+	size := make([]byte, 8)
+	binary.BigEndian.PutUint64(size, uint64(len(prevHash)))
+	h.Write(size)
+	h.Write(prevHash)
+	for _, field := range fields {
+		binary.BigEndian.PutUint64(size, uint64(len(field)))
+		h.Write(size)
+		h.Write([]byte(field))
+	}
+	return h.Sum(nil)
+}
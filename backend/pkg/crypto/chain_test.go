@@ -0,0 +1,20 @@
+package crypto
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestChainHash_Deterministic(t *testing.T) {
+	first := ChainHash(nil, "1", "login.success")
+	assert.Len(t, first, 32)
+	assert.Equal(t, first, ChainHash(nil, "1", "login.success"))
+
+	second := ChainHash(first, "2", "vault.read")
+	assert.NotEqual(t, second, ChainHash(ChainHash(nil, "1", "login.failure"), "2", "vault.read"))
+}
+
+func TestChainHash_FieldBoundary(t *testing.T) {
+	assert.NotEqual(t, ChainHash(nil, "ab", "c"), ChainHash(nil, "a", "bc"))
+	assert.NotEqual(t, ChainHash([]byte("a"), "b"), ChainHash(nil, "a", "b"))
+}
@@ -3,6 +3,8 @@ package crypto
 import (
 	"crypto/ed25519"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 )
 
@@ -16,9 +18,40 @@ func GenerateKeyPairEd25519() (ed25519.PublicKey, ed25519.PrivateKey, error) {
 }
 
 // ValidateKeyPairEd25519 checks if the provided private key matches the public key.
+// The public key is derived again from the seed, as the one stored in the private key is not checked against it.
 func ValidateKeyPairEd25519(pubKey ed25519.PublicKey, privKey ed25519.PrivateKey) error {
-	if !pubKey.Equal(privKey.Public().(ed25519.PublicKey)) {
+	if !pubKey.Equal(ed25519.NewKeyFromSeed(privKey.Seed()).Public()) {
 		return errors.New("private key does not match the public key")
 	}
 	return nil
 }
+
+// ParsePublicKeyEd25519 decodes a hex encoded Ed25519 public key.
+func ParsePublicKeyEd25519(s string) (ed25519.PublicKey, error) {
+	key, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid public key length")
+	}
+	return key, nil
+}
+
+// ParsePrivateKeyEd25519 decodes a hex encoded Ed25519 private key.
+func ParsePrivateKeyEd25519(s string) (ed25519.PrivateKey, error) {
+	key, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != ed25519.PrivateKeySize {
+		return nil, errors.New("invalid private key length")
+	}
+	return key, nil
+}
+
+// FingerprintEd25519 identifies an Ed25519 public key by the hex encoded beginning of its SHA-256.
+func FingerprintEd25519(pubKey ed25519.PublicKey) string {
+	sum := sha256.Sum256(pubKey)
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,30 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestValidateKeyPairEd25519_Match(t *testing.T) {
+	pub, pvt, err := GenerateKeyPairEd25519()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, ValidateKeyPairEd25519(pub, pvt))
+}
+
+func TestValidateKeyPairEd25519_ForgedPublicHalf(t *testing.T) {
+	pub, _, err := GenerateKeyPairEd25519()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, other, err := GenerateKeyPairEd25519()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the seed of another key followed by the public key of the user
+	forged := append(ed25519.PrivateKey{}, other.Seed()...)
+	forged = append(forged, pub...)
+	assert.Error(t, ValidateKeyPairEd25519(pub, forged))
+}
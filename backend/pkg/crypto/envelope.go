@@ -0,0 +1,224 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/chacha20poly1305"
+	"io"
+	"strings"
+)
+
+// Algorithm identifies the AEAD used to seal an envelope.
+type Algorithm byte
+
+const (
+	AlgorithmAESGCM            Algorithm = 1
+	AlgorithmXChaCha20Poly1305 Algorithm = 2
+)
+
+const (
+	// VersionAESGCM is the header of the first versioned format, AES-256-GCM without key ID nor AAD.
+	VersionAESGCM = "v1"
+	// VersionEnvelope is the header of the self-describing envelope.
+	VersionEnvelope = "v2"
+
+	versionSeparator = ":"
+	envelopeVersion  = byte(2)
+)
+
+// Envelope is the decoded form of a string produced by SealEnvelope.
+//
+// The binary layout is: version (1 byte) | algorithm (1 byte) | key ID length (1 byte) |
+// key ID | nonce | cipher text. Everything before the nonce is authenticated together
+// with the caller provided associated data.
+type Envelope struct {
+	Version    byte
+	Algorithm  Algorithm
+	KeyID      string
+	Nonce      []byte
+	CipherText []byte
+}
+
+// IsVersioned checks whether an encrypted string carries a version header,
+// the legacy format produced by EncryptAES is a bare base64 string without one.
+func IsVersioned(encryptedText string) bool {
+	return strings.Contains(encryptedText, versionSeparator)
+}
+
+// Seal encrypts the plain text using a 256-bit key with the default algorithm.
+func Seal(plainText, key []byte) (string, error) {
+	return SealEnvelope(AlgorithmAESGCM, key, "", plainText, nil)
+}
+
+// Open decrypts a string produced by Seal, or any format accepted by OpenEnvelope without associated data.
+func Open(encryptedText string, key []byte) ([]byte, error) {
+	return OpenEnvelope(encryptedText, key, nil)
+}
+
+// SealEnvelope encrypts the plain text into a self-describing envelope. The key ID is stored in clear
+// to tell which key to use when opening, while the associated data binds the cipher text to its context
+// (e.g. the ID of the row that store it) and must be given again to open the envelope.
+func SealEnvelope(alg Algorithm, key []byte, keyID string, plainText, aad []byte) (string, error) {
+	if len(keyID) > 255 {
+		return "", errors.New("key ID too long")
+	}
+
+	aead, err := newAEAD(alg, key)
+	if err != nil {
+		return "", err
+	}
+
+	header := append([]byte{envelopeVersion, byte(alg), byte(len(keyID))}, keyID...)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	out := append(header, nonce...)
+	out = aead.Seal(out, nonce, plainText, envelopeAAD(header, aad))
+	return VersionEnvelope + versionSeparator + base64.StdEncoding.EncodeToString(out), nil
+}
+
+// OpenEnvelope decrypts an envelope. For compatibility, the v1 format and the legacy
+// bare format of EncryptAES are accepted as well, both of them ignore the associated data.
+func OpenEnvelope(encryptedText string, key, aad []byte) ([]byte, error) {
+	if !IsVersioned(encryptedText) {
+		plainText, err := DecryptAES(encryptedText, string(key))
+		return []byte(plainText), err
+	}
+
+	version, encoded, _ := strings.Cut(encryptedText, versionSeparator)
+	switch version {
+	case VersionAESGCM:
+		return openV1(encoded, key)
+	case VersionEnvelope:
+		return openV2(encoded, key, aad)
+	}
+	return nil, fmt.Errorf("unsupported ciphertext version %s", version)
+}
+
+// OpenEnvelopeStrict decrypts an envelope produced by SealEnvelope only, rejecting the older formats
+// that ignore the associated data. Use it for any value that must be bound to its context.
+func OpenEnvelopeStrict(encryptedText string, key, aad []byte) ([]byte, error) {
+	version, encoded, found := strings.Cut(encryptedText, versionSeparator)
+	if !found || version != VersionEnvelope {
+		return nil, errors.New("not an envelope")
+	}
+	return openV2(encoded, key, aad)
+}
+
+// ParseEnvelope decodes the header of an envelope without decrypting it,
+// e.g. to find the key ID before choosing the key.
+func ParseEnvelope(encryptedText string) (Envelope, error) {
+	version, encoded, found := strings.Cut(encryptedText, versionSeparator)
+	if !found || version != VersionEnvelope {
+		return Envelope{}, errors.New("not an envelope")
+	}
+	env, _, err := parseEnvelope(encoded)
+	return env, err
+}
+
+func parseEnvelope(encoded string) (env Envelope, header []byte, err error) {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return
+	}
+	if len(raw) < 3 {
+		err = errors.New("envelope too short")
+		return
+	}
+	env.Version = raw[0]
+	env.Algorithm = Algorithm(raw[1])
+	keyIDLen := int(raw[2])
+	if env.Version != envelopeVersion {
+		err = fmt.Errorf("unsupported envelope version %d", env.Version)
+		return
+	}
+	nonceSize, err := nonceSizeOf(env.Algorithm)
+	if err != nil {
+		return
+	}
+	if len(raw) < 3+keyIDLen+nonceSize {
+		err = errors.New("envelope too short")
+		return
+	}
+	header = raw[:3+keyIDLen]
+	env.KeyID = string(raw[3 : 3+keyIDLen])
+	env.Nonce = raw[3+keyIDLen : 3+keyIDLen+nonceSize]
+	env.CipherText = raw[3+keyIDLen+nonceSize:]
+	return
+}
+
+func openV2(encoded string, key, aad []byte) ([]byte, error) {
+	env, header, err := parseEnvelope(encoded)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(env.Algorithm, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(env.Nonce) != aead.NonceSize() {
+		return nil, errors.New("invalid nonce size")
+	}
+	return aead.Open(nil, env.Nonce, env.CipherText, envelopeAAD(header, aad))
+}
+
+func openV1(encoded string, key []byte) ([]byte, error) {
+	cipherText, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newAEAD(AlgorithmAESGCM, key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonceSize := aead.NonceSize()
+	if len(cipherText) < nonceSize+aead.Overhead() {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, cipherText := cipherText[:nonceSize], cipherText[nonceSize:]
+
+	return aead.Open(nil, nonce, cipherText, nil)
+}
+
+// envelopeAAD authenticates the header together with the caller provided associated data.
+func envelopeAAD(header, aad []byte) []byte {
+	res := make([]byte, 0, len(header)+len(aad))
+	res = append(res, header...)
+	return append(res, aad...)
+}
+
+func nonceSizeOf(alg Algorithm) (int, error) {
+	switch alg {
+	case AlgorithmAESGCM:
+		return 12, nil
+	case AlgorithmXChaCha20Poly1305:
+		return chacha20poly1305.NonceSizeX, nil
+	}
+	return 0, fmt.Errorf("unsupported algorithm %d", alg)
+}
+
+func newAEAD(alg Algorithm, key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, errors.New("key must be 256-bit")
+	}
+
+	switch alg {
+	case AlgorithmAESGCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case AlgorithmXChaCha20Poly1305:
+		return chacha20poly1305.NewX(key)
+	}
+	return nil, fmt.Errorf("unsupported algorithm %d", alg)
+}
@@ -0,0 +1,91 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestSealEnvelope_RoundTrip(t *testing.T) {
+	key, _ := GenerateRandomKey(32)
+	for _, alg := range []Algorithm{AlgorithmAESGCM, AlgorithmXChaCha20Poly1305} {
+		enc, err := SealEnvelope(alg, key, "vault", []byte("secret"), []byte("vault-id"))
+		assert.NoError(t, err)
+
+		env, err := ParseEnvelope(enc)
+		assert.NoError(t, err)
+		assert.Equal(t, alg, env.Algorithm)
+		assert.Equal(t, "vault", env.KeyID)
+
+		plainText, err := OpenEnvelope(enc, key, []byte("vault-id"))
+		assert.NoError(t, err)
+		assert.Equal(t, "secret", string(plainText))
+
+		_, err = OpenEnvelope(enc, key, []byte("other-vault-id"))
+		assert.Error(t, err)
+	}
+}
+
+func TestOpenEnvelope_TamperedHeader(t *testing.T) {
+	key, _ := GenerateRandomKey(32)
+	enc, err := SealEnvelope(AlgorithmAESGCM, key, "a", []byte("secret"), nil)
+	assert.NoError(t, err)
+
+	raw, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(enc, VersionEnvelope+":"))
+	raw[3] = 'b'
+	_, err = Open(VersionEnvelope+":"+base64.StdEncoding.EncodeToString(raw), key)
+	assert.Error(t, err)
+}
+
+func TestOpenEnvelope_Legacy(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	legacy, err := EncryptAES("secret", string(key))
+	assert.NoError(t, err)
+	plainText, err := Open(legacy, key)
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", string(plainText))
+
+	block, _ := aes.NewCipher(key)
+	aesGCM, _ := cipher.NewGCM(block)
+	nonce := make([]byte, aesGCM.NonceSize())
+	v1 := VersionAESGCM + ":" + base64.StdEncoding.EncodeToString(aesGCM.Seal(nonce, nonce, []byte("secret"), nil))
+	plainText, err = Open(v1, key)
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", string(plainText))
+}
+
+func TestOpenEnvelope_ShortInput(t *testing.T) {
+	key, _ := GenerateRandomKey(32)
+	for _, enc := range []string{"", "AAAA", VersionAESGCM + ":AAAA", VersionEnvelope + ":AgE=", "v9:AAAA"} {
+		_, err := Open(enc, key)
+		assert.Error(t, err)
+	}
+	_, err := DecryptAES("AAAA", string(key))
+	assert.Error(t, err)
+}
+
+func TestOpenEnvelopeStrict(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+	enc, err := SealEnvelope(AlgorithmAESGCM, key, "", []byte("secret"), []byte("vault-id"))
+	assert.NoError(t, err)
+	plainText, err := OpenEnvelopeStrict(enc, key, []byte("vault-id"))
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", string(plainText))
+	_, err = OpenEnvelopeStrict(enc, key, []byte("other-vault-id"))
+	assert.Error(t, err)
+
+	legacy, err := EncryptAES("secret", string(key))
+	assert.NoError(t, err)
+	_, err = OpenEnvelopeStrict(legacy, key, nil)
+	assert.Error(t, err)
+
+	block, _ := aes.NewCipher(key)
+	aesGCM, _ := cipher.NewGCM(block)
+	nonce := make([]byte, aesGCM.NonceSize())
+	v1 := VersionAESGCM + ":" + base64.StdEncoding.EncodeToString(aesGCM.Seal(nonce, nonce, []byte("secret"), nil))
+	_, err = OpenEnvelopeStrict(v1, key, []byte("vault-id"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,17 @@
+package crypto
+
+import (
+	"crypto/sha256"
+	"golang.org/x/crypto/hkdf"
+	"io"
+)
+
+// DeriveKeyHKDF derives a key of the given size from a high entropy secret using HKDF-SHA256.
+// The info string separates keys derived from the same secret for different purposes.
+func DeriveKeyHKDF(secret, salt []byte, info string, size int) ([]byte, error) {
+	key := make([]byte, size)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(info)), key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
@@ -0,0 +1,84 @@
+package crypto
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"strings"
+)
+
+const (
+	DefaultArgon2idTime    uint32 = 3
+	DefaultArgon2idMemory  uint32 = 64 * 1024
+	DefaultArgon2idThreads uint8  = 2
+	argon2idKeyLen         uint32 = 32
+	argon2idSaltLen               = 16
+)
+
+// Argon2idParams holds the tunable cost and the salt of an Argon2id derivation,
+// it is stored next to the data it protects so the cost can be raised later.
+type Argon2idParams struct {
+	Salt    []byte
+	Time    uint32
+	Memory  uint32 // in KiB
+	Threads uint8
+}
+
+// NewArgon2idParams creates parameters with a fresh random salt, zero cost values fall back to the defaults.
+func NewArgon2idParams(time, memory uint32, threads uint8) (Argon2idParams, error) {
+	if time == 0 {
+		time = DefaultArgon2idTime
+	}
+	if memory == 0 {
+		memory = DefaultArgon2idMemory
+	}
+	if threads == 0 {
+		threads = DefaultArgon2idThreads
+	}
+	salt, err := GenerateRandomKey(argon2idSaltLen)
+	if err != nil {
+		return Argon2idParams{}, err
+	}
+	return Argon2idParams{Salt: salt, Time: time, Memory: memory, Threads: threads}, nil
+}
+
+// String encodes the parameters in the PHC string format, e.g. `$argon2id$v=19$m=65536,t=3,p=2$<salt>`.
+func (p Argon2idParams) String() string {
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s",
+		argon2.Version,
+		p.Memory,
+		p.Time,
+		p.Threads,
+		base64.RawStdEncoding.EncodeToString(p.Salt),
+	)
+}
+
+// ParseArgon2idParams decodes parameters encoded by Argon2idParams.String.
+func ParseArgon2idParams(s string) (p Argon2idParams, err error) {
+	parts := strings.Split(s, "$")
+	if len(parts) != 5 || parts[1] != "argon2id" {
+		return p, errors.New("invalid argon2id parameters")
+	}
+	var version int
+	if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return p, err
+	}
+	if version != argon2.Version {
+		return p, fmt.Errorf("unsupported argon2id version %d", version)
+	}
+	if _, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return p, err
+	}
+	if p.Memory == 0 || p.Time == 0 || p.Threads == 0 {
+		return p, errors.New("invalid argon2id cost")
+	}
+	p.Salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	return p, err
+}
+
+// DeriveKeyArgon2id derives a 256-bit key from a password.
+func DeriveKeyArgon2id(password string, p Argon2idParams) []byte {
+	return argon2.IDKey([]byte(password), p.Salt, p.Time, p.Memory, p.Threads, argon2idKeyLen)
+}
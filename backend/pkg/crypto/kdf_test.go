@@ -0,0 +1,33 @@
+package crypto
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestArgon2idParams_RoundTrip(t *testing.T) {
+	params, err := NewArgon2idParams(1, 1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseArgon2idParams(params.String())
+	assert.NoError(t, err)
+	assert.Equal(t, params, parsed)
+	assert.Equal(t, DeriveKeyArgon2id("passwordpassword", params), DeriveKeyArgon2id("passwordpassword", parsed))
+}
+
+func TestNewArgon2idParams_Default(t *testing.T) {
+	params, err := NewArgon2idParams(0, 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultArgon2idTime, params.Time)
+	assert.Equal(t, DefaultArgon2idMemory, params.Memory)
+	assert.Equal(t, DefaultArgon2idThreads, params.Threads)
+	assert.Len(t, params.Salt, argon2idSaltLen)
+}
+
+func TestParseArgon2idParams_Invalid(t *testing.T) {
+	_, err := ParseArgon2idParams("$2a$10$bcrypt")
+	assert.Error(t, err)
+	_, err = ParseArgon2idParams("$argon2id$v=19$m=0,t=0,p=0$c2FsdA")
+	assert.Error(t, err)
+}
@@ -0,0 +1,102 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+	"math/big"
+)
+
+// curve25519P is the field prime 2^255 - 19 shared by Ed25519 and X25519.
+var curve25519P = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+
+// PublicKeyEd25519ToX25519 converts an Ed25519 public key into its X25519 (Montgomery) form,
+// using the birational map u = (1 + y) / (1 - y).
+func PublicKeyEd25519ToX25519(pubKey ed25519.PublicKey) (*[32]byte, error) {
+	if len(pubKey) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid public key length")
+	}
+
+	// The key is the little-endian y coordinate, the top bit holds the sign of x.
+	yBytes := make([]byte, ed25519.PublicKeySize)
+	for i := range pubKey {
+		yBytes[len(pubKey)-1-i] = pubKey[i]
+	}
+	yBytes[0] &= 0x7f
+	y := new(big.Int).SetBytes(yBytes)
+	if y.Cmp(curve25519P) >= 0 {
+		return nil, errors.New("invalid public key encoding")
+	}
+
+	denominator := new(big.Int).Sub(big.NewInt(1), y)
+	denominator.Mod(denominator, curve25519P)
+	if denominator.Sign() == 0 {
+		return nil, errors.New("public key can not be converted")
+	}
+	numerator := new(big.Int).Add(big.NewInt(1), y)
+	u := numerator.Mul(numerator, denominator.ModInverse(denominator, curve25519P))
+	u.Mod(u, curve25519P)
+
+	var res [32]byte
+	uBytes := u.Bytes()
+	for i := range uBytes {
+		res[i] = uBytes[len(uBytes)-1-i]
+	}
+	return &res, nil
+}
+
+// PrivateKeyEd25519ToX25519 converts an Ed25519 private key into the X25519 scalar
+// that corresponds to PublicKeyEd25519ToX25519 of its public key.
+func PrivateKeyEd25519ToX25519(privKey ed25519.PrivateKey) *[32]byte {
+	h := sha512.Sum512(privKey.Seed())
+	h[0] &= 248
+	h[31] &= 127
+	h[31] |= 64
+
+	var res [32]byte
+	copy(res[:], h[:32])
+	return &res
+}
+
+// SealAnonymousX25519 encrypts a plain text string to the owner of an Ed25519 public key,
+// only the matching private key is able to open it.
+func SealAnonymousX25519(plainText string, pubKey ed25519.PublicKey) (string, error) {
+	recipient, err := PublicKeyEd25519ToX25519(pubKey)
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := box.SealAnonymous(nil, []byte(plainText), recipient, rand.Reader)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// OpenAnonymousX25519 decrypts a string sealed by SealAnonymousX25519 using the Ed25519 private key.
+func OpenAnonymousX25519(encryptedText string, privKey ed25519.PrivateKey) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(encryptedText)
+	if err != nil {
+		return "", err
+	}
+
+	scalar := PrivateKeyEd25519ToX25519(privKey)
+	pub, err := curve25519.X25519(scalar[:], curve25519.Basepoint)
+	if err != nil {
+		return "", err
+	}
+
+	var recipient [32]byte
+	copy(recipient[:], pub)
+	plainText, ok := box.OpenAnonymous(nil, sealed, &recipient, scalar)
+	if !ok {
+		return "", errors.New("unable to open sealed box")
+	}
+
+	return string(plainText), nil
+}
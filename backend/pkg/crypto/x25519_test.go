@@ -0,0 +1,60 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/curve25519"
+	"testing"
+)
+
+func TestPublicKeyEd25519ToX25519_MatchPrivateKey(t *testing.T) {
+	pub, pvt, err := GenerateKeyPairEd25519()
+	if err != nil {
+		t.Fatal(err)
+	}
+	converted, err := PublicKeyEd25519ToX25519(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	derived, err := curve25519.X25519(PrivateKeyEd25519ToX25519(pvt)[:], curve25519.Basepoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, derived, converted[:])
+}
+
+func TestPublicKeyEd25519ToX25519_InvalidLength(t *testing.T) {
+	_, err := PublicKeyEd25519ToX25519(ed25519.PublicKey{1, 2, 3})
+	assert.Error(t, err)
+}
+
+func TestSealAnonymousX25519_Success(t *testing.T) {
+	pub, pvt, err := GenerateKeyPairEd25519()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := SealAnonymousX25519("vault data key", pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := OpenAnonymousX25519(sealed, pvt)
+	assert.NoError(t, err)
+	assert.Equal(t, "vault data key", plain)
+}
+
+func TestSealAnonymousX25519_WrongKey(t *testing.T) {
+	pub, _, err := GenerateKeyPairEd25519()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherPvt, err := GenerateKeyPairEd25519()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := SealAnonymousX25519("vault data key", pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = OpenAnonymousX25519(sealed, otherPvt)
+	assert.Error(t, err)
+}
@@ -0,0 +1,16 @@
+package pgx
+
+import (
+	"context"
+	"hash/fnv"
+)
+
+// TryAdvisoryXactLock take a transaction level advisory lock named `name` without waiting for it, `locked`
+// is false when the lock is held by another transaction, which may be of another replica.
+// The lock is released when the transaction `tx` ends, so no connection is held for it
+func TryAdvisoryXactLock(ctx context.Context, tx DBTX, name string) (locked bool, err error) {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	err = tx.QueryRow(ctx, "SELECT pg_try_advisory_xact_lock($1)", int64(h.Sum64())).Scan(&locked)
+	return
+}
@@ -0,0 +1,188 @@
+package redis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"sync"
+	"time"
+)
+
+// Limiter count the attempts of a key in a sliding window and block a key for a while
+type Limiter interface {
+	// Hit record an attempt of a key, returning the number of attempts within the window including this one
+	Hit(key string, window time.Duration) (int64, error)
+	// Lock block a key for a duration
+	Lock(key string, duration time.Duration) error
+	// LockedFor tell how long a key is still blocked, zero when it is not
+	LockedFor(key string) (time.Duration, error)
+	// Reset forget the attempts and the lock of a key
+	Reset(key string) error
+}
+
+// NewLimiter use redis to share the attempts between replicas,
+// falling back to the memory of this replica when redis is not configured
+func NewLimiter(r *Redis) Limiter {
+	if r == nil {
+		return NewMemoryLimiter()
+	}
+	return &RedisLimiter{rdb: r.rdb}
+}
+
+// RedisLimiter keep the attempts of a key in a sorted set scored by the time of each attempt
+type RedisLimiter struct {
+	rdb *redis.Client
+}
+
+func attemptKey(key string) string {
+	return "ratelimit:" + key
+}
+
+func lockKey(key string) string {
+	return "ratelimit-lock:" + key
+}
+
+func (l *RedisLimiter) Hit(key string, window time.Duration) (int64, error) {
+	// a random suffix keep the attempts made in the same nanosecond apart
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return 0, err
+	}
+	now := time.Now()
+	member := fmt.Sprintf("%d-%s", now.UnixNano(), hex.EncodeToString(suffix))
+	var card *redis.IntCmd
+	_, err := l.rdb.TxPipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		pipe.ZRemRangeByScore(context.Background(), attemptKey(key), "-inf", fmt.Sprint(now.Add(-window).UnixNano()))
+		pipe.ZAdd(context.Background(), attemptKey(key), redis.Z{Score: float64(now.UnixNano()), Member: member})
+		pipe.PExpire(context.Background(), attemptKey(key), window)
+		card = pipe.ZCard(context.Background(), attemptKey(key))
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("%s: %s", "Error recording attempt in redis", err)
+	}
+	return card.Val(), nil
+}
+
+func (l *RedisLimiter) Lock(key string, duration time.Duration) error {
+	if err := l.rdb.Set(context.Background(), lockKey(key), "1", duration).Err(); err != nil {
+		return fmt.Errorf("%s: %s", "Error setting lock in redis", err)
+	}
+	return nil
+}
+
+func (l *RedisLimiter) LockedFor(key string) (time.Duration, error) {
+	ttl, err := l.rdb.PTTL(context.Background(), lockKey(key)).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("%s: %s", "Error getting lock from redis", err)
+	}
+	if ttl < 0 {
+		// the lock does not exist
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+func (l *RedisLimiter) Reset(key string) error {
+	if err := l.rdb.Del(context.Background(), attemptKey(key), lockKey(key)).Err(); err != nil {
+		return fmt.Errorf("%s: %s", "Error deleting value from redis", err)
+	}
+	return nil
+}
+
+// memorySweepEvery is the number of hits between two removals of the expired keys
+const memorySweepEvery = 1000
+
+// MemoryLimiter keep the attempts in the memory of this replica
+type MemoryLimiter struct {
+	mu       sync.Mutex
+	attempts map[string][]time.Time
+	windows  map[string]time.Duration
+	locks    map[string]time.Time
+	hits     int
+}
+
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{
+		attempts: map[string][]time.Time{},
+		windows:  map[string]time.Duration{},
+		locks:    map[string]time.Time{},
+	}
+}
+
+func (l *MemoryLimiter) Hit(key string, window time.Duration) (int64, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.hits++
+	if l.hits%memorySweepEvery == 0 {
+		l.sweep(now)
+	}
+	attempts := append(withinWindow(l.attempts[key], now.Add(-window)), now)
+	l.attempts[key] = attempts
+	l.windows[key] = window
+	return int64(len(attempts)), nil
+}
+
+func (l *MemoryLimiter) Lock(key string, duration time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.locks[key] = time.Now().Add(duration)
+	return nil
+}
+
+func (l *MemoryLimiter) LockedFor(key string) (time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	until, ok := l.locks[key]
+	if !ok {
+		return 0, nil
+	}
+	left := time.Until(until)
+	if left <= 0 {
+		delete(l.locks, key)
+		return 0, nil
+	}
+	return left, nil
+}
+
+func (l *MemoryLimiter) Reset(key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.attempts, key)
+	delete(l.windows, key)
+	delete(l.locks, key)
+	return nil
+}
+
+// sweep remove the keys whose attempts and lock are all expired
+func (l *MemoryLimiter) sweep(now time.Time) {
+	for key, attempts := range l.attempts {
+		if attempts = withinWindow(attempts, now.Add(-l.windows[key])); len(attempts) == 0 {
+			delete(l.attempts, key)
+			delete(l.windows, key)
+		} else {
+			l.attempts[key] = attempts
+		}
+	}
+	for key, until := range l.locks {
+		if !until.After(now) {
+			delete(l.locks, key)
+		}
+	}
+}
+
+// withinWindow drop the attempts made before the window started, the attempts are sorted by time
+func withinWindow(attempts []time.Time, start time.Time) []time.Time {
+	i := 0
+	for i < len(attempts) && !attempts[i].After(start) {
+		i++
+	}
+	return attempts[i:]
+}
@@ -0,0 +1,45 @@
+package redis
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestMemoryLimiter_Hit(t *testing.T) {
+	l := NewMemoryLimiter()
+	for i := int64(1); i <= 3; i++ {
+		count, err := l.Hit("login", time.Minute)
+		assert.NoError(t, err)
+		assert.Equal(t, i, count)
+	}
+
+	// the attempts outside the window are no longer counted
+	time.Sleep(time.Millisecond * 20)
+	count, _ := l.Hit("login", time.Millisecond*10)
+	assert.Equal(t, int64(1), count)
+
+	assert.NoError(t, l.Reset("login"))
+	count, _ = l.Hit("login", time.Minute)
+	assert.Equal(t, int64(1), count)
+}
+
+func TestMemoryLimiter_Lock(t *testing.T) {
+	l := NewMemoryLimiter()
+	left, err := l.LockedFor("account")
+	assert.NoError(t, err)
+	assert.Zero(t, left)
+
+	assert.NoError(t, l.Lock("account", time.Minute))
+	left, _ = l.LockedFor("account")
+	assert.Greater(t, left, time.Second*59)
+
+	assert.NoError(t, l.Lock("expired", time.Millisecond))
+	time.Sleep(time.Millisecond * 5)
+	left, _ = l.LockedFor("expired")
+	assert.Zero(t, left)
+
+	assert.NoError(t, l.Reset("account"))
+	left, _ = l.LockedFor("account")
+	assert.Zero(t, left)
+}
@@ -57,6 +57,24 @@ func (r *Redis) Set(key string, value string, expiration time.Duration) error {
 	return nil
 }
 
+// SetKeepTTL replace the value of a key without changing its expiration
+func (r *Redis) SetKeepTTL(key string, value string) error {
+	_, err := r.rdb.Set(context.Background(), key, value, redis.KeepTTL).Result()
+	if err != nil {
+		return fmt.Errorf("%s: %s", "Error setting value in redis", err)
+	}
+	return nil
+}
+
+// SetNX set the value of a key only when it does not exist yet, the result tell whether it was set
+func (r *Redis) SetNX(key string, value string, expiration time.Duration) (bool, error) {
+	ok, err := r.rdb.SetNX(context.Background(), key, value, expiration).Result()
+	if err != nil {
+		return false, fmt.Errorf("%s: %s", "Error setting value in redis", err)
+	}
+	return ok, nil
+}
+
 func (r *Redis) GetHash(key string, field string) (string, error) {
 	val, err := r.rdb.HGet(context.Background(), key, field).Result()
 	if err != nil {
@@ -90,6 +108,47 @@ func (r *Redis) SetHashTTL(key string, field string, value string, ttl time.Dura
 	return nil
 }
 
+// AddSetMemberExtend add a member to a set, the expiration of the set is only ever extended
+// so that a member expiring sooner does not shorten the set of the others
+func (r *Redis) AddSetMemberExtend(key string, member string, expiration time.Duration) error {
+	_, err := r.rdb.SAdd(context.Background(), key, member).Result()
+	if err != nil {
+		return fmt.Errorf("%s: %s", "Error adding set member in redis", err)
+	}
+
+	ttl, err := r.rdb.TTL(context.Background(), key).Result()
+	if err != nil {
+		return fmt.Errorf("%s: %s", "Error getting TTL of set", err)
+	}
+	if ttl < expiration {
+		err = r.rdb.Expire(context.Background(), key, expiration).Err()
+		if err != nil {
+			return fmt.Errorf("%s: %s", "Error setting TTL on set", err)
+		}
+	}
+
+	return nil
+}
+
+func (r *Redis) GetSetMembers(key string) ([]string, error) {
+	val, err := r.rdb.SMembers(context.Background(), key).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return []string{}, nil
+		}
+		return nil, fmt.Errorf("%s: %s", "Error getting set members from redis", err)
+	}
+	return val, nil
+}
+
+func (r *Redis) RemoveSetMember(key string, member string) error {
+	_, err := r.rdb.SRem(context.Background(), key, member).Result()
+	if err != nil {
+		return fmt.Errorf("%s: %s", "Error removing set member from redis", err)
+	}
+	return nil
+}
+
 func (r *Redis) Delete(key string) error {
 	_, err := r.rdb.Del(context.Background(), key).Result()
 	if err != nil {
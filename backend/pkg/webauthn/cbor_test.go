@@ -0,0 +1,148 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestDecodeCBOR_Table(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		want    interface{}
+		wantErr bool
+	}{
+		{name: "uint", data: []byte{0x18, 0x64}, want: int64(100)},
+		{name: "negative int", data: []byte{0x38, 0x63}, want: int64(-100)},
+		{name: "uint64 overflow", data: []byte{0x1b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, wantErr: true},
+		{name: "negative overflow", data: []byte{0x3b, 0x80, 0, 0, 0, 0, 0, 0, 0}, wantErr: true},
+		{name: "bytes", data: []byte{0x42, 0x01, 0x02}, want: []byte{0x01, 0x02}},
+		{name: "text", data: []byte{0x62, 'h', 'i'}, want: "hi"},
+		{name: "array", data: []byte{0x82, 0x01, 0x20}, want: []interface{}{int64(1), int64(-1)}},
+		{name: "map", data: []byte{0xa1, 0x61, 'a', 0xf5}, want: map[interface{}]interface{}{"a": true}},
+		{name: "tag", data: []byte{0xc2, 0x41, 0xff}, want: []byte{0xff}},
+		{name: "null", data: []byte{0xf6}, want: nil},
+		{name: "half float", data: []byte{0xf9, 0x3c, 0x00}, want: float64(1)},
+		{name: "bytes length beyond data", data: []byte{0x5b, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x00}, wantErr: true},
+		{name: "text length beyond data", data: []byte{0x7a, 0xff, 0xff, 0xff, 0xff}, wantErr: true},
+		{name: "array length beyond data", data: []byte{0x9a, 0xff, 0xff, 0xff, 0xff, 0x01}, wantErr: true},
+		{name: "map length beyond data", data: []byte{0xbb, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, wantErr: true},
+		{name: "indefinite bytes", data: []byte{0x5f, 0x41, 0x01, 0xff}, wantErr: true},
+		{name: "indefinite map", data: []byte{0xbf, 0x01, 0x01, 0xff}, wantErr: true},
+		{name: "reserved additional information", data: []byte{0x1c}, wantErr: true},
+		{name: "unsupported simple value", data: []byte{0xe0}, wantErr: true},
+		{name: "bytes map key", data: []byte{0xa1, 0x41, 0x01, 0x01}, wantErr: true},
+		{name: "duplicate map key", data: []byte{0xa2, 0x01, 0x01, 0x01, 0x02}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, rest, err := decodeCBOR(tt.data, 0)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			assert.Empty(t, rest)
+		})
+	}
+}
+
+func TestDecodeCBOR_EveryPrefix(t *testing.T) {
+	data := newES256Authenticator(t).create(testRP.ID, "challenge", testRP.Origin).Response.AttestationObject
+	raw, err := DecodeBase64(data)
+	assert.NoError(t, err)
+	_, _, err = decodeCBOR(raw, 0)
+	assert.NoError(t, err)
+	for i := 0; i < len(raw); i++ {
+		_, _, err = decodeCBOR(raw[:i], 0)
+		assert.Error(t, err, "prefix of %d bytes", i)
+	}
+}
+
+func TestParsePublicKey_Invalid(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modulus := func(size int) []byte {
+		n := bytes.Repeat([]byte{0xff}, size)
+		n[size-1] = 0xfd
+		return n
+	}
+	tests := []struct {
+		name string
+		cose []byte
+	}{
+		{name: "not a map", cose: encodeCBOR([]byte(pub))},
+		{name: "unknown key type", cose: encodeCBOR([]cborPair{{1, 9}, {3, -8}, {-1, 6}, {-2, []byte(pub)}})},
+		{name: "algorithm of another key type", cose: encodeCBOR([]cborPair{{1, 1}, {3, -7}, {-1, 6}, {-2, []byte(pub)}})},
+		{name: "EdDSA wrong curve", cose: encodeCBOR([]cborPair{{1, 1}, {3, -8}, {-1, 1}, {-2, []byte(pub)}})},
+		{name: "EdDSA short key", cose: encodeCBOR([]cborPair{{1, 1}, {3, -8}, {-1, 6}, {-2, []byte(pub)[:31]}})},
+		{name: "EdDSA key as text", cose: encodeCBOR([]cborPair{{1, 1}, {3, -8}, {-1, 6}, {-2, string(pub)}})},
+		{name: "ES256 off the curve", cose: encodeCBOR([]cborPair{
+			{1, 2}, {3, -7}, {-1, 1}, {-2, make([]byte, 32)}, {-3, bytes.Repeat([]byte{0x01}, 32)},
+		})},
+		{name: "ES256 missing Y", cose: encodeCBOR([]cborPair{{1, 2}, {3, -7}, {-1, 1}, {-2, make([]byte, 32)}})},
+		{name: "RS256 short modulus", cose: encodeCBOR([]cborPair{{1, 3}, {3, -257}, {-1, modulus(128)}, {-2, []byte{1, 0, 1}}})},
+		{name: "RS256 oversized modulus", cose: encodeCBOR([]cborPair{{1, 3}, {3, -257}, {-1, modulus(4096)}, {-2, []byte{1, 0, 1}}})},
+		{name: "RS256 oversized exponent", cose: encodeCBOR([]cborPair{{1, 3}, {3, -257}, {-1, modulus(256)}, {-2, make([]byte, 5)}})},
+		{name: "trailing data", cose: append(encodeCBOR([]cborPair{{1, 1}, {3, -8}, {-1, 6}, {-2, []byte(pub)}}), 0x00)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParsePublicKey(tt.cose)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestParsePublicKey_EveryPrefix(t *testing.T) {
+	for _, authenticator := range []*softAuthenticator{newES256Authenticator(t), newEdDSAAuthenticator(t)} {
+		_, err := ParsePublicKey(authenticator.cose)
+		assert.NoError(t, err)
+		for i := 0; i < len(authenticator.cose); i++ {
+			_, err = ParsePublicKey(authenticator.cose[:i])
+			assert.Error(t, err, "prefix of %d bytes", i)
+		}
+	}
+}
+
+func FuzzDecodeCBOR(f *testing.F) {
+	f.Add([]byte{0xa1, 0x61, 'a', 0xf5})
+	f.Add([]byte{0x9a, 0xff, 0xff, 0xff, 0xff, 0x01})
+	f.Add(bytes.Repeat([]byte{0x81}, cborMaxDepth+1))
+	f.Add(newEdDSAAuthenticator(f).cose)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		_, rest, err := decodeCBOR(data, 0)
+		if err == nil && !bytes.HasSuffix(data, rest) {
+			t.Fatalf("remaining %x is not the end of %x", rest, data)
+		}
+	})
+}
+
+func FuzzParsePublicKey(f *testing.F) {
+	f.Add(newES256Authenticator(f).cose)
+	f.Add(newEdDSAAuthenticator(f).cose)
+	f.Fuzz(func(t *testing.T, cose []byte) {
+		pub, err := ParsePublicKey(cose)
+		if err == nil && pub.Verify([]byte("data"), []byte("signature")) == nil {
+			t.Fatal("a random signature is accepted")
+		}
+	})
+}
+
+func FuzzVerifyAuthenticatorData(f *testing.F) {
+	authenticator := newES256Authenticator(f)
+	f.Add(authenticator.authData(testRP.ID, true))
+	f.Add(authenticator.authData(testRP.ID, false))
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		data, err := testRP.verifyAuthenticatorData(raw)
+		if err == nil && data.flags&flagAttestedData != 0 && len(data.credential.PublicKey) > len(raw) {
+			t.Fatal("the public key is longer than the authenticator data")
+		}
+	})
+}
@@ -0,0 +1,121 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithm identifiers accepted for a credential
+const (
+	AlgES256 int64 = -7
+	AlgEdDSA int64 = -8
+	AlgRS256 int64 = -257
+)
+
+// COSE key parameters (RFC 8152)
+const (
+	coseKeyType      int64 = 1
+	coseKeyAlg       int64 = 3
+	coseKeyCurve     int64 = -1
+	coseKeyX         int64 = -2
+	coseKeyY         int64 = -3
+	coseKeyRSAN      int64 = -1
+	coseKeyRSAE      int64 = -2
+	coseKeyTypeOKP   int64 = 1
+	coseKeyTypeEC2   int64 = 2
+	coseKeyTypeRSA   int64 = 3
+	coseCurveP256    int64 = 1
+	coseCurveEd25519 int64 = 6
+)
+
+// RSA modulus accepted for RS256, in bytes
+const (
+	rsaMinModulus = 256
+	rsaMaxModulus = 512
+)
+
+// PublicKey is a credential public key decoded from its COSE form
+type PublicKey struct {
+	Algorithm int64
+	key       crypto.PublicKey
+}
+
+// ParsePublicKey decode a COSE encoded public key, nothing may follow the key
+func ParsePublicKey(cose []byte) (PublicKey, error) {
+	value, rest, err := decodeCBOR(cose, 0)
+	if err != nil {
+		return PublicKey{}, err
+	}
+	if len(rest) != 0 {
+		return PublicKey{}, errors.New("trailing data after the COSE key")
+	}
+	params, ok := value.(map[interface{}]interface{})
+	if !ok {
+		return PublicKey{}, errors.New("public key is not a COSE key")
+	}
+	kty, _ := params[coseKeyType].(int64)
+	alg, _ := params[coseKeyAlg].(int64)
+
+	switch {
+	case kty == coseKeyTypeEC2 && alg == AlgES256:
+		crv, _ := params[coseKeyCurve].(int64)
+		x, _ := params[coseKeyX].([]byte)
+		y, _ := params[coseKeyY].([]byte)
+		if crv != coseCurveP256 || len(x) != 32 || len(y) != 32 {
+			return PublicKey{}, errors.New("invalid ES256 public key")
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return PublicKey{}, errors.New("invalid ES256 public key")
+		}
+		return PublicKey{Algorithm: alg, key: pub}, nil
+	case kty == coseKeyTypeOKP && alg == AlgEdDSA:
+		crv, _ := params[coseKeyCurve].(int64)
+		x, _ := params[coseKeyX].([]byte)
+		if crv != coseCurveEd25519 || len(x) != ed25519.PublicKeySize {
+			return PublicKey{}, errors.New("invalid EdDSA public key")
+		}
+		return PublicKey{Algorithm: alg, key: ed25519.PublicKey(x)}, nil
+	case kty == coseKeyTypeRSA && alg == AlgRS256:
+		n, _ := params[coseKeyRSAN].([]byte)
+		e, _ := params[coseKeyRSAE].([]byte)
+		if len(n) < rsaMinModulus || len(n) > rsaMaxModulus || len(e) == 0 || len(e) > 4 {
+			return PublicKey{}, errors.New("invalid RS256 public key")
+		}
+		return PublicKey{Algorithm: alg, key: &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}}, nil
+	}
+	return PublicKey{}, fmt.Errorf("unsupported public key type %d with algorithm %d", kty, alg)
+}
+
+// Verify check the signature of an authenticator over the data
+func (k PublicKey) Verify(data, sig []byte) error {
+	switch pub := k.key.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(data)
+		if ecdsa.VerifyASN1(pub, digest[:], sig) {
+			return nil
+		}
+	case ed25519.PublicKey:
+		if ed25519.Verify(pub, data, sig) {
+			return nil
+		}
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(data)
+		if rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig) == nil {
+			return nil
+		}
+	default:
+		return errors.New("public key is not initialized")
+	}
+	return ErrSignature
+}
@@ -0,0 +1,325 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// Timeout is how long the browser wait for the authenticator, in milliseconds
+	Timeout = 60000
+
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+
+	challengeLen = 32
+)
+
+var (
+	ErrChallenge = errors.New("webauthn: challenge mismatch")
+	ErrOrigin    = errors.New("webauthn: origin mismatch")
+	ErrRelyingID = errors.New("webauthn: relying party ID mismatch")
+	ErrUser      = errors.New("webauthn: user not present or not verified")
+	ErrSignature = errors.New("webauthn: invalid signature")
+	// ErrSignCount is returned when the signature counter goes backward, which indicate a cloned authenticator
+	ErrSignCount = errors.New("webauthn: signature counter did not increase")
+)
+
+// RelyingParty is the server that credentials are scoped to
+type RelyingParty struct {
+	// ID is the domain of the relying party, e.g. `example.com`
+	ID   string
+	Name string
+	// Origin is the full origin of the web app, e.g. `https://app.example.com`
+	Origin string
+}
+
+// Credential is what the relying party keep of a registered authenticator
+type Credential struct {
+	ID        []byte
+	PublicKey []byte
+	SignCount uint32
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int64  `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// CreationOptions is given to `navigator.credentials.create()`, binary values are base64url encoded
+type CreationOptions struct {
+	Challenge string `json:"challenge"`
+	RP        struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"rp"`
+	User struct {
+		ID          string `json:"id"`
+		Name        string `json:"name"`
+		DisplayName string `json:"displayName"`
+	} `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int                    `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection struct {
+		ResidentKey      string `json:"residentKey"`
+		UserVerification string `json:"userVerification"`
+	} `json:"authenticatorSelection"`
+	Attestation string `json:"attestation"`
+}
+
+// RequestOptions is given to `navigator.credentials.get()`, binary values are base64url encoded
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	Timeout          int                    `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// RegistrationResponse is the result of `navigator.credentials.create()`, binary values are base64url encoded
+type RegistrationResponse struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AttestationObject string `json:"attestationObject"`
+	} `json:"response"`
+}
+
+// AssertionResponse is the result of `navigator.credentials.get()`, binary values are base64url encoded
+type AssertionResponse struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+		UserHandle        string `json:"userHandle,omitempty"`
+	} `json:"response"`
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+type authenticatorData struct {
+	rpIDHash   []byte
+	flags      byte
+	signCount  uint32
+	credential Credential
+}
+
+// NewChallenge generate a random challenge, base64url encoded
+func NewChallenge() (string, error) {
+	challenge := make([]byte, challengeLen)
+	if _, err := rand.Read(challenge); err != nil {
+		return "", err
+	}
+	return EncodeBase64(challenge), nil
+}
+
+// EncodeBase64 encode binary values the way WebAuthn JSON does
+func EncodeBase64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeBase64 decode a base64url value, with or without padding
+func DecodeBase64(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// NewCreationOptions build the options to register a new credential, the already
+// registered credentials are excluded so an authenticator is not registered twice
+func (rp RelyingParty) NewCreationOptions(challenge string, userID []byte, userName string, exclude [][]byte) CreationOptions {
+	var opt CreationOptions
+	opt.Challenge = challenge
+	opt.RP.ID = rp.ID
+	opt.RP.Name = rp.Name
+	opt.User.ID = EncodeBase64(userID)
+	opt.User.Name = userName
+	opt.User.DisplayName = userName
+	for _, alg := range []int64{AlgES256, AlgEdDSA, AlgRS256} {
+		opt.PubKeyCredParams = append(opt.PubKeyCredParams, CredentialParameter{Type: "public-key", Alg: alg})
+	}
+	opt.Timeout = Timeout
+	opt.ExcludeCredentials = descriptors(exclude)
+	opt.AuthenticatorSelection.ResidentKey = "preferred"
+	opt.AuthenticatorSelection.UserVerification = "required"
+	opt.Attestation = "none"
+	return opt
+}
+
+// NewRequestOptions build the options to assert one of the allowed credentials
+func (rp RelyingParty) NewRequestOptions(challenge string, allow [][]byte) RequestOptions {
+	return RequestOptions{
+		Challenge:        challenge,
+		Timeout:          Timeout,
+		RPID:             rp.ID,
+		AllowCredentials: descriptors(allow),
+		UserVerification: "required",
+	}
+}
+
+// VerifyRegistration check the result of a registration ceremony and return the new credential.
+// Attestation statements are not verified, as the options ask for the `none` attestation
+func (rp RelyingParty) VerifyRegistration(challenge string, res RegistrationResponse) (Credential, error) {
+	if _, err := rp.verifyClientData(res.Response.ClientDataJSON, "webauthn.create", challenge); err != nil {
+		return Credential{}, err
+	}
+
+	rawAttestation, err := DecodeBase64(res.Response.AttestationObject)
+	if err != nil {
+		return Credential{}, err
+	}
+	value, _, err := decodeCBOR(rawAttestation, 0)
+	if err != nil {
+		return Credential{}, err
+	}
+	attestation, ok := value.(map[interface{}]interface{})
+	if !ok {
+		return Credential{}, errors.New("webauthn: invalid attestation object")
+	}
+	rawAuthData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return Credential{}, errors.New("webauthn: invalid attestation object")
+	}
+
+	authData, err := rp.verifyAuthenticatorData(rawAuthData)
+	if err != nil {
+		return Credential{}, err
+	}
+	if authData.flags&flagAttestedData == 0 {
+		return Credential{}, errors.New("webauthn: attested credential data missing")
+	}
+	if _, err = ParsePublicKey(authData.credential.PublicKey); err != nil {
+		return Credential{}, err
+	}
+	return authData.credential, nil
+}
+
+// VerifyAssertion check the result of an authentication ceremony against a registered credential,
+// and return the new signature counter to be stored
+func (rp RelyingParty) VerifyAssertion(challenge string, cred Credential, res AssertionResponse) (signCount uint32, err error) {
+	id, err := DecodeBase64(res.ID)
+	if err != nil {
+		return
+	}
+	if !bytes.Equal(id, cred.ID) {
+		return 0, errors.New("webauthn: credential mismatch")
+	}
+	rawClientData, err := rp.verifyClientData(res.Response.ClientDataJSON, "webauthn.get", challenge)
+	if err != nil {
+		return
+	}
+	rawAuthData, err := DecodeBase64(res.Response.AuthenticatorData)
+	if err != nil {
+		return
+	}
+	authData, err := rp.verifyAuthenticatorData(rawAuthData)
+	if err != nil {
+		return
+	}
+	sig, err := DecodeBase64(res.Response.Signature)
+	if err != nil {
+		return
+	}
+	pub, err := ParsePublicKey(cred.PublicKey)
+	if err != nil {
+		return
+	}
+	clientDataHash := sha256.Sum256(rawClientData)
+	if err = pub.Verify(append(rawAuthData, clientDataHash[:]...), sig); err != nil {
+		return
+	}
+	// authenticators without a counter always report zero
+	if (authData.signCount != 0 || cred.SignCount != 0) && authData.signCount <= cred.SignCount {
+		return 0, ErrSignCount
+	}
+	return authData.signCount, nil
+}
+
+func (rp RelyingParty) verifyClientData(encoded, ceremony, challenge string) ([]byte, error) {
+	raw, err := DecodeBase64(encoded)
+	if err != nil {
+		return nil, err
+	}
+	var data clientData
+	if err = json.Unmarshal(raw, &data); err != nil {
+		return nil, err
+	}
+	if data.Type != ceremony {
+		return nil, fmt.Errorf("webauthn: unexpected ceremony %s", data.Type)
+	}
+	if subtle.ConstantTimeCompare([]byte(strings.TrimRight(data.Challenge, "=")), []byte(challenge)) != 1 {
+		return nil, ErrChallenge
+	}
+	if data.Origin != rp.Origin {
+		return nil, ErrOrigin
+	}
+	return raw, nil
+}
+
+func (rp RelyingParty) verifyAuthenticatorData(raw []byte) (data authenticatorData, err error) {
+	if len(raw) < 37 {
+		return data, errors.New("webauthn: authenticator data too short")
+	}
+	data.rpIDHash = raw[:32]
+	data.flags = raw[32]
+	data.signCount = binary.BigEndian.Uint32(raw[33:37])
+
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(data.rpIDHash, rpIDHash[:]) {
+		return data, ErrRelyingID
+	}
+	if data.flags&flagUserPresent == 0 || data.flags&flagUserVerified == 0 {
+		return data, ErrUser
+	}
+	if data.flags&flagAttestedData == 0 {
+		return data, nil
+	}
+
+	// attested credential data: AAGUID (16) | credential ID length (2) | credential ID | COSE key
+	rest := raw[37:]
+	if len(rest) < 18 {
+		return data, errors.New("webauthn: attested credential data too short")
+	}
+	idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if len(rest) < idLen {
+		return data, errors.New("webauthn: attested credential data too short")
+	}
+	data.credential.ID = append([]byte{}, rest[:idLen]...)
+	rest = rest[idLen:]
+	_, extensions, err := decodeCBOR(rest, 0)
+	if err != nil {
+		return data, err
+	}
+	data.credential.PublicKey = append([]byte{}, rest[:len(rest)-len(extensions)]...)
+	data.credential.SignCount = data.signCount
+	return data, nil
+}
+
+func descriptors(ids [][]byte) []CredentialDescriptor {
+	res := []CredentialDescriptor{}
+	for _, id := range ids {
+		res = append(res, CredentialDescriptor{Type: "public-key", ID: EncodeBase64(id)})
+	}
+	return res
+}
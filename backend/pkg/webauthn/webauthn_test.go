@@ -0,0 +1,220 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+var testRP = RelyingParty{ID: "example.com", Name: "Example", Origin: "https://example.com"}
+
+// cborPair keep the order of map entries when encoding
+type cborPair struct {
+	key   interface{}
+	value interface{}
+}
+
+func encodeCBORHead(major byte, arg uint64) []byte {
+	switch {
+	case arg < 24:
+		return []byte{major<<5 | byte(arg)}
+	case arg < 1<<8:
+		return []byte{major<<5 | 24, byte(arg)}
+	case arg < 1<<16:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(arg))
+	}
+	return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(arg))
+}
+
+func encodeCBOR(v interface{}) []byte {
+	switch val := v.(type) {
+	case int:
+		if val < 0 {
+			return encodeCBORHead(1, uint64(-1-val))
+		}
+		return encodeCBORHead(0, uint64(val))
+	case []byte:
+		return append(encodeCBORHead(2, uint64(len(val))), val...)
+	case string:
+		return append(encodeCBORHead(3, uint64(len(val))), val...)
+	case []cborPair:
+		res := encodeCBORHead(5, uint64(len(val)))
+		for _, pair := range val {
+			res = append(res, encodeCBOR(pair.key)...)
+			res = append(res, encodeCBOR(pair.value)...)
+		}
+		return res
+	}
+	panic("unsupported type")
+}
+
+// softAuthenticator is a software authenticator holding a single credential
+type softAuthenticator struct {
+	id        []byte
+	signer    func(data []byte) []byte
+	cose      []byte
+	signCount uint32
+	flags     byte
+}
+
+func newES256Authenticator(t testing.TB) *softAuthenticator {
+	pvt, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &softAuthenticator{
+		id: []byte("es256-credential"),
+		signer: func(data []byte) []byte {
+			digest := sha256.Sum256(data)
+			sig, _ := ecdsa.SignASN1(rand.Reader, pvt, digest[:])
+			return sig
+		},
+		cose: encodeCBOR([]cborPair{
+			{1, 2}, {3, -7}, {-1, 1},
+			{-2, pvt.PublicKey.X.FillBytes(make([]byte, 32))},
+			{-3, pvt.PublicKey.Y.FillBytes(make([]byte, 32))},
+		}),
+		flags: flagUserPresent | flagUserVerified,
+	}
+}
+
+func newEdDSAAuthenticator(t testing.TB) *softAuthenticator {
+	pub, pvt, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &softAuthenticator{
+		id:     []byte("eddsa-credential"),
+		signer: func(data []byte) []byte { return ed25519.Sign(pvt, data) },
+		cose:   encodeCBOR([]cborPair{{1, 1}, {3, -8}, {-1, 6}, {-2, []byte(pub)}}),
+		flags:  flagUserPresent | flagUserVerified,
+	}
+}
+
+func (a *softAuthenticator) clientData(ceremony, challenge, origin string) string {
+	raw, _ := json.Marshal(clientData{Type: ceremony, Challenge: challenge, Origin: origin})
+	return EncodeBase64(raw)
+}
+
+func (a *softAuthenticator) authData(rpID string, attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	res := append(rpIDHash[:], a.flags)
+	res = binary.BigEndian.AppendUint32(res, a.signCount)
+	if attested {
+		res[32] |= flagAttestedData
+		res = append(res, make([]byte, 16)...)
+		res = binary.BigEndian.AppendUint16(res, uint16(len(a.id)))
+		res = append(res, a.id...)
+		res = append(res, a.cose...)
+	}
+	return res
+}
+
+func (a *softAuthenticator) create(rpID, challenge, origin string) RegistrationResponse {
+	var res RegistrationResponse
+	res.ID = EncodeBase64(a.id)
+	res.Type = "public-key"
+	res.Response.ClientDataJSON = a.clientData("webauthn.create", challenge, origin)
+	res.Response.AttestationObject = EncodeBase64(encodeCBOR([]cborPair{
+		{"fmt", "none"},
+		{"attStmt", []cborPair{}},
+		{"authData", a.authData(rpID, true)},
+	}))
+	return res
+}
+
+func (a *softAuthenticator) get(rpID, challenge, origin string) AssertionResponse {
+	a.signCount++
+	var res AssertionResponse
+	res.ID = EncodeBase64(a.id)
+	res.Type = "public-key"
+	res.Response.ClientDataJSON = a.clientData("webauthn.get", challenge, origin)
+	authData := a.authData(rpID, false)
+	rawClientData, _ := DecodeBase64(res.Response.ClientDataJSON)
+	clientDataHash := sha256.Sum256(rawClientData)
+	res.Response.AuthenticatorData = EncodeBase64(authData)
+	res.Response.Signature = EncodeBase64(a.signer(append(authData, clientDataHash[:]...)))
+	return res
+}
+
+func TestCeremony(t *testing.T) {
+	for _, authenticator := range []*softAuthenticator{newES256Authenticator(t), newEdDSAAuthenticator(t)} {
+		challenge, err := NewChallenge()
+		assert.NoError(t, err)
+		cred, err := testRP.VerifyRegistration(challenge, authenticator.create(testRP.ID, challenge, testRP.Origin))
+		assert.NoError(t, err)
+		assert.Equal(t, authenticator.id, cred.ID)
+
+		challenge, _ = NewChallenge()
+		assertion := authenticator.get(testRP.ID, challenge, testRP.Origin)
+		signCount, err := testRP.VerifyAssertion(challenge, cred, assertion)
+		assert.NoError(t, err)
+		assert.Equal(t, uint32(1), signCount)
+
+		// the same assertion can not be replayed once the counter is stored
+		cred.SignCount = signCount
+		_, err = testRP.VerifyAssertion(challenge, cred, assertion)
+		assert.ErrorIs(t, err, ErrSignCount)
+	}
+}
+
+func TestVerifyRegistration_Invalid(t *testing.T) {
+	authenticator := newES256Authenticator(t)
+	challenge, _ := NewChallenge()
+
+	_, err := testRP.VerifyRegistration(challenge, authenticator.create(testRP.ID, "other", testRP.Origin))
+	assert.ErrorIs(t, err, ErrChallenge)
+	_, err = testRP.VerifyRegistration(challenge, authenticator.create(testRP.ID, challenge, "https://evil.com"))
+	assert.ErrorIs(t, err, ErrOrigin)
+	_, err = testRP.VerifyRegistration(challenge, authenticator.create("evil.com", challenge, testRP.Origin))
+	assert.ErrorIs(t, err, ErrRelyingID)
+
+	authenticator.flags = flagUserPresent
+	_, err = testRP.VerifyRegistration(challenge, authenticator.create(testRP.ID, challenge, testRP.Origin))
+	assert.ErrorIs(t, err, ErrUser)
+}
+
+func TestVerifyAssertion_Invalid(t *testing.T) {
+	authenticator := newES256Authenticator(t)
+	challenge, _ := NewChallenge()
+	cred, err := testRP.VerifyRegistration(challenge, authenticator.create(testRP.ID, challenge, testRP.Origin))
+	assert.NoError(t, err)
+
+	assertion := authenticator.get(testRP.ID, challenge, testRP.Origin)
+	assertion.Response.Signature = EncodeBase64([]byte("invalid"))
+	_, err = testRP.VerifyAssertion(challenge, cred, assertion)
+	assert.ErrorIs(t, err, ErrSignature)
+
+	other := newEdDSAAuthenticator(t)
+	other.id = authenticator.id
+	_, err = testRP.VerifyAssertion(challenge, cred, other.get(testRP.ID, challenge, testRP.Origin))
+	assert.ErrorIs(t, err, ErrSignature)
+}
+
+func TestDecodeCBOR_Truncated(t *testing.T) {
+	for _, data := range [][]byte{{}, {0x18}, {0x42, 0x01}, {0xa1, 0x01}, {0x9f}} {
+		_, _, err := decodeCBOR(data, 0)
+		assert.Error(t, err)
+	}
+}
+
+func TestDecodeCBOR_Depth(t *testing.T) {
+	nested := func(depth int) []byte {
+		// arrays of a single item around an integer
+		data := bytes.Repeat([]byte{0x81}, depth)
+		return append(data, 0x01)
+	}
+	_, _, err := decodeCBOR(nested(cborMaxDepth), 0)
+	assert.NoError(t, err)
+	_, _, err = decodeCBOR(nested(cborMaxDepth+1), 0)
+	assert.Error(t, err)
+	_, _, err = decodeCBOR(bytes.Repeat([]byte{0xc0}, 100000), 0)
+	assert.Error(t, err)
+}
@@ -1,6 +1,6 @@
 import handleInput from '@src/utils/handle-input'
 import {useEffect, useState} from 'react'
-import vault, {CredentialType} from '@factories/vault'
+import vault, {CredentialType, CredentialWriteResponseType} from '@factories/vault'
 import notify from '@arutek/core-app/helpers/notification'
 import {useParams} from 'react-router-dom'
 import handleInitForm from '@src/utils/handle-init-form'
@@ -9,7 +9,7 @@ type CredentialModalProps = {
   modalId: string
   type: 'create'|'update'
   credential?: CredentialType & {id: string}
-  onSuccess: () => void
+  onSuccess: (credential: CredentialWriteResponseType) => void
 }
 
 const CredentialModal = (props: CredentialModalProps) => {
@@ -33,16 +33,16 @@ const CredentialModal = (props: CredentialModalProps) => {
   }
   const create = async () => {
     try {
-      await vault.createCredential(vaultId || '', credential)
-      props.onSuccess()
+      const res = await vault.createCredential(vaultId || '', credential)
+      props.onSuccess(res.data)
     } catch (e: any) {
       notify.notifyError(e.message)
     }
   }
   const update = async () => {
     try {
-      await vault.updateCredential(vaultId || '', credential.id, credential)
-      props.onSuccess()
+      const res = await vault.updateCredential(vaultId || '', credential.id, credential)
+      props.onSuccess(res.data)
     } catch (e: any) {
       notify.notifyError(e.message)
     }
@@ -11,6 +11,11 @@ export type CredentialType = {
   url: string
   note: string
 }
+// CredentialWriteResponseType is the credential that was just written, the empty fields are left out
+export type CredentialWriteResponseType = Partial<CredentialType> & {
+  id: string
+  name: string
+}
 export type VaultResponseType = {
   id: string
   name: string
@@ -26,16 +31,16 @@ export default {
   getOne (id: string):Promise<ResponseAPIType> {
     return libFetch.getDataLogged(`${apiUrl}/${id}`)
   },
-  create (payload: CreatePayloadType):Promise<ResponseAPIType> {
+  create (payload: CreatePayloadType):Promise<ResponseAPIType & {data: CredentialWriteResponseType}> {
     return libFetch.postDataLogged(`${apiUrl}`, payload)
   },
-  createCredential (vaultId: string, payload: CredentialType):Promise<ResponseAPIType> {
+  createCredential (vaultId: string, payload: CredentialType):Promise<ResponseAPIType & {data: CredentialWriteResponseType}> {
     return libFetch.postDataLogged(`${apiUrl}/${vaultId}`, payload)
   },
   update (vaultId: string, payload: { name: string }):Promise<ResponseAPIType> {
     return libFetch.putDataLogged(`${apiUrl}/${vaultId}`, payload)
   },
-  updateCredential (vaultId: string, credentialId: string, payload: CredentialType):Promise<ResponseAPIType> {
+  updateCredential (vaultId: string, credentialId: string, payload: CredentialType):Promise<ResponseAPIType & {data: CredentialWriteResponseType}> {
     return libFetch.putDataLogged(`${apiUrl}/${vaultId}/${credentialId}`, payload)
   },
   delete (vaultId: string):Promise<ResponseAPIType> {
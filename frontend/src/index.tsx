@@ -6,7 +6,9 @@ import { createBrowserRouter, RouterProvider } from 'react-router-dom'
 import '@styles/index.css'
 import '@arutek/core-app/fonticons/dist/aru-icon.scss'
 import {NotificationProvider} from '@src/components/NotificationToast'
+import keepSessionToken from '@src/utils/session-token'
 
+keepSessionToken()
 const router = createBrowserRouter(routes)
 console.log(`${pkg.displayName} v${pkg.version}`)
 
@@ -1,7 +1,7 @@
 import { useState, useEffect, useRef, ChangeEvent } from 'react'
 import libDate from '@arutek/core-app/libraries/date'
 import {Link, useNavigate, useParams} from 'react-router-dom'
-import vault, {CredentialType} from '@factories/vault'
+import vault, {CredentialType, CredentialWriteResponseType} from '@factories/vault'
 import CredentialModal from '@src/components/modal/CredentialModal'
 import {useNotification} from '@src/components/NotificationToast'
 import {TrashCan} from '@src/components/svg/TrashCan'
@@ -47,6 +47,22 @@ const Vault = () => {
     init()
     closeModal(modalId)
   }
+  // saveCredential put the written credential in the list, instead of decrypting the whole vault again
+  const saveCredential = (modalId: string, written: CredentialWriteResponseType) => {
+    const saved: VaultType = {
+      id: written.id,
+      name: written.name,
+      credential: written.credential || '',
+      url: written.url || '',
+      note: written.note || '',
+      password: written.password || '',
+    }
+    setCredentials((prevState) => {
+      if (!prevState.some((item) => item.id === saved.id)) return [...prevState, saved]
+      return prevState.map((item) => item.id === saved.id ? saved : item)
+    })
+    closeModal(modalId)
+  }
   const clipboardCopy = (val: string) => {
     navigator.clipboard.writeText(val)
     addNoty('Password has been copied to clipboard', 'success', 'Copied!')
@@ -123,12 +139,12 @@ const Vault = () => {
         name={selectedCredential?.name || ''}
         credentialId={selectedCredential?.id || ''}/>
       <CredentialModal
-        onSuccess={() => refreshVault('updateCredentialModal')}
+        onSuccess={(written) => saveCredential('updateCredentialModal', written)}
         modalId={'updateCredentialModal'}
         type="update"
         credential={selectedCredential} />
       <CredentialModal
-        onSuccess={() => refreshVault('createCredentialModal')}
+        onSuccess={(written) => saveCredential('createCredentialModal', written)}
         modalId={'createCredentialModal'}
         type="create" />
     </main>
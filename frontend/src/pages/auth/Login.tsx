@@ -4,6 +4,7 @@ import helpCookie from '@arutek/core-app/helpers/cookie'
 import {useState} from 'react'
 import handleInput from '@src/utils/handle-input'
 import {useNotification} from '@src/components/NotificationToast'
+import {SESSION_TOKEN_TTL} from '@src/utils/session-token'
 
 const Login = () => {
   const { addNoty } = useNotification()
@@ -17,7 +18,7 @@ const Login = () => {
   const login = async () => {
     try {
       const res = await userFactory.login(loginPayload)
-      helpCookie.setAuthCookie(res.data.accessToken, 30)
+      helpCookie.setAuthCookie(res.data.accessToken, SESSION_TOKEN_TTL)
       helpCookie.setCookie('userData', '{"roleId":0}', 60*24*365)
       setTimeout(() => navigate('/', {replace: true}), 250)
     } catch (e: any) {
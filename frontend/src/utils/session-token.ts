@@ -0,0 +1,18 @@
+import helpCookie from '@arutek/core-app/helpers/cookie'
+
+// The API replace the session token after every vault request, the replaced token is only
+// accepted for a few seconds before the whole session is revoked
+export const SESSION_TOKEN_HEADER = 'X-Session-Token'
+export const SESSION_TOKEN_TTL = 30
+
+// Save the rotated session token of every response in place of the current one,
+// so the next request sent by the fetch library use it
+export default () => {
+  const originFetch = window.fetch.bind(window)
+  window.fetch = async (...args: Parameters<typeof fetch>) => {
+    const res = await originFetch(...args)
+    const token = res.headers.get(SESSION_TOKEN_HEADER)
+    if (token) helpCookie.setAuthCookie(token, SESSION_TOKEN_TTL)
+    return res
+  }
+}